@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Edge is a backend of the edge-sdk.
+// the package provides the native backend (cgo, only linux), the Simulator and the MockEdge,
+// so the backend used by a program can be chosen at runtime.
+type Edge interface {
+	Lifecycle
+	LiveViewService
+	MediaService
+	CloudService
+}
+
+// Lifecycle manages the initialization of the sdk
+type Lifecycle interface {
+	// InitSDK initialize edge-sdk, see the package function InitSDK
+	InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) error
+	// DeInitSDK will de-initialize SDK environment
+	DeInitSDK() error
+	// Initialized returns whether the sdk instance has been initialized
+	Initialized() bool
+	// State returns the lifecycle state of the sdk instance
+	State() LifecycleState
+	// SubscribeState calls fn on every transition of the lifecycle state until unsubscribe is called
+	SubscribeState(fn func(StateTransition)) (unsubscribe func())
+}
+
+// LiveViewService creates the live-view of the camera streams
+type LiveViewService interface {
+	NewLiveView() LiveViewer
+}
+
+// LiveViewer receives the stream state and data of a camera, it is implemented by *LiveView
+type LiveViewer interface {
+	Init(cameraType CameraType, quality StreamQuality, handler StreamReceiver) error
+	DeInit()
+	SetCameraSource(source CameraSource) error
+	StartH264Stream() error
+	StopH264Stream() error
+	// Frames returns the stream data copied into pooled frames, see LiveView.Frames
+	Frames(ctx context.Context, opts *FrameOptions) <-chan *Frame
+	Destroy()
+}
+
+// MediaService accesses the media files of the dock
+type MediaService interface {
+	RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error
+	SetDroneNestUploadCloud(enable bool) error
+	SetDroneNestAutoDelete(enable bool) error
+	NewMediaFileReader() MediaReader
+}
+
+// MediaReader reads the media files of the dock, it is implemented by *MediaFileReader
+type MediaReader interface {
+	Open() error
+	Close() error
+	IsOpened() bool
+	GetFileList() ([]*MediaFileDesc, error)
+	OpenFile(path string) (*MediaFile, error)
+	Destroy()
+}
+
+// CloudService exchanges custom messages with the cloud
+type CloudService interface {
+	SendCustomMessageToCloud(data []byte) error
+	RegisterCloudCustomMsgHandler(handler func([]byte)) error
+}
+
+// BackendKind the name of an Edge implementation
+type BackendKind string
+
+const (
+	BackendNative    BackendKind = "native"
+	BackendSimulator BackendKind = "simulator"
+	BackendMock      BackendKind = "mock"
+)
+
+// BackendEnv is the environment variable read by NewEdgeFromEnv
+const BackendEnv = "DJIEDGE_BACKEND"
+
+// ErrBackendUnavailable the backend is not compiled into the program
+var ErrBackendUnavailable = errors.New("djiedge: backend is unavailable in this build")
+
+// nativeEdge is set by the cgo build
+var nativeEdge Edge
+
+// NewEdge returns the backend of the kind.
+// the native backend is a process-wide singleton, the others return a new instance on every call,
+// the simulator is configured by the environment variables, see SimulatorConfigFromEnv.
+func NewEdge(kind BackendKind) (Edge, error) {
+	switch kind {
+	case BackendNative:
+		if nativeEdge == nil {
+			return nil, ErrBackendUnavailable
+		}
+		return nativeEdge, nil
+	case BackendSimulator:
+		cfg, err := SimulatorConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewSimulatorWithConfig(cfg)
+	case BackendMock:
+		return NewMockEdge(), nil
+	}
+	return nil, fmt.Errorf("djiedge: unknown backend %q", kind)
+}
+
+// NewEdgeFromEnv returns the backend named by the environment variable DJIEDGE_BACKEND,
+// DefaultEdge is returned when the variable is empty, or the error of its simulator config.
+func NewEdgeFromEnv() (Edge, error) {
+	kind := os.Getenv(BackendEnv)
+	if kind == "" {
+		e, err := defaultEdge()
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return NewEdge(BackendKind(kind))
+}
@@ -0,0 +1,89 @@
+//go:build linux && !fake_edge
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+var (
+	_ LiveViewer  = (*LiveView)(nil)
+	_ MediaReader = (*MediaFileReader)(nil)
+)
+
+func init() {
+	nativeEdge = nativeBackend{}
+}
+
+// nativeBackend implements Edge by the package functions of the cgo build
+type nativeBackend struct{}
+
+// DefaultEdge returns the backend used by the package functions, it is the native backend in this build.
+func DefaultEdge() Edge {
+	return nativeEdge
+}
+
+// defaultEdge returns DefaultEdge, the native backend has no config
+func defaultEdge() (Edge, error) {
+	return nativeEdge, nil
+}
+
+func (nativeBackend) InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) error {
+	return InitSDK(device, auth, key, logger, deInitOnFailed)
+}
+
+func (nativeBackend) DeInitSDK() error {
+	return DeInitSDK()
+}
+
+func (nativeBackend) Initialized() bool {
+	return Initialized()
+}
+
+func (nativeBackend) State() LifecycleState {
+	return State()
+}
+
+func (nativeBackend) SubscribeState(fn func(StateTransition)) (unsubscribe func()) {
+	return SubscribeState(fn)
+}
+
+func (nativeBackend) NewLiveView() LiveViewer {
+	return NewLiveView()
+}
+
+func (nativeBackend) RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error {
+	return RegisterMediaFilesObserver(observer)
+}
+
+func (nativeBackend) SetDroneNestUploadCloud(enable bool) error {
+	return SetDroneNestUploadCloud(enable)
+}
+
+func (nativeBackend) SetDroneNestAutoDelete(enable bool) error {
+	return SetDroneNestAutoDelete(enable)
+}
+
+func (nativeBackend) NewMediaFileReader() MediaReader {
+	return NewMediaFileReader()
+}
+
+func (nativeBackend) SendCustomMessageToCloud(data []byte) error {
+	return SendCustomMessageToCloud(data)
+}
+
+func (nativeBackend) RegisterCloudCustomMsgHandler(handler func([]byte)) error {
+	return RegisterCloudCustomMsgHandler(handler)
+}
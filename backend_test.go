@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// testEdgeBackends returns the backends checked by testEdgeConformance, the native one can't run in the tests
+func testEdgeBackends(t *testing.T) map[string]func() Edge {
+	return map[string]func() Edge{
+		"mock": func() Edge { return NewMockEdge() },
+		"simulator": func() Edge {
+			cfg := DefaultSimulatorConfig()
+			cfg.InitDelay, cfg.StartDelay = 0, 0
+			sim, err := NewSimulatorWithConfig(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sim
+		},
+	}
+}
+
+func TestEdgeConformance(t *testing.T) {
+	for name, newEdge := range testEdgeBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Run("lifecycle", func(t *testing.T) { testEdgeLifecycle(t, newEdge()) })
+			t.Run("not initialized", func(t *testing.T) { testEdgeNotInitialized(t, newEdge()) })
+			t.Run("services", func(t *testing.T) {
+				e := newEdge()
+				if err := e.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); err != nil {
+					t.Fatal(err)
+				}
+				defer e.DeInitSDK()
+				testEdgeCloud(t, e)
+				testEdgeLiveView(t, e)
+				testEdgeMediaReader(t, e)
+			})
+		})
+	}
+}
+
+func testEdgeLifecycle(t *testing.T, e Edge) {
+	var mu sync.Mutex
+	var got []StateTransition
+	unsubscribe := e.SubscribeState(func(t StateTransition) {
+		mu.Lock()
+		got = append(got, t)
+		mu.Unlock()
+	})
+	defer unsubscribe()
+
+	if e.Initialized() || e.State() != StateUninitialized {
+		t.Fatalf("new backend is %v", e.State())
+	}
+	if err := e.DeInitSDK(); !errors.Is(err, ErrSDKNotInit) {
+		t.Errorf("DeInitSDK before InitSDK: %v", err)
+	}
+	// the invalid parameters are rejected before the sdk is called, so InitSDK is allowed again
+	if err := e.InitSDK(nil, &AuthInfo{}, &RSA2048Key{}, nil, false); err == nil || e.State() != StateFailed {
+		t.Fatalf("InitSDK without device: %v, state %v", err, e.State())
+	}
+	if err := e.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Initialized() || e.State() != StateReady {
+		t.Fatalf("initialized backend is %v", e.State())
+	}
+	if err := e.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); err == nil || e.State() != StateReady {
+		t.Errorf("InitSDK twice: %v, state %v", err, e.State())
+	}
+	if err := e.DeInitSDK(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Initialized() || e.State() != StateUninitialized {
+		t.Errorf("de-initialized backend is %v", e.State())
+	}
+	if err := e.DeInitSDK(); !errors.Is(err, ErrSDKNotInit) {
+		t.Errorf("DeInitSDK twice: %v", err)
+	}
+
+	want := []struct{ from, to LifecycleState }{
+		{StateUninitialized, StateInitializing},
+		{StateInitializing, StateFailed},
+		{StateFailed, StateInitializing},
+		{StateInitializing, StateReady},
+		{StateReady, StateShuttingDown},
+		{StateShuttingDown, StateUninitialized},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != len(want) {
+		t.Fatalf("got %d transitions %v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		if got[i].From != w.from || got[i].To != w.to {
+			t.Errorf("transition %d: %v -> %v, want %v -> %v", i, got[i].From, got[i].To, w.from, w.to)
+		}
+	}
+	if got[1].Err == nil {
+		t.Error("the failed transition has no error")
+	}
+}
+
+func testEdgeNotInitialized(t *testing.T, e Edge) {
+	if err := e.SendCustomMessageToCloud([]byte("hello")); !errors.Is(err, ErrSDKNotInit) {
+		t.Errorf("SendCustomMessageToCloud: %v", err)
+	}
+	if err := e.SetDroneNestUploadCloud(true); !errors.Is(err, ErrSDKNotInit) {
+		t.Errorf("SetDroneNestUploadCloud: %v", err)
+	}
+	if err := e.SetDroneNestAutoDelete(true); !errors.Is(err, ErrSDKNotInit) {
+		t.Errorf("SetDroneNestAutoDelete: %v", err)
+	}
+	r := e.NewMediaFileReader()
+	defer r.Destroy()
+	if err := r.Open(); !errors.Is(err, ErrSDKNotInit) || r.IsOpened() {
+		t.Errorf("MediaReader.Open: %v", err)
+	}
+	// the handlers are registered before InitSDK
+	if err := e.RegisterMediaFilesObserver(func(*MediaFileDesc) {}); err != nil {
+		t.Errorf("RegisterMediaFilesObserver: %v", err)
+	}
+	if err := e.RegisterCloudCustomMsgHandler(func([]byte) {}); err != nil {
+		t.Errorf("RegisterCloudCustomMsgHandler: %v", err)
+	}
+}
+
+func testEdgeCloud(t *testing.T, e Edge) {
+	if err := e.SendCustomMessageToCloud(make([]byte, 256)); err != nil {
+		t.Errorf("256 bytes: %v", err)
+	}
+	if err := e.SendCustomMessageToCloud(make([]byte, 257)); err == nil {
+		t.Error("257 bytes are sent")
+	}
+	if err := e.SetDroneNestUploadCloud(true); err != nil {
+		t.Errorf("SetDroneNestUploadCloud: %v", err)
+	}
+	if err := e.SetDroneNestAutoDelete(false); err != nil {
+		t.Errorf("SetDroneNestAutoDelete: %v", err)
+	}
+}
+
+func testEdgeLiveView(t *testing.T, e Edge) {
+	lv := e.NewLiveView()
+	defer lv.Destroy()
+	if err := lv.Init(CameraType(5), StreamQuality720p, discardReceiver{}); err == nil {
+		t.Error("Init with invalid camera")
+	}
+	if err := lv.Init(CameraTypePayload, StreamQuality(0), discardReceiver{}); err == nil {
+		t.Error("Init with invalid quality")
+	}
+	if err := lv.Init(CameraTypePayload, StreamQuality720p, nil); err == nil {
+		t.Error("Init without handler")
+	}
+	if err := lv.SetCameraSource(CameraSourceZoom); err == nil {
+		t.Error("SetCameraSource before Init")
+	}
+	if err := lv.StartH264Stream(); err == nil {
+		t.Error("StartH264Stream before Init")
+	}
+
+	if err := lv.Init(CameraTypePayload, StreamQuality720p, discardReceiver{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.SetCameraSource(CameraSource(0)); err == nil {
+		t.Error("SetCameraSource with invalid source")
+	}
+	if err := lv.SetCameraSource(CameraSourceZoom); err != nil {
+		t.Errorf("SetCameraSource: %v", err)
+	}
+	if err := lv.StartH264Stream(); err != nil {
+		t.Fatalf("StartH264Stream: %v", err)
+	}
+	if err := lv.StopH264Stream(); err != nil {
+		t.Errorf("StopH264Stream: %v", err)
+	}
+	lv.DeInit()
+	if err := lv.StartH264Stream(); err == nil {
+		t.Error("StartH264Stream after DeInit")
+	}
+}
+
+func testEdgeMediaReader(t *testing.T, e Edge) {
+	r := e.NewMediaFileReader()
+	defer r.Destroy()
+	if _, err := r.GetFileList(); !errors.Is(err, ErrFileReaderNotOpen) {
+		t.Errorf("GetFileList before Open: %v", err)
+	}
+	if _, err := r.OpenFile("DJI_0001.jpg"); !errors.Is(err, ErrFileReaderNotOpen) {
+		t.Errorf("OpenFile before Open: %v", err)
+	}
+	if err := r.Close(); err == nil {
+		t.Error("Close before Open")
+	}
+
+	if err := r.Open(); err != nil || !r.IsOpened() {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := r.Open(); err == nil {
+		t.Error("Open twice")
+	}
+	if files, err := r.GetFileList(); err != nil || len(files) != 0 {
+		t.Errorf("GetFileList of an empty dock: %v %v", files, err)
+	}
+	_, err := r.OpenFile("DJI_0001.jpg")
+	var sdkErr *SDKError
+	if !errors.Is(err, ErrOpenFileFailure) || !errors.As(err, &sdkErr) || sdkErr.Op != "MediaFileReader.OpenFile" {
+		t.Errorf("OpenFile of a missing file: %#v", err)
+	}
+	if err := r.Close(); err != nil || r.IsOpened() {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestNewEdge(t *testing.T) {
+	if e, err := NewEdge(BackendMock); err != nil {
+		t.Error(err)
+	} else if _, ok := e.(*MockEdge); !ok {
+		t.Errorf("mock backend is %T", e)
+	}
+	if e, err := NewEdge(BackendSimulator); err != nil {
+		t.Error(err)
+	} else if _, ok := e.(*Simulator); !ok {
+		t.Errorf("simulator backend is %T", e)
+	}
+	if _, err := NewEdge(BackendNative); nativeEdge == nil && !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("native backend without cgo: %v", err)
+	}
+	if _, err := NewEdge("remote"); err == nil {
+		t.Error("unknown backend")
+	}
+
+	t.Setenv(BackendEnv, string(BackendMock))
+	if e, err := NewEdgeFromEnv(); err != nil {
+		t.Error(err)
+	} else if _, ok := e.(*MockEdge); !ok {
+		t.Errorf("backend of %s=mock is %T", BackendEnv, e)
+	}
+}
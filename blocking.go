@@ -0,0 +1,381 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// the calls of the sdk can't be interrupted, so the context variants return when ctx is done
+// and leave the call running in its goroutine. the call is abandoned, when it returns,
+// its result is released, such as closing the file opened too late.
+
+// BlockingCallStats the counters of the context variants of a blocking call
+type BlockingCallStats struct {
+	// Op the name of the call, such as "LiveView.StartH264Stream"
+	Op string
+	// Calls the number of the calls
+	Calls uint64
+	// Abandoned the number of the calls abandoned because ctx was done
+	Abandoned uint64
+	// Leaked the number of the abandoned calls that haven't returned
+	Leaked int64
+	// Released the number of the abandoned calls whose result was released after they returned
+	Released uint64
+}
+
+type blockingCallCounter struct {
+	calls     atomic.Uint64
+	abandoned atomic.Uint64
+	leaked    atomic.Int64
+	released  atomic.Uint64
+}
+
+var blockingCalls sync.Map // op -> *blockingCallCounter
+
+// abandonedCalls the abandoned calls of the objects by abandonKey that haven't been released,
+// the channel is closed after the release
+var abandonedCalls sync.Map
+
+type abandonKey struct {
+	op  string
+	obj any
+}
+
+func blockingCounter(op string) *blockingCallCounter {
+	if c, ok := blockingCalls.Load(op); ok {
+		return c.(*blockingCallCounter)
+	}
+	c, _ := blockingCalls.LoadOrStore(op, &blockingCallCounter{})
+	return c.(*blockingCallCounter)
+}
+
+// BlockingCalls returns the counters of the context variants by the name of the call,
+// Leaked shows the calls still blocked in the sdk after their callers gave up.
+func BlockingCalls() []BlockingCallStats {
+	var stats []BlockingCallStats
+	blockingCalls.Range(func(key, value any) bool {
+		c := value.(*blockingCallCounter)
+		stats = append(stats, BlockingCallStats{
+			Op:        key.(string),
+			Calls:     c.calls.Load(),
+			Abandoned: c.abandoned.Load(),
+			Leaked:    c.leaked.Load(),
+			Released:  c.released.Load(),
+		})
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Op < stats[j].Op
+	})
+	return stats
+}
+
+// sdkCalls the calls of the native sdk running, including the abandoned ones still blocked in the sdk,
+// DeInitSDK waits for them
+var sdkCalls = newCallGroup()
+
+type callGroup struct {
+	mu   sync.Mutex
+	n    int
+	idle *sync.Cond
+}
+
+func newCallGroup() *callGroup {
+	g := &callGroup{}
+	g.idle = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *callGroup) add() {
+	g.mu.Lock()
+	g.n++
+	g.mu.Unlock()
+}
+
+func (g *callGroup) done() {
+	g.mu.Lock()
+	g.n--
+	if g.n == 0 {
+		g.idle.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+// wait waits until no call is running
+func (g *callGroup) wait() {
+	g.mu.Lock()
+	for g.n > 0 {
+		g.idle.Wait()
+	}
+	g.mu.Unlock()
+}
+
+// nativeRef defers freeing a native object until the calls using it return, like MediaFile.Close,
+// so Destroy never frees the object used by a call abandoned by a context variant.
+type nativeRef struct {
+	mu        sync.Mutex
+	calls     int
+	destroyed bool
+	free      func()
+}
+
+// acquire marks a call using the object, it returns false if the object is destroyed
+func (r *nativeRef) acquire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.destroyed {
+		return false
+	}
+	r.calls++
+	sdkCalls.add()
+	return true
+}
+
+// release ends the call, the object is freed if it is the last call after Destroy
+func (r *nativeRef) release() {
+	r.mu.Lock()
+	r.calls--
+	var free func()
+	if r.calls == 0 && r.destroyed {
+		free, r.free = r.free, nil
+	}
+	r.mu.Unlock()
+	if free != nil {
+		free()
+	}
+	sdkCalls.done()
+}
+
+// destroy frees the object now if no call is using it, or after the last call returns
+func (r *nativeRef) destroy(free func()) {
+	r.mu.Lock()
+	if r.destroyed {
+		r.mu.Unlock()
+		return
+	}
+	r.destroyed = true
+	if r.calls > 0 {
+		r.free = free
+		r.mu.Unlock()
+		return
+	}
+	sdkCalls.add()
+	r.mu.Unlock()
+	free()
+	sdkCalls.done()
+}
+
+// the states of a call of callContext
+const (
+	callRunning int32 = iota
+	callReturned
+	callAbandoned
+)
+
+// callContext runs call in a goroutine and waits for it until ctx is done.
+// if ctx is done first, the call is abandoned: abandon is called before callContext returns,
+// and release is called with the result when the call returns, always after abandon.
+//
+// if obj is not nil, the call waits for the release of the abandoned call of the same op and obj,
+// so the release never undoes a later call, such as stopping the stream started again.
+func callContext[T any](ctx context.Context, op string, obj any, call func() (T, error), abandon func(), release func(T, error)) (T, error) {
+	counter := blockingCounter(op)
+	counter.calls.Add(1)
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, fmt.Errorf("djiedge: %s: %w", op, err)
+	}
+	key := abandonKey{op, obj}
+	if obj != nil {
+		if released, ok := abandonedCalls.Load(key); ok {
+			select {
+			case <-released.(chan struct{}):
+			case <-ctx.Done():
+				return zero, fmt.Errorf("djiedge: %s: %w", op, ctx.Err())
+			}
+		}
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	var state atomic.Int32
+	done := make(chan result, 1)
+	abandoned := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		v, err := call()
+		if state.CompareAndSwap(callRunning, callReturned) {
+			done <- result{v, err}
+			return
+		}
+		<-abandoned
+		if release != nil {
+			release(v, err)
+		}
+		counter.leaked.Add(-1)
+		counter.released.Add(1)
+		if obj != nil {
+			abandonedCalls.CompareAndDelete(key, released)
+		}
+		close(released)
+	}()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		if !state.CompareAndSwap(callRunning, callAbandoned) {
+			r := <-done
+			return r.v, r.err
+		}
+		counter.abandoned.Add(1)
+		counter.leaked.Add(1)
+		if obj != nil {
+			abandonedCalls.Store(key, released)
+		}
+		if abandon != nil {
+			abandon()
+		}
+		close(abandoned)
+		return zero, fmt.Errorf("djiedge: %s: %w", op, ctx.Err())
+	}
+}
+
+// StartH264StreamContext calls lv.StartH264Stream until ctx is done,
+// the stream started after ctx is done is stopped.
+// it waits for the stream abandoned by a previous call of lv to be stopped.
+func StartH264StreamContext(ctx context.Context, lv LiveViewer) error {
+	_, err := callContext(ctx, "LiveView.StartH264Stream", lv, func() (struct{}, error) {
+		return struct{}{}, lv.StartH264Stream()
+	}, nil, func(_ struct{}, err error) {
+		if err == nil {
+			_ = lv.StopH264Stream()
+		}
+	})
+	return err
+}
+
+// OpenMediaReaderContext calls r.Open until ctx is done,
+// the reader opened after ctx is done is closed.
+// it waits for the reader abandoned by a previous call of r to be closed.
+func OpenMediaReaderContext(ctx context.Context, r MediaReader) error {
+	_, err := callContext(ctx, "MediaFileReader.Open", r, func() (struct{}, error) {
+		return struct{}{}, r.Open()
+	}, nil, func(_ struct{}, err error) {
+		if err == nil {
+			_ = r.Close()
+		}
+	})
+	return err
+}
+
+// GetFileListContext calls r.GetFileList until ctx is done
+func GetFileListContext(ctx context.Context, r MediaReader) ([]*MediaFileDesc, error) {
+	return callContext(ctx, "MediaFileReader.GetFileList", nil, r.GetFileList, nil, nil)
+}
+
+// OpenFileContext calls r.OpenFile until ctx is done,
+// the file opened after ctx is done is closed.
+func OpenFileContext(ctx context.Context, r MediaReader, path string) (*MediaFile, error) {
+	return callContext(ctx, "MediaFileReader.OpenFile", nil, func() (*MediaFile, error) {
+		return r.OpenFile(path)
+	}, nil, func(f *MediaFile, err error) {
+		if err == nil {
+			_ = f.Close()
+		}
+	})
+}
+
+// SendCustomMessageToCloudContext calls c.SendCustomMessageToCloud until ctx is done,
+// data is copied, so it can be reused after the call returns.
+// the message abandoned may still be sent.
+func SendCustomMessageToCloudContext(ctx context.Context, c CloudService, data []byte) error {
+	data = append([]byte(nil), data...)
+	_, err := callContext(ctx, "SendCustomMessageToCloud", nil, func() (struct{}, error) {
+		return struct{}{}, c.SendCustomMessageToCloud(data)
+	}, nil, nil)
+	return err
+}
+
+// maxPooledReadSize the read buffers larger than it are not pooled
+const maxPooledReadSize = 4 << 20
+
+// readBufferPool the buffers of ReadMediaFileContext
+var readBufferPool = sync.Pool{New: func() any { return new([]byte) }}
+
+// ReadMediaFileContext calls m.Read until ctx is done.
+// after a read is abandoned, the read position is lost and the reads return ErrReadAbandoned.
+func ReadMediaFileContext(ctx context.Context, m *MediaFile, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	m.mu.Lock()
+	if m.abandoned {
+		m.mu.Unlock()
+		return 0, ErrReadAbandoned
+	}
+	m.mu.Unlock()
+
+	// the abandoned read still writes its buffer after ReadMediaFileContext returns, so it reads into a buffer
+	// of the pool instead of p, and the buffer is returned to the pool after the read returns
+	bp := readBufferPool.Get().(*[]byte)
+	if cap(*bp) < len(p) {
+		*bp = make([]byte, len(p))
+	}
+	buf := (*bp)[:len(p)]
+	abandoned := false
+	n, err := callContext(ctx, "MediaFile.Read", nil, func() (int, error) {
+		return m.reader.readFile(m.handle, buf)
+	}, func() {
+		abandoned = true
+		m.mu.Lock()
+		m.abandoned, m.pending = true, true
+		m.mu.Unlock()
+	}, func(int, error) {
+		putReadBuffer(bp)
+		m.mu.Lock()
+		m.pending = false
+		closing := m.closeOnDone
+		m.mu.Unlock()
+		if closing {
+			_ = m.reader.closeFile(m.handle)
+		}
+	})
+	if abandoned {
+		return 0, err
+	}
+	defer putReadBuffer(bp)
+	if n < 0 || n > len(p) {
+		return 0, &SDKError{Code: n, Op: "MediaFile.Read", Args: []any{m.handle}, Err: ErrUnknownCode}
+	}
+	copy(p, buf[:n])
+	return n, err
+}
+
+func putReadBuffer(bp *[]byte) {
+	if cap(*bp) > maxPooledReadSize {
+		*bp = nil
+	}
+	readBufferPool.Put(bp)
+}
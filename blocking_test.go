@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeMediaFileIO returns the result of read, after block is closed if it isn't nil
+type fakeMediaFileIO struct {
+	read   func(buf []byte) (int, error)
+	block  chan struct{}
+	calls  int
+	closed chan struct{}
+}
+
+func (f *fakeMediaFileIO) readFile(_ fileHandle, buf []byte) (int, error) {
+	f.calls++
+	if f.block != nil {
+		<-f.block
+	}
+	return f.read(buf)
+}
+
+func (f *fakeMediaFileIO) closeFile(fileHandle) error {
+	if f.closed != nil {
+		close(f.closed)
+	}
+	return nil
+}
+
+func TestReadMediaFileContext(t *testing.T) {
+	data := []byte("0123456789")
+	tests := []struct {
+		name string
+		n    int
+		read func(buf []byte) (int, error)
+		err  error
+	}{
+		{"full", 10, func(buf []byte) (int, error) { return copy(buf, data), nil }, nil},
+		{"short", 4, func(buf []byte) (int, error) { return copy(buf, data[:4]), nil }, nil},
+		{"error", 0, func([]byte) (int, error) { return 0, ErrConnectFailure }, ErrConnectFailure},
+		// the size_t of a failed read converted to -1
+		{"negative", 0, func([]byte) (int, error) { return -1, nil }, ErrUnknownCode},
+		{"too many", 0, func(buf []byte) (int, error) { return len(buf) + 1, nil }, ErrUnknownCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MediaFile{reader: &fakeMediaFileIO{read: tt.read}}
+			p := make([]byte, 10)
+			n, err := ReadMediaFileContext(context.Background(), m, p)
+			if n != tt.n || !errors.Is(err, tt.err) {
+				t.Fatalf("read %d, %v, want %d, %v", n, err, tt.n, tt.err)
+			}
+			if !bytes.Equal(p[:n], data[:n]) {
+				t.Fatalf("read %q", p[:n])
+			}
+		})
+	}
+}
+
+func TestReadMediaFileContextEmpty(t *testing.T) {
+	f := &fakeMediaFileIO{read: func(buf []byte) (int, error) { return 0, nil }}
+	m := &MediaFile{reader: f}
+	if n, err := ReadMediaFileContext(context.Background(), m, nil); n != 0 || err != nil || f.calls != 0 {
+		t.Fatalf("read %d, %v with %d calls of the reader", n, err, f.calls)
+	}
+}
+
+func TestReadMediaFileContextAbandoned(t *testing.T) {
+	f := &fakeMediaFileIO{
+		read:   func(buf []byte) (int, error) { return copy(buf, "late"), nil },
+		block:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	m := &MediaFile{reader: f}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p := make([]byte, 8)
+	if n, err := ReadMediaFileContext(ctx, m, p); n != 0 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("read %d, %v, want the deadline", n, err)
+	}
+	if n, err := ReadMediaFileContext(context.Background(), m, p); n != 0 || err != ErrReadAbandoned {
+		t.Fatalf("read %d, %v after an abandoned read", n, err)
+	}
+	// the file closed during the abandoned read is closed after it returns, p isn't written
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(f.block)
+	select {
+	case <-f.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the file isn't closed after the abandoned read returned")
+	}
+	if !bytes.Equal(p, make([]byte, 8)) {
+		t.Fatalf("p written by the abandoned read: %q", p)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"time"
+)
+
+// broadcastBuffer the queue between the live-view and the subscribers of a Broadcaster
+const broadcastBuffer = 64
+
+// Broadcaster shares the stream of a live-view with any number of subscribers,
+// which are attached and detached at any time, such as a recorder, a relay and an analyzer of the same camera.
+// every subscriber has its own queue and overflow policy, a slow subscriber only loses its own frames,
+// except OverflowBlock, which also blocks the live-view and the other subscribers.
+//
+// the latest sps, pps and the frames of the current gop are cached, a new subscriber starts with them,
+// so it can decode at once instead of waiting for the next keyframe.
+type Broadcaster struct {
+	feed   frameFeed
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// BroadcastOptions the options of a Broadcaster
+type BroadcastOptions struct {
+	// GOPCacheSize the maximum bytes of the gop cached for the new subscribers, 8MB if 0, negative disables the cache.
+	// a longer gop isn't cached, the subscribers attached during it wait for the next keyframe.
+	GOPCacheSize int
+}
+
+// Subscriber a queue of frames of a Broadcaster
+type Subscriber struct {
+	q *frameQueue
+}
+
+// SubscriberStats the statistics of a subscriber
+type SubscriberStats struct {
+	// ID the id of the subscriber in the broadcaster, starts from 1
+	ID       uint64
+	Overflow OverflowPolicy
+	// Queued the number of the frames added to the queue
+	Queued uint64
+	// Dropped the number of the frames dropped by the overflow policy, including the ones removed from the queue
+	Dropped uint64
+	// Len and Cap the frames in the queue and its capacity
+	Len int
+	Cap int
+	// MaxLen the maximum number of the frames in the queue
+	MaxLen int
+	// Lag the time since the oldest frame in the queue was received by the live-view, 0 if the queue is empty
+	Lag time.Duration
+	// WaitingKeyframe the frames are dropped until a keyframe, only for OverflowDropUntilKeyframe
+	WaitingKeyframe bool
+}
+
+// NewBroadcaster starts sharing the frames of lv, until Close is called or lv is destroyed.
+// the frames are received by LiveViewer.Frames, so the handler of Init still receives the stream as usual.
+func NewBroadcaster(lv LiveViewer, opts *BroadcastOptions) *Broadcaster {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broadcaster{cancel: cancel, done: make(chan struct{})}
+	b.feed.gopLimit = defaultGOPCacheSize
+	if opts != nil && opts.GOPCacheSize != 0 {
+		b.feed.gopLimit = opts.GOPCacheSize
+	}
+	frames := lv.Frames(ctx, &FrameOptions{Buffer: broadcastBuffer, Overflow: OverflowBlock})
+	go b.run(frames)
+	return b
+}
+
+func (b *Broadcaster) run(frames <-chan *Frame) {
+	defer close(b.done)
+	for f := range frames {
+		b.feed.publish(f)
+	}
+	b.feed.close()
+}
+
+// Subscribe attaches a new subscriber, whose channel is closed after ctx is done, Subscriber.Close is called,
+// or the broadcaster is closed. the subscriber of a closed broadcaster gets a closed channel.
+// the queue starts with the gop cached, with the sps and pps inserted into its keyframe,
+// its capacity is the buffer of opts plus the frames of the gop.
+func (b *Broadcaster) Subscribe(ctx context.Context, opts *FrameOptions) *Subscriber {
+	return &Subscriber{q: b.feed.subscribe(ctx, opts)}
+}
+
+// Stats returns the statistics of the subscribers attached, ordered by their ids
+func (b *Broadcaster) Stats() []SubscriberStats {
+	subs := b.feed.subscribers()
+	stats := make([]SubscriberStats, 0, len(subs))
+	for _, q := range subs {
+		stats = append(stats, q.stats())
+	}
+	return stats
+}
+
+// Close detaches all the subscribers and stops receiving the frames of the live-view
+func (b *Broadcaster) Close() {
+	b.feed.close()
+	b.cancel()
+	<-b.done
+}
+
+// Frames returns the channel of the frames, the frames received must be released by Frame.Release
+func (s *Subscriber) Frames() <-chan *Frame {
+	return s.q.ch
+}
+
+// Stats returns the statistics of the subscriber
+func (s *Subscriber) Stats() SubscriberStats {
+	return s.q.stats()
+}
+
+// Close detaches the subscriber, the channel is closed soon after
+func (s *Subscriber) Close() {
+	s.q.cancel()
+}
+
+func (q *frameQueue) stats() SubscriberStats {
+	return SubscriberStats{
+		ID:              q.id,
+		Overflow:        q.overflow,
+		Queued:          q.queued.Load(),
+		Dropped:         q.dropped.Load(),
+		Len:             len(q.ch),
+		Cap:             cap(q.ch),
+		MaxLen:          int(q.maxLen.Load()),
+		Lag:             q.lag(),
+		WaitingKeyframe: q.waitKeyframe.Load(),
+	}
+}
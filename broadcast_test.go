@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBroadcaster(t *testing.T, opts *BroadcastOptions) (*MockLiveView, *Broadcaster) {
+	t.Helper()
+	lv := NewMockEdge().NewLiveView().(*MockLiveView)
+	if err := lv.Init(CameraTypePayload, StreamQuality720p, StreamStatusFunc(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.StartH264Stream(); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBroadcaster(lv, opts)
+	t.Cleanup(func() {
+		b.Close()
+		lv.Destroy()
+	})
+	return lv, b
+}
+
+func pushTestData(t *testing.T, lv *MockLiveView, data ...[]byte) {
+	t.Helper()
+	for _, d := range data {
+		if err := lv.PushStreamData(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// receiveSeqs receives n frames and returns their sequence numbers
+func receiveSeqs(t *testing.T, ch <-chan *Frame, n int) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	for i := 0; i < n; i++ {
+		f := receiveFrame(t, ch)
+		seqs = append(seqs, f.Seq)
+		f.Release()
+	}
+	return seqs
+}
+
+// drainSeqs receives the frames queued without waiting
+func drainSeqs(ch <-chan *Frame) []uint64 {
+	var seqs []uint64
+	for len(ch) > 0 {
+		f := <-ch
+		seqs = append(seqs, f.Seq)
+		f.Release()
+	}
+	return seqs
+}
+
+func waitClosed(t *testing.T, ch <-chan *Frame) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case f, ok := <-ch:
+			if !ok {
+				return
+			}
+			f.Release()
+		case <-timeout:
+			t.Fatal("channel is not closed")
+		}
+	}
+}
+
+func equalSeqs(a []uint64, b ...uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBroadcasterAttachDetach(t *testing.T) {
+	lv, b := newTestBroadcaster(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := b.Subscribe(ctx, nil)
+	pushTestData(t, lv, concat(testSPS, testPPS), testIDR, testP)
+	if seqs := receiveSeqs(t, first.Frames(), 3); !equalSeqs(seqs, 1, 2, 3) {
+		t.Fatalf("first subscriber received %v", seqs)
+	}
+
+	// a subscriber attached in the middle of a gop starts with its keyframe and the parameter sets
+	second := b.Subscribe(ctx, nil)
+	f := receiveFrame(t, second.Frames())
+	if !f.Keyframe || f.Seq != 2 || !bytes.Equal(f.Data, concat(testSPS, testPPS, testIDR)) {
+		t.Fatalf("primed frame %d %x", f.Seq, f.Data)
+	}
+	f.Release()
+	if seqs := receiveSeqs(t, second.Frames(), 1); !equalSeqs(seqs, 3) {
+		t.Fatalf("second subscriber received %v", seqs)
+	}
+	if stats := b.Stats(); len(stats) != 2 || stats[0].ID != 1 || stats[1].ID != 2 || stats[1].Cap != 16+2 {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	// detached by Close and by the context
+	first.Close()
+	waitClosed(t, first.Frames())
+	thirdCtx, thirdCancel := context.WithCancel(ctx)
+	third := b.Subscribe(thirdCtx, nil)
+	thirdCancel()
+	waitClosed(t, third.Frames())
+	if stats := b.Stats(); len(stats) != 1 || stats[0].ID != 2 {
+		t.Fatalf("stats after detaching %+v", stats)
+	}
+
+	pushTestData(t, lv, testP)
+	if seqs := receiveSeqs(t, second.Frames(), 1); !equalSeqs(seqs, 4) {
+		t.Fatalf("second subscriber received %v", seqs)
+	}
+	if s := second.Stats(); s.Queued != 3 || s.Dropped != 0 || s.Len != 0 || s.Lag != 0 {
+		t.Fatalf("second subscriber stats %+v", s)
+	}
+}
+
+func TestBroadcasterOverflow(t *testing.T) {
+	lv, b := newTestBroadcaster(t, &BroadcastOptions{GOPCacheSize: -1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	oldest := b.Subscribe(ctx, &FrameOptions{Buffer: 2, Overflow: OverflowDropOldest})
+	newest := b.Subscribe(ctx, &FrameOptions{Buffer: 2, Overflow: OverflowDropNewest})
+	keyframe := b.Subscribe(ctx, &FrameOptions{Buffer: 2, Overflow: OverflowDropUntilKeyframe})
+	// the frames are added to the queues in the order of the subscribers, the last one tells they are added
+	fast := b.Subscribe(ctx, &FrameOptions{Buffer: 64, Overflow: OverflowBlock})
+
+	pushTestData(t, lv, concat(testSPS, testPPS, testIDR), testP, testP, testP, testP, testP)
+	receiveSeqs(t, fast.Frames(), 6)
+	time.Sleep(10 * time.Millisecond) // for the lag
+
+	s := oldest.Stats()
+	if s.Queued != 6 || s.Dropped != 4 || s.Len != 2 || s.Cap != 2 || s.MaxLen != 2 || s.Lag < 10*time.Millisecond ||
+		s.Overflow != OverflowDropOldest {
+		t.Fatalf("drop oldest stats %+v", s)
+	}
+	if seqs := drainSeqs(oldest.Frames()); !equalSeqs(seqs, 5, 6) {
+		t.Fatalf("drop oldest received %v", seqs)
+	}
+	if s := newest.Stats(); s.Queued != 2 || s.Dropped != 4 || s.Len != 2 || s.WaitingKeyframe {
+		t.Fatalf("drop newest stats %+v", s)
+	}
+	if seqs := drainSeqs(newest.Frames()); !equalSeqs(seqs, 1, 2) {
+		t.Fatalf("drop newest received %v", seqs)
+	}
+	if s := keyframe.Stats(); s.Queued != 2 || s.Dropped != 4 || !s.WaitingKeyframe {
+		t.Fatalf("drop until keyframe stats %+v", s)
+	}
+	if seqs := drainSeqs(keyframe.Frames()); !equalSeqs(seqs, 1, 2) {
+		t.Fatalf("drop until keyframe received %v", seqs)
+	}
+
+	// the queue has room, but the frames are dropped until the next keyframe
+	pushTestData(t, lv, testP, testIDR, testP)
+	receiveSeqs(t, fast.Frames(), 3)
+	if seqs := drainSeqs(keyframe.Frames()); !equalSeqs(seqs, 8, 9) {
+		t.Fatalf("drop until keyframe received %v after the loss", seqs)
+	}
+	if s := keyframe.Stats(); s.Dropped != 5 || s.WaitingKeyframe {
+		t.Fatalf("drop until keyframe stats %+v", s)
+	}
+	if s := fast.Stats(); s.Queued != 9 || s.Dropped != 0 {
+		t.Fatalf("block stats %+v", s)
+	}
+}
+
+func TestBroadcasterBlockingSubscriber(t *testing.T) {
+	lv, b := newTestBroadcaster(t, &BroadcastOptions{GOPCacheSize: -1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blocking := b.Subscribe(ctx, &FrameOptions{Buffer: 1, Overflow: OverflowBlock})
+	other := b.Subscribe(ctx, nil)
+	pushTestData(t, lv, testIDR, testP, testP)
+	if seqs := receiveSeqs(t, other.Frames(), 1); !equalSeqs(seqs, 1) {
+		t.Fatalf("received %v", seqs)
+	}
+	// the second frame waits for the blocking subscriber
+	select {
+	case f := <-other.Frames():
+		t.Fatalf("frame %d received while the broadcaster is blocked", f.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if seqs := receiveSeqs(t, blocking.Frames(), 1); !equalSeqs(seqs, 1) {
+		t.Fatalf("blocking subscriber received %v", seqs)
+	}
+	if seqs := receiveSeqs(t, other.Frames(), 1); !equalSeqs(seqs, 2) {
+		t.Fatalf("received %v", seqs)
+	}
+	// detaching the blocking subscriber releases the broadcaster
+	blocking.Close()
+	if seqs := receiveSeqs(t, other.Frames(), 1); !equalSeqs(seqs, 3) {
+		t.Fatalf("received %v after detaching", seqs)
+	}
+	waitClosed(t, blocking.Frames())
+}
+
+func TestBroadcasterCloseWhileReading(t *testing.T) {
+	lv, b := newTestBroadcaster(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, overflow := range []OverflowPolicy{OverflowDropOldest, OverflowDropNewest, OverflowBlock, OverflowDropUntilKeyframe} {
+		sub := b.Subscribe(ctx, &FrameOptions{Buffer: 4, Overflow: overflow, InjectParamSets: i%2 == 0})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range sub.Frames() {
+				time.Sleep(time.Millisecond)
+				f.Release()
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			data := testP
+			if i%10 == 0 {
+				data = concat(testSPS, testPPS, testIDR)
+			}
+			_ = lv.PushStreamData(data)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	b.Close()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribers are not closed")
+	}
+	close(stop)
+	<-pushed
+
+	if stats := b.Stats(); len(stats) != 0 {
+		t.Fatalf("stats after close %+v", stats)
+	}
+	waitClosed(t, b.Subscribe(ctx, nil).Frames())
+}
+
+func TestBroadcasterLiveViewDestroyed(t *testing.T) {
+	lv, b := newTestBroadcaster(t, nil)
+	sub := b.Subscribe(context.Background(), nil)
+	pushTestData(t, lv, testIDR)
+	lv.Destroy()
+	waitClosed(t, sub.Frames())
+	// the broadcaster stops by itself, Close still returns
+	b.Close()
+}
@@ -19,6 +19,7 @@
 package djiedge
 
 /*
+#include <stdlib.h>
 #include "edge_common.h"
 */
 import "C"
@@ -34,6 +35,20 @@ func convertToCString(str string) C.CCString {
 	}
 }
 
+// convertSecretToCString copies the secret to C memory, which is zeroed and freed by the returned function,
+// so the go memory is never referenced by the sdk
+func convertSecretToCString(b []byte) (C.CCString, func()) {
+	if len(b) == 0 {
+		return C.CCString{}, func() {}
+	}
+	p := C.CBytes(b)
+	s := C.CCString{data: (*C.char)(p), len: C.size_t(len(b))}
+	return s, func() {
+		zeroBytes(unsafe.Slice((*byte)(p), len(b)))
+		C.free(p)
+	}
+}
+
 func convertToGoString(c C.CCString) string {
 	return C.GoStringN((*C.char)(c.data), C.int(c.len))
 }
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CaptureFileEnv names the capture file recorded from the initialization of the sdk, see StartCapture
+const CaptureFileEnv = "DJIEDGE_CAPTURE_FILE"
+
+// captureMagic starts a capture file, followed by the version byte and the start time in unix nanoseconds
+const (
+	captureMagic      = "DJEC"
+	captureVersion    = 1
+	captureMaxPayload = 64 << 20
+)
+
+// CaptureEventType the callback recorded by a CaptureEvent
+type CaptureEventType uint8
+
+const (
+	// CaptureStreamData the data of StreamReceiver.OnReceiveStreamData
+	CaptureStreamData CaptureEventType = iota + 1
+	// CaptureStreamStatus the status value of StreamReceiver.OnStreamStatusUpdate
+	CaptureStreamStatus
+	// CaptureMediaFile a new media file of the media files observer
+	CaptureMediaFile
+	// CaptureCloudMessage a custom message from the cloud
+	CaptureCloudMessage
+	// CaptureLog a line of the sdk log
+	CaptureLog
+	// CaptureLiveView the camera type and quality of the live-view of the channel,
+	// it is written before the first event of the channel.
+	CaptureLiveView
+)
+
+func (t CaptureEventType) IsValid() bool {
+	return t >= CaptureStreamData && t <= CaptureLiveView
+}
+
+func (t CaptureEventType) String() string {
+	names := [...]string{"", "stream-data", "stream-status", "media-file", "cloud-message", "log", "live-view"}
+	if t.IsValid() {
+		return names[t]
+	}
+	return fmt.Sprintf("CaptureEventType(%d)", t)
+}
+
+// CaptureEvent is a callback received by the sdk in a capture file
+type CaptureEvent struct {
+	Type CaptureEventType
+	// Offset from the start of the capture
+	Offset time.Duration
+	// Channel identifies the live-view of the stream events, 0 for the other events
+	Channel uint32
+	// Data the payload, see the accessors of the type
+	Data []byte
+}
+
+// Status returns the stream status of a CaptureStreamStatus event
+func (e *CaptureEvent) Status() (*LiveStatus, bool) {
+	if e.Type != CaptureStreamStatus {
+		return nil, false
+	}
+	v, n := binary.Uvarint(e.Data)
+	if n <= 0 {
+		return nil, false
+	}
+	return newLiveStatus(int(v)), true
+}
+
+// MediaFile returns the file of a CaptureMediaFile event
+func (e *CaptureEvent) MediaFile() (*MediaFileDesc, error) {
+	if e.Type != CaptureMediaFile {
+		return nil, fmt.Errorf("capture: %v event is not a media file", e.Type)
+	}
+	desc := &MediaFileDesc{}
+	if err := json.Unmarshal(e.Data, desc); err != nil {
+		return nil, fmt.Errorf("capture: invalid media file: %w", err)
+	}
+	return desc, nil
+}
+
+// LiveView returns the camera type and quality of a CaptureLiveView event
+func (e *CaptureEvent) LiveView() (CameraType, StreamQuality, bool) {
+	if e.Type != CaptureLiveView {
+		return 0, 0, false
+	}
+	camera, n := binary.Uvarint(e.Data)
+	if n <= 0 {
+		return 0, 0, false
+	}
+	quality, m := binary.Uvarint(e.Data[n:])
+	if m <= 0 {
+		return 0, 0, false
+	}
+	return CameraType(camera), StreamQuality(quality), true
+}
+
+// newStatusCaptureEvent returns a CaptureStreamStatus event of the status value
+func newStatusCaptureEvent(offset time.Duration, channel uint32, value int) *CaptureEvent {
+	return &CaptureEvent{
+		Type:    CaptureStreamStatus,
+		Offset:  offset,
+		Channel: channel,
+		Data:    binary.AppendUvarint(nil, uint64(value)),
+	}
+}
+
+// newMediaFileCaptureEvent returns a CaptureMediaFile event of the file
+func newMediaFileCaptureEvent(offset time.Duration, desc *MediaFileDesc) *CaptureEvent {
+	b, _ := json.Marshal(desc)
+	return &CaptureEvent{Type: CaptureMediaFile, Offset: offset, Data: b}
+}
+
+// newLiveViewCaptureEvent returns a CaptureLiveView event of the channel
+func newLiveViewCaptureEvent(offset time.Duration, channel uint32, camera CameraType, quality StreamQuality) *CaptureEvent {
+	b := binary.AppendUvarint(nil, uint64(camera))
+	return &CaptureEvent{
+		Type:    CaptureLiveView,
+		Offset:  offset,
+		Channel: channel,
+		Data:    binary.AppendUvarint(b, uint64(quality)),
+	}
+}
+
+// CaptureWriter writes the events to a capture file.
+//
+// a capture file is the header followed by the events, an event is written as
+// type(1 byte), the offset from the previous event in nanoseconds(varint), the channel(uvarint),
+// the size of the data(uvarint) and the data, so a file cut by a crash is still readable to the last whole event.
+type CaptureWriter struct {
+	w     *bufio.Writer
+	start time.Time
+	last  time.Duration
+}
+
+// NewCaptureWriter writes the header of the capture started at the time
+func NewCaptureWriter(w io.Writer, start time.Time) (*CaptureWriter, error) {
+	cw := &CaptureWriter{w: bufio.NewWriterSize(w, 256*1024), start: start}
+	header := append([]byte(captureMagic), captureVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(start.UnixNano()))
+	if _, err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Start returns the start time of the capture
+func (w *CaptureWriter) Start() time.Time {
+	return w.start
+}
+
+// Write writes an event, the events should be written in order of the offset
+func (w *CaptureWriter) Write(e *CaptureEvent) error {
+	if !e.Type.IsValid() {
+		return fmt.Errorf("capture: invalid event type %d", e.Type)
+	}
+	if len(e.Data) > captureMaxPayload {
+		return fmt.Errorf("capture: %v event exceeds %d bytes", e.Type, captureMaxPayload)
+	}
+	var buf [1 + 3*binary.MaxVarintLen64]byte
+	b := append(buf[:0], byte(e.Type))
+	b = binary.AppendVarint(b, int64(e.Offset-w.last))
+	b = binary.AppendUvarint(b, uint64(e.Channel))
+	b = binary.AppendUvarint(b, uint64(len(e.Data)))
+	w.last = e.Offset
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	_, err := w.w.Write(e.Data)
+	return err
+}
+
+// Flush writes the buffered events to the underlying writer
+func (w *CaptureWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// CaptureReader reads the events of a capture file
+type CaptureReader struct {
+	r     *bufio.Reader
+	start time.Time
+	last  time.Duration
+}
+
+// NewCaptureReader reads the header of the capture file
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	cr := &CaptureReader{r: bufio.NewReaderSize(r, 256*1024)}
+	header := make([]byte, len(captureMagic)+1+8)
+	if _, err := io.ReadFull(cr.r, header); err != nil {
+		return nil, fmt.Errorf("capture: read header: %w", err)
+	}
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, errors.New("capture: not a capture file")
+	}
+	if v := header[len(captureMagic)]; v != captureVersion {
+		return nil, fmt.Errorf("capture: unsupported version %d", v)
+	}
+	cr.start = time.Unix(0, int64(binary.BigEndian.Uint64(header[len(captureMagic)+1:])))
+	return cr, nil
+}
+
+// Start returns the start time of the capture
+func (r *CaptureReader) Start() time.Time {
+	return r.start
+}
+
+// Next returns the next event, io.EOF at the end of the file,
+// io.ErrUnexpectedEOF if the last event is incomplete.
+func (r *CaptureReader) Next() (*CaptureEvent, error) {
+	return r.next(nil)
+}
+
+// next returns the next event accepted by keep, the data of the other events are skipped
+func (r *CaptureReader) next(keep func(t CaptureEventType, channel uint32) bool) (*CaptureEvent, error) {
+	for {
+		t, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		delta, err := binary.ReadVarint(r.r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		channel, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		size, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if size > captureMaxPayload || channel > 1<<32-1 {
+			return nil, errors.New("capture: corrupted event")
+		}
+		r.last += time.Duration(delta)
+		e := &CaptureEvent{Type: CaptureEventType(t), Offset: r.last, Channel: uint32(channel)}
+		if keep != nil && !keep(e.Type, e.Channel) {
+			if _, err = r.r.Discard(int(size)); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			continue
+		}
+		e.Data = make([]byte, size)
+		if _, err = io.ReadFull(r.r, e.Data); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return e, nil
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
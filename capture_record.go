@@ -0,0 +1,198 @@
+//go:build linux && !fake_edge
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// captureQueueSize the events buffered for the file, about 30 seconds of a stream
+const captureQueueSize = 1024
+
+var activeCapture atomic.Pointer[captureRecorder]
+
+// captureRecorder writes the callbacks of the sdk to a capture file,
+// the callbacks only copy the data to the queue, the file is written by a goroutine.
+type captureRecorder struct {
+	file    *os.File
+	w       *CaptureWriter
+	fromEnv bool
+
+	mu       sync.Mutex
+	channels map[uint32]bool // the channels whose CaptureLiveView is written
+	queue    chan *CaptureEvent
+	dropped  atomic.Int64
+	done     chan error
+}
+
+// StartCapture records every callback received from the sdk to the capture file,
+// such as the stream data and status of the live-views, new media files, cloud custom messages and the sdk log.
+// the file is replayed by the simulator with SimulatorConfig.ReplayFile.
+//
+// the capture is also started by InitSDK if DJIEDGE_CAPTURE_FILE is set.
+func StartCapture(path string) error {
+	return startCapture(path, false)
+}
+
+func startCapture(path string, fromEnv bool) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w, err := NewCaptureWriter(file, time.Now())
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	rec := &captureRecorder{
+		file:     file,
+		w:        w,
+		fromEnv:  fromEnv,
+		channels: map[uint32]bool{},
+		queue:    make(chan *CaptureEvent, captureQueueSize),
+		done:     make(chan error, 1),
+	}
+	if !activeCapture.CompareAndSwap(nil, rec) {
+		_ = file.Close()
+		return errors.New("capture is already started")
+	}
+	go rec.loop()
+	return nil
+}
+
+// StopCapture stops the capture and closes the file,
+// an error is returned if some events are dropped because the file is written too slowly.
+func StopCapture() error {
+	rec := activeCapture.Swap(nil)
+	if rec == nil {
+		return errors.New("capture is not started")
+	}
+	return rec.stop()
+}
+
+// stopEnvCapture stops the capture started by DJIEDGE_CAPTURE_FILE
+func stopEnvCapture() {
+	rec := activeCapture.Load()
+	if rec != nil && rec.fromEnv && activeCapture.CompareAndSwap(rec, nil) {
+		_ = rec.stop()
+	}
+}
+
+func (r *captureRecorder) stop() error {
+	r.mu.Lock()
+	close(r.queue)
+	r.queue = nil
+	r.mu.Unlock()
+	err := <-r.done
+	if n := r.dropped.Load(); n > 0 && err == nil {
+		err = fmt.Errorf("capture: %d events dropped", n)
+	}
+	return err
+}
+
+func (r *captureRecorder) loop() {
+	var err error
+	for e := range r.queue {
+		if err == nil {
+			err = r.w.Write(e)
+		}
+		// flush when idle, so the file is complete up to the last callbacks if the process crashes
+		if err == nil && len(r.queue) == 0 {
+			err = r.w.Flush()
+		}
+	}
+	if err == nil {
+		err = r.w.Flush()
+	}
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.done <- err
+}
+
+// pushLocked queues an event, it never blocks the callback of the sdk,
+// returns false if the event is dropped.
+func (r *captureRecorder) pushLocked(e *CaptureEvent) bool {
+	if r.queue == nil {
+		return false
+	}
+	select {
+	case r.queue <- e:
+		return true
+	default:
+		r.dropped.Add(1)
+		return false
+	}
+}
+
+func (r *captureRecorder) record(t CaptureEventType, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushLocked(&CaptureEvent{Type: t, Offset: time.Since(r.w.Start()), Data: data})
+}
+
+// recordLiveView records an event of the live-view, the camera of the channel is written before the first event,
+// it is written again with the next event if the queue is full.
+func (r *captureRecorder) recordLiveView(lv *LiveView, e *CaptureEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Offset, e.Channel = time.Since(r.w.Start()), lv.captureID
+	if !r.channels[lv.captureID] {
+		r.channels[lv.captureID] = r.pushLocked(newLiveViewCaptureEvent(e.Offset, lv.captureID, lv.cameraType, lv.quality))
+	}
+	r.pushLocked(e)
+}
+
+// captureStreamData records the data of the live-view, the data is copied from the c memory
+func captureStreamData(lv *LiveView, data []byte) {
+	if rec := activeCapture.Load(); rec != nil {
+		rec.recordLiveView(lv, &CaptureEvent{Type: CaptureStreamData, Data: append([]byte(nil), data...)})
+	}
+}
+
+func captureStreamStatus(lv *LiveView, value int) {
+	if rec := activeCapture.Load(); rec != nil {
+		rec.recordLiveView(lv, newStatusCaptureEvent(0, 0, value))
+	}
+}
+
+func captureMediaFile(desc *MediaFileDesc) {
+	if rec := activeCapture.Load(); rec != nil {
+		e := newMediaFileCaptureEvent(0, desc)
+		rec.record(e.Type, e.Data)
+	}
+}
+
+func captureCloudMessage(data []byte) {
+	if rec := activeCapture.Load(); rec != nil {
+		rec.record(CaptureCloudMessage, append([]byte(nil), data...))
+	}
+}
+
+// captureLog records a line of the sdk log, the line is copied only while capturing
+func captureLog(line []byte) {
+	if rec := activeCapture.Load(); rec != nil {
+		rec.record(CaptureLog, append([]byte(nil), line...))
+	}
+}
@@ -0,0 +1,63 @@
+//go:build linux && !fake_edge
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRecordLiveViewQueueFull(t *testing.T) {
+	w, err := NewCaptureWriter(io.Discard, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &captureRecorder{w: w, channels: map[uint32]bool{}, queue: make(chan *CaptureEvent, 2)}
+	lv := &LiveView{captureID: 7, cameraType: CameraTypePayload, quality: StreamQuality720p}
+	data := func() *CaptureEvent { return &CaptureEvent{Type: CaptureStreamData, Data: []byte{0, 0, 0, 1, 0x65}} }
+
+	rec.queue <- &CaptureEvent{Type: CaptureLog}
+	rec.queue <- &CaptureEvent{Type: CaptureLog}
+	rec.recordLiveView(lv, data())
+	if rec.channels[7] || rec.dropped.Load() != 2 {
+		t.Fatalf("dropped header: channel marked %v, %d events dropped", rec.channels[7], rec.dropped.Load())
+	}
+
+	// the camera of the channel is written with the next event
+	<-rec.queue
+	<-rec.queue
+	rec.recordLiveView(lv, data())
+	if !rec.channels[7] || len(rec.queue) != 2 {
+		t.Fatalf("channel marked %v, %d events queued", rec.channels[7], len(rec.queue))
+	}
+	if e := <-rec.queue; e.Type != CaptureLiveView || e.Channel != 7 {
+		t.Fatalf("first event %v of channel %d", e.Type, e.Channel)
+	} else if camera, quality, ok := e.LiveView(); !ok || camera != CameraTypePayload || quality != StreamQuality720p {
+		t.Fatalf("live-view %v %v %v", camera, quality, ok)
+	}
+	if e := <-rec.queue; e.Type != CaptureStreamData || e.Channel != 7 {
+		t.Fatalf("second event %v of channel %d", e.Type, e.Channel)
+	}
+
+	rec.recordLiveView(lv, data())
+	if e := <-rec.queue; e.Type != CaptureStreamData || len(rec.queue) != 0 {
+		t.Fatalf("the camera is written again: %v", e.Type)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testCaptureEvents() []*CaptureEvent {
+	return []*CaptureEvent{
+		{Type: CaptureLog, Offset: 0, Data: []byte("[Info]-[edge] init")},
+		newLiveViewCaptureEvent(10*time.Millisecond, 1, CameraTypePayload, StreamQuality720p),
+		newStatusCaptureEvent(20*time.Millisecond, 1, 31),
+		{Type: CaptureStreamData, Offset: 30 * time.Millisecond, Channel: 1, Data: []byte{0, 0, 0, 1, 0x65, 0x88}},
+		newMediaFileCaptureEvent(time.Second, &MediaFileDesc{FileName: "DJI_0001_W.JPG", FileSize: 1024, CameraAttr: CameraAttrWide}),
+		// the offsets may go back, they are written as deltas
+		{Type: CaptureCloudMessage, Offset: 900 * time.Millisecond, Data: []byte(`{"method":"ping"}`)},
+		{Type: CaptureStreamData, Offset: 2 * time.Second, Channel: 1 << 31, Data: bytes.Repeat([]byte{0xab}, 300)},
+	}
+}
+
+// writeTestCapture returns the capture file of the events, and the size of the file after each event
+func writeTestCapture(t *testing.T, start time.Time, events []*CaptureEvent) ([]byte, []int) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewCaptureWriter(&buf, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ends []int
+	for _, e := range events {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, buf.Len())
+	}
+	return buf.Bytes(), ends
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	start := time.Date(2023, 7, 1, 10, 30, 0, 123, time.UTC)
+	events := testCaptureEvents()
+	data, _ := writeTestCapture(t, start, events)
+
+	r, err := NewCaptureReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Start().Equal(start) {
+		t.Fatalf("start %v, want %v", r.Start(), start)
+	}
+	for i, want := range events {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(e, want) {
+			t.Fatalf("event %d: %+v, want %+v", i, e, want)
+		}
+	}
+	if e, err := r.Next(); err != io.EOF {
+		t.Fatalf("after the last event: %+v, %v", e, err)
+	}
+}
+
+func TestCaptureReaderTruncated(t *testing.T) {
+	events := testCaptureEvents()
+	data, ends := writeTestCapture(t, time.Unix(0, 0), events)
+	headerSize := len(captureMagic) + 9
+
+	// a file cut by a crash is readable to the last whole event
+	for size := headerSize; size < len(data); size++ {
+		r, err := NewCaptureReader(bytes.NewReader(data[:size]))
+		if err != nil {
+			t.Fatalf("cut at %d: %v", size, err)
+		}
+		whole := 0
+		for whole < len(ends) && ends[whole] <= size {
+			whole++
+		}
+		for i := 0; i < whole; i++ {
+			if _, err := r.Next(); err != nil {
+				t.Fatalf("cut at %d: event %d: %v", size, i, err)
+			}
+		}
+		// the file is cut between the events
+		wantErr := io.ErrUnexpectedEOF
+		if size == headerSize || whole > 0 && ends[whole-1] == size {
+			wantErr = io.EOF
+		}
+		if e, err := r.Next(); err != wantErr {
+			t.Fatalf("cut at %d after %d events: %+v, %v, want %v", size, whole, e, err, wantErr)
+		}
+	}
+}
+
+func TestCaptureReaderInvalid(t *testing.T) {
+	data, _ := writeTestCapture(t, time.Unix(0, 0), testCaptureEvents()[:1])
+	version := append([]byte{}, data...)
+	version[len(captureMagic)]++
+	oversized := append(append([]byte{}, data[:len(captureMagic)+9]...), byte(CaptureStreamData), 0, 1)
+	oversized = append(oversized, 0x80, 0x80, 0x80, 0x40) // 128MB
+
+	tests := []struct {
+		name   string
+		data   []byte
+		header bool // the header is valid
+	}{
+		{"empty", nil, false},
+		{"short header", data[:6], false},
+		{"not a capture", append([]byte("RIFF"), data[4:]...), false},
+		{"unsupported version", version, false},
+		{"oversized event", oversized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewCaptureReader(bytes.NewReader(tt.data))
+			if !tt.header {
+				if err == nil {
+					t.Fatal("invalid header is read")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e, err := r.Next(); err == nil || errors.Is(err, io.EOF) {
+				t.Fatalf("event %+v, %v", e, err)
+			}
+		})
+	}
+}
+
+func TestCaptureWriterInvalid(t *testing.T) {
+	w, err := NewCaptureWriter(io.Discard, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(&CaptureEvent{Type: 0}); err == nil {
+		t.Fatal("event of invalid type is written")
+	}
+	if err := w.Write(&CaptureEvent{Type: CaptureLiveView + 1}); err == nil {
+		t.Fatal("event of invalid type is written")
+	}
+}
+
+func TestCaptureEventAccessors(t *testing.T) {
+	status, ok := newStatusCaptureEvent(0, 1, 31).Status()
+	if !ok || status.Value != 31 {
+		t.Fatalf("status %+v, %v", status, ok)
+	}
+	if _, ok := (&CaptureEvent{Type: CaptureStreamStatus}).Status(); ok {
+		t.Fatal("status of empty data")
+	}
+
+	camera, quality, ok := newLiveViewCaptureEvent(0, 1, CameraTypePayload, StreamQuality1080p).LiveView()
+	if !ok || camera != CameraTypePayload || quality != StreamQuality1080p {
+		t.Fatalf("live-view %v %v %v", camera, quality, ok)
+	}
+	if _, _, ok := (&CaptureEvent{Type: CaptureLiveView, Data: []byte{1}}).LiveView(); ok {
+		t.Fatal("live-view without quality")
+	}
+
+	desc := &MediaFileDesc{FileName: "DJI_0001_W.JPG", FilePath: "mission/DJI_0001_W.JPG", FileSize: 1024, Latitude: 22.5}
+	got, err := newMediaFileCaptureEvent(0, desc).MediaFile()
+	if err != nil || !reflect.DeepEqual(got, desc) {
+		t.Fatalf("media file %+v, %v", got, err)
+	}
+	if _, err := (&CaptureEvent{Type: CaptureMediaFile, Data: []byte("{")}).MediaFile(); err == nil {
+		t.Fatal("invalid media file is read")
+	}
+
+	// the accessors reject the other types
+	log := &CaptureEvent{Type: CaptureLog, Data: []byte{1, 2}}
+	if _, ok := log.Status(); ok {
+		t.Fatal("status of a log event")
+	}
+	if _, _, ok := log.LiveView(); ok {
+		t.Fatal("live-view of a log event")
+	}
+	if _, err := log.MediaFile(); err == nil {
+		t.Fatal("media file of a log event")
+	}
+	if s := CaptureEventType(9).String(); s != "CaptureEventType(9)" {
+		t.Fatalf("name %q", s)
+	}
+}
@@ -32,10 +32,11 @@ import (
 
 //export esdkCgoCloudCustomMsgCallback
 func esdkCgoCloudCustomMsgCallback(buf *C.uint8_t, size C.uint32_t) {
+	b := C.GoBytes(unsafe.Pointer(buf), C.int(size))
+	captureCloudMessage(b)
 	if cloudCustomMsgHandler == nil {
 		return
 	}
-	b := C.GoBytes(unsafe.Pointer(buf), C.int(size))
 	cloudCustomMsgHandler(b)
 }
 
@@ -52,8 +53,10 @@ func SendCustomMessageToCloud(data []byte) error {
 	if size > 256 {
 		return errors.New("data size exceeds 256 bytes")
 	}
+	sdkCalls.add()
+	defer sdkCalls.done()
 	ret := C.Edge_Cloud_sendCustomEventsMessage((*C.uint8_t)(unsafe.SliceData(data)), C.uint32_t(size))
-	return convertCCodeToError(int(ret))
+	return sdkError("SendCustomMessageToCloud", int(ret))
 }
 
 // RegisterCloudCustomMsgHandler register a callback function via this interface to manage incoming data from the cloud.
@@ -62,7 +65,7 @@ func SendCustomMessageToCloud(data []byte) error {
 // move data to a buffer queue for asynchronous processing.
 func RegisterCloudCustomMsgHandler(handler func([]byte)) error {
 	ret := C.Edge_Cloud_registerCustomMsgHandler(C.CEdgeCloudCustomMsgHandler(C.esdkCgoCloudCustomMsgCallback))
-	if err := convertCCodeToError(int(ret)); err != nil {
+	if err := sdkError("RegisterCloudCustomMsgHandler", int(ret)); err != nil {
 		return err
 	}
 	cloudCustomMsgHandler = handler
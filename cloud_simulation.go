@@ -0,0 +1,30 @@
+//go:build !linux || fake_edge
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+// SendCustomMessageToCloud simulate sending custom event message to cloud,
+// allows for sending data up to 256 bytes, the message is written to the sdk log and the clients of the cloud endpoint of the default simulator.
+func SendCustomMessageToCloud(data []byte) error {
+	return defaultSimulator().SendCustomMessageToCloud(data)
+}
+
+// RegisterCloudCustomMsgHandler register a callback function via this interface to manage incoming data from the cloud.
+func RegisterCloudCustomMsgHandler(handler func([]byte)) error {
+	return defaultSimulator().RegisterCloudCustomMsgHandler(handler)
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command djiedge-cloud talks to the cloud endpoint of the simulator,
+// it injects custom messages from the cloud and prints the messages sent by the edge.
+//
+// Usage:
+//
+//	djiedge-cloud [-endpoint tcp://127.0.0.1:7788] send [-hex] <message>
+//	djiedge-cloud [-endpoint unix:///tmp/edge_cloud.sock] watch
+//	djiedge-cloud [-endpoint ...]                      # send the lines of stdin and print the messages
+//
+// It doesn't import the djiedge package, so it can be built without Edge-SDK.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// message is the json line of the endpoint, see djiedge.CloudMessage
+type message struct {
+	Type  string    `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+	Text  string    `json:"text,omitempty"`
+	Time  time.Time `json:"time,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+func main() {
+	defaultEndpoint := os.Getenv("DJIEDGE_SIM_CLOUD_ENDPOINT")
+	if defaultEndpoint == "" {
+		defaultEndpoint = "tcp://127.0.0.1:7788"
+	}
+	endpoint := flag.String("endpoint", defaultEndpoint, "the cloud endpoint of the simulator, tcp:// or unix://")
+	flag.Parse()
+
+	conn, err := dial(*endpoint)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	switch flag.Arg(0) {
+	case "send":
+		err = send(conn, flag.Args()[1:])
+	case "watch":
+		err = watch(conn)
+	case "":
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if err := write(conn, &message{Type: "down", Text: scanner.Text()}); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+		}()
+		err = watch(conn)
+	default:
+		err = fmt.Errorf("unknown command %q", flag.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func dial(endpoint string) (net.Conn, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		return net.Dial("tcp", u.Host)
+	case "unix":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		return net.Dial("unix", path)
+	}
+	return nil, fmt.Errorf("unsupported endpoint %q", endpoint)
+}
+
+func write(conn net.Conn, msg *message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(b, '\n'))
+	return err
+}
+
+func send(conn net.Conn, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	isHex := fs.Bool("hex", false, "the message is hex encoded")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: send [-hex] <message>")
+	}
+	msg := &message{Type: "down", Text: fs.Arg(0)}
+	if *isHex {
+		data, err := hex.DecodeString(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		msg = &message{Type: "down", Data: data}
+	}
+	if err := write(conn, msg); err != nil {
+		return err
+	}
+	// wait a moment for the rejection of the message
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var reply message
+		if json.Unmarshal(scanner.Bytes(), &reply) == nil && reply.Type == "error" {
+			return fmt.Errorf("rejected: %s", reply.Error)
+		}
+	}
+	return nil
+}
+
+func watch(conn net.Conn) error {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return err
+		}
+		switch {
+		case msg.Type == "error":
+			fmt.Println("error:", msg.Error)
+		case msg.Text != "":
+			fmt.Printf("%s %s %q\n", msg.Time.Format(time.RFC3339Nano), msg.Type, msg.Text)
+		default:
+			fmt.Printf("%s %s %x\n", msg.Time.Format(time.RFC3339Nano), msg.Type, msg.Data)
+		}
+	}
+	return scanner.Err()
+}
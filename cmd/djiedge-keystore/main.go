@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command djiedge-keystore manages the encrypted keystore read by the keystore SecretProvider.
+//
+// Usage:
+//
+//	djiedge-keystore -file <keystore> init
+//	djiedge-keystore -file <keystore> set <name> [file]   # the value is read from the file or stdin
+//	djiedge-keystore -file <keystore> delete <name>
+//	djiedge-keystore -file <keystore> list
+//
+// The passphrase is read from DJIEDGE_KEYSTORE_PASSPHRASE or the file of -passphrase-file.
+// It only uses the keystore, build it with '-tags fake_edge' on linux if Edge-SDK is not installed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	edge "github.com/lynnplus/go-djiedge"
+)
+
+func main() {
+	file := flag.String("file", "", "the keystore file")
+	passphraseFile := flag.String("passphrase-file", "", "the file of the passphrase, DJIEDGE_KEYSTORE_PASSPHRASE if empty")
+	flag.Parse()
+
+	if err := run(*file, *passphraseFile, flag.Args(), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(file, passphraseFile string, args []string, stdin io.Reader, stdout io.Writer) error {
+	if file == "" || len(args) == 0 {
+		return fmt.Errorf("usage: djiedge-keystore -file <keystore> init|set|delete|list")
+	}
+	passphrase := []byte(os.Getenv(edge.KeystorePassphraseEnv))
+	if passphraseFile != "" {
+		b, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return err
+		}
+		passphrase = bytes.TrimRight(b, "\r\n")
+	}
+	if len(passphrase) == 0 {
+		return fmt.Errorf("the passphrase is empty, set %s or -passphrase-file", edge.KeystorePassphraseEnv)
+	}
+
+	if args[0] == "init" {
+		ks, err := edge.CreateKeystore(file, passphrase)
+		if err != nil {
+			return err
+		}
+		defer ks.Close()
+		return ks.Save()
+	}
+
+	ks, err := edge.OpenKeystore(file, passphrase)
+	if err != nil {
+		return err
+	}
+	defer ks.Close()
+	switch args[0] {
+	case "set":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("usage: set <name> [file]")
+		}
+		var value []byte
+		if len(args) == 3 {
+			value, err = os.ReadFile(args[2])
+		} else {
+			value, err = io.ReadAll(stdin)
+			value = bytes.TrimRight(value, "\r\n")
+		}
+		if err != nil {
+			return err
+		}
+		ks.Set(args[1], value)
+		for i := range value {
+			value[i] = 0
+		}
+		return ks.Save()
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: delete <name>")
+		}
+		ks.Delete(args[1])
+		return ks.Save()
+	case "list":
+		for _, name := range ks.Names() {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	edge "github.com/lynnplus/go-djiedge"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secrets.ks")
+	t.Setenv(edge.KeystorePassphraseEnv, "correct horse")
+
+	exec := func(stdin string, args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(file, "", args, strings.NewReader(stdin), &out)
+		return out.String(), err
+	}
+	if _, err := exec("", "init"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec("", "init"); err == nil {
+		t.Error("the keystore is initialized twice")
+	}
+	// the value of stdin is trimmed, the value of a file is kept as it is
+	if _, err := exec("app-key\n", "set", edge.SecretAppKey); err != nil {
+		t.Fatal(err)
+	}
+	license := filepath.Join(dir, "license.txt")
+	if err := os.WriteFile(license, []byte("license\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec("", "set", edge.SecretAppLicense, license); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec("tmp", "set", "tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec("", "delete", "tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec("", "list"); err != nil || out != edge.SecretAppKey+"\n"+edge.SecretAppLicense+"\n" {
+		t.Errorf("list %q %v", out, err)
+	}
+
+	ks, err := edge.OpenKeystore(file, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	if v, _ := ks.Secret(edge.SecretAppKey); string(v) != "app-key" {
+		t.Errorf("app key %q", v)
+	}
+	if v, _ := ks.Secret(edge.SecretAppLicense); string(v) != "license\n" {
+		t.Errorf("license %q", v)
+	}
+}
+
+func TestRunPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secrets.ks")
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("from file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(edge.KeystorePassphraseEnv, "")
+	if err := run(file, "", []string{"init"}, nil, nil); err == nil {
+		t.Error("the keystore is initialized without passphrase")
+	}
+	if err := run(file, passphraseFile, []string{"init"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the wrong passphrase and the tampered file are rejected
+	t.Setenv(edge.KeystorePassphraseEnv, "from file!")
+	if err := run(file, "", []string{"list"}, nil, &bytes.Buffer{}); !errors.Is(err, edge.ErrKeystorePassphrase) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0x80
+	if err = os.WriteFile(file, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = run(file, passphraseFile, []string{"set", "name"}, strings.NewReader("value"), nil); !errors.Is(err, edge.ErrKeystorePassphrase) {
+		t.Errorf("tampered keystore: %v", err)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	t.Setenv(edge.KeystorePassphraseEnv, "pass")
+	file := filepath.Join(t.TempDir(), "secrets.ks")
+	if err := run(file, "", []string{"init"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{nil, {"unknown"}, {"set"}, {"set", "a", "b", "c"}, {"delete"}} {
+		if err := run(file, "", args, strings.NewReader(""), &bytes.Buffer{}); err == nil {
+			t.Errorf("%q: no error", args)
+		}
+	}
+	if err := run("", "", []string{"list"}, nil, nil); err == nil {
+		t.Error("no error without file")
+	}
+}
@@ -0,0 +1,615 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// environment variables read by LoadConfig, they override the values of the config file
+const (
+	ConfigFileEnv         = "DJIEDGE_CONFIG"
+	ProductNameEnv        = "DJIEDGE_PRODUCT_NAME"
+	VendorNameEnv         = "DJIEDGE_VENDOR_NAME"
+	SerialNumberEnv       = "DJIEDGE_SERIAL_NUMBER"
+	FirmwareVersionEnv    = "DJIEDGE_FIRMWARE_VERSION"
+	AppNameEnv            = "DJIEDGE_APP_NAME"
+	AppIdEnv              = "DJIEDGE_APP_ID"
+	AppKeyEnv             = "DJIEDGE_APP_KEY"
+	AppLicenseEnv         = "DJIEDGE_APP_LICENSE"
+	DeveloperAccountEnv   = "DJIEDGE_DEVELOPER_ACCOUNT"
+	PrivateKeyFileEnv     = "DJIEDGE_PRIVATE_KEY_FILE"
+	PublicKeyFileEnv      = "DJIEDGE_PUBLIC_KEY_FILE"
+	KeyPassphraseEnv      = "DJIEDGE_KEY_PASSPHRASE"
+	KeystorePassphraseEnv = "DJIEDGE_KEYSTORE_PASSPHRASE"
+	LogLevelEnv           = "DJIEDGE_LOG_LEVEL"
+	LogOutputEnv          = "DJIEDGE_LOG_OUTPUT"
+	LogFormatEnv          = "DJIEDGE_LOG_FORMAT"
+)
+
+// Config the parameters of InitSDK, loaded from a yaml, json or toml file by LoadConfig.
+//
+//	device:
+//	  product_name: edge
+//	  vendor_name: lynn
+//	  serial_number: SN0001
+//	  firmware_version: "1.0.0.1"
+//	auth:
+//	  name: my-app
+//	  id: "123456"
+//	  app_key: ...
+//	  license: ...
+//	  account: ...
+//	key:
+//	  private_key_file: /data/edge/private.pem
+//	log:
+//	  level: info
+type Config struct {
+	Device DeviceConfig `json:"device"`
+	Auth   AuthConfig   `json:"auth"`
+	Key    KeyConfig    `json:"key"`
+	Log    LogConfig    `json:"log"`
+	// Secrets provides the app key, the license and the private key missing in the config
+	Secrets SecretsConfig `json:"secrets"`
+	// DeInitOnFailed see InitSDK
+	DeInitOnFailed bool `json:"deinit_on_failed"`
+}
+
+// DeviceConfig see DeviceInfo
+type DeviceConfig struct {
+	ProductName  string `json:"product_name"`
+	VendorName   string `json:"vendor_name"`
+	SerialNumber string `json:"serial_number"`
+	// FirmwareVersion the version like "1.2.3.4", see ParseVersion
+	FirmwareVersion string `json:"firmware_version"`
+}
+
+// AuthConfig see AuthInfo
+type AuthConfig struct {
+	Name    string `json:"name"`
+	Id      string `json:"id"`
+	AppKey  string `json:"app_key"`
+	License string `json:"license"`
+	Account string `json:"account"`
+}
+
+// KeyConfig the files of the RSA2048 key, see LoadRSA2048Key and LoadRSA2048KeyPair.
+// the relative paths of a config file are relative to the directory of the file.
+type KeyConfig struct {
+	PrivateKeyFile string `json:"private_key_file"`
+	// PublicKeyFile is optional, it is checked to match the private key if set
+	PublicKeyFile string `json:"public_key_file"`
+	// Passphrase decrypts an encrypted private key, PassphraseFile reads it from a file instead
+	Passphrase     string `json:"passphrase"`
+	PassphraseFile string `json:"passphrase_file"`
+	// Generate generates the private key if the file doesn't exist, see LoadOrGenerateRSA2048Key
+	Generate bool `json:"generate"`
+}
+
+// SecretsConfig selects the SecretProvider of the secrets missing in the config,
+// the app key and the license are read if both are empty, the private key if key.private_key_file is empty.
+type SecretsConfig struct {
+	// Provider one of env, file and keystore, empty means no provider
+	Provider string `json:"provider"`
+	// Prefix the prefix of the variables of the env provider, see EnvSecretProvider
+	Prefix string `json:"prefix"`
+	// Dir the directory of the file provider, see FileSecretProvider
+	Dir string `json:"dir"`
+	// Keystore the file of the keystore provider, see OpenKeystore,
+	// the passphrase is read from KeystorePassphraseFile or DJIEDGE_KEYSTORE_PASSPHRASE.
+	Keystore               string `json:"keystore"`
+	KeystorePassphraseFile string `json:"keystore_passphrase_file"`
+}
+
+// LogConfig see Logger
+type LogConfig struct {
+	// Level one of error, warn, info and debug, the default is info
+	Level string `json:"level"`
+	// MaxLevel the level of the sdk, the most verbose level of the output and the sinks if empty.
+	// the sdk only produces the lines up to it, so the LogRouter of Logger.Router can't be raised above it at runtime.
+	MaxLevel string `json:"max_level"`
+	Colorful bool   `json:"colorful"`
+	// Output one of stdout, stderr and none, the default is stdout
+	Output string `json:"output"`
+	// Format one of raw, text and json, text and json are the slog records of the parsed lines, the default is raw
+	Format string `json:"format"`
+	// File writes the log to a rotating file too, see RotatingFileSink
+	File *LogFileConfig `json:"file"`
+	// Syslog writes the log to the syslog too, see SyslogSink
+	Syslog *LogSyslogConfig `json:"syslog"`
+}
+
+// LogFileConfig see RotatingFileSink and RotateOptions
+type LogFileConfig struct {
+	Path string `json:"path"`
+	// Level the level of the file, the level of the log if empty
+	Level string `json:"level"`
+	// MaxSize the limit of the size in megabytes, 100 if 0
+	MaxSize int64 `json:"max_size"`
+	// MaxAge the duration of keeping the rotated files, such as "168h", empty keeps them
+	MaxAge     string `json:"max_age"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+// LogSyslogConfig see NewSyslogSink
+type LogSyslogConfig struct {
+	// Network and Address of the daemon, empty means the local daemon
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Tag     string `json:"tag"`
+	// Level the level of the syslog, the level of the log if empty
+	Level string `json:"level"`
+}
+
+// LoadConfig loads the config from a file, then overrides it by the DJIEDGE_* environment variables and validates it.
+// the format is selected by the extension of the file: .yaml, .yml, .json or .toml,
+// an empty path loads the config only from the environment variables.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if cfg, err = ParseConfig(b, strings.TrimPrefix(filepath.Ext(path), ".")); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+		cfg.resolve(filepath.Dir(path))
+	}
+	cfg.ApplyEnv()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ConfigFromEnv loads the config from the file named by DJIEDGE_CONFIG, see LoadConfig
+func ConfigFromEnv() (*Config, error) {
+	return LoadConfig(os.Getenv(ConfigFileEnv))
+}
+
+// ParseConfig decodes the config of the format: yaml, yml, json or toml, the unknown fields are rejected.
+// it is not validated.
+func ParseConfig(data []byte, format string) (*Config, error) {
+	var doc any
+	switch strings.ToLower(format) {
+	case "json":
+		doc = json.RawMessage(data)
+	case "yaml", "yml":
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		yamlFloatsToStrings(&node)
+		if err := node.Decode(&doc); err != nil {
+			return nil, err
+		}
+		doc = stringifyNumbers(doc, reflect.TypeOf(Config{}))
+	case "toml":
+		var m map[string]any
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return nil, err
+		}
+		doc = stringifyNumbers(m, reflect.TypeOf(Config{}))
+	default:
+		return nil, fmt.Errorf("unknown config format %q, want yaml, json or toml", format)
+	}
+	cfg := &Config{}
+	if doc == nil {
+		return cfg, nil
+	}
+	// yaml and toml are converted to json, so the fields and the errors are the same in all formats
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// yamlFloatsToStrings keeps the floats as written, such as the version 1.10, see stringifyNumbers
+func yamlFloatsToStrings(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!float" {
+		n.Tag = "!!str"
+	}
+	for _, c := range n.Content {
+		yamlFloatsToStrings(c)
+	}
+}
+
+// stringifyNumbers converts the numbers of the string fields of t to strings,
+// so the values like the app id 123456 don't have to be quoted in yaml and toml.
+func stringifyNumbers(v any, t reflect.Type) any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch value := v.(type) {
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return v
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if item, ok := value[name]; ok {
+				value[name] = stringifyNumbers(item, f.Type)
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice {
+			for i, item := range value {
+				value[i] = stringifyNumbers(item, t.Elem())
+			}
+		}
+	case int, int64, uint64, float64:
+		if t.Kind() == reflect.String {
+			return fmt.Sprint(value)
+		}
+	}
+	return v
+}
+
+// ApplyEnv overrides the config by the DJIEDGE_* environment variables which are set
+func (c *Config) ApplyEnv() {
+	strs := []struct {
+		env string
+		dst *string
+	}{
+		{ProductNameEnv, &c.Device.ProductName},
+		{VendorNameEnv, &c.Device.VendorName},
+		{SerialNumberEnv, &c.Device.SerialNumber},
+		{FirmwareVersionEnv, &c.Device.FirmwareVersion},
+		{AppNameEnv, &c.Auth.Name},
+		{AppIdEnv, &c.Auth.Id},
+		{AppKeyEnv, &c.Auth.AppKey},
+		{AppLicenseEnv, &c.Auth.License},
+		{DeveloperAccountEnv, &c.Auth.Account},
+		{PrivateKeyFileEnv, &c.Key.PrivateKeyFile},
+		{PublicKeyFileEnv, &c.Key.PublicKeyFile},
+		{LogLevelEnv, &c.Log.Level},
+		{LogOutputEnv, &c.Log.Output},
+		{LogFormatEnv, &c.Log.Format},
+	}
+	for _, s := range strs {
+		if v := os.Getenv(s.env); v != "" {
+			*s.dst = v
+		}
+	}
+	if v := os.Getenv(KeyPassphraseEnv); v != "" {
+		c.Key.Passphrase, c.Key.PassphraseFile = v, ""
+	}
+}
+
+// Validate checks the config, all the invalid fields are reported
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(field, v string) {
+		if strings.TrimSpace(v) == "" {
+			errs = append(errs, fmt.Errorf("config: %s is required", field))
+		}
+	}
+	// dji bug,an exception occurs when sn is empty
+	required("device.serial_number", c.Device.SerialNumber)
+	if c.Device.FirmwareVersion != "" {
+		if _, err := ParseVersion(c.Device.FirmwareVersion); err != nil {
+			errs = append(errs, fmt.Errorf("config: device.firmware_version %q is invalid, want 'major.minor.modify.debug': %w", c.Device.FirmwareVersion, err))
+		}
+	}
+	required("auth.id", c.Auth.Id)
+	if c.Secrets.Provider == "" || c.Auth.AppKey != "" || c.Auth.License != "" {
+		required("auth.app_key", c.Auth.AppKey)
+		required("auth.license", c.Auth.License)
+	}
+	required("auth.account", c.Auth.Account)
+
+	if c.Secrets.Provider == "" || c.Key.Generate {
+		required("key.private_key_file", c.Key.PrivateKeyFile)
+	}
+	if c.Key.Passphrase != "" && c.Key.PassphraseFile != "" {
+		errs = append(errs, errors.New("config: key.passphrase and key.passphrase_file are exclusive"))
+	}
+	if c.Key.Generate && (c.Key.PublicKeyFile != "" || c.Key.Passphrase != "" || c.Key.PassphraseFile != "") {
+		errs = append(errs, errors.New("config: key.generate writes an unencrypted private key, key.public_key_file and the passphrase are not allowed"))
+	}
+
+	if c.Log.Level != "" {
+		if _, err := ParseLogLevel(c.Log.Level); err != nil {
+			errs = append(errs, fmt.Errorf("config: log.level: %w", err))
+		}
+	}
+	if c.Log.MaxLevel != "" {
+		if _, err := ParseLogLevel(c.Log.MaxLevel); err != nil {
+			errs = append(errs, fmt.Errorf("config: log.max_level: %w", err))
+		}
+	}
+	switch c.Log.Output {
+	case "", "stdout", "stderr", "none":
+	default:
+		errs = append(errs, fmt.Errorf("config: log.output %q is invalid, want stdout, stderr or none", c.Log.Output))
+	}
+	switch c.Log.Format {
+	case "", "raw", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("config: log.format %q is invalid, want raw, text or json", c.Log.Format))
+	}
+	if f := c.Log.File; f != nil {
+		required("log.file.path", f.Path)
+		if f.Level != "" {
+			if _, err := ParseLogLevel(f.Level); err != nil {
+				errs = append(errs, fmt.Errorf("config: log.file.level: %w", err))
+			}
+		}
+		if f.MaxSize < 0 || f.MaxBackups < 0 {
+			errs = append(errs, errors.New("config: log.file.max_size and log.file.max_backups must not be negative"))
+		}
+		if f.MaxAge != "" {
+			if d, err := time.ParseDuration(f.MaxAge); err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("config: log.file.max_age %q is invalid, want a duration like 168h", f.MaxAge))
+			}
+		}
+	}
+	if sl := c.Log.Syslog; sl != nil && sl.Level != "" {
+		if _, err := ParseLogLevel(sl.Level); err != nil {
+			errs = append(errs, fmt.Errorf("config: log.syslog.level: %w", err))
+		}
+	}
+	switch c.Secrets.Provider {
+	case "", "env":
+	case "file":
+		required("secrets.dir", c.Secrets.Dir)
+	case "keystore":
+		required("secrets.keystore", c.Secrets.Keystore)
+	default:
+		errs = append(errs, fmt.Errorf("config: secrets.provider %q is invalid, want env, file or keystore", c.Secrets.Provider))
+	}
+	return errors.Join(errs...)
+}
+
+// InitParams returns the parameters of InitSDK, the key is loaded from the files or the SecretProvider.
+// the sinks of the log are owned by the LogRouter of Logger.Router, which changes their levels at runtime
+// and is closed after DeInitSDK.
+func (c *Config) InitParams() (*DeviceInfo, *AuthInfo, *RSA2048Key, *Logger, error) {
+	if err := c.Validate(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	device := &DeviceInfo{
+		ProductName:  c.Device.ProductName,
+		VendorName:   c.Device.VendorName,
+		SerialNumber: c.Device.SerialNumber,
+	}
+	if c.Device.FirmwareVersion != "" {
+		v, _ := ParseVersion(c.Device.FirmwareVersion)
+		device.FirmwareVersion = *v
+	}
+	auth := &AuthInfo{
+		Name:    c.Auth.Name,
+		Id:      c.Auth.Id,
+		AppKey:  c.Auth.AppKey,
+		License: c.Auth.License,
+		Account: c.Auth.Account,
+	}
+	provider, err := c.Secrets.provider()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if closer, ok := provider.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
+	if provider != nil && auth.AppKey == "" && auth.License == "" {
+		if err = auth.LoadSecrets(provider); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("config: load auth secrets: %w", err)
+		}
+	}
+	var key *RSA2048Key
+	if provider != nil && c.Key.PrivateKeyFile == "" {
+		key, err = LoadRSA2048KeyFromSecrets(provider)
+	} else {
+		key, err = c.Key.load()
+	}
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	logger, err := c.Log.logger()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return device, auth, key, logger, nil
+}
+
+// InitSDKFromConfig loads the config by LoadConfig and initializes the sdk,
+// an empty path uses the file named by DJIEDGE_CONFIG.
+// it returns the router of the log, nil if the log is disabled, see Config.InitParams.
+func InitSDKFromConfig(path string) (*LogRouter, error) {
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	device, auth, key, logger, err := cfg.InitParams()
+	if err != nil {
+		return nil, err
+	}
+	router := logger.Router()
+	if err = InitSDK(device, auth, key, logger, cfg.DeInitOnFailed); err != nil {
+		if router != nil {
+			_ = router.Close()
+		}
+		return nil, err
+	}
+	return router, nil
+}
+
+// resolve makes the relative paths relative to dir
+func (c *Config) resolve(dir string) {
+	paths := []*string{
+		&c.Key.PrivateKeyFile, &c.Key.PublicKeyFile, &c.Key.PassphraseFile,
+		&c.Secrets.Dir, &c.Secrets.Keystore, &c.Secrets.KeystorePassphraseFile,
+	}
+	if c.Log.File != nil {
+		paths = append(paths, &c.Log.File.Path)
+	}
+	for _, p := range paths {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+}
+
+func (k *KeyConfig) load() (*RSA2048Key, error) {
+	if k.Generate {
+		return LoadOrGenerateRSA2048Key(k.PrivateKeyFile)
+	}
+	passphrase := []byte(k.Passphrase)
+	if k.PassphraseFile != "" {
+		b, err := readPassphraseFile(k.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("config: key.passphrase_file: %w", err)
+		}
+		passphrase = b
+	}
+	defer zeroBytes(passphrase)
+	if k.PublicKeyFile != "" {
+		return LoadRSA2048KeyPair(k.PrivateKeyFile, k.PublicKeyFile, passphrase)
+	}
+	return LoadRSA2048Key(k.PrivateKeyFile, passphrase)
+}
+
+func (s *SecretsConfig) provider() (SecretProvider, error) {
+	switch s.Provider {
+	case "env":
+		return &EnvSecretProvider{Prefix: s.Prefix}, nil
+	case "file":
+		return &FileSecretProvider{Dir: s.Dir}, nil
+	case "keystore":
+		passphrase := []byte(os.Getenv(KeystorePassphraseEnv))
+		if s.KeystorePassphraseFile != "" {
+			b, err := readPassphraseFile(s.KeystorePassphraseFile)
+			if err != nil {
+				return nil, fmt.Errorf("config: secrets.keystore_passphrase_file: %w", err)
+			}
+			passphrase = b
+		}
+		defer zeroBytes(passphrase)
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("config: the passphrase of secrets.keystore is required, set %s or secrets.keystore_passphrase_file", KeystorePassphraseEnv)
+		}
+		return OpenKeystore(s.Keystore, passphrase)
+	}
+	return nil, nil
+}
+
+// readPassphraseFile reads the passphrase without the trailing newline, see FileSecretProvider
+func readPassphraseFile(path string) ([]byte, error) {
+	return (&FileSecretProvider{Dir: filepath.Dir(path)}).Secret(filepath.Base(path))
+}
+
+// logger returns the logger routing the log to the output and the sinks of the config, nil if they are all disabled
+func (l *LogConfig) logger() (*Logger, error) {
+	level := parseLogLevelOr(l.Level, LogLevelInfo)
+	router := NewLogRouter(level)
+	sinks := 0
+	// the sdk produces the lines of the most verbose sink
+	maxLevel := level
+	if l.Output != "none" {
+		out := os.Stdout
+		if l.Output == "stderr" {
+			out = os.Stderr
+		}
+		opts := &slog.HandlerOptions{Level: level.slogLevel()}
+		switch l.Format {
+		case "text":
+			router.AddSink(NewSlogSink(slog.NewTextHandler(out, opts)), level)
+		case "json":
+			router.AddSink(NewSlogSink(slog.NewJSONHandler(out, opts)), level)
+		default:
+			router.AddSink(NewWriterSink(out), level)
+		}
+		sinks++
+	}
+	if f := l.File; f != nil {
+		maxAge, _ := time.ParseDuration(f.MaxAge)
+		sink, err := NewRotatingFileSink(f.Path, RotateOptions{MaxSize: f.MaxSize << 20, MaxAge: maxAge, MaxBackups: f.MaxBackups})
+		if err != nil {
+			return nil, fmt.Errorf("config: log.file: %w", err)
+		}
+		fileLevel := parseLogLevelOr(f.Level, level)
+		router.AddSink(sink, fileLevel)
+		maxLevel = max(maxLevel, fileLevel)
+		sinks++
+	}
+	if sl := l.Syslog; sl != nil {
+		sink, err := NewSyslogSink(sl.Network, sl.Address, sl.Tag)
+		if err != nil {
+			_ = router.Close()
+			return nil, fmt.Errorf("config: log.syslog: %w", err)
+		}
+		syslogLevel := parseLogLevelOr(sl.Level, level)
+		router.AddSink(sink, syslogLevel)
+		maxLevel = max(maxLevel, syslogLevel)
+		sinks++
+	}
+	if sinks == 0 {
+		return nil, nil
+	}
+	router.SetLevel(maxLevel)
+	if l.MaxLevel != "" {
+		maxLevel = max(maxLevel, parseLogLevelOr(l.MaxLevel, maxLevel))
+	}
+	logger := router.Logger(maxLevel)
+	logger.EnableColorful = l.Colorful
+	return logger, nil
+}
+
+func parseLogLevelOr(s string, def LogLevel) LogLevel {
+	if level, err := ParseLogLevel(s); err == nil {
+		return level
+	}
+	return def
+}
+
+// ParseLogLevel parses the name of a LogLevel: error, warn, info or debug
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error":
+		return LogLevelError, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "debug":
+		return LogLevelDebug, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, want error, warn, info or debug", s)
+}
@@ -0,0 +1,386 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogConfigRouter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.log")
+	tests := []struct {
+		name              string
+		config            LogConfig
+		sdkLevel, routing LogLevel
+	}{
+		{"sinks", LogConfig{Level: "warn", Output: "none", File: &LogFileConfig{Path: path, Level: "info"}},
+			LogLevelInfo, LogLevelInfo},
+		{"max level", LogConfig{Level: "warn", MaxLevel: "debug", Output: "none", File: &LogFileConfig{Path: path}},
+			LogLevelDebug, LogLevelWarn},
+		{"max level below the sinks", LogConfig{Level: "info", MaxLevel: "error", Output: "none", File: &LogFileConfig{Path: path}},
+			LogLevelInfo, LogLevelInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := tt.config.logger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := logger.Router()
+			if router == nil {
+				t.Fatal("the router is not reachable")
+			}
+			defer router.Close()
+			if logger.Level != tt.sdkLevel || router.Level() != tt.routing {
+				t.Errorf("sdk level %v router level %v, want %v %v", logger.Level, router.Level(), tt.sdkLevel, tt.routing)
+			}
+		})
+	}
+
+	logger, err := (&LogConfig{Output: "none"}).logger()
+	if err != nil || logger != nil || logger.Router() != nil {
+		t.Errorf("the disabled log has a logger %+v %v", logger, err)
+	}
+	if (&Logger{}).Router() != nil {
+		t.Error("a logger not created by a router has a router")
+	}
+}
+
+func TestLogConfigRouterRuntimeLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.log")
+	logger, err := (&LogConfig{Level: "warn", MaxLevel: "debug", Output: "none", File: &LogFileConfig{Path: path}}).logger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := logger.Router()
+	routes := router.Routes()
+	if len(routes) != 1 || routes[0].Level() != LogLevelWarn {
+		t.Fatalf("routes %v", routes)
+	}
+	logger.Outputer("[Debug]-[a.cc:1] before")
+	router.SetLevel(LogLevelDebug)
+	routes[0].SetLevel(LogLevelDebug)
+	logger.Outputer("[Debug]-[a.cc:2] after")
+	if err = router.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); strings.Contains(s, "before") || !strings.Contains(s, "after") {
+		t.Errorf("log file %q", s)
+	}
+}
+
+func TestInitSDKFromConfigRouter(t *testing.T) {
+	dir := t.TempDir()
+	config := `
+device: {serial_number: SN0001}
+auth: {id: "1", app_key: key, license: license, account: account}
+key: {generate: true, private_key_file: private.pem}
+log: {output: none, file: {path: edge.log}}
+deinit_on_failed: true
+`
+	path := filepath.Join(dir, "edge.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	router, err := InitSDKFromConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	defer DeInitSDK()
+	if router == nil {
+		t.Fatal("no router is returned")
+	}
+	router.SetLevel(LogLevelError)
+
+	if router, err = InitSDKFromConfig(filepath.Join(dir, "missing.yaml")); err == nil || router != nil {
+		t.Errorf("missing config: %v %v", router, err)
+	}
+}
+
+// testConfig returns a valid config
+func testConfig() *Config {
+	return &Config{
+		Device: DeviceConfig{ProductName: "edge", VendorName: "lynn", SerialNumber: "SN0001", FirmwareVersion: "1.10.0.1"},
+		Auth:   AuthConfig{Name: "my-app", Id: "123456", AppKey: "key", License: "license", Account: "account"},
+		Key:    KeyConfig{PrivateKeyFile: "/data/edge/private.pem"},
+		Log:    LogConfig{Level: "debug", Output: "stderr", File: &LogFileConfig{Path: "/var/log/edge.log", MaxSize: 10, MaxAge: "168h"}},
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	docs := map[string]string{
+		"yaml": `
+device:
+  product_name: edge
+  vendor_name: lynn
+  serial_number: SN0001
+  firmware_version: 1.10.0.1
+auth: {name: my-app, id: 123456, app_key: key, license: license, account: account}
+key:
+  private_key_file: /data/edge/private.pem
+log:
+  level: debug
+  output: stderr
+  file: {path: /var/log/edge.log, max_size: 10, max_age: 168h}
+`,
+		"json": `{
+	"device": {"product_name": "edge", "vendor_name": "lynn", "serial_number": "SN0001", "firmware_version": "1.10.0.1"},
+	"auth": {"name": "my-app", "id": "123456", "app_key": "key", "license": "license", "account": "account"},
+	"key": {"private_key_file": "/data/edge/private.pem"},
+	"log": {"level": "debug", "output": "stderr", "file": {"path": "/var/log/edge.log", "max_size": 10, "max_age": "168h"}}
+}`,
+		"toml": `
+[device]
+product_name = "edge"
+vendor_name = "lynn"
+serial_number = "SN0001"
+firmware_version = "1.10.0.1"
+[auth]
+name = "my-app"
+id = 123456
+app_key = "key"
+license = "license"
+account = "account"
+[key]
+private_key_file = "/data/edge/private.pem"
+[log]
+level = "debug"
+output = "stderr"
+[log.file]
+path = "/var/log/edge.log"
+max_size = 10
+max_age = "168h"
+`,
+	}
+	want := testConfig()
+	for format, doc := range docs {
+		cfg, err := ParseConfig([]byte(doc), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if cfg.Device != want.Device || cfg.Auth != want.Auth || cfg.Key != want.Key ||
+			cfg.Log.Level != want.Log.Level || cfg.Log.Output != want.Log.Output || cfg.Log.File == nil || *cfg.Log.File != *want.Log.File {
+			t.Errorf("%s: got %+v", format, cfg)
+		}
+	}
+
+	// the floats of yaml are kept as written
+	cfg, err := ParseConfig([]byte("device: {firmware_version: 1.10}\nauth: {id: 1.50}"), "yml")
+	if err != nil || cfg.Device.FirmwareVersion != "1.10" || cfg.Auth.Id != "1.50" {
+		t.Errorf("yaml floats: %+v %v", cfg, err)
+	}
+	if cfg, err = ParseConfig(nil, "yaml"); err != nil || cfg == nil {
+		t.Errorf("empty yaml: %v %v", cfg, err)
+	}
+
+	invalid := []struct {
+		format, doc string
+	}{
+		{"yaml", "device: {serial: SN0001}"},
+		{"json", `{"device": {"serial": "SN0001"}}`},
+		{"toml", "[device]\nserial = \"SN0001\""},
+		{"yaml", "device: ["},
+		{"json", `{"device": `},
+		{"toml", "[device"},
+		{"json", `{"log": {"file": {"max_size": "10"}}}`},
+		{"ini", "serial_number=SN0001"},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseConfig([]byte(tt.doc), tt.format); err == nil {
+			t.Errorf("%s %q is accepted", tt.format, tt.doc)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("edge.toml", `
+deinit_on_failed = true
+[device]
+serial_number = "SN0001"
+[auth]
+id = 1
+app_key = "key"
+license = "license"
+account = "account"
+[key]
+private_key_file = "keys/private.pem"
+public_key_file = "/data/public.pem"
+[log.file]
+path = "log/edge.log"
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Key.PrivateKeyFile != filepath.Join(dir, "keys/private.pem") || cfg.Key.PublicKeyFile != "/data/public.pem" ||
+		cfg.Log.File.Path != filepath.Join(dir, "log/edge.log") || !cfg.DeInitOnFailed {
+		t.Errorf("config %+v", cfg)
+	}
+
+	t.Setenv(SerialNumberEnv, "SN0002")
+	if cfg, err = LoadConfig(path); err != nil || cfg.Device.SerialNumber != "SN0002" {
+		t.Errorf("env override: %+v %v", cfg, err)
+	}
+
+	t.Setenv(LogLevelEnv, "verbose")
+	if _, err = LoadConfig(path); err == nil || !strings.Contains(err.Error(), "log.level") {
+		t.Errorf("invalid env: %v", err)
+	}
+	t.Setenv(LogLevelEnv, "")
+
+	if _, err = LoadConfig(write("bad.yaml", "device: {serial: SN0001}")); err == nil || !strings.Contains(err.Error(), "bad.yaml") {
+		t.Errorf("unknown field: %v", err)
+	}
+	if _, err = LoadConfig(write("edge.ini", "")); err == nil {
+		t.Error("unknown extension is accepted")
+	}
+	if _, err = LoadConfig(filepath.Join(dir, "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+
+	// only the environment variables
+	for env, v := range map[string]string{AppIdEnv: "1", AppKeyEnv: "key", AppLicenseEnv: "license",
+		DeveloperAccountEnv: "account", PrivateKeyFileEnv: "private.pem"} {
+		t.Setenv(env, v)
+	}
+	t.Setenv(ConfigFileEnv, "")
+	if cfg, err = ConfigFromEnv(); err != nil || cfg.Device.SerialNumber != "SN0002" || cfg.Key.PrivateKeyFile != "private.pem" {
+		t.Errorf("config from env: %+v %v", cfg, err)
+	}
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	envs := map[string]string{
+		ProductNameEnv:      "product",
+		VendorNameEnv:       "vendor",
+		SerialNumberEnv:     "SN0002",
+		FirmwareVersionEnv:  "2.0.0.0",
+		AppNameEnv:          "app",
+		AppIdEnv:            "654321",
+		AppKeyEnv:           "env-key",
+		AppLicenseEnv:       "env-license",
+		DeveloperAccountEnv: "env-account",
+		PrivateKeyFileEnv:   "/env/private.pem",
+		PublicKeyFileEnv:    "/env/public.pem",
+		LogLevelEnv:         "warn",
+		LogOutputEnv:        "none",
+		LogFormatEnv:        "json",
+	}
+	for env, v := range envs {
+		t.Setenv(env, v)
+	}
+	t.Setenv(KeyPassphraseEnv, "secret")
+
+	cfg := testConfig()
+	cfg.Key.PassphraseFile = "/data/passphrase"
+	cfg.ApplyEnv()
+	want := Config{
+		Device: DeviceConfig{ProductName: "product", VendorName: "vendor", SerialNumber: "SN0002", FirmwareVersion: "2.0.0.0"},
+		Auth:   AuthConfig{Name: "app", Id: "654321", AppKey: "env-key", License: "env-license", Account: "env-account"},
+		Key:    KeyConfig{PrivateKeyFile: "/env/private.pem", PublicKeyFile: "/env/public.pem", Passphrase: "secret"},
+	}
+	if cfg.Device != want.Device || cfg.Auth != want.Auth || cfg.Key != want.Key {
+		t.Errorf("got %+v", cfg)
+	}
+	if cfg.Log.Level != "warn" || cfg.Log.Output != "none" || cfg.Log.Format != "json" || cfg.Log.File.Path != "/var/log/edge.log" {
+		t.Errorf("log %+v", cfg.Log)
+	}
+
+	// the empty variables don't override
+	for env := range envs {
+		t.Setenv(env, "")
+	}
+	t.Setenv(KeyPassphraseEnv, "")
+	cfg = testConfig()
+	cfg.ApplyEnv()
+	if want := testConfig(); cfg.Device != want.Device || cfg.Auth != want.Auth || cfg.Key != want.Key || cfg.Log.Level != want.Log.Level {
+		t.Errorf("empty variables override the config: %+v", cfg)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := testConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"required", func(c *Config) { c.Device.SerialNumber, c.Auth.Id, c.Auth.Account = " ", "", "" },
+			[]string{"device.serial_number", "auth.id", "auth.account"}},
+		{"secrets in the config", func(c *Config) { c.Auth.AppKey, c.Auth.License, c.Key.PrivateKeyFile = "", "", "" },
+			[]string{"auth.app_key", "auth.license", "key.private_key_file"}},
+		{"firmware version", func(c *Config) { c.Device.FirmwareVersion = "1.256.0.0" }, []string{"device.firmware_version"}},
+		{"passphrase", func(c *Config) { c.Key.Passphrase, c.Key.PassphraseFile = "a", "b" }, []string{"exclusive"}},
+		{"generate", func(c *Config) { c.Key.Generate, c.Key.PublicKeyFile = true, "public.pem" }, []string{"key.generate"}},
+		{"log", func(c *Config) {
+			c.Log.Level, c.Log.MaxLevel, c.Log.Output, c.Log.Format = "trace", "all", "file", "xml"
+		},
+			[]string{"log.level", "log.max_level", "log.output", "log.format"}},
+		{"log file", func(c *Config) { c.Log.File = &LogFileConfig{Level: "trace", MaxSize: -1, MaxAge: "7d"} },
+			[]string{"log.file.path", "log.file.level", "log.file.max_size", "log.file.max_age"}},
+		{"syslog", func(c *Config) { c.Log.Syslog = &LogSyslogConfig{Level: "trace"} }, []string{"log.syslog.level"}},
+		{"file provider", func(c *Config) { c.Secrets.Provider = "file" }, []string{"secrets.dir"}},
+		{"keystore provider", func(c *Config) { c.Secrets.Provider = "keystore" }, []string{"secrets.keystore"}},
+		{"unknown provider", func(c *Config) { c.Secrets.Provider = "vault" }, []string{"secrets.provider"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("the config is valid")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("%q is not reported: %v", w, err)
+				}
+			}
+		})
+	}
+
+	// the secrets of a provider are not required in the config
+	cfg := testConfig()
+	cfg.Secrets.Provider = "env"
+	cfg.Auth.AppKey, cfg.Auth.License, cfg.Key.PrivateKeyFile = "", "", ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("secrets of the provider: %v", err)
+	}
+	cfg.Auth.AppKey = "key"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "auth.license") {
+		t.Errorf("partial secrets in the config: %v", err)
+	}
+}
@@ -28,24 +28,25 @@ void esdkCallGoLogger(uint8_t *data, uint32_t dataLen);
 */
 import "C"
 import (
-	"errors"
-	"fmt"
+	"bytes"
+	"os"
 	"runtime"
 	"sync/atomic"
 	"unsafe"
 )
 
-var sdkLogHandler func(string)
+// sdkLog the writer of the logger passed to InitSDK
+var sdkLog atomic.Pointer[sdkLogWriter]
 
 //export esdkCallGoLogger
 func esdkCallGoLogger(data *C.uint8_t, dataLen C.uint32_t) {
-	if sdkLogHandler == nil {
+	if data == nil || dataLen == 0 {
 		return
 	}
-	//int(dataLen)-2 remove the last newline character(\r\n)
-	tmp := unsafe.Slice((*byte)(data), int(dataLen)-2)
-	str := string(tmp)
-	sdkLogHandler(str)
+	// a write is usually a line ending with \r\n, but it may also be a part of a line or several lines
+	tmp := unsafe.Slice((*byte)(data), int(dataLen))
+	captureLog(bytes.TrimRight(tmp, "\r\n"))
+	sdkLog.Load().write(tmp)
 }
 
 func convertToCVersion(version *FirmwareVersion) C.CEdgeVersion {
@@ -57,59 +58,76 @@ func convertToCVersion(version *FirmwareVersion) C.CEdgeVersion {
 	}
 }
 
-var initState atomic.Int32 //0:none  1: initializing  2:initialized
+// sdkLifecycle the lifecycle of the process-wide sdk instance
+var sdkLifecycle lifecycle
 
 // Initialized returns whether the sdk instance has been initialized
 func Initialized() bool {
-	return initState.Load() == 2
+	return sdkLifecycle.State() == StateReady
+}
+
+// State returns the lifecycle state of the sdk instance
+func State() LifecycleState {
+	return sdkLifecycle.State()
+}
+
+// SubscribeState calls fn on every transition of the lifecycle state until unsubscribe is called,
+// fn is called in the goroutine changing the state, it should not block.
+func SubscribeState(fn func(StateTransition)) (unsubscribe func()) {
+	return sdkLifecycle.SubscribeState(fn)
 }
 
 // InitSDK initialize edge-sdk
 // deInitOnFailed: de-initialize the sdk after failure,because DJI will have some threads continuing to run after initialization failure.
+// without it, DeInitSDK is required after a failure before calling InitSDK again.
 func InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) (err error) {
-	if !initState.CompareAndSwap(0, 1) {
-		return errors.New("sdk is initializing or initialized")
+	if err = sdkLifecycle.beginInit(); err != nil {
+		return err
 	}
+	called := false
 	defer func() {
-		if err != nil {
-			initState.Store(0)
-		} else {
-			initState.Store(2)
-		}
+		// Edge_init de-initializes the sdk itself with deInitOnFailed
+		sdkLifecycle.finishInit(err, !called || deInitOnFailed)
 	}()
-
-	if device == nil || auth == nil || key == nil {
-		return errors.New("parameter is nil")
+	if path := os.Getenv(CaptureFileEnv); path != "" && activeCapture.Load() == nil {
+		if err = startCapture(path, true); err != nil {
+			return err
+		}
 	}
-	// dji bug,an exception occurs when sn is empty
-	if device.SerialNumber == "" {
-		return errors.New("parameter is nil of device sn")
+
+	if err = validateInitParams(device, auth, key, logger); err != nil {
+		return err
 	}
 
+	// the secrets are copied to C memory zeroed after Edge_init has copied them
+	appKey, freeAppKey := convertSecretToCString(auth.appKey())
+	defer freeAppKey()
+	license, freeLicense := convertSecretToCString(auth.license())
+	defer freeLicense()
+	privateKey, freePrivateKey := convertSecretToCString(key.privateKey())
+	defer freePrivateKey()
+
 	appInfo := C.CEdgeAppInfo{
 		app_name:          convertToCString(auth.Name),
 		app_id:            convertToCString(auth.Id),
-		app_key:           convertToCString(auth.AppKey),
-		app_license:       convertToCString(auth.License),
+		app_key:           appKey,
+		app_license:       license,
 		developer_account: convertToCString(auth.Account),
 	}
 	ks := C.CEdgeKeyStore{
-		private_key: convertToCString(key.PrivateKey),
+		private_key: privateKey,
 		public_key:  convertToCString(key.PublicKey),
 	}
 
 	var logs C.CEdgeLogger
 	if logger != nil {
-		if !logger.Level.IsValid() {
-			return fmt.Errorf("%v is not a valid log level", logger.Level)
-		}
 		logs = C.CEdgeLogger{
 			level:            C.int(logger.Level),
 			is_support_color: C.bool(logger.EnableColorful),
 			output:           C.CEdgeLogOutput(C.esdkCallGoLogger),
 		}
-		sdkLogHandler = logger.Outputer
 	}
+	sdkLog.Store(newSDKLogWriter(logger))
 
 	opts := C.CEdgeInitOptions{
 		product_name:     convertToCString(device.ProductName),
@@ -121,18 +139,36 @@ func InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger
 		logger:           logs,
 	}
 
+	called = true
 	ret := C.Edge_init(opts, C.bool(deInitOnFailed))
 	runtime.KeepAlive(device)
 	runtime.KeepAlive(auth)
 	runtime.KeepAlive(key)
-	return convertCCodeToError(int(ret))
+	return sdkError("InitSDK", int(ret))
 }
 
-// DeInitSDK will de-initialize SDK environment
-func DeInitSDK() error {
-	if !initState.CompareAndSwap(1, 0) {
-		return errors.New("sdk is not initialized")
+// DeInitSDK will de-initialize SDK environment,
+// it is allowed after a failed InitSDK to stop the threads left by the sdk,
+// the sdk de-initialized by deInitOnFailed is not de-initialized again, only the state is reset.
+// it waits for the calls still running in the sdk, such as the ones abandoned by the context variants.
+func DeInitSDK() (err error) {
+	released, err := sdkLifecycle.beginDeInit()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		sdkLifecycle.finishDeInit(err)
+	}()
+	if released {
+		sdkLog.Load().flush()
+		stopEnvCapture()
+		return nil
 	}
+	// the calls still running in the sdk, such as the ones abandoned by the context variants,
+	// must return before the sdk is de-initialized
+	sdkCalls.wait()
 	ret := C.Edge_deInit()
-	return convertCCodeToError(int(ret))
+	sdkLog.Load().flush()
+	stopEnvCapture()
+	return sdkError("DeInitSDK", int(ret))
 }
@@ -16,191 +16,86 @@
  * limitations under the License.
  */
 
+// Package djiedge provides Go language bindings for the dji-edge-sdk.
 package djiedge
 
 import (
-	"bufio"
-	"bytes"
-	"errors"
 	"fmt"
-	"io"
-	"os"
 	"sync"
-	"sync/atomic"
-	"time"
 )
 
-const fakeEdgeStreamFileName = "edge_stream.h264"
-
-func InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) (err error) {
-	time.Sleep(5 * time.Second)
-	return nil
-}
-
-// LiveView simulate edge device sending h264 data stream
-type LiveView struct {
-	handler StreamReceiver
+var (
+	_ LiveViewer  = (*LiveView)(nil)
+	_ MediaReader = (*MediaFileReader)(nil)
+)
 
-	reading      atomic.Bool
-	streamReader io.ReadCloser
-	dataChan     chan []byte
-	closeSig     chan bool
+var (
+	defaultSimOnce sync.Once
+	defaultSim     *Simulator
+	defaultSimErr  error
+)
 
-	wg sync.WaitGroup
+// defaultSimulator returns the backend of the package functions in this build, it is created at the first call
+// and configured by the environment variables, see SimulatorConfigFromEnv.
+// with an invalid config, it has the default config and its InitSDK returns the error of the config.
+func defaultSimulator() *Simulator {
+	defaultSimOnce.Do(func() {
+		cfg, err := SimulatorConfigFromEnv()
+		if err == nil {
+			defaultSim, err = NewSimulatorWithConfig(cfg)
+		}
+		if err != nil {
+			defaultSimErr = fmt.Errorf("djiedge: invalid simulator config of the environment: %w", err)
+			defaultSim = NewSimulator()
+			defaultSim.configErr = defaultSimErr
+		}
+	})
+	return defaultSim
 }
 
-func NewLiveView() *LiveView {
-	return &LiveView{}
+// DefaultEdge returns the backend used by the package functions, it is a Simulator in this build.
+// if the simulator config of the environment is invalid, its InitSDK returns the error, see NewEdgeFromEnv.
+func DefaultEdge() Edge {
+	return defaultSimulator()
 }
 
-func (lv *LiveView) Init(cameraType CameraType, quality StreamQuality, handler StreamReceiver) error {
-	if lv.handler != nil {
-		return nil
-	}
-	lv.handler = handler
-	go lv.updateState()
-	return nil
-}
-func (lv *LiveView) SetCameraSource(source CameraSource) error {
-	return nil
+// defaultEdge returns DefaultEdge and the error of its config
+func defaultEdge() (Edge, error) {
+	sim := defaultSimulator()
+	return sim, defaultSimErr
 }
 
-// StartH264Stream read local h264-stream file[edge_stream.h264] and push data to StreamReceiver
-func (lv *LiveView) StartH264Stream() error {
-	if !lv.reading.CompareAndSwap(false, true) {
-		return nil
-	}
-
-	f, err := os.Open(fakeEdgeStreamFileName)
-	if err != nil {
-		lv.reading.Store(false)
-		return err
-	}
-	lv.streamReader = f
-	lv.closeSig = make(chan bool)
-	lv.dataChan = make(chan []byte, 10)
-	time.Sleep(1 * time.Second)
-
-	lv.wg.Add(2)
-	go func() {
-		loopReadH264File(lv.streamReader, 30, lv.dataChan, lv.closeSig)
-		lv.wg.Done()
-	}()
-	go func() {
-		lv.pushStreamData()
-		lv.wg.Done()
-	}()
-	return nil
+// Initialized returns whether the sdk instance has been initialized
+func Initialized() bool {
+	return defaultSimulator().Initialized()
 }
 
-func (lv *LiveView) StopH264Stream() error {
-	if lv.streamReader == nil {
-		return nil
-	}
-	if !lv.reading.CompareAndSwap(true, false) {
-		return errors.New("state error")
-	}
-	lv.closeSig <- true
-	lv.wg.Wait()
-
-	close(lv.closeSig)
-	close(lv.dataChan)
-	_ = lv.streamReader.Close()
-	lv.streamReader = nil
-	return nil
+// State returns the lifecycle state of the sdk instance
+func State() LifecycleState {
+	return defaultSimulator().State()
 }
 
-func (lv *LiveView) updateState() {
-	status := &LiveStatus{}
-	c := time.Tick(2 * time.Second)
-	i := 0
-	for range c {
-		if i == 3 {
-			status.Value = 1
-			status.QualityAutoAvailable = true
-		}
-		if i > 180 {
-			i = 0
-			status = &LiveStatus{}
-		}
-		i++
-		if lv.handler != nil {
-			lv.handler.OnStreamStatusUpdate(status)
-		}
-	}
+// SubscribeState calls fn on every transition of the lifecycle state until unsubscribe is called,
+// fn is called in the goroutine changing the state, it should not block.
+func SubscribeState(fn func(StateTransition)) (unsubscribe func()) {
+	return defaultSimulator().SubscribeState(fn)
 }
 
-func (lv *LiveView) pushStreamData() {
-	for d := range lv.dataChan {
-		if lv.handler != nil {
-			lv.handler.OnReceiveStreamData(d)
-		}
-	}
+// InitSDK simulate the initialization of edge-sdk, it takes SimulatorConfig.InitDelay like a real device.
+func InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) (err error) {
+	return defaultSimulator().InitSDK(device, auth, key, logger, deInitOnFailed)
 }
 
-func indexH264NaluStartCode(s []byte) (int, int) {
-	start := 0
-	for {
-		i := bytes.Index(s, []byte{0x00, 0x00})
-		if i < 0 {
-			return -1, 0
-		}
-
-		if len(s) <= (i + 2) {
-			return -1, 0
-		}
-		if s[i+2] == 0x01 {
-			return start + i, 3
-		}
-		if len(s) <= (i + 3) {
-			return -1, 0
-		}
-		if s[i+3] == 0x01 {
-			return start + i, 4
-		}
-		s = s[1:]
-		start++
-	}
+// DeInitSDK will de-initialize SDK environment
+func DeInitSDK() error {
+	return defaultSimulator().DeInitSDK()
 }
 
-func loopReadH264File(reader io.Reader, frameInterval int, receiver chan []byte, closeSig <-chan bool) {
-	r := bufio.NewScanner(reader)
-	r.Buffer(nil, 1024*1024*2)
-	r.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-		start, w := indexH264NaluStartCode(data)
-		if start < 0 {
-			return 0, nil, nil
-		}
-		start += w
-		end, w := indexH264NaluStartCode(data[start:])
-		if end < 0 {
-			return 0, nil, nil
-		}
-		return start + end, data[:start+end], nil
-	})
-
-	ticker := time.NewTicker(time.Duration(frameInterval) * time.Millisecond)
-	defer ticker.Stop()
+// LiveView simulate edge device sending h264 data stream
+type LiveView struct {
+	*simLiveView
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			if ok := r.Scan(); !ok {
-				return
-			}
-			b := r.Bytes()
-			if cap(receiver) <= len(receiver) {
-				fmt.Println("fake stream block!!!")
-				continue
-			}
-			cc := make([]byte, len(b))
-			copy(cc, b)
-			receiver <- cc
-		case <-closeSig:
-			return
-		}
-	}
+func NewLiveView() *LiveView {
+	return &LiveView{newSimLiveView(defaultSimulator())}
 }
@@ -0,0 +1,73 @@
+//go:build !linux || fake_edge
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// resetDefaultSimulator makes the next call create the default simulator again, it is restored after the test
+func resetDefaultSimulator(t *testing.T) {
+	sim, err := defaultSimulator(), defaultSimErr
+	t.Cleanup(func() {
+		defaultSimOnce = sync.Once{}
+		defaultSimOnce.Do(func() {})
+		defaultSim, defaultSimErr = sim, err
+	})
+	defaultSimOnce = sync.Once{}
+	defaultSim, defaultSimErr = nil, nil
+}
+
+func TestDefaultSimulatorInvalidEnv(t *testing.T) {
+	resetDefaultSimulator(t)
+	t.Setenv(BackendEnv, "")
+	t.Setenv(SimInitDelayEnv, "soon")
+
+	e, err := NewEdgeFromEnv()
+	if err == nil || e != nil {
+		t.Fatalf("NewEdgeFromEnv returned %v, %v with an invalid config", e, err)
+	}
+	// the package functions and DefaultEdge report the error instead of using another config
+	if err2 := InitSDK(nil, nil, nil, nil, false); !errors.Is(err2, err) {
+		t.Fatalf("InitSDK returned %v, want %v", err2, err)
+	}
+	if err2 := DefaultEdge().InitSDK(nil, nil, nil, nil, false); !errors.Is(err2, err) {
+		t.Fatalf("InitSDK of DefaultEdge returned %v, want %v", err2, err)
+	}
+	if Initialized() {
+		t.Fatal("initialized with an invalid config")
+	}
+}
+
+func TestDefaultSimulatorEnv(t *testing.T) {
+	resetDefaultSimulator(t)
+	t.Setenv(BackendEnv, "")
+	t.Setenv(SimInitDelayEnv, "10ms")
+
+	e, err := NewEdgeFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, ok := e.(*Simulator)
+	if !ok || sim != DefaultEdge() || sim.cfg.InitDelay.Milliseconds() != 10 {
+		t.Fatalf("NewEdgeFromEnv returned %T, not the default simulator of the environment", e)
+	}
+}
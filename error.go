@@ -19,12 +19,13 @@ package djiedge
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // error wrap for DJI-Edge-SDK c++ ErrorCode
 var (
 	ErrInvalidArgument   = errors.New("djiedge: invalid argument")
-	ErrSystemError       = errors.New("djiedge: invalid use of UnreadRune")
+	ErrSystemError       = errors.New("djiedge: system error")
 	ErrInvalidOperation  = errors.New("djiedge: invalid operation")
 	ErrRepeatOperation   = errors.New("djiedge: repeated operation")
 	ErrNullPointer       = errors.New("djiedge: null pointer")
@@ -45,6 +46,12 @@ var (
 var (
 	ErrSDKNotInit        = errors.New("sdk is not initialized")
 	ErrFileReaderNotOpen = errors.New("file reader is not opened")
+	// ErrDestroyed the live-view or the media file reader is destroyed
+	ErrDestroyed = errors.New("djiedge: the object is destroyed")
+	// ErrUnknownCode the error of a code that is not documented by the sdk
+	ErrUnknownCode = errors.New("djiedge: unknown error")
+	// ErrOpenFileFailure the error of a negative handle returned by opening a media file
+	ErrOpenFileFailure = errors.New("djiedge: failed to open the media file")
 )
 
 var (
@@ -69,12 +76,97 @@ var (
 	}
 )
 
-func convertCCodeToError(code int) error {
+// SDKError the error of a failed call of the sdk,
+// errors.Is reports whether it is the sentinel of the code, such as ErrRequestTimeout.
+type SDKError struct {
+	// Code the error code of the sdk, or the negative value returned by the call, such as the handle of OpenFile
+	Code int
+	// Op the name of the call, such as "LiveView.StartH264Stream"
+	Op string
+	// Args the arguments of the call, the secrets are never included
+	Args []any
+	// Err the sentinel of the code, ErrUnknownCode if the code is unknown
+	Err error
+}
+
+func (e *SDKError) Error() string {
+	var b strings.Builder
+	b.WriteString("djiedge: ")
+	b.WriteString(e.Op)
+	if len(e.Args) > 0 {
+		b.WriteByte('(')
+		for i, arg := range e.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if s, ok := arg.(string); ok {
+				fmt.Fprintf(&b, "%q", s)
+			} else {
+				fmt.Fprint(&b, arg)
+			}
+		}
+		b.WriteByte(')')
+	}
+	msg := "unknown error"
+	if e.Err != nil {
+		msg = strings.TrimPrefix(e.Err.Error(), "djiedge: ")
+	}
+	fmt.Fprintf(&b, ": %s (code %d)", msg, e.Code)
+	return b.String()
+}
+
+func (e *SDKError) Unwrap() error {
+	return e.Err
+}
+
+// sdkError returns the *SDKError of the code returned by the call of op, or nil if the code is 0
+func sdkError(op string, code int, args ...any) error {
 	if code == 0 {
 		return nil
 	}
-	if err := codeErrMap[code]; err != nil {
+	err := codeErrMap[code]
+	if err == nil {
+		err = ErrUnknownCode
+	}
+	return &SDKError{Code: code, Op: op, Args: args, Err: err}
+}
+
+// wrapSDKError returns the *SDKError of the sentinel of a code failing the call of op,
+// such as the sentinels injected by the Simulator and MockEdge.
+// other errors are returned unchanged.
+func wrapSDKError(op string, err error, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	var sdkErr *SDKError
+	if errors.As(err, &sdkErr) {
 		return err
 	}
-	return fmt.Errorf("djiedge: unknown error,code %v", code)
+	for code, sentinel := range codeErrMap {
+		if err == sentinel {
+			return &SDKError{Code: code, Op: op, Args: args, Err: err}
+		}
+	}
+	return err
+}
+
+// IsRetryable reports whether the call may succeed later after the error of the sdk,
+// the failures of the connection and the transient failures of the remote, such as ErrRequestTimeout
+// and ErrNoVideoID of a stream not available yet.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrConnectFailure) ||
+		errors.Is(err, ErrRequestTimeout) ||
+		errors.Is(err, ErrSendPackFailure) ||
+		errors.Is(err, ErrRemoteFailure) ||
+		errors.Is(err, ErrNoVideoID)
+}
+
+// IsFatal reports whether the error of the sdk is not recovered without fixing the configuration
+// or restarting the sdk, such as ErrAuthVerifyFailure.
+func IsFatal(err error) bool {
+	return errors.Is(err, ErrAuthVerifyFailure) ||
+		errors.Is(err, ErrEncryptFailure) ||
+		errors.Is(err, ErrDecryptFailure) ||
+		errors.Is(err, ErrSystemError) ||
+		errors.Is(err, ErrNullPointer)
 }
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSDKError(t *testing.T) {
+	tests := []struct {
+		code int
+		want error
+	}{
+		{1, ErrInvalidArgument},
+		{2, ErrSystemError},
+		{3, ErrInvalidOperation},
+		{4, ErrRepeatOperation},
+		{5, ErrNullPointer},
+		{6, ErrParamOutOfRange},
+		{7, ErrParamGetFailure},
+		{8, ErrParamSetFailure},
+		{9, ErrSendPackFailure},
+		{10, ErrRequestTimeout},
+		{11, ErrAuthVerifyFailure},
+		{12, ErrEncryptFailure},
+		{13, ErrDecryptFailure},
+		{14, ErrInvalidRespond},
+		{15, ErrRemoteFailure},
+		{16, ErrNoVideoID},
+		{17, ErrConnectFailure},
+		{18, ErrUnknownCode},
+		{-1, ErrUnknownCode},
+	}
+	for _, tt := range tests {
+		err := sdkError("MediaFile.Read", tt.code, fileHandle(3))
+		var sdkErr *SDKError
+		if !errors.As(err, &sdkErr) || sdkErr.Code != tt.code || sdkErr.Op != "MediaFile.Read" {
+			t.Fatalf("code %d: %#v", tt.code, err)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("code %d: %v isn't %v", tt.code, err, tt.want)
+		}
+		for _, other := range codeErrMap {
+			if other != tt.want && errors.Is(err, other) {
+				t.Errorf("code %d: %v is also %v", tt.code, err, other)
+			}
+		}
+		// the sentinel is still found through a wrapping error
+		if !errors.Is(fmt.Errorf("read: %w", err), tt.want) {
+			t.Errorf("code %d: the wrapped error isn't %v", tt.code, tt.want)
+		}
+	}
+	if err := sdkError("MediaFile.Read", 0); err != nil {
+		t.Fatalf("code 0 returned %v", err)
+	}
+}
+
+func TestSDKErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *SDKError
+		want string
+	}{
+		{&SDKError{Code: 10, Op: "LiveView.StartH264Stream", Err: ErrRequestTimeout},
+			"djiedge: LiveView.StartH264Stream: request has timed out (code 10)"},
+		{&SDKError{Code: -1, Op: "MediaFileReader.OpenFile", Args: []any{"/DJI/a.jpg"}, Err: ErrOpenFileFailure},
+			`djiedge: MediaFileReader.OpenFile("/DJI/a.jpg"): failed to open the media file (code -1)`},
+		{&SDKError{Code: 3, Op: "MediaFile.Read", Args: []any{fileHandle(2), 64}, Err: ErrInvalidOperation},
+			"djiedge: MediaFile.Read(2, 64): invalid operation (code 3)"},
+		{&SDKError{Code: 99, Op: "InitSDK"}, "djiedge: InitSDK: unknown error (code 99)"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestWrapSDKError(t *testing.T) {
+	err := wrapSDKError("MediaFile.Read", ErrConnectFailure, fileHandle(1))
+	var sdkErr *SDKError
+	if !errors.As(err, &sdkErr) || sdkErr.Code != 17 || !errors.Is(err, ErrConnectFailure) {
+		t.Fatalf("wrapped %#v", err)
+	}
+	if again := wrapSDKError("MediaFile.Close", err); again != err {
+		t.Fatalf("an *SDKError wrapped again: %v", again)
+	}
+	other := errors.New("other")
+	if got := wrapSDKError("MediaFile.Read", other); got != other {
+		t.Fatalf("a non sdk error wrapped: %v", got)
+	}
+	if wrapSDKError("MediaFile.Read", nil) != nil {
+		t.Fatal("nil wrapped")
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		err              error
+		retryable, fatal bool
+	}{
+		{ErrConnectFailure, true, false},
+		{ErrRequestTimeout, true, false},
+		{ErrSendPackFailure, true, false},
+		{ErrRemoteFailure, true, false},
+		{ErrNoVideoID, true, false},
+		{ErrAuthVerifyFailure, false, true},
+		{ErrEncryptFailure, false, true},
+		{ErrDecryptFailure, false, true},
+		{ErrSystemError, false, true},
+		{ErrNullPointer, false, true},
+		{ErrInvalidArgument, false, false},
+		{ErrInvalidOperation, false, false},
+		{ErrRepeatOperation, false, false},
+		{ErrParamOutOfRange, false, false},
+		{ErrParamGetFailure, false, false},
+		{ErrParamSetFailure, false, false},
+		{ErrInvalidRespond, false, false},
+		{ErrUnknownCode, false, false},
+		{errors.New("other"), false, false},
+		{nil, false, false},
+	}
+	for _, tt := range tests {
+		errs := []error{tt.err}
+		if tt.err != nil {
+			errs = append(errs, wrapSDKError("Op", tt.err), fmt.Errorf("wrapped: %w", wrapSDKError("Op", tt.err)))
+		}
+		for _, err := range errs {
+			if IsRetryable(err) != tt.retryable || IsFatal(err) != tt.fatal {
+				t.Errorf("%v: retryable %v fatal %v, want %v %v", err, IsRetryable(err), IsFatal(err), tt.retryable, tt.fatal)
+			}
+		}
+	}
+}
@@ -0,0 +1,438 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lynnplus/go-djiedge/h264"
+)
+
+// Frame the stream data of a callback of the live-view copied into go memory,
+// usually an access unit of annex-b h264.
+// the frame is owned by the receiver until Release is called, the data must not be used after that.
+type Frame struct {
+	Data       []byte
+	CameraType CameraType
+	// Source the source set by SetCameraSource, 0 if it is not set
+	Source CameraSource
+	// Time the time of receiving the data
+	Time time.Time
+	// Seq the sequence number of the data of the live-view, starts from 1
+	Seq uint64
+	// Keyframe the data contains an IDR slice
+	Keyframe bool
+
+	refs atomic.Int32
+	// buf the pooled buffer of Data, the frames themselves aren't pooled, so a frame released twice is always detected
+	buf *[]byte
+}
+
+var framePool = sync.Pool{New: func() any { return new([]byte) }}
+
+const (
+	// maxPooledFrameSize the buffers larger than it are not pooled, so a burst of big frames doesn't pin the memory
+	maxPooledFrameSize = 4 << 20
+	// defaultGOPCacheSize the bytes of the gop cached by the live-views and BroadcastOptions.GOPCacheSize
+	defaultGOPCacheSize = 8 << 20
+)
+
+func newFrame(data []byte, refs int32) *Frame {
+	f := &Frame{buf: framePool.Get().(*[]byte)}
+	f.Data = append((*f.buf)[:0], data...)
+	f.refs.Store(refs)
+	return f
+}
+
+// Release returns the data to the pool after the last reference is released,
+// the frame must not be used after that, releasing it again panics.
+func (f *Frame) Release() {
+	if f == nil {
+		return
+	}
+	n := f.refs.Add(-1)
+	if n > 0 {
+		return
+	}
+	if n < 0 {
+		panic("djiedge: frame released twice")
+	}
+	if cap(f.Data) > maxPooledFrameSize {
+		*f.buf = nil
+	} else {
+		*f.buf = f.Data[:0]
+	}
+	framePool.Put(f.buf)
+	f.Data, f.buf = nil, nil
+}
+
+// OverflowPolicy what the queue of Frames does with a new frame when it is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest drops the oldest frame of the queue
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest drops the new frame
+	OverflowDropNewest
+	// OverflowBlock waits for the receiver, it blocks the stream callback of the sdk and the other receivers
+	OverflowBlock
+	// OverflowDropUntilKeyframe drops the new frame and the following ones until a keyframe,
+	// so the receiver always gets the gop from its start after a loss
+	OverflowDropUntilKeyframe
+)
+
+// FrameOptions the queue of Frames
+type FrameOptions struct {
+	// Buffer the capacity of the queue, 16 if 0
+	Buffer int
+	// Overflow the policy of a full queue
+	Overflow OverflowPolicy
+	// InjectParamSets inserts the latest sps and pps before the keyframes without them,
+	// for the decoders that can't take the parameter sets out of band
+	InjectParamSets bool
+}
+
+// StreamStatusFunc is a StreamReceiver only receiving the stream status,
+// for the live-views whose data is received by Frames.
+type StreamStatusFunc func(status *LiveStatus)
+
+func (f StreamStatusFunc) OnStreamStatusUpdate(status *LiveStatus) {
+	if f != nil {
+		f(status)
+	}
+}
+
+func (f StreamStatusFunc) OnReceiveStreamData([]byte) {}
+
+// frameFeed copies the stream data of a live-view to the queues of Frames
+type frameFeed struct {
+	seq    atomic.Uint64
+	ids    atomic.Uint64
+	mu     sync.Mutex
+	subs   atomic.Pointer[[]*frameQueue]
+	closed bool
+
+	// the latest sps and pps with start codes
+	sps, pps []byte
+	// the frames of the current gop from its keyframe, cached up to gopLimit bytes to prime the new queues
+	gopLimit int
+	gop      []*Frame
+	gopSize  int
+}
+
+type frameQueue struct {
+	id       uint64
+	ch       chan *Frame
+	overflow OverflowPolicy
+	inject   bool
+	done     <-chan struct{}
+	cancel   context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+
+	// the statistics, the receive times of the frames queued are kept in a ring indexed by the count of them,
+	// the channel always holds the latest frames queued
+	queued       atomic.Uint64
+	dropped      atomic.Uint64
+	maxLen       atomic.Int64
+	waitKeyframe atomic.Bool
+	times        []atomic.Int64
+}
+
+// frames returns the channel of a new queue, it is closed after ctx is done or the feed is closed
+func (feed *frameFeed) frames(ctx context.Context, opts *FrameOptions) <-chan *Frame {
+	return feed.subscribe(ctx, opts).ch
+}
+
+// subscribe adds a new queue, it is removed and closed after ctx is done or the feed is closed
+func (feed *frameFeed) subscribe(ctx context.Context, opts *FrameOptions) *frameQueue {
+	size, overflow, inject := 16, OverflowDropOldest, false
+	if opts != nil {
+		if opts.Buffer > 0 {
+			size = opts.Buffer
+		}
+		overflow, inject = opts.Overflow, opts.InjectParamSets
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	feed.mu.Lock()
+	// the queue is primed with the gop cached, it holds them besides the buffer
+	size += len(feed.gop)
+	q := &frameQueue{id: feed.ids.Add(1), ch: make(chan *Frame, size), overflow: overflow, inject: inject,
+		done: ctx.Done(), cancel: cancel, times: make([]atomic.Int64, size)}
+	for i, f := range feed.gop {
+		if i == 0 {
+			f = feed.withParamSets(f, 1)
+		} else {
+			f.refs.Add(1)
+		}
+		q.ch <- f
+		q.enqueued(f.Time.UnixNano())
+	}
+	if feed.closed {
+		cancel()
+	}
+	subs := feed.subscribers()
+	next := append(subs[:len(subs):len(subs)], q)
+	feed.subs.Store(&next)
+	feed.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		feed.mu.Lock()
+		subs := feed.subscribers()
+		next := make([]*frameQueue, 0, len(subs))
+		for _, s := range subs {
+			if s != q {
+				next = append(next, s)
+			}
+		}
+		feed.subs.Store(&next)
+		feed.mu.Unlock()
+		q.close()
+	}()
+	return q
+}
+
+// close closes the queues of the live-view destroyed, the queues added later are closed at once
+func (feed *frameFeed) close() {
+	feed.mu.Lock()
+	feed.closed = true
+	feed.gopLimit = 0
+	feed.dropGOP()
+	feed.mu.Unlock()
+	for _, q := range feed.subscribers() {
+		q.cancel()
+	}
+}
+
+func (feed *frameFeed) subscribers() []*frameQueue {
+	if subs := feed.subs.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+// push copies the data to a frame shared by the queues,
+// the parameter sets and the gop are tracked without queues too, so the queues added later are primed by them
+func (feed *frameFeed) push(data []byte, cameraType CameraType, source CameraSource) {
+	seq := feed.seq.Add(1)
+	keyframe := h264.ContainsType(data, h264.NALIDR)
+	if len(feed.subscribers()) == 0 {
+		// the data is only copied if it is cached
+		feed.mu.Lock()
+		cached := feed.gopLimit > 0 && (keyframe || len(feed.gop) > 0)
+		if !cached {
+			feed.updateParamSets(data)
+		}
+		feed.mu.Unlock()
+		if !cached {
+			return
+		}
+	}
+	f := newFrame(data, 1)
+	f.CameraType, f.Source = cameraType, source
+	f.Time = time.Now()
+	f.Seq = seq
+	f.Keyframe = keyframe
+	feed.publish(f)
+}
+
+// publish adds the frame to the queues and releases the reference of the caller
+func (feed *frameFeed) publish(f *Frame) {
+	feed.mu.Lock()
+	feed.updateParamSets(f.Data)
+	feed.cache(f)
+	subs := feed.subscribers()
+	// the keyframe with the parameter sets of the n queues injecting them
+	injected, n := f, int32(0)
+	if f.Keyframe {
+		for _, q := range subs {
+			if q.inject {
+				n++
+			}
+		}
+		if n > 0 {
+			injected = feed.withParamSets(f, n)
+		}
+	}
+	f.refs.Add(int32(len(subs)) - n)
+	feed.mu.Unlock()
+
+	for _, q := range subs {
+		if q.inject {
+			q.push(injected)
+		} else {
+			q.push(f)
+		}
+	}
+	f.Release()
+}
+
+// updateParamSets keeps the sps and pps of the data
+func (feed *frameFeed) updateParamSets(data []byte) {
+	for _, nalu := range h264.SplitNALUnits(data) {
+		switch h264.Type(nalu) {
+		case h264.NALSPS:
+			feed.sps = append(append(feed.sps[:0], 0, 0, 0, 1), nalu...)
+		case h264.NALPPS:
+			feed.pps = append(append(feed.pps[:0], 0, 0, 0, 1), nalu...)
+		}
+	}
+}
+
+// withParamSets returns a new frame of the keyframe with the sps and pps inserted after its access unit delimiter,
+// or the keyframe itself with refs more references if it has them or they are unknown
+func (feed *frameFeed) withParamSets(f *Frame, refs int32) *Frame {
+	if feed.sps == nil || feed.pps == nil ||
+		h264.ContainsType(f.Data, h264.NALSPS) && h264.ContainsType(f.Data, h264.NALPPS) {
+		f.refs.Add(refs)
+		return f
+	}
+	// the access unit delimiter must be the first nal unit
+	at := 0
+	if h264.Type(f.Data) == h264.NALAUD {
+		i, w := h264.IndexStartCode(f.Data)
+		if j, _ := h264.IndexStartCode(f.Data[i+w:]); j >= 0 {
+			at = i + w + j
+		} else {
+			at = len(f.Data)
+		}
+	}
+	g := newFrame(f.Data[:at], refs)
+	g.Data = append(append(append(g.Data, feed.sps...), feed.pps...), f.Data[at:]...)
+	g.CameraType, g.Source, g.Time, g.Seq, g.Keyframe = f.CameraType, f.Source, f.Time, f.Seq, f.Keyframe
+	return g
+}
+
+// cache adds the frame to the gop cached, a gop larger than gopLimit isn't cached
+func (feed *frameFeed) cache(f *Frame) {
+	if feed.gopLimit <= 0 {
+		return
+	}
+	switch {
+	case f.Keyframe:
+		feed.dropGOP()
+	case len(feed.gop) == 0:
+		return
+	case feed.gopSize+len(f.Data) > feed.gopLimit:
+		feed.dropGOP()
+		return
+	}
+	f.refs.Add(1)
+	feed.gop = append(feed.gop, f)
+	feed.gopSize += len(f.Data)
+}
+
+func (feed *frameFeed) dropGOP() {
+	for i, f := range feed.gop {
+		f.Release()
+		feed.gop[i] = nil
+	}
+	feed.gop, feed.gopSize = feed.gop[:0], 0
+}
+
+func (q *frameQueue) push(f *Frame) {
+	// the frame may be released by the receiver once it is sent
+	t := f.Time.UnixNano()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		f.Release()
+		return
+	}
+	switch q.overflow {
+	case OverflowBlock:
+		select {
+		case q.ch <- f:
+			q.enqueued(t)
+		case <-q.done:
+			q.drop(f)
+		}
+	case OverflowDropNewest:
+		select {
+		case q.ch <- f:
+			q.enqueued(t)
+		default:
+			q.drop(f)
+		}
+	case OverflowDropUntilKeyframe:
+		if q.waitKeyframe.Load() && !f.Keyframe {
+			q.drop(f)
+			return
+		}
+		select {
+		case q.ch <- f:
+			q.waitKeyframe.Store(false)
+			q.enqueued(t)
+		default:
+			q.waitKeyframe.Store(true)
+			q.drop(f)
+		}
+	default:
+		for {
+			select {
+			case q.ch <- f:
+				q.enqueued(t)
+				return
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.drop(old)
+			default:
+			}
+		}
+	}
+}
+
+// enqueued counts the frame received at t added to the channel
+func (q *frameQueue) enqueued(t int64) {
+	n := q.queued.Add(1)
+	q.times[(n-1)%uint64(len(q.times))].Store(t)
+	if l := int64(len(q.ch)); l > q.maxLen.Load() {
+		q.maxLen.Store(l)
+	}
+}
+
+func (q *frameQueue) drop(f *Frame) {
+	q.dropped.Add(1)
+	f.Release()
+}
+
+// lag returns the age of the oldest frame in the channel, 0 if it is empty
+func (q *frameQueue) lag() time.Duration {
+	n := uint64(len(q.ch))
+	total := q.queued.Load()
+	if n == 0 || n > total {
+		return 0
+	}
+	t := q.times[(total-n)%uint64(len(q.times))].Load()
+	return max(time.Since(time.Unix(0, t)), 0)
+}
+
+// close closes the channel, the frames left in it are received and released by the receiver
+func (q *frameQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	close(q.ch)
+}
@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testSPS = []byte{0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xe8}
+	testPPS = []byte{0, 0, 0, 1, 0x68, 0xce, 0x0f, 0x2c, 0x80}
+	testIDR = []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33}
+	testP   = []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+)
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func receiveFrame(t *testing.T, ch <-chan *Frame) *Frame {
+	t.Helper()
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return f
+	case <-time.After(time.Second):
+		t.Fatal("no frame received")
+	}
+	return nil
+}
+
+func TestFrameFeedPrimedWithoutSubscribers(t *testing.T) {
+	feed := &frameFeed{gopLimit: defaultGOPCacheSize}
+	feed.push(concat(testSPS, testPPS), CameraTypePayload, CameraSourceWide)
+	feed.push(testP, CameraTypePayload, CameraSourceWide)
+	feed.push(testIDR, CameraTypePayload, CameraSourceWide)
+	feed.push(testP, CameraTypePayload, CameraSourceWide)
+	feed.push(testP, CameraTypePayload, CameraSourceWide)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := feed.frames(ctx, nil)
+	want := []struct {
+		seq      uint64
+		keyframe bool
+		data     []byte
+	}{
+		{3, true, concat(testSPS, testPPS, testIDR)},
+		{4, false, testP},
+		{5, false, testP},
+	}
+	for _, w := range want {
+		f := receiveFrame(t, ch)
+		if f.Seq != w.seq || f.Keyframe != w.keyframe || !bytes.Equal(f.Data, w.data) ||
+			f.CameraType != CameraTypePayload || f.Source != CameraSourceWide {
+			t.Fatalf("frame %d: seq %d keyframe %v data %x", w.seq, f.Seq, f.Keyframe, f.Data)
+		}
+		f.Release()
+	}
+	if len(ch) != 0 {
+		t.Fatalf("%d frames more than the gop", len(ch))
+	}
+	feed.push(testP, CameraTypePayload, CameraSourceWide)
+	if f := receiveFrame(t, ch); f.Seq != 6 {
+		t.Fatalf("seq %d, want 6", f.Seq)
+	} else {
+		f.Release()
+	}
+}
+
+func TestFrameFeedParamSetsWithoutCache(t *testing.T) {
+	feed := &frameFeed{}
+	feed.push(concat(testSPS, testPPS), CameraTypePayload, CameraSourceZoom)
+	feed.push(testIDR, CameraTypePayload, CameraSourceZoom)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := feed.frames(ctx, &FrameOptions{InjectParamSets: true})
+	if len(ch) != 0 {
+		t.Fatalf("%d frames cached with the cache disabled", len(ch))
+	}
+	feed.push(testIDR, CameraTypePayload, CameraSourceZoom)
+	f := receiveFrame(t, ch)
+	defer f.Release()
+	if !bytes.Equal(f.Data, concat(testSPS, testPPS, testIDR)) {
+		t.Fatalf("keyframe %x without the parameter sets pushed before the queue", f.Data)
+	}
+}
+
+func TestMockLiveViewFramesPrimed(t *testing.T) {
+	lv := NewMockEdge().NewLiveView().(*MockLiveView)
+	defer lv.Destroy()
+	if err := lv.Init(CameraTypePayload, StreamQuality720p, StreamStatusFunc(func(*LiveStatus) {})); err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.StartH264Stream(); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{concat(testSPS, testPPS, testIDR), testP} {
+		if err := lv.PushStreamData(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := lv.Frames(ctx, nil)
+	if f := receiveFrame(t, ch); !f.Keyframe || !bytes.Equal(f.Data, concat(testSPS, testPPS, testIDR)) {
+		t.Fatalf("first frame %x isn't the keyframe cached", f.Data)
+	} else {
+		f.Release()
+	}
+	if f := receiveFrame(t, ch); f.Keyframe || !bytes.Equal(f.Data, testP) {
+		t.Fatalf("second frame %x isn't the frame after the keyframe", f.Data)
+	} else {
+		f.Release()
+	}
+}
+
+func TestFrameRelease(t *testing.T) {
+	const refs = 8
+	f := newFrame(testIDR, refs)
+	var wg sync.WaitGroup
+	for i := 0; i < refs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !bytes.Equal(f.Data, testIDR) {
+				t.Error("data changed before the last release")
+			}
+			f.Release()
+		}()
+	}
+	wg.Wait()
+	if f.Data != nil {
+		t.Fatal("data kept after the last release")
+	}
+	var nilFrame *Frame
+	nilFrame.Release()
+}
+
+func TestFrameReleaseTwice(t *testing.T) {
+	f := newFrame(testIDR, 1)
+	f.Release()
+	// the buffer of f is reused by the new frames, f itself isn't
+	for i := 0; i < 16; i++ {
+		defer newFrame(testP, 1).Release()
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic releasing a frame twice after its buffer was reused")
+		}
+	}()
+	f.Release()
+}
+
+func TestFramePool(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte(i)}, 64+i)
+			for j := 0; j < 1000; j++ {
+				f := newFrame(data, 2)
+				f.Release()
+				if !bytes.Equal(f.Data, data) {
+					t.Error("data of a pooled buffer changed while it was referenced")
+					return
+				}
+				f.Release()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	big := newFrame(make([]byte, maxPooledFrameSize+1), 1)
+	buf := big.buf
+	big.Release()
+	if *buf != nil {
+		t.Fatal("buffer larger than maxPooledFrameSize pooled")
+	}
+}
+
+func TestFrameQueueOverflow(t *testing.T) {
+	frame := func(seq uint64, keyframe bool) *Frame {
+		f := newFrame(testP, 1)
+		f.Seq, f.Keyframe = seq, keyframe
+		return f
+	}
+	receive := func(q *frameQueue) []uint64 {
+		var seqs []uint64
+		for len(q.ch) > 0 {
+			f := <-q.ch
+			seqs = append(seqs, f.Seq)
+			f.Release()
+		}
+		return seqs
+	}
+	newQueue := func(overflow OverflowPolicy) (*frameFeed, *frameQueue, context.CancelFunc) {
+		feed := &frameFeed{}
+		ctx, cancel := context.WithCancel(context.Background())
+		return feed, feed.subscribe(ctx, &FrameOptions{Buffer: 2, Overflow: overflow}), cancel
+	}
+
+	t.Run("DropOldest", func(t *testing.T) {
+		feed, q, cancel := newQueue(OverflowDropOldest)
+		defer cancel()
+		for i := uint64(1); i <= 5; i++ {
+			feed.publish(frame(i, false))
+		}
+		if seqs := receive(q); len(seqs) != 2 || seqs[0] != 4 || seqs[1] != 5 {
+			t.Fatalf("received %v, want [4 5]", seqs)
+		}
+		if s := q.stats(); s.Queued != 5 || s.Dropped != 3 || s.MaxLen != 2 {
+			t.Fatalf("stats %+v", s)
+		}
+	})
+
+	t.Run("DropNewest", func(t *testing.T) {
+		feed, q, cancel := newQueue(OverflowDropNewest)
+		defer cancel()
+		for i := uint64(1); i <= 5; i++ {
+			feed.publish(frame(i, false))
+		}
+		if seqs := receive(q); len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+			t.Fatalf("received %v, want [1 2]", seqs)
+		}
+		if s := q.stats(); s.Queued != 2 || s.Dropped != 3 {
+			t.Fatalf("stats %+v", s)
+		}
+	})
+
+	t.Run("DropUntilKeyframe", func(t *testing.T) {
+		feed, q, cancel := newQueue(OverflowDropUntilKeyframe)
+		defer cancel()
+		for i := uint64(1); i <= 4; i++ {
+			feed.publish(frame(i, i == 1))
+		}
+		if !q.stats().WaitingKeyframe {
+			t.Fatal("not waiting for a keyframe after a loss")
+		}
+		receive(q)
+		feed.publish(frame(5, false))
+		feed.publish(frame(6, true))
+		feed.publish(frame(7, false))
+		if seqs := receive(q); len(seqs) != 2 || seqs[0] != 6 || seqs[1] != 7 {
+			t.Fatalf("received %v, want [6 7]", seqs)
+		}
+		if s := q.stats(); s.WaitingKeyframe || s.Dropped != 3 {
+			t.Fatalf("stats %+v", s)
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		feed, q, cancel := newQueue(OverflowBlock)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := uint64(1); i <= 100; i++ {
+				feed.publish(frame(i, false))
+			}
+		}()
+		for i := uint64(1); i <= 100; i++ {
+			f := receiveFrame(t, q.ch)
+			if f.Seq != i {
+				t.Fatalf("received %d, want %d", f.Seq, i)
+			}
+			f.Release()
+		}
+		<-done
+		if s := q.stats(); s.Queued != 100 || s.Dropped != 0 {
+			t.Fatalf("stats %+v", s)
+		}
+
+		// a blocked push is released by the cancel of the queue
+		feed.publish(frame(101, false))
+		feed.publish(frame(102, false))
+		blocked := make(chan struct{})
+		go func() {
+			defer close(blocked)
+			feed.publish(frame(103, false))
+		}()
+		select {
+		case <-blocked:
+			t.Fatal("push of a full queue didn't block")
+		case <-time.After(50 * time.Millisecond):
+		}
+		cancel()
+		<-blocked
+		for f := range q.ch {
+			f.Release()
+		}
+		if s := q.stats(); s.Dropped != 1 {
+			t.Fatalf("stats %+v", s)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ParamSets the latest sps and pps of a stream by their ids
+type ParamSets struct {
+	sps map[uint32]*SPS
+	pps map[uint32]*PPS
+	// the nal units of the parameter sets without start code
+	rawSPS map[uint32][]byte
+	rawPPS map[uint32][]byte
+}
+
+// Update stores the sps or pps nal unit with or without start code, the other nal units are ignored
+func (p *ParamSets) Update(nalu []byte) error {
+	nalu = TrimStartCode(nalu)
+	switch Type(nalu) {
+	case NALSPS:
+		sps, err := ParseSPS(nalu)
+		if err != nil {
+			return err
+		}
+		if p.sps == nil {
+			p.sps, p.rawSPS = map[uint32]*SPS{}, map[uint32][]byte{}
+		}
+		p.sps[sps.ID] = sps
+		p.rawSPS[sps.ID] = append([]byte(nil), nalu...)
+	case NALPPS:
+		chromaFormat := uint32(1)
+		if id, err := ppsSPSID(nalu); err == nil && p.sps[id] != nil {
+			chromaFormat = p.sps[id].ChromaFormatIDC
+		}
+		pps, err := parsePPS(nalu, chromaFormat)
+		if err != nil {
+			return err
+		}
+		if p.pps == nil {
+			p.pps, p.rawPPS = map[uint32]*PPS{}, map[uint32][]byte{}
+		}
+		p.pps[pps.ID] = pps
+		p.rawPPS[pps.ID] = append([]byte(nil), nalu...)
+	}
+	return nil
+}
+
+func ppsSPSID(nalu []byte) (uint32, error) {
+	r := newBitReader(EBSPToRBSP(nalu[1:min(len(nalu), 16)]))
+	r.readUE()
+	id := r.readUE()
+	return id, r.err
+}
+
+// SPS returns the sps of the id, nil if it is unknown
+func (p *ParamSets) SPS(id uint32) *SPS {
+	return p.sps[id]
+}
+
+// PPS returns the pps of the id, nil if it is unknown
+func (p *ParamSets) PPS(id uint32) *PPS {
+	return p.pps[id]
+}
+
+// RawSPS returns the nal unit of the sps of the id without start code, nil if it is unknown
+func (p *ParamSets) RawSPS(id uint32) []byte {
+	return p.rawSPS[id]
+}
+
+// RawPPS returns the nal unit of the pps of the id without start code, nil if it is unknown
+func (p *ParamSets) RawPPS(id uint32) []byte {
+	return p.rawPPS[id]
+}
+
+// ParseSliceHeader parses the header of the slice nal unit with the parameter sets it refers to
+func (p *ParamSets) ParseSliceHeader(nalu []byte) (*SliceHeader, error) {
+	nalu = TrimStartCode(nalu)
+	id, err := slicePPSID(nalu)
+	if err != nil {
+		return nil, err
+	}
+	pps := p.pps[id]
+	if pps == nil {
+		return nil, fmt.Errorf("h264: unknown pps %d", id)
+	}
+	sps := p.sps[pps.SPSID]
+	if sps == nil {
+		return nil, fmt.Errorf("h264: unknown sps %d", pps.SPSID)
+	}
+	return ParseSliceHeader(nalu, sps, pps)
+}
+
+// AccessUnitSplitter groups the nal units of an annex-b stream into access units.
+// the first slice of a picture is detected by the slice headers of 7.4.1.2.4 when the parameter sets are known,
+// and by first_mb_in_slice 0, assuming the slices are in order.
+type AccessUnitSplitter struct {
+	// Params the parameter sets of the stream, updated by the sps and pps pushed
+	Params ParamSets
+
+	cur     []byte
+	hasVCL  bool
+	lastVCL *SliceHeader
+}
+
+// Push adds a nal unit with start code, such as a token of ScanNALUnits, a 4-byte start code is added if it has none.
+// it returns the previous access unit when the nal unit starts a new one.
+func (s *AccessUnitSplitter) Push(nalu []byte) []byte {
+	var au []byte
+	switch t := Type(nalu); {
+	case t.startsAccessUnit():
+		if s.hasVCL {
+			au = s.Flush()
+		}
+		if t == NALSPS || t == NALPPS {
+			_ = s.Params.Update(nalu)
+		}
+	case t.IsVCL():
+		h, _ := s.Params.ParseSliceHeader(nalu)
+		if s.hasVCL {
+			if h != nil && s.lastVCL != nil && firstSliceOfNewPicture(s.lastVCL, h) || isFirstSlice(nalu) {
+				au = s.Flush()
+			}
+		}
+		s.hasVCL = true
+		s.lastVCL = h
+	}
+	if i, _ := IndexStartCode(nalu); i < 0 {
+		s.cur = append(s.cur, 0, 0, 0, 1)
+	}
+	s.cur = append(s.cur, nalu...)
+	return au
+}
+
+// Flush returns the pending access unit
+func (s *AccessUnitSplitter) Flush() []byte {
+	au := s.cur
+	s.cur = nil
+	s.hasVCL = false
+	return au
+}
+
+// isFirstSlice reports whether the slice has first_mb_in_slice 0
+func isFirstSlice(nalu []byte) bool {
+	nalu = TrimStartCode(nalu)
+	if len(nalu) < 2 {
+		return false
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:min(len(nalu), 8)]))
+	return r.readUE() == 0 && r.err == nil
+}
+
+// AccessUnits splits the annex-b data into access units copied from data,
+// the last one is returned even if it may be incomplete.
+func AccessUnits(data []byte) [][]byte {
+	var s AccessUnitSplitter
+	var aus [][]byte
+	for len(data) > 0 {
+		adv, nalu, err := ScanNALUnits(data, true)
+		if err != nil || adv == 0 {
+			break
+		}
+		data = data[adv:]
+		if nalu == nil {
+			continue
+		}
+		if au := s.Push(nalu); au != nil {
+			aus = append(aus, au)
+		}
+	}
+	if au := s.Flush(); au != nil {
+		aus = append(aus, au)
+	}
+	return aus
+}
+
+var errNoParamSets = errors.New("h264: no sps or pps")
+
+// AVCDecoderConfig returns the AVCDecoderConfigurationRecord(avcC) of ISO/IEC 14496-15 of the sps and pps nal units
+// with or without start code.
+func AVCDecoderConfig(sps, pps []byte) ([]byte, error) {
+	sps, pps = TrimStartCode(sps), TrimStartCode(pps)
+	if len(sps) < 4 || len(pps) == 0 || Type(sps) != NALSPS || Type(pps) != NALPPS {
+		return nil, errNoParamSets
+	}
+	if len(sps) > 0xffff || len(pps) > 0xffff {
+		return nil, errors.New("h264: parameter set too large")
+	}
+	b := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1}
+	b = append(b, byte(len(sps)>>8), byte(len(sps)))
+	b = append(b, sps...)
+	b = append(b, 1, byte(len(pps)>>8), byte(len(pps)))
+	b = append(b, pps...)
+	return b, nil
+}
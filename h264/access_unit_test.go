@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+// bitWriter writes the fields of the rbsp of the test slices
+type bitWriter struct {
+	b []byte
+	n int
+}
+
+func (w *bitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) writeUE(v uint32) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(v, n+1)
+}
+
+// testSlice returns the slice nal unit with start code of the sps spsHigh720p24 and pps ppsHighCABAC,
+// which have log2_max_frame_num 4 and log2_max_pic_order_cnt_lsb 6
+func testSlice(header byte, firstMb uint32, st SliceType, frameNum, poc uint32) []byte {
+	var w bitWriter
+	w.writeUE(firstMb)
+	w.writeUE(uint32(st) + 5)
+	w.writeUE(0) // pps id
+	w.writeBits(frameNum, 4)
+	if NALType(header&0x1f) == NALIDR {
+		w.writeUE(0) // idr_pic_id
+	}
+	w.writeBits(poc, 6)
+	w.writeBits(0xa5a5, 16) // the rest of the slice
+	w.writeBits(1, 1)
+	return append([]byte{0, 0, 0, 1, header}, escape(w.b)...)
+}
+
+func TestParseSliceHeader(t *testing.T) {
+	var ps ParamSets
+	for _, s := range []string{spsHigh720p24, ppsHighCABAC} {
+		if err := ps.Update(mustHex(t, s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name  string
+		nalu  []byte
+		want  SliceHeader
+		isIDR bool
+	}{
+		{"x264 idr", mustHex(t, "6588840021ff"), SliceHeader{NALType: NALIDR, NALRefIDC: 3, SliceType: SliceI}, true},
+		{"x264 p", mustHex(t, "419a2146"), SliceHeader{NALType: NALSlice, NALRefIDC: 2, SliceType: SliceP, FrameNum: 1, PicOrderCntLsb: 2}, false},
+		{"x264 b", mustHex(t, "019e4174"), SliceHeader{NALType: NALSlice, SliceType: SliceB, FrameNum: 2, PicOrderCntLsb: 2}, false},
+		{"second slice", testSlice(0x41, 1800, SliceP, 3, 10),
+			SliceHeader{NALType: NALSlice, NALRefIDC: 2, FirstMbInSlice: 1800, SliceType: SliceP, FrameNum: 3, PicOrderCntLsb: 10}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ps.ParseSliceHeader(TrimStartCode(tt.nalu))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *h != tt.want || h.IsIDR() != tt.isIDR {
+				t.Errorf("got %+v\nwant %+v", *h, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseSliceHeader(mustHex(t, "419a4c"), ps.SPS(0), ps.PPS(0)); err == nil {
+		t.Error("truncated slice header is parsed")
+	}
+	if _, err := ParseSliceHeader(mustHex(t, "419a2146"), ps.SPS(0), nil); err == nil {
+		t.Error("slice header is parsed without pps")
+	}
+	if _, err := ParseSliceHeader(mustHex(t, spsHigh720p24), ps.SPS(0), ps.PPS(0)); err == nil {
+		t.Error("sps is parsed as a slice")
+	}
+}
+
+func TestAccessUnits(t *testing.T) {
+	aud := []byte{0, 0, 0, 1, 0x09, 0xf0}
+	sps := append([]byte{0, 0, 0, 1}, mustHex(t, spsHigh720p24)...)
+	pps := append([]byte{0, 0, 0, 1}, mustHex(t, ppsHighCABAC)...)
+	sei := []byte{0, 0, 1, 0x06, 0x05, 0x01, 0xff, 0x80}
+
+	want := [][][]byte{
+		{aud, sps, pps, sei, testSlice(0x65, 0, SliceI, 0, 0), testSlice(0x65, 1800, SliceI, 0, 0)},
+		// no aud, the new frame_num and poc start a new picture
+		{testSlice(0x41, 0, SliceP, 1, 6), testSlice(0x41, 1800, SliceP, 1, 6)},
+		// a b-frame without references, only nal_ref_idc and poc differ
+		{testSlice(0x01, 0, SliceB, 2, 2)},
+		{testSlice(0x01, 0, SliceB, 2, 4)},
+		{aud, testSlice(0x41, 0, SliceP, 2, 12)},
+		// an idr without the parameter sets
+		{testSlice(0x65, 0, SliceI, 0, 0)},
+	}
+	var stream []byte
+	for _, au := range want {
+		stream = append(stream, bytes.Join(au, nil)...)
+	}
+	aus := AccessUnits(stream)
+	if len(aus) != len(want) {
+		t.Fatalf("got %d access units, want %d", len(aus), len(want))
+	}
+	for i, au := range aus {
+		if w := bytes.Join(want[i], nil); !bytes.Equal(au, w) {
+			t.Errorf("access unit %d: got %x, want %x", i, au, w)
+		}
+	}
+}
+
+func TestAccessUnitSplitterWithoutParamSets(t *testing.T) {
+	// the slices are split by first_mb_in_slice when the parameter sets are unknown
+	var s AccessUnitSplitter
+	var aus [][]byte
+	for _, nalu := range [][]byte{
+		testSlice(0x41, 0, SliceP, 1, 2),
+		testSlice(0x41, 1800, SliceP, 1, 2),
+		testSlice(0x41, 0, SliceP, 2, 4),
+		TrimStartCode(testSlice(0x41, 0, SliceP, 3, 6)),
+	} {
+		if au := s.Push(nalu); au != nil {
+			aus = append(aus, au)
+		}
+	}
+	if au := s.Flush(); au != nil {
+		aus = append(aus, au)
+	}
+	if len(aus) != 3 || len(SplitNALUnits(aus[0])) != 2 || !bytes.Equal(aus[2], testSlice(0x41, 0, SliceP, 3, 6)) {
+		t.Errorf("got %x", aus)
+	}
+}
+
+func TestAVCDecoderConfig(t *testing.T) {
+	sps, pps := mustHex(t, spsHigh720p24), mustHex(t, ppsHighCABAC)
+	b, err := AVCDecoderConfig(append([]byte{0, 0, 0, 1}, sps...), pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{1, 0x64, 0x00, 0x1f, 0xff, 0xe1, 0, byte(len(sps))}, sps...)
+	want = append(append(want, 1, 0, byte(len(pps))), pps...)
+	if !bytes.Equal(b, want) {
+		t.Errorf("got %x, want %x", b, want)
+	}
+	if _, err := AVCDecoderConfig(pps, sps); err == nil {
+		t.Error("swapped parameter sets are accepted")
+	}
+}
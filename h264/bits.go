@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"errors"
+)
+
+// ErrTruncated the rbsp ends before the syntax element
+var ErrTruncated = errors.New("h264: unexpected end of the bitstream")
+
+// bitReader reads the syntax elements of a rbsp, the first error is kept and the later reads return 0
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newBitReader(rbsp []byte) *bitReader {
+	return &bitReader{data: rbsp}
+}
+
+func (r *bitReader) readBit() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.data)*8 {
+		r.err = ErrTruncated
+		return 0
+	}
+	bit := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+	r.pos++
+	return uint32(bit)
+}
+
+// readBits reads n bits, n <= 32
+func (r *bitReader) readBits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.readBit()
+	}
+	return v
+}
+
+func (r *bitReader) readFlag() bool {
+	return r.readBit() == 1
+}
+
+// readUE reads an unsigned exp-golomb code
+func (r *bitReader) readUE() uint32 {
+	zeros := 0
+	for r.readBit() == 0 {
+		if r.err != nil {
+			return 0
+		}
+		if zeros >= 31 {
+			r.err = errors.New("h264: invalid exp-golomb code")
+			return 0
+		}
+		zeros++
+	}
+	return (1<<zeros - 1) + r.readBits(zeros)
+}
+
+// readSE reads a signed exp-golomb code
+func (r *bitReader) readSE() int32 {
+	v := r.readUE()
+	if v&1 == 1 {
+		return int32((v + 1) / 2)
+	}
+	return -int32(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := int32(8), int32(8)
+	for j := 0; j < size && r.err == nil; j++ {
+		if next != 0 {
+			next = (last + r.readSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// moreRBSPData reports whether there is data before the rbsp_stop_one_bit
+func (r *bitReader) moreRBSPData() bool {
+	if r.err != nil {
+		return false
+	}
+	last := len(r.data) - 1
+	for last >= 0 && r.data[last] == 0 {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+	b := r.data[last]
+	stop := last*8 + 7
+	for b&1 == 0 {
+		b >>= 1
+		stop--
+	}
+	return r.pos < stop
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+var (
+	seedSPS = []string{spsHigh720p24, spsHigh1080p30, spsHigh1080p30NoB, spsMain720p25, spsHigh360p30, spsBaseline720p}
+	seedPPS = []string{ppsHighCABAC, ppsHighCABACQP26, ppsBaselineCAVLC, ppsMainCABAC}
+)
+
+func seedBytes(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func FuzzParseSPS(f *testing.F) {
+	for _, s := range seedSPS {
+		f.Add(seedBytes(s))
+	}
+	f.Fuzz(func(t *testing.T, nalu []byte) {
+		s, err := ParseSPS(nalu)
+		if err != nil {
+			return
+		}
+		if s.Width <= 0 || s.Height <= 0 || s.Width > s.CodedWidth || s.Height > s.CodedHeight {
+			t.Fatalf("invalid size %+v", s)
+		}
+		if s.Log2MaxFrameNum < 4 || s.Log2MaxFrameNum > 16 || s.PicOrderCntType > 2 {
+			t.Fatalf("invalid sps %+v", s)
+		}
+		if s.FrameRate() < 0 || s.FrameInterval() < 0 {
+			t.Fatalf("invalid timing %+v", s.VUI)
+		}
+	})
+}
+
+func FuzzParsePPS(f *testing.F) {
+	for _, s := range seedPPS {
+		f.Add(seedBytes(s))
+	}
+	f.Fuzz(func(t *testing.T, nalu []byte) {
+		p, err := ParsePPS(nalu)
+		if err != nil {
+			return
+		}
+		if p.NumSliceGroups < 1 || p.NumSliceGroups > 8 ||
+			p.NumRefIdxL0DefaultActive < 1 || p.NumRefIdxL0DefaultActive > 32 ||
+			p.NumRefIdxL1DefaultActive < 1 || p.NumRefIdxL1DefaultActive > 32 {
+			t.Fatalf("invalid pps %+v", p)
+		}
+	})
+}
+
+func FuzzParseSliceHeader(f *testing.F) {
+	for _, slice := range [][]byte{
+		seedBytes("6588840021ff"),
+		seedBytes("419a2146"),
+		seedBytes("019e4174"),
+		testSlice(0x41, 1800, SliceP, 3, 10),
+	} {
+		f.Add(seedBytes(spsHigh720p24), seedBytes(ppsHighCABAC), slice)
+	}
+	f.Add(seedBytes(spsBaseline720p), seedBytes(ppsBaselineCAVLC), seedBytes("6588840021ff"))
+	f.Fuzz(func(t *testing.T, spsNALU, ppsNALU, slice []byte) {
+		var ps ParamSets
+		if ps.Update(spsNALU) != nil || ps.Update(ppsNALU) != nil {
+			return
+		}
+		h, err := ps.ParseSliceHeader(TrimStartCode(slice))
+		if err != nil {
+			return
+		}
+		sps := ps.SPS(ps.PPS(h.PPSID).SPSID)
+		if h.SliceType > SliceSI || h.FrameNum >= 1<<sps.Log2MaxFrameNum {
+			t.Fatalf("invalid slice header %+v", h)
+		}
+	})
+}
+
+func FuzzScanNALUnits(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 1})
+	f.Add([]byte{0, 0, 0, 0, 1, 0x09, 0xf0, 0, 0, 1})
+	f.Add(bytes.Join([][]byte{{0xff}, seedBytes(spsHigh720p24), seedBytes(ppsHighCABAC), seedBytes("6588840021ff")},
+		[]byte{0, 0, 0, 1}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var tokens []byte
+		for rest := data; len(rest) > 0; {
+			adv, token, err := ScanNALUnits(rest, true)
+			if err != nil || adv <= 0 || adv > len(rest) || len(token) > adv {
+				t.Fatalf("advance %d token %x err %v of %x", adv, token, err, rest)
+			}
+			if token != nil {
+				if i, _ := IndexStartCode(token); i < 0 {
+					t.Fatalf("token %x has no start code", token)
+				}
+				if !bytes.HasSuffix(rest[:adv], token) {
+					t.Fatalf("token %x is not in %x", token, rest[:adv])
+				}
+				tokens = append(tokens, token...)
+			}
+			rest = rest[adv:]
+		}
+		// every byte after the first start code is returned
+		if i, _ := IndexStartCode(data); i >= 0 && !bytes.HasSuffix(data, tokens) {
+			t.Fatalf("tokens %x of %x", tokens, data)
+		}
+		for _, nalu := range SplitNALUnits(data) {
+			if len(nalu) == 0 || !bytes.Contains(data, nalu) {
+				t.Fatalf("nal unit %x of %x", nalu, data)
+			}
+		}
+	})
+}
+
+func FuzzAccessUnitSplitter(f *testing.F) {
+	f.Add(bytes.Join([][]byte{
+		{0, 0, 0, 1, 0x09, 0xf0},
+		{0, 0, 0, 1}, seedBytes(spsHigh720p24),
+		{0, 0, 0, 1}, seedBytes(ppsHighCABAC),
+		testSlice(0x65, 0, SliceI, 0, 0),
+		testSlice(0x41, 0, SliceP, 1, 2),
+		testSlice(0x41, 1800, SliceP, 1, 2),
+		testSlice(0x01, 0, SliceB, 2, 4),
+	}, nil))
+	f.Add([]byte{0, 0, 1, 0x65, 0x88, 0, 0, 1, 0x41, 0x9a})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var nalus []byte
+		for rest := data; len(rest) > 0; {
+			adv, token, _ := ScanNALUnits(rest, true)
+			nalus = append(nalus, token...)
+			rest = rest[adv:]
+		}
+		// the access units have all the nal units in order
+		aus := AccessUnits(data)
+		if got := bytes.Join(aus, nil); !bytes.Equal(got, nalus) {
+			t.Fatalf("access units %x, want %x", got, nalus)
+		}
+		for _, au := range aus {
+			if len(au) == 0 {
+				t.Fatal("empty access unit")
+			}
+		}
+	})
+}
+
+func FuzzEBSPToRBSP(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 3})
+	f.Add(seedBytes(spsHigh720p24))
+	f.Fuzz(func(t *testing.T, rbsp []byte) {
+		ebsp := escape(rbsp)
+		if i, _ := IndexStartCode(ebsp); i >= 0 {
+			t.Fatalf("escaped %x has a start code", ebsp)
+		}
+		if got := EBSPToRBSP(ebsp); !bytes.Equal(got, rbsp) {
+			t.Fatalf("round-trip %x, want %x", got, rbsp)
+		}
+	})
+}
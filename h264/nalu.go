@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package h264 parses the annex-b h264 streams of the live-views, the recordings and the simulator:
+// it splits the nal units, decodes the sps, pps and slice headers, and assembles the access units.
+package h264
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// NALType the nal_unit_type of a nal unit
+type NALType uint8
+
+const (
+	NALUnspecified   NALType = 0
+	NALSlice         NALType = 1
+	NALSliceDPA      NALType = 2
+	NALSliceDPB      NALType = 3
+	NALSliceDPC      NALType = 4
+	NALIDR           NALType = 5
+	NALSEI           NALType = 6
+	NALSPS           NALType = 7
+	NALPPS           NALType = 8
+	NALAUD           NALType = 9
+	NALEndOfSequence NALType = 10
+	NALEndOfStream   NALType = 11
+	NALFiller        NALType = 12
+	NALSPSExt        NALType = 13
+	NALPrefix        NALType = 14
+	NALSubsetSPS     NALType = 15
+	NALDPS           NALType = 16
+	NALAuxSlice      NALType = 19
+	NALSliceExt      NALType = 20
+	NALSliceExtDepth NALType = 21
+)
+
+var nalTypeNames = map[NALType]string{
+	NALUnspecified:   "Unspecified",
+	NALSlice:         "Slice",
+	NALSliceDPA:      "SliceDPA",
+	NALSliceDPB:      "SliceDPB",
+	NALSliceDPC:      "SliceDPC",
+	NALIDR:           "IDR",
+	NALSEI:           "SEI",
+	NALSPS:           "SPS",
+	NALPPS:           "PPS",
+	NALAUD:           "AUD",
+	NALEndOfSequence: "EndOfSequence",
+	NALEndOfStream:   "EndOfStream",
+	NALFiller:        "Filler",
+	NALSPSExt:        "SPSExt",
+	NALPrefix:        "Prefix",
+	NALSubsetSPS:     "SubsetSPS",
+	NALDPS:           "DPS",
+	NALAuxSlice:      "AuxSlice",
+	NALSliceExt:      "SliceExt",
+	NALSliceExtDepth: "SliceExtDepth",
+}
+
+func (t NALType) String() string {
+	if name, ok := nalTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("NALType(%d)", uint8(t))
+}
+
+// IsVCL reports whether the nal unit is a slice of a primary coded picture
+func (t NALType) IsVCL() bool {
+	return t >= NALSlice && t <= NALIDR
+}
+
+// startsAccessUnit reports whether the nal unit starts a new access unit after a vcl nal unit, see 7.4.1.2.3
+func (t NALType) startsAccessUnit() bool {
+	return t == NALAUD || t == NALSEI || t == NALSPS || t == NALPPS || t >= NALPrefix && t <= 18
+}
+
+// IndexStartCode returns the index and the width(3 or 4) of the first start code of b, -1 if there is none
+func IndexStartCode(b []byte) (int, int) {
+	for i := 0; i+2 < len(b); i++ {
+		j := bytes.Index(b[i:], []byte{0, 0})
+		if j < 0 {
+			return -1, 0
+		}
+		i += j
+		if i+2 >= len(b) {
+			return -1, 0
+		}
+		switch b[i+2] {
+		case 1:
+			return i, 3
+		case 0:
+			if i+3 < len(b) && b[i+3] == 1 {
+				return i, 4
+			}
+		}
+	}
+	return -1, 0
+}
+
+// TrimStartCode returns the nal unit without the leading zeros and start code
+func TrimStartCode(nalu []byte) []byte {
+	if i, w := IndexStartCode(nalu); i >= 0 && bytes.Count(nalu[:i], []byte{0}) == i {
+		return nalu[i+w:]
+	}
+	return nalu
+}
+
+// Type returns the type of the nal unit with or without start code, NALUnspecified if it is empty
+func Type(nalu []byte) NALType {
+	nalu = TrimStartCode(nalu)
+	if len(nalu) == 0 {
+		return NALUnspecified
+	}
+	return NALType(nalu[0] & 0x1f)
+}
+
+// RefIDC returns the nal_ref_idc of the nal unit with or without start code
+func RefIDC(nalu []byte) uint8 {
+	nalu = TrimStartCode(nalu)
+	if len(nalu) == 0 {
+		return 0
+	}
+	return nalu[0] >> 5 & 0x3
+}
+
+// ScanNALUnits is a bufio.SplitFunc splitting an annex-b stream into nal units with the start code,
+// the bytes before the first start code are dropped.
+func ScanNALUnits(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	start, w := IndexStartCode(data)
+	if start < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	if start > 0 && bytes.Count(data[:start], []byte{0}) != start {
+		return start, nil, nil
+	}
+	start += w
+	end, _ := IndexStartCode(data[start:])
+	if end < 0 {
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+	return start + end, data[:start+end], nil
+}
+
+// SplitNALUnits returns the nal units of the annex-b data without the start codes,
+// they share the memory of data.
+func SplitNALUnits(data []byte) [][]byte {
+	var units [][]byte
+	start, w := IndexStartCode(data)
+	for start >= 0 {
+		data = data[start+w:]
+		start, w = IndexStartCode(data)
+		nalu := data
+		if start >= 0 {
+			nalu = data[:start]
+		}
+		// the zeros before a 4-byte start code are the trailing_zero_8bits
+		nalu = bytes.TrimRight(nalu, "\x00")
+		if len(nalu) > 0 {
+			units = append(units, nalu)
+		}
+	}
+	return units
+}
+
+// ContainsType reports whether the annex-b data contains a nal unit of the type, such as NALIDR of a keyframe
+func ContainsType(data []byte, t NALType) bool {
+	for {
+		i, w := IndexStartCode(data)
+		if i < 0 {
+			return false
+		}
+		data = data[i+w:]
+		if len(data) > 0 && NALType(data[0]&0x1f) == t {
+			return true
+		}
+	}
+}
+
+// EBSPToRBSP removes the emulation prevention bytes of the payload of a nal unit
+func EBSPToRBSP(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 0x03 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// escape inserts the emulation prevention bytes into the rbsp, see 7.4.1
+func escape(rbsp []byte) []byte {
+	out := make([]byte, 0, len(rbsp)+len(rbsp)/2)
+	zeros := 0
+	for _, c := range rbsp {
+		if zeros >= 2 && c <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func TestEBSPToRBSP(t *testing.T) {
+	tests := []struct {
+		name       string
+		rbsp, ebsp []byte
+	}{
+		{"empty", []byte{}, []byte{}},
+		{"no zeros", []byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"start code", []byte{0, 0, 1}, []byte{0, 0, 3, 1}},
+		{"zeros", []byte{0, 0, 0, 0, 0, 0}, []byte{0, 0, 3, 0, 0, 3, 0, 0}},
+		{"escaped 3", []byte{0, 0, 3}, []byte{0, 0, 3, 3}},
+		{"not escaped", []byte{0, 0, 4, 0, 1}, []byte{0, 0, 4, 0, 1}},
+		{"trailing zeros", []byte{5, 0, 0}, []byte{5, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escape(tt.rbsp); !bytes.Equal(got, tt.ebsp) {
+				t.Errorf("escape %x, want %x", got, tt.ebsp)
+			}
+			if got := EBSPToRBSP(tt.ebsp); !bytes.Equal(got, tt.rbsp) {
+				t.Errorf("EBSPToRBSP %x, want %x", got, tt.rbsp)
+			}
+		})
+	}
+}
+
+func TestEBSPToRBSPParamSets(t *testing.T) {
+	for _, s := range []string{spsHigh720p24, spsHigh1080p30, spsMain720p25, spsHigh360p30, ppsHighCABAC} {
+		nalu := mustHex(t, s)
+		rbsp := EBSPToRBSP(nalu[1:])
+		if got := escape(rbsp); !bytes.Equal(got, nalu[1:]) {
+			t.Errorf("%s: round-trip %x", s, got)
+		}
+	}
+	// the sps of x264 has two emulation prevention bytes in the vui
+	if nalu := mustHex(t, spsHigh720p24); len(nalu)-1-len(EBSPToRBSP(nalu[1:])) != 2 {
+		t.Error("the emulation prevention bytes are not removed")
+	}
+}
+
+func TestIndexStartCode(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		i, w int
+	}{
+		{nil, -1, 0},
+		{[]byte{0, 0}, -1, 0},
+		{[]byte{0, 0, 1}, 0, 3},
+		{[]byte{0, 0, 0, 1}, 0, 4},
+		{[]byte{9, 0, 0, 1, 0x65}, 1, 3},
+		{[]byte{9, 0, 0, 0, 0, 1}, 2, 4},
+		{[]byte{0, 0, 2, 0, 0, 0}, -1, 0},
+	}
+	for _, tt := range tests {
+		if i, w := IndexStartCode(tt.b); i != tt.i || w != tt.w {
+			t.Errorf("%x: %d %d, want %d %d", tt.b, i, w, tt.i, tt.w)
+		}
+	}
+}
+
+func TestScanNALUnits(t *testing.T) {
+	sps, pps := mustHex(t, spsHigh720p24), mustHex(t, ppsHighCABAC)
+	var stream []byte
+	stream = append(stream, 0xff) // garbage before the first start code
+	stream = append(append(stream, 0, 0, 0, 1), sps...)
+	stream = append(append(stream, 0, 0, 1), pps...)
+	stream = append(append(stream, 0, 0, 0, 1), 0x65, 0x88, 0x84, 0x00, 0x00, 0x03, 0x01)
+
+	want := [][]byte{
+		append([]byte{0, 0, 0, 1}, sps...),
+		append([]byte{0, 0, 1}, pps...),
+		{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x00, 0x03, 0x01},
+	}
+	for _, size := range []int{1, 3, 7, 4096} {
+		sc := bufio.NewScanner(bytes.NewReader(stream))
+		sc.Buffer(make([]byte, size), 1<<20)
+		sc.Split(ScanNALUnits)
+		var got [][]byte
+		for sc.Scan() {
+			got = append(got, bytes.Clone(sc.Bytes()))
+		}
+		if err := sc.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("buffer %d: got %x, want %x", size, got, want)
+		}
+	}
+
+	units := SplitNALUnits(stream)
+	if len(units) != 3 || !bytes.Equal(units[0], sps) || !bytes.Equal(units[1], pps) || Type(units[2]) != NALIDR {
+		t.Errorf("SplitNALUnits %x", units)
+	}
+	if !ContainsType(stream, NALIDR) || ContainsType(stream, NALSlice) {
+		t.Error("ContainsType is wrong")
+	}
+}
+
+func TestTypeAndRefIDC(t *testing.T) {
+	for _, tt := range []struct {
+		nalu []byte
+		t    NALType
+		ref  uint8
+	}{
+		{nil, NALUnspecified, 0},
+		{[]byte{0, 0, 1}, NALUnspecified, 0},
+		{[]byte{0x67}, NALSPS, 3},
+		{[]byte{0, 0, 0, 1, 0x41}, NALSlice, 2},
+		{[]byte{0, 0, 1, 0x01}, NALSlice, 0},
+		{[]byte{0x09, 0xf0}, NALAUD, 0},
+	} {
+		if Type(tt.nalu) != tt.t || RefIDC(tt.nalu) != tt.ref {
+			t.Errorf("%x: %v %d, want %v %d", tt.nalu, Type(tt.nalu), RefIDC(tt.nalu), tt.t, tt.ref)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// PPS the picture parameter set, see 7.3.2.2
+type PPS struct {
+	ID    uint32
+	SPSID uint32
+	// EntropyCodingMode true for CABAC, false for CAVLC
+	EntropyCodingMode                 bool
+	BottomFieldPicOrderInFramePresent bool
+	NumSliceGroups                    uint32
+	NumRefIdxL0DefaultActive          uint32
+	NumRefIdxL1DefaultActive          uint32
+	WeightedPred                      bool
+	WeightedBipredIDC                 uint32
+	PicInitQP                         int32
+	PicInitQS                         int32
+	ChromaQPIndexOffset               int32
+	DeblockingFilterControlPresent    bool
+	ConstrainedIntraPred              bool
+	RedundantPicCntPresent            bool
+	Transform8x8Mode                  bool
+	SecondChromaQPIndexOffset         int32
+}
+
+// ParsePPS parses the pps nal unit with or without start code,
+// the scaling matrices of the high profiles are read as the ones of the chroma format 4:2:0,
+// ParamSets.Update uses the chroma format of the sps.
+func ParsePPS(nalu []byte) (*PPS, error) {
+	return parsePPS(nalu, 1)
+}
+
+func parsePPS(nalu []byte, chromaFormatIDC uint32) (*PPS, error) {
+	nalu = TrimStartCode(nalu)
+	if len(nalu) < 2 || NALType(nalu[0]&0x1f) != NALPPS {
+		return nil, errors.New("h264: not a pps nal unit")
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:]))
+	p := &PPS{
+		ID:    r.readUE(),
+		SPSID: r.readUE(),
+	}
+	if p.ID > 255 || p.SPSID > 31 {
+		return nil, fmt.Errorf("h264: invalid pps id %d or sps id %d", p.ID, p.SPSID)
+	}
+	p.EntropyCodingMode = r.readFlag()
+	p.BottomFieldPicOrderInFramePresent = r.readFlag()
+	p.NumSliceGroups = r.readUE() + 1
+	if p.NumSliceGroups > 8 {
+		return nil, fmt.Errorf("h264: invalid num_slice_groups %d", p.NumSliceGroups)
+	}
+	if p.NumSliceGroups > 1 {
+		switch mapType := r.readUE(); mapType {
+		case 0:
+			for i := uint32(0); i < p.NumSliceGroups; i++ {
+				r.readUE() // run_length_minus1
+			}
+		case 2:
+			for i := uint32(0); i < p.NumSliceGroups-1; i++ {
+				r.readUE() // top_left
+				r.readUE() // bottom_right
+			}
+		case 3, 4, 5:
+			r.readBit() // slice_group_change_direction_flag
+			r.readUE()  // slice_group_change_rate_minus1
+		case 6:
+			n := r.readUE() + 1
+			if n > 1<<20 {
+				return nil, errors.New("h264: invalid pic_size_in_map_units")
+			}
+			w := bits.Len32(p.NumSliceGroups - 1)
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				r.readBits(w)
+			}
+		case 1:
+		default:
+			return nil, fmt.Errorf("h264: invalid slice_group_map_type %d", mapType)
+		}
+	}
+	p.NumRefIdxL0DefaultActive = r.readUE() + 1
+	p.NumRefIdxL1DefaultActive = r.readUE() + 1
+	if p.NumRefIdxL0DefaultActive > 32 || p.NumRefIdxL1DefaultActive > 32 {
+		return nil, errors.New("h264: invalid num_ref_idx_default_active")
+	}
+	p.WeightedPred = r.readFlag()
+	p.WeightedBipredIDC = r.readBits(2)
+	p.PicInitQP = r.readSE() + 26
+	p.PicInitQS = r.readSE() + 26
+	p.ChromaQPIndexOffset = r.readSE()
+	p.DeblockingFilterControlPresent = r.readFlag()
+	p.ConstrainedIntraPred = r.readFlag()
+	p.RedundantPicCntPresent = r.readFlag()
+	p.SecondChromaQPIndexOffset = p.ChromaQPIndexOffset
+	if r.moreRBSPData() {
+		p.Transform8x8Mode = r.readFlag()
+		if r.readFlag() {
+			n := 6
+			if p.Transform8x8Mode {
+				if chromaFormatIDC == 3 {
+					n += 6
+				} else {
+					n += 2
+				}
+			}
+			for i := 0; i < n; i++ {
+				if !r.readFlag() {
+					continue
+				}
+				if i < 6 {
+					r.skipScalingList(16)
+				} else {
+					r.skipScalingList(64)
+				}
+			}
+		}
+		p.SecondChromaQPIndexOffset = r.readSE()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return p, nil
+}
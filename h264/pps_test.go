@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import "testing"
+
+func TestParsePPS(t *testing.T) {
+	tests := []struct {
+		name string
+		pps  string
+		want PPS
+	}{
+		{name: "high cabac", pps: ppsHighCABAC, want: PPS{EntropyCodingMode: true, NumSliceGroups: 1,
+			NumRefIdxL0DefaultActive: 3, NumRefIdxL1DefaultActive: 1, WeightedPred: true, WeightedBipredIDC: 2,
+			PicInitQP: 23, PicInitQS: 26, ChromaQPIndexOffset: -2, DeblockingFilterControlPresent: true,
+			Transform8x8Mode: true, SecondChromaQPIndexOffset: -2}},
+		{name: "high cabac qp 26", pps: ppsHighCABACQP26, want: PPS{EntropyCodingMode: true, NumSliceGroups: 1,
+			NumRefIdxL0DefaultActive: 3, NumRefIdxL1DefaultActive: 1, WeightedPred: true, WeightedBipredIDC: 2,
+			PicInitQP: 26, PicInitQS: 26, ChromaQPIndexOffset: -2, DeblockingFilterControlPresent: true,
+			Transform8x8Mode: true, SecondChromaQPIndexOffset: -2}},
+		{name: "baseline cavlc", pps: ppsBaselineCAVLC, want: PPS{NumSliceGroups: 1,
+			NumRefIdxL0DefaultActive: 1, NumRefIdxL1DefaultActive: 1, PicInitQP: 26, PicInitQS: 26}},
+		{name: "main cabac", pps: ppsMainCABAC, want: PPS{EntropyCodingMode: true, NumSliceGroups: 1,
+			NumRefIdxL0DefaultActive: 1, NumRefIdxL1DefaultActive: 1, PicInitQP: 26, PicInitQS: 26,
+			DeblockingFilterControlPresent: true, Transform8x8Mode: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePPS(mustHex(t, tt.pps))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *p != tt.want {
+				t.Errorf("got %+v\nwant %+v", *p, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePPSErrors(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		{0x68},
+		mustHex(t, spsHigh720p24),
+		mustHex(t, ppsHighCABAC)[:2],
+		{0x68, 0x00, 0x00, 0x80}, // pps id out of range
+	} {
+		if p, err := ParsePPS(b); err == nil {
+			t.Errorf("%x: no error, got %+v", b, p)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SliceType the slice_type of a slice header, the values 5 to 9 are reduced to 0 to 4
+type SliceType uint32
+
+const (
+	SliceP  SliceType = 0
+	SliceB  SliceType = 1
+	SliceI  SliceType = 2
+	SliceSP SliceType = 3
+	SliceSI SliceType = 4
+)
+
+func (t SliceType) String() string {
+	switch t {
+	case SliceP:
+		return "P"
+	case SliceB:
+		return "B"
+	case SliceI:
+		return "I"
+	case SliceSP:
+		return "SP"
+	case SliceSI:
+		return "SI"
+	}
+	return fmt.Sprintf("SliceType(%d)", uint32(t))
+}
+
+// SliceHeader the fields of the slice header until the picture order count, see 7.3.3
+type SliceHeader struct {
+	NALType   NALType
+	NALRefIDC uint8
+
+	FirstMbInSlice uint32
+	SliceType      SliceType
+	PPSID          uint32
+	ColourPlaneID  uint8
+	FrameNum       uint32
+	FieldPic       bool
+	BottomField    bool
+	// IDRPicID the idr_pic_id of an IDR slice
+	IDRPicID               uint32
+	PicOrderCntLsb         uint32
+	DeltaPicOrderCntBottom int32
+	DeltaPicOrderCnt       [2]int32
+}
+
+// IsIDR reports whether the slice belongs to an IDR picture
+func (h *SliceHeader) IsIDR() bool {
+	return h.NALType == NALIDR
+}
+
+// slicePPSID reads the pps id of the slice nal unit without start code
+func slicePPSID(nalu []byte) (uint32, error) {
+	if len(nalu) < 2 || !NALType(nalu[0]&0x1f).IsVCL() {
+		return 0, errors.New("h264: not a slice nal unit")
+	}
+	n := len(nalu)
+	if n > 16 {
+		n = 16
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:n]))
+	r.readUE() // first_mb_in_slice
+	r.readUE() // slice_type
+	id := r.readUE()
+	return id, r.err
+}
+
+// ParseSliceHeader parses the header of the slice nal unit with or without start code,
+// sps and pps are the parameter sets referred by it, see ParamSets.ParseSliceHeader.
+func ParseSliceHeader(nalu []byte, sps *SPS, pps *PPS) (*SliceHeader, error) {
+	nalu = TrimStartCode(nalu)
+	if len(nalu) < 2 || !NALType(nalu[0]&0x1f).IsVCL() {
+		return nil, errors.New("h264: not a slice nal unit")
+	}
+	if sps == nil || pps == nil || pps.SPSID != sps.ID {
+		return nil, errors.New("h264: the parameter sets of the slice are missing")
+	}
+	// the header is short, the slice data is not unescaped
+	n := len(nalu)
+	if n > 64 {
+		n = 64
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:n]))
+	h := &SliceHeader{
+		NALType:        NALType(nalu[0] & 0x1f),
+		NALRefIDC:      nalu[0] >> 5 & 0x3,
+		FirstMbInSlice: r.readUE(),
+	}
+	sliceType := r.readUE()
+	if sliceType > 9 {
+		return nil, fmt.Errorf("h264: invalid slice_type %d", sliceType)
+	}
+	h.SliceType = SliceType(sliceType % 5)
+	h.PPSID = r.readUE()
+	if r.err == nil && h.PPSID != pps.ID {
+		return nil, fmt.Errorf("h264: the slice refers to pps %d, not %d", h.PPSID, pps.ID)
+	}
+	if sps.SeparateColourPlane {
+		h.ColourPlaneID = uint8(r.readBits(2))
+	}
+	h.FrameNum = r.readBits(int(sps.Log2MaxFrameNum))
+	if !sps.FrameMbsOnly {
+		if h.FieldPic = r.readFlag(); h.FieldPic {
+			h.BottomField = r.readFlag()
+		}
+	}
+	if h.IsIDR() {
+		h.IDRPicID = r.readUE()
+	}
+	if sps.PicOrderCntType == 0 {
+		h.PicOrderCntLsb = r.readBits(int(sps.Log2MaxPicOrderCntLsb))
+		if pps.BottomFieldPicOrderInFramePresent && !h.FieldPic {
+			h.DeltaPicOrderCntBottom = r.readSE()
+		}
+	}
+	if sps.PicOrderCntType == 1 && !sps.DeltaPicOrderAlwaysZero {
+		h.DeltaPicOrderCnt[0] = r.readSE()
+		if pps.BottomFieldPicOrderInFramePresent && !h.FieldPic {
+			h.DeltaPicOrderCnt[1] = r.readSE()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return h, nil
+}
+
+// firstSliceOfNewPicture reports whether the slice of cur starts a new primary coded picture after prev,
+// see 7.4.1.2.4
+func firstSliceOfNewPicture(prev, cur *SliceHeader) bool {
+	return cur.FrameNum != prev.FrameNum ||
+		cur.PPSID != prev.PPSID ||
+		cur.FieldPic != prev.FieldPic ||
+		cur.BottomField != prev.BottomField ||
+		(cur.NALRefIDC == 0) != (prev.NALRefIDC == 0) ||
+		cur.PicOrderCntLsb != prev.PicOrderCntLsb ||
+		cur.DeltaPicOrderCntBottom != prev.DeltaPicOrderCntBottom ||
+		cur.DeltaPicOrderCnt != prev.DeltaPicOrderCnt ||
+		cur.IsIDR() != prev.IsIDR() ||
+		cur.IsIDR() && prev.IsIDR() && cur.IDRPicID != prev.IDRPicID
+}
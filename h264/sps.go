@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SPS the sequence parameter set, see 7.3.2.1.1
+type SPS struct {
+	ProfileIDC uint8
+	// ConstraintFlags constraint_set0_flag to constraint_set5_flag from the high bit, and the reserved bits
+	ConstraintFlags uint8
+	LevelIDC        uint8
+	ID              uint32
+
+	// ChromaFormatIDC 0 monochrome, 1 4:2:0, 2 4:2:2, 3 4:4:4
+	ChromaFormatIDC     uint32
+	SeparateColourPlane bool
+	BitDepthLuma        uint32
+	BitDepthChroma      uint32
+
+	Log2MaxFrameNum         uint32
+	PicOrderCntType         uint32
+	Log2MaxPicOrderCntLsb   uint32
+	DeltaPicOrderAlwaysZero bool
+	MaxNumRefFrames         uint32
+	FrameMbsOnly            bool
+
+	// CodedWidth and CodedHeight the size of the macroblocks
+	CodedWidth  int
+	CodedHeight int
+	// Width and Height the size after the frame cropping
+	Width  int
+	Height int
+
+	// VUI the video usability information, nil if it is not present
+	VUI *VUI
+}
+
+// VUI the video usability information of the sps, see E.1.1
+type VUI struct {
+	// SARWidth and SARHeight the sample aspect ratio, 0 if unspecified
+	SARWidth  uint32
+	SARHeight uint32
+
+	VideoSignalTypePresent bool
+	VideoFormat            uint8
+	VideoFullRange         bool
+	// the colour description of Table E-3, E-4 and E-5, 2(unspecified) if not present
+	ColourPrimaries         uint8
+	TransferCharacteristics uint8
+	MatrixCoefficients      uint8
+
+	TimingInfoPresent bool
+	NumUnitsInTick    uint32
+	TimeScale         uint32
+	FixedFrameRate    bool
+
+	// BitstreamRestriction the max_num_reorder_frames and max_dec_frame_buffering are present,
+	// a stream without b-frames has MaxNumReorderFrames 0
+	BitstreamRestriction bool
+	MaxNumReorderFrames  uint32
+	MaxDecFrameBuffering uint32
+}
+
+// the sample aspect ratios of aspect_ratio_idc 1 to 16, Table E-1
+var sampleAspectRatios = [...][2]uint32{
+	{1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// FrameRate returns the frame rate of the VUI timing info, 0 if it is unknown.
+// a frame has two fields, so it is time_scale / (2 * num_units_in_tick).
+func (s *SPS) FrameRate() float64 {
+	if s.VUI == nil || !s.VUI.TimingInfoPresent || s.VUI.NumUnitsInTick == 0 || s.VUI.TimeScale == 0 {
+		return 0
+	}
+	return float64(s.VUI.TimeScale) / float64(2*uint64(s.VUI.NumUnitsInTick))
+}
+
+// FrameInterval returns the duration of a frame of the VUI timing info, 0 if it is unknown
+func (s *SPS) FrameInterval() time.Duration {
+	if s.VUI == nil || !s.VUI.TimingInfoPresent || s.VUI.NumUnitsInTick == 0 || s.VUI.TimeScale == 0 {
+		return 0
+	}
+	return time.Duration(uint64(time.Second) * 2 * uint64(s.VUI.NumUnitsInTick) / uint64(s.VUI.TimeScale))
+}
+
+// chromaArrayType returns ChromaArrayType of 7.4.2.1.1
+func (s *SPS) chromaArrayType() uint32 {
+	if s.SeparateColourPlane {
+		return 0
+	}
+	return s.ChromaFormatIDC
+}
+
+// hasChromaInfo reports whether the profile has the chroma and bit depth fields
+func hasChromaInfo(profile uint8) bool {
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		return true
+	}
+	return false
+}
+
+// ParseSPS parses the sps nal unit with or without start code
+func ParseSPS(nalu []byte) (*SPS, error) {
+	s, _, err := parseSPS(nalu)
+	return s, err
+}
+
+// parseSPS returns the reader at the end of the sps
+func parseSPS(nalu []byte) (*SPS, *bitReader, error) {
+	nalu = TrimStartCode(nalu)
+	if len(nalu) < 4 || NALType(nalu[0]&0x1f) != NALSPS {
+		return nil, nil, errors.New("h264: not a sps nal unit")
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:]))
+	s := &SPS{
+		ProfileIDC:      uint8(r.readBits(8)),
+		ConstraintFlags: uint8(r.readBits(8)),
+		LevelIDC:        uint8(r.readBits(8)),
+		ID:              r.readUE(),
+		ChromaFormatIDC: 1,
+		BitDepthLuma:    8,
+		BitDepthChroma:  8,
+	}
+	if s.ID > 31 {
+		return nil, nil, fmt.Errorf("h264: invalid sps id %d", s.ID)
+	}
+	if hasChromaInfo(s.ProfileIDC) {
+		s.ChromaFormatIDC = r.readUE()
+		if s.ChromaFormatIDC > 3 {
+			return nil, nil, fmt.Errorf("h264: invalid chroma_format_idc %d", s.ChromaFormatIDC)
+		}
+		if s.ChromaFormatIDC == 3 {
+			s.SeparateColourPlane = r.readFlag()
+		}
+		s.BitDepthLuma = r.readUE() + 8
+		s.BitDepthChroma = r.readUE() + 8
+		if s.BitDepthLuma > 14 || s.BitDepthChroma > 14 {
+			return nil, nil, errors.New("h264: invalid bit depth")
+		}
+		r.readBit() // qpprime_y_zero_transform_bypass_flag
+		if r.readFlag() {
+			n := 8
+			if s.ChromaFormatIDC == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if !r.readFlag() {
+					continue
+				}
+				if i < 6 {
+					r.skipScalingList(16)
+				} else {
+					r.skipScalingList(64)
+				}
+			}
+		}
+	}
+
+	s.Log2MaxFrameNum = r.readUE() + 4
+	if s.Log2MaxFrameNum > 16 {
+		return nil, nil, fmt.Errorf("h264: invalid log2_max_frame_num %d", s.Log2MaxFrameNum)
+	}
+	s.PicOrderCntType = r.readUE()
+	switch s.PicOrderCntType {
+	case 0:
+		s.Log2MaxPicOrderCntLsb = r.readUE() + 4
+		if s.Log2MaxPicOrderCntLsb > 16 {
+			return nil, nil, fmt.Errorf("h264: invalid log2_max_pic_order_cnt_lsb %d", s.Log2MaxPicOrderCntLsb)
+		}
+	case 1:
+		s.DeltaPicOrderAlwaysZero = r.readFlag()
+		r.readSE() // offset_for_non_ref_pic
+		r.readSE() // offset_for_top_to_bottom_field
+		n := r.readUE()
+		if n > 255 {
+			return nil, nil, fmt.Errorf("h264: invalid num_ref_frames_in_pic_order_cnt_cycle %d", n)
+		}
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			r.readSE()
+		}
+	case 2:
+	default:
+		return nil, nil, fmt.Errorf("h264: invalid pic_order_cnt_type %d", s.PicOrderCntType)
+	}
+	s.MaxNumRefFrames = r.readUE()
+	r.readBit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.readUE() + 1
+	heightMapUnits := r.readUE() + 1
+	s.FrameMbsOnly = r.readFlag()
+	if !s.FrameMbsOnly {
+		r.readBit() // mb_adaptive_frame_field_flag
+	}
+	r.readBit() // direct_8x8_inference_flag
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if widthMbs > 1<<12 || heightMapUnits > 1<<12 {
+		return nil, nil, errors.New("h264: invalid picture size")
+	}
+	frameHeightMbs := heightMapUnits
+	if !s.FrameMbsOnly {
+		frameHeightMbs *= 2
+	}
+	s.CodedWidth, s.CodedHeight = int(widthMbs)*16, int(frameHeightMbs)*16
+	s.Width, s.Height = s.CodedWidth, s.CodedHeight
+
+	if r.readFlag() {
+		left, right, top, bottom := r.readUE(), r.readUE(), r.readUE(), r.readUE()
+		// the crop unit of 7.4.2.1.1
+		cropX, cropY := uint32(1), uint32(2)
+		if s.FrameMbsOnly {
+			cropY = 1
+		}
+		switch s.chromaArrayType() {
+		case 1:
+			cropX, cropY = 2, cropY*2
+		case 2:
+			cropX = 2
+		}
+		x, y := uint64(cropX)*(uint64(left)+uint64(right)), uint64(cropY)*(uint64(top)+uint64(bottom))
+		if x >= uint64(s.CodedWidth) || y >= uint64(s.CodedHeight) {
+			return nil, nil, errors.New("h264: invalid frame cropping")
+		}
+		s.Width -= int(x)
+		s.Height -= int(y)
+	}
+	if r.readFlag() {
+		vui, err := parseVUI(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.VUI = vui
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	return s, r, nil
+}
+
+// parseVUI parses the vui, the hrd parameters are skipped
+func parseVUI(r *bitReader) (*VUI, error) {
+	v := &VUI{ColourPrimaries: 2, TransferCharacteristics: 2, MatrixCoefficients: 2, VideoFormat: 5}
+	if r.readFlag() {
+		idc := r.readBits(8)
+		switch {
+		case idc == 255:
+			v.SARWidth, v.SARHeight = r.readBits(16), r.readBits(16)
+		case idc >= 1 && int(idc) <= len(sampleAspectRatios):
+			v.SARWidth, v.SARHeight = sampleAspectRatios[idc-1][0], sampleAspectRatios[idc-1][1]
+		}
+	}
+	if r.readFlag() {
+		r.readBit() // overscan_appropriate_flag
+	}
+	if v.VideoSignalTypePresent = r.readFlag(); v.VideoSignalTypePresent {
+		v.VideoFormat = uint8(r.readBits(3))
+		v.VideoFullRange = r.readFlag()
+		if r.readFlag() {
+			v.ColourPrimaries = uint8(r.readBits(8))
+			v.TransferCharacteristics = uint8(r.readBits(8))
+			v.MatrixCoefficients = uint8(r.readBits(8))
+		}
+	}
+	if r.readFlag() {
+		if r.readUE() > 5 || r.readUE() > 5 {
+			return nil, errors.New("h264: invalid chroma sample location")
+		}
+	}
+	if v.TimingInfoPresent = r.readFlag(); v.TimingInfoPresent {
+		v.NumUnitsInTick = r.readBits(32)
+		v.TimeScale = r.readBits(32)
+		v.FixedFrameRate = r.readFlag()
+	}
+	nalHRD := r.readFlag()
+	if nalHRD {
+		skipHRD(r)
+	}
+	vclHRD := r.readFlag()
+	if vclHRD {
+		skipHRD(r)
+	}
+	if nalHRD || vclHRD {
+		r.readBit() // low_delay_hrd_flag
+	}
+	r.readBit() // pic_struct_present_flag
+	if v.BitstreamRestriction = r.readFlag(); v.BitstreamRestriction {
+		r.readBit() // motion_vectors_over_pic_boundaries_flag
+		r.readUE()  // max_bytes_per_pic_denom
+		r.readUE()  // max_bits_per_mb_denom
+		r.readUE()  // log2_max_mv_length_horizontal
+		r.readUE()  // log2_max_mv_length_vertical
+		v.MaxNumReorderFrames = r.readUE()
+		v.MaxDecFrameBuffering = r.readUE()
+	}
+	return v, r.err
+}
+
+// skipHRD skips the hrd_parameters of E.1.2
+func skipHRD(r *bitReader) {
+	n := r.readUE() + 1
+	if n > 32 {
+		r.err = fmt.Errorf("h264: invalid cpb_cnt %d", n)
+		return
+	}
+	r.readBits(8) // bit_rate_scale and cpb_size_scale
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		r.readUE()  // bit_rate_value_minus1
+		r.readUE()  // cpb_size_value_minus1
+		r.readBit() // cbr_flag
+	}
+	r.readBits(20) // the lengths of the delays and time_offset_length
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package h264
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// the parameter sets of the streams encoded by x264 and ffmpeg
+var (
+	// high 3.1 1280x720 24fps, sar 1:1, with b-frames and emulation prevention bytes
+	spsHigh720p24 = "6764001facd9405005bb011000000300100000030300f1831960"
+	// high 4.0 1920x1080 30fps cropped from 1088, sar 1:1
+	spsHigh1080p30 = "67640028acd940780227e5c044000003000400000300f03c60c658"
+	// high 4.0 1920x1080 30fps without b-frames
+	spsHigh1080p30NoB = "67640028acd940780227e584000003000400000300f03c60c920"
+	// main 3.1 1280x720 25fps, bt.709 colour description
+	spsMain720p25 = "674d401fe8802802dd80b501010140000003004000000c83c60c4480"
+	// high 3.0 640x360 30fps, fixed frame rate
+	spsHigh360p30 = "6764001eacd940a02ff9610000030001000003003c8f162d96"
+	// constrained baseline 3.1 1280x720, pic_order_cnt_type 2 and no vui
+	spsBaseline720p = "6742e01fdc05005b90"
+
+	// cabac, 3 references, weighted prediction, 8x8 transform, chroma qp offset -2
+	ppsHighCABAC = "68ebe3cb22c0"
+	// the same with pic_init_qp 26
+	ppsHighCABACQP26 = "68ebecb22c"
+	// cavlc of the baseline profile
+	ppsBaselineCAVLC = "68ce3880"
+	// cabac with 8x8 transform, no weighted prediction
+	ppsMainCABAC = "68ee3cb0"
+)
+
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		name             string
+		sps              string
+		profile, level   uint8
+		width, height    int
+		codedHeight      int
+		pocType          uint32
+		frameRate        float64
+		interval         time.Duration
+		sar              [2]uint32
+		reorder          uint32
+		colour           uint8
+		noVUI, fixedRate bool
+	}{
+		{name: "high 720p24", sps: spsHigh720p24, profile: 100, level: 31, width: 1280, height: 720, codedHeight: 720,
+			frameRate: 24, interval: time.Second / 24, sar: [2]uint32{1, 1}, reorder: 2, colour: 2},
+		{name: "high 1080p30", sps: spsHigh1080p30, profile: 100, level: 40, width: 1920, height: 1080, codedHeight: 1088,
+			frameRate: 30, interval: time.Second / 30, sar: [2]uint32{1, 1}, reorder: 2, colour: 2},
+		{name: "high 1080p30 without b-frames", sps: spsHigh1080p30NoB, profile: 100, level: 40, width: 1920, height: 1080,
+			codedHeight: 1088, frameRate: 30, interval: time.Second / 30, reorder: 0, colour: 2},
+		{name: "main 720p25", sps: spsMain720p25, profile: 77, level: 31, width: 1280, height: 720, codedHeight: 720,
+			frameRate: 25, interval: time.Second / 25, sar: [2]uint32{1, 1}, reorder: 1, colour: 1},
+		{name: "high 360p30", sps: spsHigh360p30, profile: 100, level: 30, width: 640, height: 360, codedHeight: 368,
+			frameRate: 30, interval: time.Second / 30, reorder: 2, colour: 2, fixedRate: true},
+		{name: "baseline 720p", sps: spsBaseline720p, profile: 66, level: 31, width: 1280, height: 720, codedHeight: 720,
+			pocType: 2, noVUI: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, r, err := parseSPS(mustHex(t, tt.sps))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the whole sps is consumed until the rbsp_stop_one_bit
+			if r.moreRBSPData() {
+				t.Errorf("sps has unread data at bit %d of %d", r.pos, len(r.data)*8)
+			}
+			if s.ProfileIDC != tt.profile || s.LevelIDC != tt.level {
+				t.Errorf("profile %d level %d, want %d %d", s.ProfileIDC, s.LevelIDC, tt.profile, tt.level)
+			}
+			if s.Width != tt.width || s.Height != tt.height || s.CodedHeight != tt.codedHeight {
+				t.Errorf("size %dx%d coded height %d, want %dx%d %d", s.Width, s.Height, s.CodedHeight, tt.width, tt.height, tt.codedHeight)
+			}
+			if s.PicOrderCntType != tt.pocType || s.ChromaFormatIDC != 1 || s.BitDepthLuma != 8 || !s.FrameMbsOnly {
+				t.Errorf("unexpected sps %+v", s)
+			}
+			if s.FrameRate() != tt.frameRate || s.FrameInterval() != tt.interval {
+				t.Errorf("frame rate %v interval %v, want %v %v", s.FrameRate(), s.FrameInterval(), tt.frameRate, tt.interval)
+			}
+			if tt.noVUI {
+				if s.VUI != nil {
+					t.Errorf("unexpected vui %+v", s.VUI)
+				}
+				return
+			}
+			if s.VUI == nil {
+				t.Fatal("vui is missing")
+			}
+			v := s.VUI
+			if [2]uint32{v.SARWidth, v.SARHeight} != tt.sar {
+				t.Errorf("sar %d:%d, want %d:%d", v.SARWidth, v.SARHeight, tt.sar[0], tt.sar[1])
+			}
+			if !v.BitstreamRestriction || v.MaxNumReorderFrames != tt.reorder {
+				t.Errorf("max_num_reorder_frames %d, want %d", v.MaxNumReorderFrames, tt.reorder)
+			}
+			if v.ColourPrimaries != tt.colour || v.MatrixCoefficients != tt.colour || v.FixedFrameRate != tt.fixedRate {
+				t.Errorf("unexpected vui %+v", v)
+			}
+		})
+	}
+}
+
+func TestParseSPSErrors(t *testing.T) {
+	valid := mustHex(t, spsHigh720p24)
+	tests := []struct {
+		name string
+		sps  []byte
+	}{
+		{"empty", nil},
+		{"pps", mustHex(t, ppsHighCABAC)},
+		{"header only", valid[:4]},
+		{"truncated", valid[:8]},
+		{"truncated vui", valid[:len(valid)-6]},
+		{"invalid sps id", []byte{0x67, 0x64, 0x00, 0x1f, 0x00, 0x00, 0x80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s, err := ParseSPS(tt.sps); err == nil {
+				t.Errorf("no error, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestParseSPSStartCode(t *testing.T) {
+	for _, prefix := range [][]byte{{0, 0, 1}, {0, 0, 0, 1}} {
+		s, err := ParseSPS(append(prefix, mustHex(t, spsHigh720p24)...))
+		if err != nil || s.Width != 1280 || s.Height != 720 {
+			t.Errorf("start code %x: %+v %v", prefix, s, err)
+		}
+	}
+}
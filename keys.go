@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const rsaKeyBits = 2048
+
+var (
+	ErrKeyPassphrase = errors.New("djiedge: wrong passphrase or corrupted encrypted key")
+	ErrKeyMismatch   = errors.New("djiedge: the public key doesn't match the private key")
+)
+
+// NewRSA2048Key returns the key of the sdk, the private key is checked and converted to DER,
+// the private key is written as PKCS#1 RSAPrivateKey and the public key as PKCS#1 RSAPublicKey.
+func NewRSA2048Key(key *rsa.PrivateKey) (*RSA2048Key, error) {
+	return newRSA2048Key(key, nil)
+}
+
+// newRSA2048Key the private key is kept in the secrets instead of PrivateKey if they are not nil,
+// see LoadRSA2048KeyFromSecrets
+func newRSA2048Key(key *rsa.PrivateKey, secrets *secretBuffers) (*RSA2048Key, error) {
+	if key == nil {
+		return nil, errors.New("parameter is nil")
+	}
+	if err := checkRSA2048Key(key); err != nil {
+		return nil, err
+	}
+	k := &RSA2048Key{PublicKey: string(x509.MarshalPKCS1PublicKey(&key.PublicKey))}
+	if secrets != nil {
+		secrets.privateKey = x509.MarshalPKCS1PrivateKey(key)
+		k.secrets = secrets
+	} else {
+		k.PrivateKey = string(x509.MarshalPKCS1PrivateKey(key))
+	}
+	return k, nil
+}
+
+// GenerateRSA2048Key generates a new key pair
+func GenerateRSA2048Key() (*RSA2048Key, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := NewRSA2048Key(key)
+	return k, key, err
+}
+
+// ParseRSA2048Key parses a private key of PEM or DER, PKCS#1 or PKCS#8,
+// the passphrase decrypts an encrypted PKCS#8 key or a legacy encrypted PEM block, it is ignored if the key is not encrypted.
+func ParseRSA2048Key(private []byte, passphrase []byte) (*RSA2048Key, error) {
+	key, err := parseRSAPrivateKey(private, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewRSA2048Key(key)
+}
+
+// ParseRSA2048KeyPair parses the private key like ParseRSA2048Key,
+// and checks the public key of PEM or DER, PKCS#1 or PKIX, is the pair of it.
+func ParseRSA2048KeyPair(private, public []byte, passphrase []byte) (*RSA2048Key, error) {
+	key, err := parseRSAPrivateKey(private, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := parseRSAPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	if !key.PublicKey.Equal(pub) {
+		return nil, ErrKeyMismatch
+	}
+	return NewRSA2048Key(key)
+}
+
+// LoadRSA2048Key loads the private key file, see ParseRSA2048Key
+func LoadRSA2048Key(privatePath string, passphrase []byte) (*RSA2048Key, error) {
+	b, err := os.ReadFile(privatePath)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParseRSA2048Key(b, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("load key %s: %w", privatePath, err)
+	}
+	return k, nil
+}
+
+// LoadRSA2048KeyPair loads the private and public key files, see ParseRSA2048KeyPair
+func LoadRSA2048KeyPair(privatePath, publicPath string, passphrase []byte) (*RSA2048Key, error) {
+	private, err := os.ReadFile(privatePath)
+	if err != nil {
+		return nil, err
+	}
+	public, err := os.ReadFile(publicPath)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParseRSA2048KeyPair(private, public, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("load key %s: %w", privatePath, err)
+	}
+	return k, nil
+}
+
+// LoadOrGenerateRSA2048Key loads the private key file, or generates a new key and writes it to the file
+// as an unencrypted PKCS#8 PEM readable only by the owner if the file doesn't exist, such as on the first boot.
+func LoadOrGenerateRSA2048Key(privatePath string) (*RSA2048Key, error) {
+	k, err := LoadRSA2048Key(privatePath, nil)
+	if !errors.Is(err, os.ErrNotExist) {
+		return k, err
+	}
+	k, key, err := GenerateRSA2048Key()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(privatePath), 0o700); err != nil {
+		return nil, err
+	}
+	// O_EXCL keeps the key of another process started at the same time
+	f, err := os.OpenFile(privatePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return LoadRSA2048Key(privatePath, nil)
+		}
+		return nil, err
+	}
+	err = pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(privatePath)
+		return nil, err
+	}
+	return k, nil
+}
+
+// Wipe zeroes the private key loaded by LoadRSA2048KeyFromSecrets, a later InitSDK returns ErrSecretsWiped.
+// PrivateKey is a string, it can't be zeroed.
+func (k *RSA2048Key) Wipe() {
+	k.secrets.wipe()
+}
+
+// privateKey returns the private key loaded by LoadRSA2048KeyFromSecrets, or PrivateKey
+func (k *RSA2048Key) privateKey() []byte {
+	if k.secrets != nil {
+		return k.secrets.privateKey
+	}
+	return []byte(k.PrivateKey)
+}
+
+// Validate checks the key is the DER form of a 2048-bit key pair expected by the sdk, see NewRSA2048Key
+func (k *RSA2048Key) Validate() error {
+	key, err := x509.ParsePKCS1PrivateKey(k.privateKey())
+	if err != nil {
+		return fmt.Errorf("djiedge: private key is not PKCS#1 DER: %w", err)
+	}
+	if err = checkRSA2048Key(key); err != nil {
+		return err
+	}
+	pub, err := x509.ParsePKCS1PublicKey([]byte(k.PublicKey))
+	if err != nil {
+		return fmt.Errorf("djiedge: public key is not PKCS#1 DER: %w", err)
+	}
+	if !key.PublicKey.Equal(pub) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+func checkRSA2048Key(key *rsa.PrivateKey) error {
+	if n := key.N.BitLen(); n != rsaKeyBits {
+		return fmt.Errorf("djiedge: key is %d-bit, want %d-bit", n, rsaKeyBits)
+	}
+	if err := key.Validate(); err != nil {
+		return fmt.Errorf("djiedge: invalid key: %w", err)
+	}
+	return nil
+}
+
+// parseRSAPrivateKey parses a private key of PEM or DER, the first PEM block of a private key is used
+func parseRSAPrivateKey(data []byte, passphrase []byte) (*rsa.PrivateKey, error) {
+	der := data
+	if block := findPEMBlock(data, "RSA PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY"); block != nil {
+		der = block.Bytes
+		// the legacy encryption of openssl(Proc-Type: 4,ENCRYPTED) is deprecated but still found on devices
+		if x509.IsEncryptedPEMBlock(block) {
+			if len(passphrase) == 0 {
+				return nil, errors.New("djiedge: key is encrypted, passphrase is required")
+			}
+			var err error
+			if der, err = x509.DecryptPEMBlock(block, passphrase); err != nil {
+				return nil, ErrKeyPassphrase
+			}
+		}
+	} else if isPEM(data) {
+		return nil, errors.New("djiedge: no private key in the PEM data")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("djiedge: %T is not a RSA key", key)
+		}
+		return rsaKey, nil
+	}
+	if isEncryptedPKCS8(der) {
+		if len(passphrase) == 0 {
+			return nil, errors.New("djiedge: key is encrypted, passphrase is required")
+		}
+		plain, err := decryptPKCS8(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return parseRSAPrivateKey(plain, nil)
+	}
+	return nil, errors.New("djiedge: unknown private key format, want PKCS#1 or PKCS#8 of PEM or DER")
+}
+
+// parseRSAPublicKey parses a public key of PEM or DER, PKCS#1 or PKIX
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	der := data
+	if block := findPEMBlock(data, "RSA PUBLIC KEY", "PUBLIC KEY"); block != nil {
+		der = block.Bytes
+	} else if isPEM(data) {
+		return nil, errors.New("djiedge: no public key in the PEM data")
+	}
+	if pub, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return pub, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.New("djiedge: unknown public key format, want PKCS#1 or PKIX of PEM or DER")
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("djiedge: %T is not a RSA key", pub)
+	}
+	return rsaPub, nil
+}
+
+func findPEMBlock(data []byte, types ...string) *pem.Block {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		for _, t := range types {
+			if block.Type == t {
+				return block
+			}
+		}
+	}
+}
+
+func isPEM(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the fixtures of testdata are written by openssl 3.0 from rsa2048.pem with the passphrase testKeyPassphrase:
+//
+//	openssl pkcs8 -topk8 -in rsa2048.pem -v2 aes-128-cbc -v2prf hmacWithSHA1 -passout pass:djiedge-test
+//	openssl pkcs8 -topk8 -in rsa2048.pem -v2 aes-256-cbc -v2prf hmacWithSHA256 -passout pass:djiedge-test [-outform DER]
+//	openssl pkcs8 -topk8 -in rsa2048.pem -v2 aes-192-cbc -v2prf hmacWithSHA512 -passout pass:djiedge-test
+//	openssl pkcs8 -topk8 -in rsa2048.pem -v2 des3 -passout pass:djiedge-test
+//	openssl pkcs8 -topk8 -in rsa2048.pem -v1 PBE-SHA1-3DES -passout pass:djiedge-test
+//	openssl rsa -in rsa2048.pem -aes256 -traditional -passout pass:djiedge-test
+const testKeyPassphrase = "djiedge-test"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		name     string
+		h        func() hash.Hash
+		password string
+		salt     string
+		iter     int
+		keyLen   int
+		want     string
+		short    bool
+	}{
+		// RFC 6070
+		{"rfc6070 1", sha1.New, "password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6", true},
+		{"rfc6070 2", sha1.New, "password", "salt", 2, 20, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957", true},
+		{"rfc6070 4096", sha1.New, "password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1", true},
+		{"rfc6070 16777216", sha1.New, "password", "salt", 16777216, 20, "eefe3d61cd4da4e4e9945b3d6ba2158c2634e984", false},
+		{"rfc6070 long", sha1.New, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25,
+			"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038", true},
+		{"rfc6070 nul", sha1.New, "pass\x00word", "sa\x00lt", 4096, 16, "56fa6aa75548099dcc37d7f03425e0c3", true},
+		// RFC 7914 section 11
+		{"rfc7914 1", sha256.New, "passwd", "salt", 1, 64,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783", true},
+		{"rfc7914 80000", sha256.New, "Password", "NaCl", 80000, 64,
+			"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.short && testing.Short() {
+				t.Skip("slow in short mode")
+			}
+			got := pbkdf2(tt.h, []byte(tt.password), []byte(tt.salt), tt.iter, tt.keyLen)
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("got %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRSA2048KeyEncrypted(t *testing.T) {
+	want, err := ParseRSA2048Key(readTestdata(t, "rsa2048.pem"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"rsa2048_pkcs8.pem",
+		"rsa2048_aes128_sha1.pem",
+		"rsa2048_aes192_sha512.pem",
+		"rsa2048_aes256_sha256.pem",
+		"rsa2048_aes256_sha256.der",
+		"rsa2048_des3_sha256.pem",
+		"rsa2048_legacy_aes256.pem",
+	} {
+		t.Run(name, func(t *testing.T) {
+			k, err := ParseRSA2048KeyPair(readTestdata(t, name), readTestdata(t, "rsa2048_pub.pem"), []byte(testKeyPassphrase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.PrivateKey != want.PrivateKey || k.PublicKey != want.PublicKey {
+				t.Error("the key differs from rsa2048.pem")
+			}
+			if err = k.Validate(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseRSA2048KeyWrongPassphrase(t *testing.T) {
+	for _, name := range []string{
+		"rsa2048_aes128_sha1.pem",
+		"rsa2048_aes192_sha512.pem",
+		"rsa2048_aes256_sha256.der",
+		"rsa2048_des3_sha256.pem",
+		"rsa2048_legacy_aes256.pem",
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := readTestdata(t, name)
+			if _, err := ParseRSA2048Key(data, nil); err == nil {
+				t.Error("the encrypted key is parsed without passphrase")
+			}
+			// a wrong passphrase may produce a valid padding by chance, try enough of them
+			for i := 0; i < 64; i++ {
+				passphrase := []byte(testKeyPassphrase + string(rune('a'+i%26)) + string(rune('0'+i/26)))
+				if _, err := ParseRSA2048Key(data, passphrase); !errors.Is(err, ErrKeyPassphrase) {
+					t.Fatalf("passphrase %q: %v", passphrase, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecryptPKCS8Corrupted(t *testing.T) {
+	block, _ := pem.Decode(readTestdata(t, "rsa2048_aes256_sha256.pem"))
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	modify := func(f func(data []byte) []byte) []byte {
+		c := info
+		c.EncryptedData = f(bytes.Clone(info.EncryptedData))
+		der, err := asn1.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	tests := []struct {
+		name string
+		der  []byte
+		// the corrupted data isn't detected by the padding but by the key parser
+		parserErr bool
+	}{
+		// the last byte of the previous block flips the last byte of the padding
+		{name: "bad padding", der: modify(func(b []byte) []byte { b[len(b)-17] ^= 0x01; return b })},
+		{name: "zero padding", der: modify(func(b []byte) []byte { b[len(b)-17] ^= 0x08; return b })},
+		{name: "truncated", der: modify(func(b []byte) []byte { return b[:len(b)-5] })},
+		{name: "empty", der: modify(func(b []byte) []byte { return b[:0] }), parserErr: true},
+		{name: "last block", der: modify(func(b []byte) []byte { return b[len(b)-16:] })},
+		{name: "corrupted", der: modify(func(b []byte) []byte { b[100] ^= 0xff; return b }), parserErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRSAPrivateKey(tt.der, []byte(testKeyPassphrase))
+			if err == nil {
+				t.Fatal("the corrupted key is parsed")
+			}
+			if !tt.parserErr && !errors.Is(err, ErrKeyPassphrase) {
+				t.Errorf("got %v, want ErrKeyPassphrase", err)
+			}
+		})
+	}
+	if _, err := decryptPKCS8(modify(func(b []byte) []byte { return b }), []byte(testKeyPassphrase)); err != nil {
+		t.Errorf("the unmodified key: %v", err)
+	}
+}
+
+func TestDecryptPKCS8Unsupported(t *testing.T) {
+	block, _ := pem.Decode(readTestdata(t, "rsa2048_pbes1.pem"))
+	if _, err := decryptPKCS8(block.Bytes, []byte(testKeyPassphrase)); err == nil || errors.Is(err, ErrKeyPassphrase) {
+		t.Errorf("PBES1: %v", err)
+	}
+}
+
+func TestUnpadPKCS7(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want []byte
+	}{
+		{[]byte{1, 2, 3, 4, 4, 4, 4, 4}, []byte{1, 2, 3, 4}},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 1}, []byte{1, 2, 3, 4, 5, 6, 7}},
+		{[]byte{8, 8, 8, 8, 8, 8, 8, 8}, []byte{}},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 0}, nil},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 9}, nil},
+		{[]byte{1, 2, 3, 4, 5, 3, 4, 3}, nil},
+	}
+	for _, tt := range tests {
+		got, err := unpadPKCS7(tt.in, 8)
+		if tt.want == nil {
+			if !errors.Is(err, ErrKeyPassphrase) {
+				t.Errorf("%x: got %x %v, want ErrKeyPassphrase", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%x: got %x %v, want %x", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LifecycleState the state of the sdk instance of a backend
+//
+//	Uninitialized -> Initializing -> Ready -> ShuttingDown -> Uninitialized
+//	                      |                      ^
+//	                      +------> Failed -------+
+//
+// InitSDK is allowed in Uninitialized, and in Failed if the failed InitSDK left nothing to de-initialize,
+// DeInitSDK in Ready and Failed.
+type LifecycleState int32
+
+const (
+	StateUninitialized LifecycleState = iota
+	StateInitializing
+	StateReady
+	StateFailed
+	StateShuttingDown
+)
+
+func (s LifecycleState) String() string {
+	switch s {
+	case StateUninitialized:
+		return "uninitialized"
+	case StateInitializing:
+		return "initializing"
+	case StateReady:
+		return "ready"
+	case StateFailed:
+		return "failed"
+	case StateShuttingDown:
+		return "shutting-down"
+	}
+	return fmt.Sprintf("LifecycleState(%d)", int32(s))
+}
+
+// StateTransition is a change of the LifecycleState
+type StateTransition struct {
+	From LifecycleState
+	To   LifecycleState
+	// Err the error of InitSDK or DeInitSDK if To is StateFailed
+	Err  error
+	Time time.Time
+}
+
+var (
+	errSDKInitializing = errors.New("sdk is initializing or initialized")
+	errSDKNotReleased  = errors.New("sdk failed to initialize without deInitOnFailed, DeInitSDK is required before InitSDK")
+)
+
+// lifecycle is the state machine of an sdk instance
+type lifecycle struct {
+	mu    sync.Mutex
+	state LifecycleState
+	// released the failed InitSDK left nothing to de-initialize, such as the sdk de-initialized by deInitOnFailed
+	released bool
+	subs     []stateSubscriber // in order of subscribing
+	nextSub  uint64
+}
+
+type stateSubscriber struct {
+	id uint64
+	fn func(StateTransition)
+}
+
+func (l *lifecycle) State() LifecycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// SubscribeState calls fn on every transition until the returned function is called,
+// fn is called in the goroutine changing the state, it should not block.
+func (l *lifecycle) SubscribeState(fn func(StateTransition)) (unsubscribe func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := l.nextSub
+	l.nextSub++
+	l.subs = append(l.subs, stateSubscriber{id: id, fn: fn})
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, sub := range l.subs {
+			if sub.id == id {
+				l.subs = append(l.subs[:i:i], l.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// begin moves to the state if the current state is one of from
+func (l *lifecycle) begin(to LifecycleState, from ...LifecycleState) bool {
+	l.mu.Lock()
+	for _, s := range from {
+		if l.state == s {
+			l.transitionLocked(to, nil)
+			return true
+		}
+	}
+	l.mu.Unlock()
+	return false
+}
+
+// beginInit moves to StateInitializing, returns an error if the sdk is initializing or initialized,
+// or the failed InitSDK left the sdk initialized, such as without deInitOnFailed.
+func (l *lifecycle) beginInit() error {
+	l.mu.Lock()
+	switch {
+	case l.state == StateUninitialized, l.state == StateFailed && l.released:
+		l.transitionLocked(StateInitializing, nil)
+		return nil
+	case l.state == StateFailed:
+		l.mu.Unlock()
+		return errSDKNotReleased
+	}
+	l.mu.Unlock()
+	return errSDKInitializing
+}
+
+// beginDeInit moves to StateShuttingDown, returns ErrSDKNotInit if the sdk is not initialized or failed.
+// released reports whether the state is from a failed InitSDK which released the sdk, so only the state is changed.
+func (l *lifecycle) beginDeInit() (released bool, err error) {
+	l.mu.Lock()
+	released = l.state == StateFailed && l.released
+	l.mu.Unlock()
+	if !l.begin(StateShuttingDown, StateReady, StateFailed) {
+		return false, ErrSDKNotInit
+	}
+	return released, nil
+}
+
+// finishInit moves to StateReady, or StateFailed if err is not nil,
+// released reports whether the failed InitSDK left nothing to de-initialize.
+func (l *lifecycle) finishInit(err error, released bool) {
+	l.mu.Lock()
+	l.released = err != nil && released
+	l.mu.Unlock()
+	l.finish(StateReady, err)
+}
+
+// finishDeInit moves to StateUninitialized, or StateFailed if err is not nil
+func (l *lifecycle) finishDeInit(err error) {
+	l.finish(StateUninitialized, err)
+}
+
+func (l *lifecycle) finish(to LifecycleState, err error) {
+	if err != nil {
+		to = StateFailed
+	}
+	l.mu.Lock()
+	l.transitionLocked(to, err)
+}
+
+// transitionLocked changes the state and notifies the subscribers after unlocking l.mu
+func (l *lifecycle) transitionLocked(to LifecycleState, err error) {
+	t := StateTransition{From: l.state, To: to, Err: err, Time: time.Now()}
+	l.state = to
+	subs := l.subs
+	l.mu.Unlock()
+	for _, sub := range subs {
+		sub.fn(t)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLifecycleTransitions(t *testing.T) {
+	var l lifecycle
+	var got []StateTransition
+	unsubscribe := l.SubscribeState(func(t StateTransition) { got = append(got, t) })
+
+	failure := errors.New("failure")
+	if err := l.beginInit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.beginInit(); err == nil {
+		t.Error("InitSDK is allowed while initializing")
+	}
+	l.finishInit(failure, true)
+	if err := l.beginInit(); err != nil {
+		t.Fatalf("InitSDK is not allowed after failed: %v", err)
+	}
+	l.finishInit(nil, false)
+	if _, err := l.beginDeInit(); err != nil {
+		t.Fatal(err)
+	}
+	l.finishDeInit(nil)
+	if _, err := l.beginDeInit(); !errors.Is(err, ErrSDKNotInit) {
+		t.Errorf("DeInitSDK of an uninitialized sdk: %v", err)
+	}
+	unsubscribe()
+	_ = l.beginInit()
+
+	want := []struct{ from, to LifecycleState }{
+		{StateUninitialized, StateInitializing},
+		{StateInitializing, StateFailed},
+		{StateFailed, StateInitializing},
+		{StateInitializing, StateReady},
+		{StateReady, StateShuttingDown},
+		{StateShuttingDown, StateUninitialized},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transitions, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].From != w.from || got[i].To != w.to {
+			t.Errorf("transition %d: %v -> %v, want %v -> %v", i, got[i].From, got[i].To, w.from, w.to)
+		}
+	}
+	if !errors.Is(got[1].Err, failure) {
+		t.Errorf("the failed transition has err %v", got[1].Err)
+	}
+}
+
+func TestLifecycleInitNotReleased(t *testing.T) {
+	var l lifecycle
+	_ = l.beginInit()
+	l.finishInit(errors.New("failure"), false)
+	if err := l.beginInit(); !errors.Is(err, errSDKNotReleased) {
+		t.Fatalf("InitSDK after a failure not released: %v", err)
+	}
+	if l.State() != StateFailed {
+		t.Fatalf("state %v after the rejected InitSDK", l.State())
+	}
+	if released, err := l.beginDeInit(); err != nil || released {
+		t.Fatalf("DeInitSDK: %v, released %v", err, released)
+	}
+	l.finishDeInit(nil)
+	if err := l.beginInit(); err != nil {
+		t.Errorf("InitSDK after DeInitSDK: %v", err)
+	}
+}
+
+func TestLifecycleReleased(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		released bool
+		want     bool
+	}{
+		{"failed and released", errors.New("failure"), true, true},
+		{"failed and not released", errors.New("failure"), false, false},
+		{"succeeded", nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l lifecycle
+			_ = l.beginInit()
+			l.finishInit(tt.err, tt.released)
+			released, err := l.beginDeInit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if released != tt.want {
+				t.Errorf("released %v, want %v", released, tt.want)
+			}
+			l.finishDeInit(nil)
+		})
+	}
+
+	// a new InitSDK resets it
+	var l lifecycle
+	_ = l.beginInit()
+	l.finishInit(errors.New("failure"), true)
+	_ = l.beginInit()
+	l.finishInit(nil, false)
+	if released, _ := l.beginDeInit(); released {
+		t.Error("released is kept after a successful InitSDK")
+	}
+}
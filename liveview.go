@@ -28,6 +28,7 @@ void esdkCgoStreamStatusCallback(void* ctx,uint32_t value);
 */
 import "C"
 import (
+	"context"
 	"errors"
 	"runtime"
 	"sync/atomic"
@@ -48,23 +49,28 @@ func esdkCgoStreamStatusCallback(ctx unsafe.Pointer, value C.uint32_t) {
 	if ctx == nil {
 		return
 	}
-	s := int(value)
-	status := &LiveStatus{
-		Value:                 s,
-		QualityAutoAvailable:  s&1 == 1,
-		Quality540PAvailable:  s&2 == 2,
-		Quality720PAvailable:  s&4 == 4,
-		Quality720PHAvailable: s&8 == 8,
-		Quality1080PAvailable: s&16 == 16,
-	}
+	status := newLiveStatus(int(value))
 	lv := (*LiveView)(ctx)
+	captureStreamStatus(lv, status.Value)
 	lv.onLiveStatusUpdate(status)
 }
 
+// liveViewCount assigns LiveView.captureID
+var liveViewCount atomic.Uint32
+
 type LiveView struct {
 	native          *C.CEdgeLiveView
+	ref             nativeRef
 	streamReceiver  StreamReceiver
 	cameraInitState atomic.Int32
+
+	// the camera of the live-view, they are recorded with the events of the capture
+	captureID  uint32
+	cameraType CameraType
+	quality    StreamQuality
+	source     atomic.Int32
+
+	frames frameFeed
 }
 
 // NewLiveView return a LiveView ptr that receives stream state and data.
@@ -75,7 +81,7 @@ type LiveView struct {
 // making it invalid; see runtime.SetFinalizer for more information on when
 // a finalizer might be run.
 func NewLiveView() *LiveView {
-	lv := &LiveView{}
+	lv := &LiveView{captureID: liveViewCount.Add(1), frames: frameFeed{gopLimit: defaultGOPCacheSize}}
 	p := C.Edge_LiveView_new(nil)
 	lv.native = p
 	lv.native.ctx = unsafe.Pointer(lv)
@@ -83,14 +89,18 @@ func NewLiveView() *LiveView {
 	return lv
 }
 
+// Destroy releases the live-view, if a call abandoned by a context variant is still blocked in the sdk,
+// the native live-view is deleted after the call returns.
 func (lv *LiveView) Destroy() {
 	runtime.SetFinalizer(lv, nil)
-	if lv.native != nil {
-		lv.DeInit()
+	lv.ref.destroy(func() {
+		if lv.cameraInitState.CompareAndSwap(2, 0) {
+			C.Edge_LiveView_deInit(lv.native)
+		}
 		C.Edge_LiveView_delete(lv.native)
-		lv.native = nil
-		lv.streamReceiver = nil
-	}
+	})
+	lv.streamReceiver = nil
+	lv.frames.close()
 }
 
 // Init initialize live stream subscription.
@@ -105,6 +115,10 @@ func (lv *LiveView) Init(cameraType CameraType, quality StreamQuality, handler S
 	if handler == nil {
 		return errors.New("parameter handler is nil")
 	}
+	if !lv.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer lv.ref.release()
 
 	opts := &C.CEdgeLiveViewOptions{
 		camera:          C.int(cameraType),
@@ -113,8 +127,9 @@ func (lv *LiveView) Init(cameraType CameraType, quality StreamQuality, handler S
 	}
 	lv.streamReceiver = handler
 	if lv.cameraInitState.CompareAndSwap(0, 1) {
+		lv.cameraType, lv.quality = cameraType, quality
 		ret := C.Edge_LiveView_init(lv.native, opts)
-		if err := convertCCodeToError(int(ret)); err != nil {
+		if err := sdkError("LiveView.Init", int(ret), cameraType, quality); err != nil {
 			lv.cameraInitState.Store(0)
 			return err
 		}
@@ -130,6 +145,10 @@ func (lv *LiveView) Init(cameraType CameraType, quality StreamQuality, handler S
 
 // DeInit de-initialize stream subscription
 func (lv *LiveView) DeInit() {
+	if !lv.ref.acquire() {
+		return
+	}
+	defer lv.ref.release()
 	if lv.cameraInitState.CompareAndSwap(2, 0) {
 		C.Edge_LiveView_deInit(lv.native)
 	}
@@ -152,9 +171,21 @@ func (lv *LiveView) onReceiveStream(buf *C.uint8_t, size C.uint32_t) {
 
 	//Note: only reference the memory data from cgo, no memory copy occurs
 	data := unsafe.Slice((*byte)(buf), int(size))
+	captureStreamData(lv, data)
+	lv.frames.push(data, lv.cameraType, CameraSource(lv.source.Load()))
 	lv.streamReceiver.OnReceiveStreamData(data)
 }
 
+// Frames returns a channel of the stream data copied into go memory, so the receiver never touches the C memory.
+// every call returns a new channel with its own queue, it is closed after ctx is done or the live-view is destroyed,
+// the frames received must be released by Frame.Release.
+// the frames are received after Init, StreamStatusFunc is a handler of Init only receiving the status.
+// a new channel starts with the frames since the last keyframe, up to 8MB, with the sps and pps inserted into
+// the keyframe, so the receiver decodes at once instead of waiting for the next keyframe.
+func (lv *LiveView) Frames(ctx context.Context, opts *FrameOptions) <-chan *Frame {
+	return lv.frames.frames(ctx, opts)
+}
+
 // SetCameraSource can switch the camera source used
 func (lv *LiveView) SetCameraSource(source CameraSource) error {
 	if !source.IsValid() {
@@ -163,14 +194,22 @@ func (lv *LiveView) SetCameraSource(source CameraSource) error {
 	if !lv.cameraInitialized() {
 		return errors.New(" live-view is not initialized")
 	}
+	if !lv.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer lv.ref.release()
 
 	ret := C.Edge_LiveView_setCameraSource(lv.native, C.int(source))
-	return convertCCodeToError(int(ret))
+	if err := sdkError("LiveView.SetCameraSource", int(ret), source); err != nil {
+		return err
+	}
+	lv.source.Store(int32(source))
+	return nil
 }
 
 func (lv *LiveView) setupStreamStatusCallback() error {
 	ret := C.Edge_LiveView_subscribeStreamStatus(lv.native, C.CEdgeLiveViewStreamStatusCallback(C.esdkCgoStreamStatusCallback))
-	return convertCCodeToError(int(ret))
+	return sdkError("LiveView.SubscribeStreamStatus", int(ret), lv.cameraType)
 }
 
 // StartH264Stream start receive live H264 stream,stream data can be received through StreamReceiver.OnReceiveStreamData
@@ -182,12 +221,20 @@ func (lv *LiveView) StartH264Stream() error {
 	if !lv.cameraInitialized() {
 		return errors.New(" live-view is not initialized")
 	}
+	if !lv.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer lv.ref.release()
 	ret := C.Edge_LiveView_startH264Stream(lv.native)
-	return convertCCodeToError(int(ret))
+	return sdkError("LiveView.StartH264Stream", int(ret), lv.cameraType, lv.quality)
 }
 
 // StopH264Stream stop receive live H264 stream
 func (lv *LiveView) StopH264Stream() error {
+	if !lv.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer lv.ref.release()
 	ret := C.Edge_LiveView_stopH264Stream(lv.native)
-	return convertCCodeToError(int(ret))
+	return sdkError("LiveView.StopH264Stream", int(ret), lv.cameraType, lv.quality)
 }
@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// LogEventKind the kind of a known failure found in the sdk log
+type LogEventKind int
+
+const (
+	// LogEventOther the kind of the signatures not in the catalogue of the package
+	LogEventOther LogEventKind = iota
+	// LogEventAuthFailure the license or the app key is rejected
+	LogEventAuthFailure
+	// LogEventConnectionLost the link to the dock or the cloud drops
+	LogEventConnectionLost
+	// LogEventStreamUnavailable the live stream of a camera can't be received
+	LogEventStreamUnavailable
+	// LogEventMediaChannelReset the channel of the media files is reset
+	LogEventMediaChannelReset
+)
+
+func (k LogEventKind) String() string {
+	switch k {
+	case LogEventOther:
+		return "other"
+	case LogEventAuthFailure:
+		return "auth-failure"
+	case LogEventConnectionLost:
+		return "connection-lost"
+	case LogEventStreamUnavailable:
+		return "stream-unavailable"
+	case LogEventMediaChannelReset:
+		return "media-channel-reset"
+	}
+	return fmt.Sprintf("LogEventKind(%d)", int(k))
+}
+
+// LogSignature a pattern of the sdk log of a known failure
+type LogSignature struct {
+	// Name identifies the signature in the events and the counters
+	Name string
+	Kind LogEventKind
+	// Pattern the regular expression matched against the message of a line
+	Pattern string
+	// Level only the lines up to the level are matched, such as LogLevelWarn for the warnings and the errors
+	Level LogLevel
+	// Module only the lines of the module are matched if not empty
+	Module string
+	// Cooldown suppresses the events of the signature for the duration after an event, the matches are still counted
+	Cooldown time.Duration
+}
+
+// DefaultLogSignatures returns the catalogue of the known failures of the sdk,
+// the specific signatures are before the general ones, see LogAnalyzer.
+func DefaultLogSignatures() []LogSignature {
+	return []LogSignature{
+		{
+			// the license, authentication and key verification failures, not the other verifications such as a crc
+			Name: "auth-failure",
+			Kind: LogEventAuthFailure,
+			Pattern: `(?i)\b(licen[cs]e|authenticat\w*|authori[sz]\w*|app[ _]?key|(signature|certificate|public key) verif\w*)\b` +
+				`.{0,40}\b(fail\w*|invalid|expired?|reject\w*|denied|mismatch\w*|error)\b` +
+				`|\bfail(ed)? to (authenticate|authori[sz]e|verify (the )?(license|signature|certificate|app[ _]?key))`,
+			Level: LogLevelWarn,
+			// the sdk retries the authentication, logging every attempt
+			Cooldown: time.Second,
+		},
+		{
+			Name:    "media-channel-reset",
+			Kind:    LogEventMediaChannelReset,
+			Pattern: `(?i)(media|file).*(channel|transfer|connection).*(reset|re-?connect|dis-?connect|closed|abort)`,
+			Level:   LogLevelInfo,
+		},
+		{
+			Name:     "stream-unavailable",
+			Kind:     LogEventStreamUnavailable,
+			Pattern:  `(?i)(stream|live ?view|video).*(unavailable|not available|no signal|fail|broken|interrupt|stopped)`,
+			Level:    LogLevelWarn,
+			Cooldown: time.Second,
+		},
+		{
+			Name:     "connection-lost",
+			Kind:     LogEventConnectionLost,
+			Pattern:  `(?i)(dis-?connect(ed)?\b|connection (lost|closed|broken|reset|timeout|timed out)|link (lost|down|broken)|heart ?beat (lost|timeout)|offline)`,
+			Level:    LogLevelInfo,
+			Cooldown: time.Second,
+		},
+	}
+}
+
+// LogEvent a line of the sdk log matching a signature
+type LogEvent struct {
+	Kind      LogEventKind
+	Signature string
+	Record    SDKLogRecord
+	// Line the line without the colour codes
+	Line string
+	Time time.Time
+}
+
+// LogSignatureStats the counter of a signature
+type LogSignatureStats struct {
+	Name  string
+	Kind  LogEventKind
+	Count uint64
+	// Last the time of the last match, zero if it has never matched
+	Last time.Time
+}
+
+// LogAnalyzer matches the lines of the sdk log against a catalogue of signatures, and publishes the events.
+// the signatures are tried in order, a line is counted and published by the first matching signature.
+// it is a LogSink, add it to a LogRouter to analyze the log of the sdk.
+type LogAnalyzer struct {
+	mu      sync.Mutex
+	sigs    []*compiledSignature
+	subs    []logEventSubscriber // in order of subscribing
+	nextSub uint64
+}
+
+type compiledSignature struct {
+	LogSignature
+	re        *regexp.Regexp
+	count     uint64
+	last      time.Time
+	lastEvent time.Time
+}
+
+type logEventSubscriber struct {
+	id uint64
+	fn func(LogEvent)
+}
+
+// NewLogAnalyzer compiles the signatures, DefaultLogSignatures is used if signatures is nil
+func NewLogAnalyzer(signatures []LogSignature) (*LogAnalyzer, error) {
+	if signatures == nil {
+		signatures = DefaultLogSignatures()
+	}
+	a := &LogAnalyzer{}
+	names := map[string]bool{}
+	for _, sig := range signatures {
+		if sig.Name == "" {
+			return nil, fmt.Errorf("djiedge: log signature %q has no name", sig.Pattern)
+		}
+		if names[sig.Name] {
+			return nil, fmt.Errorf("djiedge: duplicate log signature %q", sig.Name)
+		}
+		names[sig.Name] = true
+		re, err := regexp.Compile(sig.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("djiedge: log signature %q: %w", sig.Name, err)
+		}
+		a.sigs = append(a.sigs, &compiledSignature{LogSignature: sig, re: re})
+	}
+	return a, nil
+}
+
+// Subscribe calls fn on every event until the returned function is called,
+// fn is called in the goroutine of the log callback of the sdk, it should not block.
+func (a *LogAnalyzer) Subscribe(fn func(LogEvent)) (unsubscribe func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id := a.nextSub
+	a.nextSub++
+	a.subs = append(a.subs, logEventSubscriber{id: id, fn: fn})
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for i, sub := range a.subs {
+			if sub.id == id {
+				a.subs = append(a.subs[:i:i], a.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// WriteLog matches the line against the signatures, and publishes the event of the first matching signature
+func (a *LogAnalyzer) WriteLog(rec *SDKLogRecord, line string) error {
+	now := time.Now()
+	a.mu.Lock()
+	var sig *compiledSignature
+	for _, s := range a.sigs {
+		if rec.Level <= s.Level && (s.Module == "" || s.Module == rec.Module) && s.re.MatchString(rec.Message) {
+			sig = s
+			break
+		}
+	}
+	if sig == nil {
+		a.mu.Unlock()
+		return nil
+	}
+	sig.count++
+	sig.last = now
+	if sig.Cooldown > 0 && !sig.lastEvent.IsZero() && now.Sub(sig.lastEvent) < sig.Cooldown {
+		a.mu.Unlock()
+		return nil
+	}
+	sig.lastEvent = now
+	e := LogEvent{Kind: sig.Kind, Signature: sig.Name, Record: *rec, Line: stripANSI(line), Time: now}
+	subs := a.subs
+	a.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.fn(e)
+	}
+	return nil
+}
+
+// Stats returns the counters of the signatures in the order of the catalogue
+func (a *LogAnalyzer) Stats() []LogSignatureStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := make([]LogSignatureStats, 0, len(a.sigs))
+	for _, sig := range a.sigs {
+		stats = append(stats, LogSignatureStats{Name: sig.Name, Kind: sig.Kind, Count: sig.count, Last: sig.last})
+	}
+	return stats
+}
+
+// Count returns the number of the matches of the signatures of the kind
+func (a *LogAnalyzer) Count(kind LogEventKind) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var n uint64
+	for _, sig := range a.sigs {
+		if sig.Kind == kind {
+			n += sig.count
+		}
+	}
+	return n
+}
+
+// Reset clears the counters
+func (a *LogAnalyzer) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sig := range a.sigs {
+		sig.count, sig.last, sig.lastEvent = 0, time.Time{}, time.Time{}
+	}
+}
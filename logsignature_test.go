@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultLogSignatures(t *testing.T) {
+	tests := []struct {
+		line string
+		// sig the name of the signature matching the line, empty if none
+		sig string
+	}{
+		{"[Error]-[edge_sdk_init.cc:88]-[Init] license verify failed, please check the app key", "auth-failure"},
+		{"[12.345][core]-[Error]-[DjiAuth_Verify:210) authentication failed, error code:0xE0010001", "auth-failure"},
+		{"[Warn]-[edge_sdk_auth.cc:51]-[Auth] app license expired", "auth-failure"},
+		{"[Error]-[edge_sdk_init.cc:72]-[Init] Failed to verify the signature of the rsa2048 public key", "auth-failure"},
+		{"[Error]-[edge_sdk_auth.cc:64]-[Auth] authorization rejected by the dock", "auth-failure"},
+		{"[Warn]-[edge_sdk_init.cc:40]-[Init] app key mismatch", "auth-failure"},
+		{"\x1b[31m[Error]-[edge_sdk_auth.cc:70]-[Auth] certificate verification failed\x1b[0m", "auth-failure"},
+		{"[Warn]-[media_file.cc:191]-[Media] media file transfer disconnected", "media-channel-reset"},
+		{"[Info]-[media_file.cc:205]-[Media] file channel reset by the dock", "media-channel-reset"},
+		{"[Warn]-[liveview.cc:120]-[Liveview] video stream unavailable, camera not ready", "stream-unavailable"},
+		{"[Error]-[liveview.cc:230]-[Liveview] live view interrupted, no data for 3s", "stream-unavailable"},
+		{"[Info]-[edge_link.cc:55]-[Link] heartbeat timeout, dock offline", "connection-lost"},
+		{"[Warn]-[edge_link.cc:60]-[Link] connection lost, reconnecting", "connection-lost"},
+
+		// the verifications and the words sharing a prefix with the auth keywords aren't auth failures
+		{"[Warn]-[media_file.cc:120]-[Media] verify crc of file DJI_0001.JPG failed", ""},
+		{"[Error]-[media_manager.cc:210]-[Media] verification of the downloaded chunk failed, retry", ""},
+		{"[Warn]-[media_manager.cc:90]-[Media] verifying the media file list, 3 files fail to download", ""},
+		{"[Warn]-[payload.cc:90]-[Camera] authoring tool metadata invalid", ""},
+		{"[Warn]-[payload.cc:96]-[Camera] author field of the sei invalid", ""},
+		{"[Warn]-[edge_sdk_auth.cc:80]-[Auth] license check passed, 12 days until expiry", ""},
+		// the info lines are below the level of the auth failures
+		{"[Info]-[edge_sdk_init.cc:88]-[Init] license verify failed, retry with the backup key", ""},
+		{"[Debug]-[liveview.cc:88]-[Liveview] stream fps 30, bitrate 4096kbps", ""},
+		{"[Info]-[edge_sdk_init.cc:60]-[Init] the sdk is initialized", ""},
+	}
+	a, err := NewLogAnalyzer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]LogEventKind{}
+	for _, sig := range DefaultLogSignatures() {
+		kinds[sig.Name] = sig.Kind
+	}
+	for _, tt := range tests {
+		a.Reset()
+		var events []LogEvent
+		unsubscribe := a.Subscribe(func(e LogEvent) { events = append(events, e) })
+		rec := ParseSDKLogLine(tt.line)
+		if err := a.WriteLog(&rec, tt.line); err != nil {
+			t.Fatal(err)
+		}
+		unsubscribe()
+		switch {
+		case tt.sig == "" && len(events) > 0:
+			t.Errorf("%q matched %s", tt.line, events[0].Signature)
+		case tt.sig == "":
+		case len(events) != 1:
+			t.Errorf("%q: %d events, want %s", tt.line, len(events), tt.sig)
+		case events[0].Signature != tt.sig || events[0].Kind != kinds[tt.sig]:
+			t.Errorf("%q matched %s, want %s", tt.line, events[0].Signature, tt.sig)
+		case events[0].Line != stripANSI(tt.line):
+			t.Errorf("event line %q", events[0].Line)
+		}
+	}
+}
+
+func TestLogAnalyzerCooldown(t *testing.T) {
+	a, err := NewLogAnalyzer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events int
+	a.Subscribe(func(LogEvent) { events++ })
+	line := "[Error]-[edge_sdk_init.cc:88]-[Init] license verify failed"
+	for i := 0; i < 5; i++ {
+		rec := ParseSDKLogLine(line)
+		if err := a.WriteLog(&rec, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the retries of the authentication are counted, but published once
+	if events != 1 || a.Count(LogEventAuthFailure) != 5 {
+		t.Fatalf("%d events and %d matches, want 1 and 5", events, a.Count(LogEventAuthFailure))
+	}
+	stats := a.Stats()
+	if stats[0].Name != "auth-failure" || stats[0].Count != 5 || time.Since(stats[0].Last) > time.Second {
+		t.Fatalf("stats %+v", stats[0])
+	}
+}
+
+func TestNewLogAnalyzerErrors(t *testing.T) {
+	for _, sigs := range [][]LogSignature{
+		{{Pattern: "fail"}},
+		{{Name: "a", Pattern: "fail"}, {Name: "a", Pattern: "error"}},
+		{{Name: "a", Pattern: "(fail"}},
+	} {
+		if _, err := NewLogAnalyzer(sigs); err == nil {
+			t.Errorf("no error for %+v", sigs)
+		}
+	}
+}
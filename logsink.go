@@ -0,0 +1,403 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogSink is a destination of the sdk log routed by LogRouter
+type LogSink interface {
+	// WriteLog writes a line of the sdk log without the newline, rec is parsed from the line.
+	// it is called by one goroutine at a time for a router, but a sink shared by routers must be safe for concurrency.
+	WriteLog(rec *SDKLogRecord, line string) error
+}
+
+// LogRouter fans out the sdk log to multiple sinks, every sink has its own level,
+// and the level of the router filters the lines of all sinks, both can be changed at runtime.
+//
+// The sdk only produces the lines up to the level of the Logger passed to InitSDK,
+// so the Logger of the router should have the most verbose level enabled at runtime.
+type LogRouter struct {
+	level  atomic.Int32
+	errors atomic.Uint64
+
+	mu     sync.Mutex
+	routes atomic.Pointer[[]*LogRoute]
+	parser sdkLogParser
+}
+
+// LogRoute is a sink added to a router
+type LogRoute struct {
+	router *LogRouter
+	sink   LogSink
+	level  atomic.Int32
+}
+
+// NewLogRouter returns a router delivering the lines up to the level
+func NewLogRouter(level LogLevel) *LogRouter {
+	r := &LogRouter{}
+	r.level.Store(int32(level))
+	r.routes.Store(&[]*LogRoute{})
+	return r
+}
+
+// Logger returns the logger of InitSDK delivering the sdk log to the router,
+// maxLevel is the level of the sdk, the level of the router can't be more verbose than it.
+func (r *LogRouter) Logger(maxLevel LogLevel) *Logger {
+	return &Logger{Level: maxLevel, Outputer: r.Output, router: r}
+}
+
+// SetLevel changes the level of the router, it only filters the lines up to the level of the Logger passed to InitSDK,
+// the sdk doesn't produce the more verbose lines.
+func (r *LogRouter) SetLevel(level LogLevel) {
+	r.level.Store(int32(level))
+}
+
+func (r *LogRouter) Level() LogLevel {
+	return LogLevel(r.level.Load())
+}
+
+// WriteErrors returns the number of the failed writes of the sinks
+func (r *LogRouter) WriteErrors() uint64 {
+	return r.errors.Load()
+}
+
+// AddSink routes the lines up to the level to the sink
+func (r *LogRouter) AddSink(sink LogSink, level LogLevel) *LogRoute {
+	route := &LogRoute{router: r, sink: sink}
+	route.level.Store(int32(level))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routes := append(append([]*LogRoute(nil), *r.routes.Load()...), route)
+	r.routes.Store(&routes)
+	return route
+}
+
+// Routes returns the sinks added in order, the routes of a LogConfig are the output, the file and the syslog enabled
+func (r *LogRouter) Routes() []*LogRoute {
+	return append([]*LogRoute(nil), *r.routes.Load()...)
+}
+
+// Output delivers a line of the sdk log to the sinks, it is the Outputer of the Logger of the router
+func (r *LogRouter) Output(line string) {
+	r.mu.Lock()
+	rec := r.parser.parse(line)
+	r.mu.Unlock()
+	level := rec.Level
+	if level > r.Level() {
+		return
+	}
+	for _, route := range *r.routes.Load() {
+		if level > route.Level() {
+			continue
+		}
+		if err := route.sink.WriteLog(&rec, line); err != nil {
+			r.errors.Add(1)
+		}
+	}
+}
+
+// Close removes all the sinks, and closes the sinks which are io.Closer
+func (r *LogRouter) Close() error {
+	r.mu.Lock()
+	routes := *r.routes.Load()
+	r.routes.Store(&[]*LogRoute{})
+	r.mu.Unlock()
+	var errs []error
+	for _, route := range routes {
+		if c, ok := route.sink.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetLevel changes the level of the sink
+func (rt *LogRoute) SetLevel(level LogLevel) {
+	rt.level.Store(int32(level))
+}
+
+func (rt *LogRoute) Level() LogLevel {
+	return LogLevel(rt.level.Load())
+}
+
+// Remove stops routing the lines to the sink, the sink is not closed
+func (rt *LogRoute) Remove() {
+	r := rt.router
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var routes []*LogRoute
+	for _, route := range *r.routes.Load() {
+		if route != rt {
+			routes = append(routes, route)
+		}
+	}
+	r.routes.Store(&routes)
+}
+
+// WriterSink writes the lines to a writer such as os.Stdout, the colour codes are kept
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) WriteLog(_ *SDKLogRecord, line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, line+"\n")
+	return err
+}
+
+// slogSink see NewSlogSink
+type slogSink struct {
+	h slog.Handler
+}
+
+// NewSlogSink emits the lines as slog records to the handler, see Logger.Handler
+func NewSlogSink(h slog.Handler) LogSink {
+	return &slogSink{h: h}
+}
+
+func (s *slogSink) WriteLog(rec *SDKLogRecord, _ string) error {
+	return handleSlogRecord(s.h, rec)
+}
+
+// LogEntry a line kept by RingBufferSink
+type LogEntry struct {
+	Record SDKLogRecord
+	// Line the line without the colour codes
+	Line string
+}
+
+// RingBufferSink keeps the last lines in memory for the diagnostics
+type RingBufferSink struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+}
+
+// NewRingBufferSink keeps the last size lines
+func NewRingBufferSink(size int) *RingBufferSink {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingBufferSink{entries: make([]LogEntry, size)}
+}
+
+func (s *RingBufferSink) WriteLog(rec *SDKLogRecord, line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[s.next] = LogEntry{Record: *rec, Line: stripANSI(line)}
+	s.next++
+	if s.next == len(s.entries) {
+		s.next, s.full = 0, true
+	}
+	return nil
+}
+
+// Entries returns the kept lines from the oldest
+func (s *RingBufferSink) Entries() []LogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]LogEntry(nil), s.entries[:s.next]...)
+	}
+	return append(append([]LogEntry(nil), s.entries[s.next:]...), s.entries[:s.next]...)
+}
+
+// WriteTo writes the kept lines from the oldest
+func (s *RingBufferSink) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, e := range s.Entries() {
+		m, err := io.WriteString(w, e.Line+"\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// RotateOptions the limits of RotatingFileSink
+type RotateOptions struct {
+	// MaxSize rotates the file before its size exceeds the bytes, 100MB if 0
+	MaxSize int64
+	// MaxAge removes the rotated files older than the duration, 0 keeps them
+	MaxAge time.Duration
+	// MaxBackups the limit of the number of the rotated files, 0 keeps all
+	MaxBackups int
+}
+
+const defaultLogMaxSize = 100 << 20
+
+// rotatedLogTime the time in the name of a rotated file, such as edge-20231018T070102.123.log
+const rotatedLogTime = "20060102T150405.000"
+
+// RotatingFileSink writes the lines to a file with the time of receiving, the colour codes are removed.
+// the file is renamed with the time of rotating when it reaches MaxSize, and a new file is created.
+type RotatingFileSink struct {
+	path string
+	opts RotateOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func NewRotatingFileSink(path string, opts RotateOptions) (*RotatingFileSink, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultLogMaxSize
+	}
+	if opts.MaxAge < 0 || opts.MaxBackups < 0 {
+		return nil, errors.New("djiedge: invalid log rotate options")
+	}
+	s := &RotatingFileSink{path: path, opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RotatingFileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *RotatingFileSink) WriteLog(rec *SDKLogRecord, line string) error {
+	b := make([]byte, 0, len(line)+32)
+	b = time.Now().AppendFormat(b, "2006-01-02T15:04:05.000Z07:00 ")
+	b = append(b, stripANSI(line)...)
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if s.size > 0 && s.size+int64(len(b)) > s.opts.MaxSize {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// Rotate renames the current file and creates a new one
+func (s *RotatingFileSink) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	return s.rotateLocked()
+}
+
+func (s *RotatingFileSink) rotateLocked() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	ext := filepath.Ext(s.path)
+	backup := strings.TrimSuffix(s.path, ext) + "-" + time.Now().Format(rotatedLogTime) + ext
+	if err := os.Rename(s.path, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.removeBackups()
+}
+
+// removeBackups removes the rotated files beyond MaxAge and MaxBackups
+func (s *RotatingFileSink) removeBackups() error {
+	if s.opts.MaxAge == 0 && s.opts.MaxBackups == 0 {
+		return nil
+	}
+	ext := filepath.Ext(s.path)
+	prefix := filepath.Base(strings.TrimSuffix(s.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+	type backup struct {
+		name string
+		t    time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(rotatedLogTime, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{name: name, t: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].t.After(backups[j].t)
+	})
+	var errs []error
+	for i, b := range backups {
+		expired := s.opts.MaxAge > 0 && time.Since(b.t) > s.opts.MaxAge
+		if expired || (s.opts.MaxBackups > 0 && i >= s.opts.MaxBackups) {
+			errs = append(errs, os.Remove(filepath.Join(filepath.Dir(s.path), b.name)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,52 @@
+//go:build !windows && !plan9
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import "log/syslog"
+
+// SyslogSink writes the lines to the syslog with the priority of their level, the colour codes are removed
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog daemon, see syslog.Dial, the empty network and raddr are the local daemon
+func NewSyslogSink(network, raddr, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) WriteLog(rec *SDKLogRecord, line string) error {
+	line = stripANSI(line)
+	switch rec.Level {
+	case LogLevelError:
+		return s.w.Err(line)
+	case LogLevelWarn:
+		return s.w.Warning(line)
+	case LogLevelDebug:
+		return s.w.Debug(line)
+	}
+	return s.w.Info(line)
+}
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,40 @@
+//go:build windows || plan9
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// SyslogSink is not supported on the system
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error, the syslog is not supported on the system
+func NewSyslogSink(network, raddr, tag string) (*SyslogSink, error) {
+	return nil, fmt.Errorf("djiedge: syslog is not supported on %s", runtime.GOOS)
+}
+
+func (s *SyslogSink) WriteLog(*SDKLogRecord, string) error {
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -1,5 +1,3 @@
-//go:build linux && !fake_edge
-
 /*
  * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
  *
@@ -19,15 +17,33 @@
 package djiedge
 
 import (
+	"errors"
 	"runtime"
+	"sync"
 )
 
+// ErrReadAbandoned the read position of the media file is lost by a read abandoned by ReadMediaFileContext
+var ErrReadAbandoned = errors.New("djiedge: the media file has an abandoned read, close it and open it again")
+
 type fileHandle int32
 
+// mediaFileIO is implemented by the readers of every backend that can access opened media files
+type mediaFileIO interface {
+	readFile(fh fileHandle, buf []byte) (int, error)
+	closeFile(fh fileHandle) error
+}
+
 type MediaFile struct {
 	path   string
 	handle fileHandle
-	reader *MediaFileReader
+	reader mediaFileIO
+
+	mu sync.Mutex
+	// abandoned a read is abandoned by ReadMediaFileContext, the data read by it is lost
+	abandoned bool
+	// pending the abandoned read hasn't returned, Close is deferred until it returns
+	pending     bool
+	closeOnDone bool
 }
 
 func (m *MediaFile) setup() {
@@ -38,11 +54,26 @@ func (m *MediaFile) Path() string {
 	return m.path
 }
 
+// Close closes the file, if a read abandoned by ReadMediaFileContext is still blocked,
+// the file is closed after the read returns.
 func (m *MediaFile) Close() error {
 	runtime.SetFinalizer(m, nil)
+	m.mu.Lock()
+	if m.pending {
+		m.closeOnDone = true
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
 	return m.reader.closeFile(m.handle)
 }
 
 func (m *MediaFile) Read(p []byte) (n int, err error) {
+	m.mu.Lock()
+	abandoned := m.abandoned
+	m.mu.Unlock()
+	if abandoned {
+		return 0, ErrReadAbandoned
+	}
 	return m.reader.readFile(m.handle, p)
 }
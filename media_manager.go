@@ -27,7 +27,6 @@ void esdkCgoNewMediaFileCallback(CEdgeMediaFile*);
 import "C"
 import (
 	"errors"
-	"fmt"
 	"runtime"
 	"sync/atomic"
 	"time"
@@ -36,10 +35,11 @@ import (
 
 //export esdkCgoNewMediaFileCallback
 func esdkCgoNewMediaFileCallback(f *C.CEdgeMediaFile) {
+	desc := convertToMFDesc(f)
+	captureMediaFile(desc)
 	if sdkNewMFObserver == nil {
 		return
 	}
-	desc := convertToMFDesc(f)
 	sdkNewMFObserver(desc)
 }
 
@@ -68,7 +68,7 @@ var sdkNewMFObserver func(desc *MediaFileDesc)
 // register media file notification processing callback.
 func RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error {
 	ret := C.Edge_MediaMgr_registerMediaFilesObserver(C.CEdgeMediaFilesObserver(C.esdkCgoNewMediaFileCallback))
-	if err := convertCCodeToError(int(ret)); err != nil {
+	if err := sdkError("RegisterMediaFilesObserver", int(ret)); err != nil {
 		return err
 	}
 	sdkNewMFObserver = observer
@@ -85,7 +85,7 @@ func SetDroneNestUploadCloud(enable bool) error {
 		return ErrSDKNotInit
 	}
 	ret := C.Edge_MediaMgr_setDroneNestUploadCloud(C.bool(enable))
-	return convertCCodeToError(int(ret))
+	return sdkError("SetDroneNestUploadCloud", int(ret), enable)
 }
 
 // SetDroneNestAutoDelete
@@ -96,11 +96,12 @@ func SetDroneNestAutoDelete(enable bool) error {
 		return ErrSDKNotInit
 	}
 	ret := C.Edge_MediaMgr_setDroneNestAutoDelete(C.bool(enable))
-	return convertCCodeToError(int(ret))
+	return sdkError("SetDroneNestAutoDelete", int(ret), enable)
 }
 
 type MediaFileReader struct {
 	native unsafe.Pointer
+	ref    nativeRef
 	status atomic.Int32 //0:closed  1:opening  2:opened 3:closing
 }
 
@@ -112,13 +113,14 @@ func NewMediaFileReader() *MediaFileReader {
 	return r
 }
 
+// Destroy releases the reader, if a call abandoned by a context variant is still blocked in the sdk,
+// such as reading a file, the native reader is deleted after the call returns.
 func (m *MediaFileReader) Destroy() {
 	runtime.SetFinalizer(m, nil)
 	m.status.Store(0)
-	if m.native != nil {
+	m.ref.destroy(func() {
 		C.Edge_MediaMgr_deleteMediaFilesReader(m.native)
-		m.native = nil
-	}
+	})
 }
 
 // Open establish a media file transfer connection with the dock.
@@ -129,11 +131,15 @@ func (m *MediaFileReader) Open() error {
 		return ErrSDKNotInit
 	}
 
+	if !m.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer m.ref.release()
 	if !m.status.CompareAndSwap(0, 1) {
 		return errors.New("status abnormal")
 	}
 	ret := C.Edge_MFReader_init(m.native)
-	if err := convertCCodeToError(int(ret)); err != nil {
+	if err := sdkError("MediaFileReader.Open", int(ret)); err != nil {
 		m.status.Store(0)
 		return err
 	}
@@ -145,12 +151,16 @@ func (m *MediaFileReader) Open() error {
 // when no longer need to pull media files, call this interface to disconnect.
 // after disconnection, need to reinitialize to pull media files.
 func (m *MediaFileReader) Close() error {
+	if !m.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer m.ref.release()
 	if !m.status.CompareAndSwap(2, 3) {
 		return errors.New("status abnormal")
 	}
 
 	ret := C.Edge_MFReader_deInit(m.native)
-	if err := convertCCodeToError(int(ret)); err != nil {
+	if err := sdkError("MediaFileReader.Close", int(ret)); err != nil {
 		m.status.Store(2)
 		return err
 	}
@@ -170,6 +180,10 @@ func (m *MediaFileReader) GetFileList() ([]*MediaFileDesc, error) {
 	if !m.IsOpened() {
 		return nil, ErrFileReaderNotOpen
 	}
+	if !m.ref.acquire() {
+		return nil, ErrDestroyed
+	}
+	defer m.ref.release()
 
 	var arr *C.CEdgeMediaFile
 	n := int(C.Edge_MFReader_fileList(m.native, &arr))
@@ -192,11 +206,15 @@ func (m *MediaFileReader) OpenFile(path string) (*MediaFile, error) {
 	if !m.IsOpened() {
 		return nil, ErrFileReaderNotOpen
 	}
+	if !m.ref.acquire() {
+		return nil, ErrDestroyed
+	}
+	defer m.ref.release()
 
 	p := convertToCString(path)
 	fh := int(C.Edge_MFReader_open(m.native, &p))
 	if fh < 0 {
-		return nil, fmt.Errorf("open file %v fail,code: %v", path, fh)
+		return nil, &SDKError{Code: fh, Op: "MediaFileReader.OpenFile", Args: []any{path}, Err: ErrOpenFileFailure}
 	}
 
 	mf := &MediaFile{
@@ -212,15 +230,29 @@ func (m *MediaFileReader) readFile(fh fileHandle, buf []byte) (int, error) {
 	if !m.IsOpened() {
 		return 0, ErrFileReaderNotOpen
 	}
-	count := len(buf)
-	n := C.Edge_MFReader_read(m.native, C.int32_t(fh), unsafe.Pointer(&buf[0]), C.size_t(count))
-	return int(n), nil
+	if !m.ref.acquire() {
+		return 0, ErrDestroyed
+	}
+	defer m.ref.release()
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	// the size_t of a failed read is negative as an int, such as -1
+	n := int(C.Edge_MFReader_read(m.native, C.int32_t(fh), unsafe.Pointer(&buf[0]), C.size_t(len(buf))))
+	if n < 0 || n > len(buf) {
+		return 0, sdkError("MediaFile.Read", n, fh)
+	}
+	return n, nil
 }
 
 func (m *MediaFileReader) closeFile(fh fileHandle) error {
 	if !m.IsOpened() {
 		return ErrFileReaderNotOpen
 	}
+	if !m.ref.acquire() {
+		return ErrDestroyed
+	}
+	defer m.ref.release()
 	ret := C.Edge_MFReader_close(m.native, C.int32_t(fh))
-	return convertCCodeToError(int(ret))
+	return sdkError("MediaFile.Close", int(ret), fh)
 }
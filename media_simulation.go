@@ -0,0 +1,47 @@
+//go:build !linux || fake_edge
+
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+// RegisterMediaFilesObserver
+// register media file notification processing callback.
+func RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error {
+	return defaultSimulator().RegisterMediaFilesObserver(observer)
+}
+
+// SetDroneNestUploadCloud
+// Set media files for cloud upload.
+func SetDroneNestUploadCloud(enable bool) error {
+	return defaultSimulator().SetDroneNestUploadCloud(enable)
+}
+
+// SetDroneNestAutoDelete
+// set whether to delete local media files at the dock after uploading is complete.
+func SetDroneNestAutoDelete(enable bool) error {
+	return defaultSimulator().SetDroneNestAutoDelete(enable)
+}
+
+// MediaFileReader simulate the media file transfer connection with the dock
+type MediaFileReader struct {
+	*simMediaFileReader
+}
+
+// NewMediaFileReader return a media file reader
+func NewMediaFileReader() *MediaFileReader {
+	return &MediaFileReader{newSimMediaFileReader(defaultSimulator())}
+}
@@ -0,0 +1,558 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// MockOp the name of an operation of MockEdge
+type MockOp string
+
+const (
+	MockOpInitSDK                    MockOp = "InitSDK"
+	MockOpDeInitSDK                  MockOp = "DeInitSDK"
+	MockOpLiveViewInit               MockOp = "LiveView.Init"
+	MockOpSetCameraSource            MockOp = "LiveView.SetCameraSource"
+	MockOpStartH264Stream            MockOp = "LiveView.StartH264Stream"
+	MockOpStopH264Stream             MockOp = "LiveView.StopH264Stream"
+	MockOpRegisterMediaFilesObserver MockOp = "RegisterMediaFilesObserver"
+	MockOpSetDroneNestUploadCloud    MockOp = "SetDroneNestUploadCloud"
+	MockOpSetDroneNestAutoDelete     MockOp = "SetDroneNestAutoDelete"
+	MockOpReaderOpen                 MockOp = "MediaFileReader.Open"
+	MockOpReaderClose                MockOp = "MediaFileReader.Close"
+	MockOpGetFileList                MockOp = "MediaFileReader.GetFileList"
+	MockOpOpenFile                   MockOp = "MediaFileReader.OpenFile"
+	MockOpReadFile                   MockOp = "MediaFile.Read"
+	MockOpCloseFile                  MockOp = "MediaFile.Close"
+	MockOpSendCustomMessage          MockOp = "SendCustomMessageToCloud"
+	MockOpRegisterCloudHandler       MockOp = "RegisterCloudCustomMsgHandler"
+)
+
+// MockCall a call received by MockEdge
+type MockCall struct {
+	Op   MockOp
+	Args []any
+}
+
+type mockMediaFile struct {
+	desc MediaFileDesc
+	data []byte
+}
+
+// MockEdge is an in-memory programmable Edge backend for unit tests.
+//
+// By default, every call succeeds and follows the state rules of the sdk,
+// an error can be programmed for any operation by SetError,
+// the data callbacks are driven by the test through AddMediaFile, DeliverCloudMessage, EmitLog
+// and the methods of MockLiveView.
+type MockEdge struct {
+	mu        sync.Mutex
+	lifecycle lifecycle
+	logger    *Logger
+	logOut    *sdkLogWriter
+	errs      map[MockOp]error
+	calls     []MockCall
+
+	liveViews    []*MockLiveView
+	files        []*mockMediaFile
+	mfObserver   func(desc *MediaFileDesc)
+	cloudHandler func([]byte)
+	sent         [][]byte
+	uploadCloud  bool
+	autoDelete   bool
+}
+
+// NewMockEdge return an empty mock backend
+func NewMockEdge() *MockEdge {
+	return &MockEdge{errs: map[MockOp]error{}}
+}
+
+// SetError makes the operation return err until it is reset by a nil err.
+func (m *MockEdge) SetError(op MockOp, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.errs, op)
+		return
+	}
+	m.errs[op] = err
+}
+
+// Calls returns a copy of the calls received in order
+func (m *MockEdge) Calls() []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockCall(nil), m.calls...)
+}
+
+// record records the call and returns the programmed error of the operation
+func (m *MockEdge) record(op MockOp, args ...any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, MockCall{Op: op, Args: args})
+	return wrapSDKError(string(op), m.errs[op])
+}
+
+// InitSDK initializes the mock, the programmed error makes the lifecycle state failed
+func (m *MockEdge) InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) (err error) {
+	programmed := m.record(MockOpInitSDK, device, auth, key, logger, deInitOnFailed)
+	if err = m.lifecycle.beginInit(); err != nil {
+		if programmed != nil {
+			return programmed
+		}
+		return err
+	}
+	defer func() {
+		// the programmed error is a failure of the sdk, it is released by deInitOnFailed
+		m.lifecycle.finishInit(err, programmed == nil || deInitOnFailed)
+	}()
+	if programmed != nil {
+		return programmed
+	}
+	if err = validateInitParams(device, auth, key, logger); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.logger = logger
+	m.logOut = newSDKLogWriter(logger)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MockEdge) DeInitSDK() error {
+	if err := m.record(MockOpDeInitSDK); err != nil {
+		return err
+	}
+	if _, err := m.lifecycle.beginDeInit(); err != nil {
+		return err
+	}
+	m.lifecycle.finishDeInit(nil)
+	return nil
+}
+
+func (m *MockEdge) Initialized() bool {
+	return m.lifecycle.State() == StateReady
+}
+
+func (m *MockEdge) State() LifecycleState {
+	return m.lifecycle.State()
+}
+
+func (m *MockEdge) SubscribeState(fn func(StateTransition)) (unsubscribe func()) {
+	return m.lifecycle.SubscribeState(fn)
+}
+
+// EmitLog delivers a sdk log line to the logger passed to InitSDK when the level is enabled
+func (m *MockEdge) EmitLog(level LogLevel, msg string) {
+	m.mu.Lock()
+	logger, out := m.logger, m.logOut
+	m.mu.Unlock()
+	if logger != nil && level <= logger.Level {
+		out.writeLine(msg)
+	}
+}
+
+func (m *MockEdge) NewLiveView() LiveViewer {
+	lv := &MockLiveView{edge: m, frames: frameFeed{gopLimit: defaultGOPCacheSize}}
+	m.mu.Lock()
+	m.liveViews = append(m.liveViews, lv)
+	m.mu.Unlock()
+	return lv
+}
+
+// LiveViews returns the live-views created by the backend in order
+func (m *MockEdge) LiveViews() []*MockLiveView {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*MockLiveView(nil), m.liveViews...)
+}
+
+func (m *MockEdge) RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error {
+	if err := m.record(MockOpRegisterMediaFilesObserver); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.mfObserver = observer
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MockEdge) SetDroneNestUploadCloud(enable bool) error {
+	if err := m.record(MockOpSetDroneNestUploadCloud, enable); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.Initialized() {
+		return ErrSDKNotInit
+	}
+	m.uploadCloud = enable
+	return nil
+}
+
+func (m *MockEdge) SetDroneNestAutoDelete(enable bool) error {
+	if err := m.record(MockOpSetDroneNestAutoDelete, enable); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.Initialized() {
+		return ErrSDKNotInit
+	}
+	m.autoDelete = enable
+	return nil
+}
+
+// AddMediaFile adds a media file to the dock and notifies the registered observer.
+// the FileSize of desc is set to the length of data.
+func (m *MockEdge) AddMediaFile(desc MediaFileDesc, data []byte) {
+	desc.FileSize = uint64(len(data))
+	m.mu.Lock()
+	m.files = append(m.files, &mockMediaFile{desc: desc, data: data})
+	observer := m.mfObserver
+	m.mu.Unlock()
+	if observer != nil {
+		observer(&desc)
+	}
+}
+
+func (m *MockEdge) NewMediaFileReader() MediaReader {
+	return &mockMediaFileReader{edge: m, handles: map[fileHandle]*mockOpenFile{}}
+}
+
+func (m *MockEdge) SendCustomMessageToCloud(data []byte) error {
+	if err := m.record(MockOpSendCustomMessage, data); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.Initialized() {
+		return ErrSDKNotInit
+	}
+	if len(data) > 256 {
+		return errors.New("data size exceeds 256 bytes")
+	}
+	m.sent = append(m.sent, append([]byte(nil), data...))
+	return nil
+}
+
+// SentCloudMessages returns the messages sent to the cloud in order
+func (m *MockEdge) SentCloudMessages() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.sent...)
+}
+
+func (m *MockEdge) RegisterCloudCustomMsgHandler(handler func([]byte)) error {
+	if err := m.record(MockOpRegisterCloudHandler); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cloudHandler = handler
+	m.mu.Unlock()
+	return nil
+}
+
+// DeliverCloudMessage delivers a message from the cloud to the registered handler,
+// returns false if no handler is registered.
+func (m *MockEdge) DeliverCloudMessage(data []byte) bool {
+	m.mu.Lock()
+	handler := m.cloudHandler
+	m.mu.Unlock()
+	if handler == nil {
+		return false
+	}
+	handler(data)
+	return true
+}
+
+// MockLiveView is the live-view of MockEdge, the stream is pushed by the test
+type MockLiveView struct {
+	edge *MockEdge
+
+	mu          sync.Mutex
+	initialized bool
+	streaming   bool
+	cameraType  CameraType
+	quality     StreamQuality
+	source      CameraSource
+	handler     StreamReceiver
+
+	frames frameFeed
+}
+
+func (lv *MockLiveView) Init(cameraType CameraType, quality StreamQuality, handler StreamReceiver) error {
+	if err := lv.edge.record(MockOpLiveViewInit, cameraType, quality); err != nil {
+		return err
+	}
+	if !cameraType.IsValid() || !quality.IsValid() {
+		return errors.New("invalid parameter for camera or quality")
+	}
+	if handler == nil {
+		return errors.New("parameter handler is nil")
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.initialized = true
+	lv.cameraType = cameraType
+	lv.quality = quality
+	lv.handler = handler
+	return nil
+}
+
+func (lv *MockLiveView) DeInit() {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.initialized = false
+	lv.streaming = false
+}
+
+func (lv *MockLiveView) SetCameraSource(source CameraSource) error {
+	if err := lv.edge.record(MockOpSetCameraSource, source); err != nil {
+		return err
+	}
+	if !source.IsValid() {
+		return errors.New("invalid parameter for camera source")
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if !lv.initialized {
+		return errors.New(" live-view is not initialized")
+	}
+	lv.source = source
+	return nil
+}
+
+func (lv *MockLiveView) StartH264Stream() error {
+	if err := lv.edge.record(MockOpStartH264Stream); err != nil {
+		return err
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if !lv.initialized {
+		return errors.New(" live-view is not initialized")
+	}
+	lv.streaming = true
+	return nil
+}
+
+func (lv *MockLiveView) StopH264Stream() error {
+	if err := lv.edge.record(MockOpStopH264Stream); err != nil {
+		return err
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.streaming = false
+	return nil
+}
+
+func (lv *MockLiveView) Destroy() {
+	lv.DeInit()
+	lv.mu.Lock()
+	lv.handler = nil
+	lv.mu.Unlock()
+	lv.frames.close()
+}
+
+// Frames returns a channel of the data pushed by PushStreamData, see LiveView.Frames
+func (lv *MockLiveView) Frames(ctx context.Context, opts *FrameOptions) <-chan *Frame {
+	return lv.frames.frames(ctx, opts)
+}
+
+// Streaming returns whether the stream is started
+func (lv *MockLiveView) Streaming() bool {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	return lv.streaming
+}
+
+// CameraSource returns the source set by SetCameraSource
+func (lv *MockLiveView) CameraSource() CameraSource {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	return lv.source
+}
+
+// PushStatus delivers the stream status to the receiver of the initialized live-view
+func (lv *MockLiveView) PushStatus(status *LiveStatus) {
+	lv.mu.Lock()
+	handler := lv.handler
+	lv.mu.Unlock()
+	if handler != nil {
+		handler.OnStreamStatusUpdate(status)
+	}
+}
+
+// PushStreamData delivers the stream data to the receiver, the stream must be started
+func (lv *MockLiveView) PushStreamData(data []byte) error {
+	lv.mu.Lock()
+	handler, streaming := lv.handler, lv.streaming
+	cameraType, source := lv.cameraType, lv.source
+	lv.mu.Unlock()
+	if !streaming || handler == nil {
+		return errors.New("stream is not started")
+	}
+	lv.frames.push(data, cameraType, source)
+	handler.OnReceiveStreamData(data)
+	return nil
+}
+
+type mockOpenFile struct {
+	file   *mockMediaFile
+	offset int
+}
+
+type mockMediaFileReader struct {
+	edge *MockEdge
+
+	mu         sync.Mutex
+	opened     bool
+	nextHandle fileHandle
+	handles    map[fileHandle]*mockOpenFile
+}
+
+func (r *mockMediaFileReader) Open() error {
+	if err := r.edge.record(MockOpReaderOpen); err != nil {
+		return err
+	}
+	if !r.edge.Initialized() {
+		return ErrSDKNotInit
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opened {
+		return errors.New("status abnormal")
+	}
+	r.opened = true
+	return nil
+}
+
+func (r *mockMediaFileReader) Close() error {
+	if err := r.edge.record(MockOpReaderClose); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.opened {
+		return errors.New("status abnormal")
+	}
+	r.opened = false
+	r.handles = map[fileHandle]*mockOpenFile{}
+	return nil
+}
+
+func (r *mockMediaFileReader) IsOpened() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.opened
+}
+
+func (r *mockMediaFileReader) Destroy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opened = false
+	r.handles = map[fileHandle]*mockOpenFile{}
+}
+
+func (r *mockMediaFileReader) GetFileList() ([]*MediaFileDesc, error) {
+	if err := r.edge.record(MockOpGetFileList); err != nil {
+		return nil, err
+	}
+	if !r.IsOpened() {
+		return nil, ErrFileReaderNotOpen
+	}
+	r.edge.mu.Lock()
+	defer r.edge.mu.Unlock()
+	ret := make([]*MediaFileDesc, len(r.edge.files))
+	for i, f := range r.edge.files {
+		desc := f.desc
+		ret[i] = &desc
+	}
+	return ret, nil
+}
+
+func (r *mockMediaFileReader) OpenFile(path string) (*MediaFile, error) {
+	if err := r.edge.record(MockOpOpenFile, path); err != nil {
+		return nil, err
+	}
+	if !r.IsOpened() {
+		return nil, ErrFileReaderNotOpen
+	}
+	var file *mockMediaFile
+	r.edge.mu.Lock()
+	for _, f := range r.edge.files {
+		if f.desc.FilePath == path {
+			file = f
+			break
+		}
+	}
+	r.edge.mu.Unlock()
+	if file == nil {
+		return nil, &SDKError{Code: -1, Op: string(MockOpOpenFile), Args: []any{path}, Err: ErrOpenFileFailure}
+	}
+
+	r.mu.Lock()
+	r.nextHandle++
+	fh := r.nextHandle
+	r.handles[fh] = &mockOpenFile{file: file}
+	r.mu.Unlock()
+
+	mf := &MediaFile{handle: fh, reader: r, path: path}
+	mf.setup()
+	return mf, nil
+}
+
+func (r *mockMediaFileReader) readFile(fh fileHandle, buf []byte) (int, error) {
+	if err := r.edge.record(MockOpReadFile, len(buf)); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.opened {
+		return 0, ErrFileReaderNotOpen
+	}
+	f := r.handles[fh]
+	if f == nil {
+		return 0, wrapSDKError(string(MockOpReadFile), ErrInvalidArgument, fh)
+	}
+	if f.offset >= len(f.file.data) {
+		return 0, io.EOF
+	}
+	n := copy(buf, f.file.data[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+func (r *mockMediaFileReader) closeFile(fh fileHandle) error {
+	if err := r.edge.record(MockOpCloseFile); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.opened {
+		return ErrFileReaderNotOpen
+	}
+	if r.handles[fh] == nil {
+		return wrapSDKError(string(MockOpCloseFile), ErrInvalidArgument, fh)
+	}
+	delete(r.handles, fh)
+	return nil
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// newTestMockEdge returns an initialized MockEdge
+func newTestMockEdge(t *testing.T, logger *Logger) *MockEdge {
+	m := NewMockEdge()
+	if err := m.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, logger, false); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMockEdgeSetError(t *testing.T) {
+	m := newTestMockEdge(t, nil)
+	m.SetError(MockOpSendCustomMessage, ErrRequestTimeout)
+	err := m.SendCustomMessageToCloud([]byte("a"))
+	var sdkErr *SDKError
+	if !errors.Is(err, ErrRequestTimeout) || !errors.As(err, &sdkErr) || sdkErr.Op != string(MockOpSendCustomMessage) {
+		t.Fatalf("programmed sentinel: %#v", err)
+	}
+	custom := errors.New("custom")
+	m.SetError(MockOpSendCustomMessage, custom)
+	if err := m.SendCustomMessageToCloud([]byte("b")); err != custom {
+		t.Errorf("programmed error: %#v", err)
+	}
+	m.SetError(MockOpSendCustomMessage, nil)
+	if err := m.SendCustomMessageToCloud([]byte("c")); err != nil {
+		t.Errorf("reset error: %v", err)
+	}
+	if sent := m.SentCloudMessages(); len(sent) != 1 || string(sent[0]) != "c" {
+		t.Errorf("sent %q", sent)
+	}
+
+	calls := m.Calls()
+	want := []MockOp{MockOpInitSDK, MockOpSendCustomMessage, MockOpSendCustomMessage, MockOpSendCustomMessage}
+	if len(calls) != len(want) {
+		t.Fatalf("calls %v", calls)
+	}
+	for i, op := range want {
+		if calls[i].Op != op {
+			t.Errorf("call %d is %s, want %s", i, calls[i].Op, op)
+		}
+	}
+	if data, ok := calls[3].Args[0].([]byte); !ok || string(data) != "c" {
+		t.Errorf("args %v", calls[3].Args)
+	}
+}
+
+func TestMockEdgeInitFailed(t *testing.T) {
+	for _, deInitOnFailed := range []bool{false, true} {
+		m := NewMockEdge()
+		m.SetError(MockOpInitSDK, ErrConnectFailure)
+		if err := m.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, deInitOnFailed); !errors.Is(err, ErrConnectFailure) {
+			t.Fatalf("InitSDK: %v", err)
+		}
+		if m.State() != StateFailed || m.Initialized() {
+			t.Fatalf("state %v", m.State())
+		}
+		m.SetError(MockOpInitSDK, nil)
+		err := m.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, deInitOnFailed)
+		if deInitOnFailed && err != nil || !deInitOnFailed && !errors.Is(err, errSDKNotReleased) {
+			t.Errorf("deInitOnFailed %v: InitSDK after a failure: %v", deInitOnFailed, err)
+		}
+	}
+
+	m := NewMockEdge()
+	m.SetError(MockOpDeInitSDK, ErrSystemError)
+	_ = m.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false)
+	if err := m.DeInitSDK(); !errors.Is(err, ErrSystemError) || m.State() != StateReady {
+		t.Errorf("programmed DeInitSDK: %v, state %v", err, m.State())
+	}
+}
+
+func TestMockEdgeEmitLog(t *testing.T) {
+	var lines []string
+	m := newTestMockEdge(t, &Logger{Level: LogLevelInfo, Outputer: func(msg string) { lines = append(lines, msg) }})
+	m.EmitLog(LogLevelInfo, "[Info]-[edge.cc:1] hello")
+	m.EmitLog(LogLevelDebug, "[Debug]-[edge.cc:2] hidden")
+	if len(lines) != 1 || !strings.Contains(lines[0], "hello") {
+		t.Errorf("lines %q", lines)
+	}
+	// no logger
+	newTestMockEdge(t, nil).EmitLog(LogLevelError, "dropped")
+}
+
+func TestMockEdgeCloud(t *testing.T) {
+	m := newTestMockEdge(t, nil)
+	if m.DeliverCloudMessage([]byte("a")) {
+		t.Error("delivered without a handler")
+	}
+	var got [][]byte
+	if err := m.RegisterCloudCustomMsgHandler(func(b []byte) { got = append(got, b) }); err != nil {
+		t.Fatal(err)
+	}
+	if !m.DeliverCloudMessage([]byte("b")) || len(got) != 1 || string(got[0]) != "b" {
+		t.Errorf("delivered %q", got)
+	}
+
+	data := []byte("message")
+	_ = m.SendCustomMessageToCloud(data)
+	data[0] = 'M'
+	if sent := m.SentCloudMessages(); len(sent) != 1 || string(sent[0]) != "message" {
+		t.Errorf("sent messages aren't copied: %q", sent)
+	}
+}
+
+func TestMockEdgeMediaFiles(t *testing.T) {
+	m := newTestMockEdge(t, nil)
+	var observed []*MediaFileDesc
+	_ = m.RegisterMediaFilesObserver(func(desc *MediaFileDesc) { observed = append(observed, desc) })
+	data := bytes.Repeat([]byte("jpeg"), 1000)
+	m.AddMediaFile(MediaFileDesc{FileName: "DJI_0001.jpg", FilePath: "/media/DJI_0001.jpg"}, data)
+	if len(observed) != 1 || observed[0].FileSize != uint64(len(data)) {
+		t.Fatalf("observed %v", observed)
+	}
+
+	r := m.NewMediaFileReader()
+	defer r.Destroy()
+	if err := r.Open(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := r.GetFileList()
+	if err != nil || len(files) != 1 || files[0].FilePath != "/media/DJI_0001.jpg" {
+		t.Fatalf("files %v %v", files, err)
+	}
+	f, err := r.OpenFile(files[0].FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(f); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("read %d bytes: %v", len(b), err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+
+	m.SetError(MockOpReadFile, ErrRequestTimeout)
+	if f, err = r.OpenFile(files[0].FilePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 16)); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("programmed read: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m.SetError(MockOpReadFile, nil)
+	if _, err := f.Read(make([]byte, 16)); err == nil {
+		t.Error("read after the reader is closed")
+	}
+}
+
+func TestMockLiveView(t *testing.T) {
+	m := newTestMockEdge(t, nil)
+	lv := m.NewLiveView().(*MockLiveView)
+	if views := m.LiveViews(); len(views) != 1 || views[0] != lv {
+		t.Fatalf("live-views %v", views)
+	}
+	r := newChanReceiver()
+	if err := lv.Init(CameraTypePayload, StreamQuality720p, r); err != nil {
+		t.Fatal(err)
+	}
+	_ = lv.SetCameraSource(CameraSourceZoom)
+	if lv.CameraSource() != CameraSourceZoom {
+		t.Errorf("source %v", lv.CameraSource())
+	}
+	if err := lv.PushStreamData(testIDR); err == nil {
+		t.Error("data pushed before StartH264Stream")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames := lv.Frames(ctx, nil)
+	if err := lv.StartH264Stream(); err != nil || !lv.Streaming() {
+		t.Fatalf("StartH264Stream: %v", err)
+	}
+	lv.PushStatus(&LiveStatus{Value: 1})
+	if status := <-r.status; status != 1 {
+		t.Errorf("status %d", status)
+	}
+	au := concat(testSPS, testPPS, testIDR)
+	if err := lv.PushStreamData(au); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-r.data; !bytes.Equal(data, au) {
+		t.Errorf("received %x", data)
+	}
+	f := receiveFrame(t, frames)
+	if !bytes.Equal(f.Data, au) || !f.Keyframe || f.CameraType != CameraTypePayload || f.Source != CameraSourceZoom {
+		t.Errorf("frame %+v", f)
+	}
+	f.Release()
+
+	if err := lv.StopH264Stream(); err != nil || lv.Streaming() {
+		t.Errorf("StopH264Stream: %v", err)
+	}
+	lv.Destroy()
+	if _, ok := <-frames; ok {
+		t.Error("frames aren't closed by Destroy")
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// mp4Epoch the base time of the mp4 timestamps
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// mp4Builder writes the boxes of a mp4 file
+type mp4Builder struct {
+	buf []byte
+}
+
+// box writes a box, the content is written by the function
+func (b *mp4Builder) box(typ string, content func()) {
+	start := len(b.buf)
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	b.buf = append(b.buf, typ...)
+	if content != nil {
+		content()
+	}
+	binary.BigEndian.PutUint32(b.buf[start:], uint32(len(b.buf)-start))
+}
+
+func (b *mp4Builder) u8(v uint8)   { b.buf = append(b.buf, v) }
+func (b *mp4Builder) u16(v uint16) { b.buf = binary.BigEndian.AppendUint16(b.buf, v) }
+func (b *mp4Builder) u32(v uint32) { b.buf = binary.BigEndian.AppendUint32(b.buf, v) }
+func (b *mp4Builder) bytes(v []byte) {
+	b.buf = append(b.buf, v...)
+}
+
+// matrix writes the unity matrix
+func (b *mp4Builder) matrix() {
+	for _, v := range []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000} {
+		b.u32(v)
+	}
+}
+
+func (b *mp4Builder) u64(v uint64) { b.buf = binary.BigEndian.AppendUint64(b.buf, v) }
+
+// avc1 writes the sample entry of the h264 video with the AVCDecoderConfigurationRecord
+func (b *mp4Builder) avc1(width, height int, avcC []byte) {
+	b.box("avc1", func() {
+		b.bytes(make([]byte, 6))
+		b.u16(1) // data_reference_index
+		b.bytes(make([]byte, 16))
+		b.u16(uint16(width))
+		b.u16(uint16(height))
+		b.u32(0x480000)
+		b.u32(0x480000)
+		b.u32(0)
+		b.u16(1) // frame_count
+		b.bytes(make([]byte, 32))
+		b.u16(0x18)
+		b.u16(0xffff)
+		b.box("avcC", func() {
+			b.bytes(avcC)
+		})
+	})
+}
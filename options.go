@@ -19,6 +19,7 @@ package djiedge
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -77,6 +78,8 @@ type AuthInfo struct {
 	AppKey  string
 	License string
 	Account string
+
+	secrets *secretBuffers // see LoadSecrets
 }
 
 // DeviceInfo firmware device information
@@ -88,9 +91,30 @@ type DeviceInfo struct {
 }
 
 // RSA2048Key the EdgeSDK obtains the RSA2048 key for establishing secure communication
+// the keys are DER of PKCS#1, use NewRSA2048Key or LoadRSA2048Key to convert them from other forms
 type RSA2048Key struct {
 	PrivateKey string
 	PublicKey  string
+
+	secrets *secretBuffers // see LoadRSA2048KeyFromSecrets
+}
+
+// validateInitParams checks the parameters passed to InitSDK before they are handed to the sdk.
+func validateInitParams(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger) error {
+	if device == nil || auth == nil || key == nil {
+		return errors.New("parameter is nil")
+	}
+	if auth.secrets.isWiped() || key.secrets.isWiped() {
+		return ErrSecretsWiped
+	}
+	// dji bug,an exception occurs when sn is empty
+	if device.SerialNumber == "" {
+		return errors.New("parameter is nil of device sn")
+	}
+	if logger != nil && !logger.Level.IsValid() {
+		return fmt.Errorf("%v is not a valid log level", logger.Level)
+	}
+	return nil
 }
 
 type LogLevel int
@@ -109,7 +133,21 @@ const (
 type Logger struct {
 	Level          LogLevel
 	EnableColorful bool
-	Outputer       func(msg string)
+	// Outputer receives every line of the sdk log without the newline
+	Outputer func(msg string)
+	// Handler receives the lines parsed into slog records, see ParseSDKLogLine,
+	// the module, the source and the relative timestamp of a line are the attributes "module", "sdk_source" and "sdk_uptime".
+	Handler slog.Handler
+
+	router *LogRouter
+}
+
+// Router returns the LogRouter of the logger returned by LogRouter.Logger, nil for other loggers
+func (l *Logger) Router() *LogRouter {
+	if l == nil {
+		return nil
+	}
+	return l.router
 }
 
 // The CameraSource type of stream source
@@ -182,6 +220,18 @@ type LiveStatus struct {
 	Quality1080PAvailable bool
 }
 
+// newLiveStatus parse the status bitmask of the stream
+func newLiveStatus(value int) *LiveStatus {
+	return &LiveStatus{
+		Value:                 value,
+		QualityAutoAvailable:  value&1 == 1,
+		Quality540PAvailable:  value&2 == 2,
+		Quality720PAvailable:  value&4 == 4,
+		Quality720PHAvailable: value&8 == 8,
+		Quality1080PAvailable: value&16 == 16,
+	}
+}
+
 func (l *LiveStatus) String() string {
 	return fmt.Sprintf("value:%d auto:%v 540p:%v 720p:%v 720ph:%v 1080p:%v",
 		l.Value,
@@ -0,0 +1,510 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lynnplus/go-djiedge/h264"
+)
+
+const (
+	// recordTimescale the timescale of the video track, the one of the mpeg-ts
+	recordTimescale = 90000
+	// defaultFragmentDuration the default of RecorderOptions.FragmentDuration
+	defaultFragmentDuration = time.Second
+	// defaultRecordInterval the duration of the last frame of a file if the frame rate is unknown
+	defaultRecordInterval = time.Second / 30
+	// recordFileTime the local time in the name of a file, such as payload-zoom-20231018T070102.123.mp4
+	recordFileTime = "20060102T150405.000"
+)
+
+// RecorderOptions the options of a Recorder
+type RecorderOptions struct {
+	// Dir the directory of the files, it is created if it doesn't exist
+	Dir string
+	// MaxDuration starts a new file at the first keyframe after the duration, 0 disables it
+	MaxDuration time.Duration
+	// MaxSize starts a new file at the first keyframe after the bytes, 0 disables it
+	MaxSize int64
+	// FragmentDuration the duration of the frames buffered and written as a fragment at once, 1s if 0.
+	// the frames buffered are lost if the process crashes, the fragments written are kept.
+	FragmentDuration time.Duration
+	// Sync calls fsync after every fragment, so the fragments also survive a power loss
+	Sync bool
+	// Name returns the file name of a file starting with the frame, RecordFileName if nil
+	Name func(f *Frame) string
+}
+
+// RecordFileName returns the name of the camera type, the source and the local time of the frame,
+// such as payload-zoom-20231018T070102.123.mp4
+func RecordFileName(f *Frame) string {
+	var name string
+	switch f.CameraType {
+	case CameraTypeFpv:
+		name = "fpv"
+	case CameraTypePayload:
+		name = "payload"
+	default:
+		name = fmt.Sprintf("camera%d", int(f.CameraType))
+	}
+	switch f.Source {
+	case CameraSourceWide:
+		name += "-wide"
+	case CameraSourceZoom:
+		name += "-zoom"
+	case CameraSourceIR:
+		name += "-ir"
+	}
+	return name + "-" + f.Time.Local().Format(recordFileTime) + ".mp4"
+}
+
+// Recorder muxes the frames of a live-view into fragmented mp4 files.
+// a file starts with the sps and pps in the sample description and a keyframe, the frames before them are skipped.
+// the timestamps are the receive times of the frames, the frames are presented in the order of receiving.
+//
+// every fragment is written by a single write after the header of the file, so a file is playable
+// to its last fragment even if the process crashes.
+type Recorder struct {
+	opts RecorderOptions
+
+	mu       sync.Mutex
+	closed   bool
+	sps, pps []byte
+	skipped  uint64
+
+	// the current file
+	f        *os.File
+	path     string
+	fileSPS  []byte
+	start    time.Time
+	size     int64
+	seq      uint32
+	interval uint32
+	samples  []recordSample
+	lastDTS  uint64
+}
+
+type recordSample struct {
+	data []byte
+	dts  uint64
+	key  bool
+}
+
+// NewRecorder returns a recorder writing to the directory of opts
+func NewRecorder(opts RecorderOptions) (*Recorder, error) {
+	if opts.Dir == "" || opts.MaxDuration < 0 || opts.MaxSize < 0 || opts.FragmentDuration < 0 {
+		return nil, errors.New("djiedge: invalid recorder options")
+	}
+	if opts.FragmentDuration == 0 {
+		opts.FragmentDuration = defaultFragmentDuration
+	}
+	if opts.Name == nil {
+		opts.Name = RecordFileName
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Recorder{opts: opts}, nil
+}
+
+// Record writes and releases the frames until the channel is closed or ctx is done,
+// the recorder is still open after it returns.
+func (r *Recorder) Record(ctx context.Context, frames <-chan *Frame) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case f, ok := <-frames:
+			if !ok {
+				return nil
+			}
+			err := r.WriteFrame(f)
+			f.Release()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// WriteFrame adds the frame to the current file, the frame isn't released
+func (r *Recorder) WriteFrame(f *Frame) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	sample, key := r.sample(f.Data)
+
+	if r.f != nil {
+		dts := r.nextDTS(f.Time)
+		if key && r.rotateDue(f, len(sample)) {
+			if err := r.closeFileLocked(dts); err != nil {
+				return err
+			}
+		} else if len(r.samples) > 0 && dts-r.samples[0].dts >= r.ticks(r.opts.FragmentDuration) {
+			if err := r.flushLocked(dts); err != nil {
+				return err
+			}
+		}
+	}
+	if r.f == nil {
+		if !key || r.sps == nil || r.pps == nil {
+			r.skipped++
+			return nil
+		}
+		if err := r.openFileLocked(f); err != nil {
+			return err
+		}
+	}
+	if len(sample) == 0 {
+		return nil
+	}
+	dts := r.nextDTS(f.Time)
+	r.lastDTS = dts
+	r.samples = append(r.samples, recordSample{data: sample, dts: dts, key: key})
+	return nil
+}
+
+// Path returns the path of the current file, empty if no file is being written
+func (r *Recorder) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
+}
+
+// Skipped returns the number of the frames skipped before a file starts
+func (r *Recorder) Skipped() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.skipped
+}
+
+// Close writes the frames buffered and closes the current file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.f == nil {
+		return nil
+	}
+	return r.closeFileLocked(r.lastDTS + uint64(r.interval))
+}
+
+// sample converts the annex-b data to the length prefixed nal units of a sample,
+// the parameter sets are kept for the sample description, and the access unit delimiters are removed.
+func (r *Recorder) sample(data []byte) (sample []byte, key bool) {
+	for _, nalu := range h264.SplitNALUnits(data) {
+		switch h264.Type(nalu) {
+		case h264.NALSPS:
+			r.sps = append(r.sps[:0], nalu...)
+			continue
+		case h264.NALPPS:
+			r.pps = append(r.pps[:0], nalu...)
+			continue
+		case h264.NALAUD:
+			continue
+		case h264.NALIDR:
+			key = true
+		}
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(nalu)))
+		sample = append(sample, nalu...)
+	}
+	return sample, key
+}
+
+// rotateDue reports whether a new file starts with the keyframe
+func (r *Recorder) rotateDue(f *Frame, size int) bool {
+	if !bytes.Equal(r.sps, r.fileSPS) {
+		return true
+	}
+	if r.opts.MaxDuration > 0 && f.Time.Sub(r.start) >= r.opts.MaxDuration {
+		return true
+	}
+	if r.opts.MaxSize > 0 {
+		n := r.size + int64(size)
+		for _, s := range r.samples {
+			n += int64(len(s.data))
+		}
+		return n > r.opts.MaxSize
+	}
+	return false
+}
+
+func (r *Recorder) ticks(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d*recordTimescale + time.Second/2) / time.Second)
+}
+
+// nextDTS returns the decoding time of the frame received at t in the current file,
+// it is after the last frame written, so the decoding times never go backwards even if the clock does.
+func (r *Recorder) nextDTS(t time.Time) uint64 {
+	dts := r.ticks(t.Sub(r.start))
+	if len(r.samples) > 0 || r.seq > 0 {
+		dts = max(dts, r.lastDTS+1)
+	}
+	return dts
+}
+
+func (r *Recorder) openFileLocked(first *Frame) error {
+	name := r.opts.Name(first)
+	path := filepath.Join(r.opts.Dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	// the files of the same name are numbered
+	ext := filepath.Ext(name)
+	for i := 1; errors.Is(err, os.ErrExist) && i < 100; i++ {
+		path = filepath.Join(r.opts.Dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+
+	width, height := 0, 0
+	r.interval = uint32(r.ticks(defaultRecordInterval))
+	if sps, err := h264.ParseSPS(r.sps); err == nil {
+		width, height = sps.Width, sps.Height
+		if d := sps.FrameInterval(); d > 0 {
+			r.interval = uint32(r.ticks(d))
+		}
+	}
+	avcC, err := h264.AVCDecoderConfig(r.sps, r.pps)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	init := buildFMP4Init(width, height, avcC, first.Time)
+	if _, err := f.Write(init); err != nil {
+		f.Close()
+		return err
+	}
+	if r.opts.Sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	r.f, r.path = f, path
+	r.fileSPS = append(r.fileSPS[:0], r.sps...)
+	r.start, r.size, r.seq, r.lastDTS = first.Time, int64(len(init)), 0, 0
+	return nil
+}
+
+// closeFileLocked writes the frames buffered, the last one ends at next, and closes the file
+func (r *Recorder) closeFileLocked(next uint64) error {
+	err := r.flushLocked(next)
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f, r.path, r.samples = nil, "", r.samples[:0]
+	return err
+}
+
+// flushLocked writes the frames buffered as a fragment, the last one ends at next
+func (r *Recorder) flushLocked(next uint64) error {
+	if len(r.samples) == 0 {
+		return nil
+	}
+	if last := r.samples[len(r.samples)-1].dts; next <= last {
+		next = last + uint64(r.interval)
+	}
+	r.seq++
+	frag := buildFMP4Fragment(r.seq, r.samples, next)
+	for i := range r.samples {
+		r.samples[i].data = nil
+	}
+	r.samples = r.samples[:0]
+	n, err := r.f.Write(frag)
+	r.size += int64(n)
+	if err == nil && r.opts.Sync {
+		err = r.f.Sync()
+	}
+	return err
+}
+
+// buildFMP4Init returns the ftyp and moov boxes of a fragmented mp4 file with a single video track
+func buildFMP4Init(width, height int, avcC []byte, created time.Time) []byte {
+	ts := uint32(created.Sub(mp4Epoch) / time.Second)
+	b := &mp4Builder{}
+	b.box("ftyp", func() {
+		b.bytes([]byte("iso5"))
+		b.u32(0x200)
+		b.bytes([]byte("iso5iso6avc1mp41"))
+	})
+	b.box("moov", func() {
+		b.box("mvhd", func() {
+			b.u32(0)
+			b.u32(ts)
+			b.u32(ts)
+			b.u32(1000)
+			b.u32(0)       // duration
+			b.u32(0x10000) // rate
+			b.u16(0x100)   // volume
+			b.bytes(make([]byte, 10))
+			b.matrix()
+			b.bytes(make([]byte, 24))
+			b.u32(2) // next_track_ID
+		})
+		b.box("trak", func() {
+			b.box("tkhd", func() {
+				b.u32(3) // enabled and in movie
+				b.u32(ts)
+				b.u32(ts)
+				b.u32(1) // track_ID
+				b.u32(0)
+				b.u32(0) // duration
+				b.bytes(make([]byte, 8))
+				b.u16(0) // layer
+				b.u16(0) // alternate_group
+				b.u16(0) // volume
+				b.u16(0)
+				b.matrix()
+				b.u32(uint32(width) << 16)
+				b.u32(uint32(height) << 16)
+			})
+			b.box("mdia", func() {
+				b.box("mdhd", func() {
+					b.u32(0)
+					b.u32(ts)
+					b.u32(ts)
+					b.u32(recordTimescale)
+					b.u32(0)
+					b.u16(0x55c4) // und
+					b.u16(0)
+				})
+				b.box("hdlr", func() {
+					b.u32(0)
+					b.u32(0)
+					b.bytes([]byte("vide"))
+					b.bytes(make([]byte, 12))
+					b.bytes([]byte("VideoHandler\x00"))
+				})
+				b.box("minf", func() {
+					b.box("vmhd", func() {
+						b.u32(1)
+						b.bytes(make([]byte, 8))
+					})
+					b.box("dinf", func() {
+						b.box("dref", func() {
+							b.u32(0)
+							b.u32(1)
+							b.box("url ", func() { b.u32(1) })
+						})
+					})
+					// the sample tables are empty, the samples are in the fragments
+					b.box("stbl", func() {
+						b.box("stsd", func() {
+							b.u32(0)
+							b.u32(1)
+							b.avc1(width, height, avcC)
+						})
+						for _, typ := range []string{"stts", "stsc", "stco"} {
+							b.box(typ, func() {
+								b.u32(0)
+								b.u32(0)
+							})
+						}
+						b.box("stsz", func() {
+							b.u32(0)
+							b.u32(0)
+							b.u32(0)
+						})
+					})
+				})
+			})
+		})
+		b.box("mvex", func() {
+			b.box("trex", func() {
+				b.u32(0)
+				b.u32(1) // track_ID
+				b.u32(1) // default_sample_description_index
+				b.u32(0)
+				b.u32(0)
+				b.u32(0)
+			})
+		})
+	})
+	return b.buf
+}
+
+// buildFMP4Fragment returns the moof and mdat boxes of the samples, the last sample ends at next
+func buildFMP4Fragment(seq uint32, samples []recordSample, next uint64) []byte {
+	b := &mp4Builder{}
+	var dataOffset int
+	b.box("moof", func() {
+		b.box("mfhd", func() {
+			b.u32(0)
+			b.u32(seq)
+		})
+		b.box("traf", func() {
+			b.box("tfhd", func() {
+				b.u32(0x020000) // default-base-is-moof
+				b.u32(1)        // track_ID
+			})
+			b.box("tfdt", func() {
+				b.u32(1 << 24) // version 1
+				b.u64(samples[0].dts)
+			})
+			b.box("trun", func() {
+				// data-offset, sample-duration, sample-size and sample-flags present
+				b.u32(0x000701)
+				b.u32(uint32(len(samples)))
+				dataOffset = len(b.buf)
+				b.u32(0)
+				for i, s := range samples {
+					end := next
+					if i+1 < len(samples) {
+						end = samples[i+1].dts
+					}
+					b.u32(uint32(end - s.dts))
+					b.u32(uint32(len(s.data)))
+					if s.key {
+						b.u32(0x02000000) // depends on no other sample
+					} else {
+						b.u32(0x01010000) // depends on others, not a sync sample
+					}
+				}
+			})
+		})
+	})
+	binary.BigEndian.PutUint32(b.buf[dataOffset:], uint32(len(b.buf)+8))
+	size := 8
+	for _, s := range samples {
+		size += len(s.data)
+	}
+	b.u32(uint32(size))
+	b.bytes([]byte("mdat"))
+	for _, s := range samples {
+		b.bytes(s.data)
+	}
+	return b.buf
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+type recordedBox struct {
+	typ  string
+	data []byte
+	size int
+}
+
+func parseBoxes(t *testing.T, b []byte) []recordedBox {
+	t.Helper()
+	var boxes []recordedBox
+	for len(b) > 0 {
+		if len(b) < 8 {
+			t.Fatalf("truncated box header %x", b)
+		}
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			t.Fatalf("box %q of size %d in %d bytes", b[4:8], size, len(b))
+		}
+		boxes = append(boxes, recordedBox{typ: string(b[4:8]), data: b[8:size], size: size})
+		b = b[size:]
+	}
+	return boxes
+}
+
+// findBox returns the box of the path of types, the children of stsd and avc1 are after their fixed fields
+func findBox(t *testing.T, b []byte, path ...string) []byte {
+	t.Helper()
+	for _, typ := range path {
+		var found []byte
+		for _, box := range parseBoxes(t, b) {
+			if box.typ == typ {
+				found = box.data
+				break
+			}
+		}
+		if found == nil {
+			t.Fatalf("no box %q in %v", typ, path)
+		}
+		switch typ {
+		case "stsd":
+			found = found[8:]
+		case "avc1":
+			found = found[78:]
+		}
+		b = found
+	}
+	return b
+}
+
+type recordedFragment struct {
+	seq       uint32
+	baseDTS   uint64
+	durations []uint32
+	sizes     []uint32
+	flags     []uint32
+}
+
+// parseRecordFile checks the boxes of a file written by the recorder and returns its fragments
+func parseRecordFile(t *testing.T, path string) (width, height uint32, frags []recordedFragment) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes := parseBoxes(t, data)
+	if len(boxes) < 2 || boxes[0].typ != "ftyp" || boxes[1].typ != "moov" {
+		t.Fatalf("%s doesn't start with ftyp and moov", path)
+	}
+	if brand := string(boxes[0].data[:4]); brand != "iso5" {
+		t.Fatalf("major brand %q", brand)
+	}
+	tkhd := findBox(t, boxes[1].data, "trak", "tkhd")
+	width, height = binary.BigEndian.Uint32(tkhd[76:])>>16, binary.BigEndian.Uint32(tkhd[80:])>>16
+	avcC := findBox(t, boxes[1].data, "trak", "mdia", "minf", "stbl", "stsd", "avc1", "avcC")
+	if avcC[0] != 1 || avcC[5]&0x1f != 1 {
+		t.Fatalf("unexpected avcC %x", avcC[:6])
+	}
+	if timescale := binary.BigEndian.Uint32(findBox(t, boxes[1].data, "trak", "mdia", "mdhd")[12:]); timescale != recordTimescale {
+		t.Fatalf("timescale %d", timescale)
+	}
+
+	rest := boxes[2:]
+	if len(rest)%2 != 0 {
+		t.Fatalf("%d boxes after moov, want moof and mdat pairs", len(rest))
+	}
+	for i := 0; i < len(rest); i += 2 {
+		moof, mdat := rest[i], rest[i+1]
+		if moof.typ != "moof" || mdat.typ != "mdat" {
+			t.Fatalf("boxes %q %q, want moof mdat", moof.typ, mdat.typ)
+		}
+		var frag recordedFragment
+		frag.seq = binary.BigEndian.Uint32(findBox(t, moof.data, "mfhd")[4:])
+		tfdt := findBox(t, moof.data, "traf", "tfdt")
+		if tfdt[0] != 1 {
+			t.Fatalf("tfdt version %d", tfdt[0])
+		}
+		frag.baseDTS = binary.BigEndian.Uint64(tfdt[4:])
+		trun := findBox(t, moof.data, "traf", "trun")
+		n := int(binary.BigEndian.Uint32(trun[4:]))
+		if offset := int(binary.BigEndian.Uint32(trun[8:])); offset != moof.size+8 {
+			t.Fatalf("data offset %d, want %d", offset, moof.size+8)
+		}
+		total := 0
+		for j := 0; j < n; j++ {
+			entry := trun[12+12*j:]
+			frag.durations = append(frag.durations, binary.BigEndian.Uint32(entry))
+			frag.sizes = append(frag.sizes, binary.BigEndian.Uint32(entry[4:]))
+			frag.flags = append(frag.flags, binary.BigEndian.Uint32(entry[8:]))
+			total += int(frag.sizes[j])
+		}
+		if total != len(mdat.data) {
+			t.Fatalf("samples of %d bytes in a mdat of %d", total, len(mdat.data))
+		}
+		// the samples are length prefixed nal units
+		for j, s := 0, mdat.data; j < n; j++ {
+			sample := s[:frag.sizes[j]]
+			for len(sample) > 0 {
+				l := int(binary.BigEndian.Uint32(sample))
+				if l == 0 || 4+l > len(sample) {
+					t.Fatalf("invalid nal unit length %d", l)
+				}
+				sample = sample[4+l:]
+			}
+			s = s[frag.sizes[j]:]
+		}
+		frags = append(frags, frag)
+	}
+	return width, height, frags
+}
+
+func recordFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestRecorder(t *testing.T) {
+	p, err := NewTestPattern(StreamQuality540p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderOptions{Dir: dir, MaxDuration: 3 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 10, 18, 7, 1, 2, 0, time.Local)
+	// the frames before the first keyframe are skipped
+	p.NextAccessUnit(start)
+	const frames = 5 * testPatternGOP
+	for i := 1; i <= frames; i++ {
+		at := start.Add(time.Duration(i) * p.FrameInterval())
+		f := newFrame(p.NextAccessUnit(at), 1)
+		f.CameraType, f.Source, f.Time = CameraTypePayload, CameraSourceZoom, at
+		if err := r.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Release()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Skipped() != testPatternGOP-1 {
+		t.Fatalf("skipped %d frames, want %d", r.Skipped(), testPatternGOP-1)
+	}
+
+	files := recordFiles(t, dir)
+	// the files of 3s rotate at the keyframes every 2s, at 4s, 8s and the last frame
+	if len(files) != 3 {
+		t.Fatalf("files %v, want 3", files)
+	}
+	first := start.Add(testPatternGOP * p.FrameInterval())
+	if want := "payload-zoom-" + first.Format(recordFileTime) + ".mp4"; filepath.Base(files[0]) != want {
+		t.Fatalf("name %s, want %s", filepath.Base(files[0]), want)
+	}
+
+	interval := uint32(recordTimescale / testPatternFPS)
+	samples := 0
+	for _, file := range files {
+		width, height, frags := parseRecordFile(t, file)
+		if w, h := p.Size(); width != uint32(w) || height != uint32(h) {
+			t.Fatalf("size %dx%d, want %dx%d", width, height, w, h)
+		}
+		if len(frags) == 0 || frags[0].flags[0] != 0x02000000 {
+			t.Fatalf("%s doesn't start with a keyframe", file)
+		}
+		var dts uint64
+		for i, frag := range frags {
+			if frag.seq != uint32(i+1) {
+				t.Fatalf("fragment sequence %d, want %d", frag.seq, i+1)
+			}
+			// the fragments continue the decoding times of the previous ones
+			if frag.baseDTS != dts {
+				t.Fatalf("tfdt %d, want %d", frag.baseDTS, dts)
+			}
+			for j, d := range frag.durations {
+				if d != interval {
+					t.Fatalf("duration %d, want %d", d, interval)
+				}
+				if key := frag.flags[j] == 0x02000000; key != ((samples % testPatternGOP) == 0) {
+					t.Fatalf("sample %d: keyframe %v", samples, key)
+				}
+				dts += uint64(d)
+				samples++
+			}
+		}
+	}
+	if samples != frames-testPatternGOP+1 {
+		t.Fatalf("%d samples, want %d", samples, frames-testPatternGOP+1)
+	}
+}
+
+func TestRecorderClockBackwards(t *testing.T) {
+	p, err := NewTestPattern(StreamQuality540p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	// 1.2s of frames, then the clock goes back 1s
+	var times []time.Time
+	for i := 0; i < 36; i++ {
+		times = append(times, start.Add(time.Duration(i)*p.FrameInterval()))
+	}
+	for i := 0; i < 10; i++ {
+		times = append(times, start.Add(200*time.Millisecond+time.Duration(i)*p.FrameInterval()))
+	}
+	for _, at := range times {
+		f := newFrame(p.NextAccessUnit(at), 1)
+		f.Time = at
+		if err := r.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Release()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := recordFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("files %v, want 1", files)
+	}
+	_, _, frags := parseRecordFile(t, files[0])
+	// a fragment at 1s, the frames after the clock went back are in the second one
+	if len(frags) != 2 {
+		t.Fatalf("%d fragments, want 2", len(frags))
+	}
+	samples := 0
+	var dts uint64
+	for _, frag := range frags {
+		if frag.baseDTS != dts {
+			t.Fatalf("tfdt %d, want %d", frag.baseDTS, dts)
+		}
+		for _, d := range frag.durations {
+			if d == 0 || d > recordTimescale {
+				t.Fatalf("sample %d: duration %d", samples, d)
+			}
+			dts += uint64(d)
+			samples++
+		}
+	}
+	if samples != len(times) {
+		t.Fatalf("%d samples, want %d", samples, len(times))
+	}
+}
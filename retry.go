@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy the exponential backoff of retrying a failed InitSDK,
+// the zero fields of the delays are the ones of DefaultRetryPolicy, a negative field is invalid.
+type RetryPolicy struct {
+	// InitialBackoff the delay before the first retry, 1s if 0
+	InitialBackoff time.Duration
+	// MaxBackoff the limit of the delay, 30s or InitialBackoff if it is longer, if 0
+	MaxBackoff time.Duration
+	// Multiplier the growth of the delay after every retry, at least 1, 2 if 0
+	Multiplier float64
+	// Jitter randomizes the delay by the fraction, such as 0.2 for ±20%, at most 1
+	Jitter float64
+	// MaxAttempts the limit of the calls of InitSDK, 0 means unlimited
+	MaxAttempts int
+	// Retryable reports whether the error of InitSDK is retried, IsRetryableInitError if nil
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy retries forever from 1s to 30s
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// withDefaults returns a copy with the defaults of the zero fields, DefaultRetryPolicy if p is nil
+func (p *RetryPolicy) withDefaults() (*RetryPolicy, error) {
+	if p == nil {
+		return DefaultRetryPolicy(), nil
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.Multiplier < 0 || p.Jitter < 0 || p.MaxAttempts < 0 {
+		return nil, errors.New("djiedge: the fields of RetryPolicy must not be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 || p.Jitter > 1 {
+		return nil, errors.New("djiedge: RetryPolicy.Multiplier must be at least 1 and Jitter at most 1")
+	}
+	c := *p
+	def := DefaultRetryPolicy()
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = def.InitialBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = max(def.MaxBackoff, c.InitialBackoff)
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		return nil, errors.New("djiedge: RetryPolicy.MaxBackoff is shorter than InitialBackoff")
+	}
+	if c.Multiplier == 0 {
+		c.Multiplier = def.Multiplier
+	}
+	return &c, nil
+}
+
+// backoff returns the delay after the attempt, the first attempt is 1
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt && d < float64(p.MaxBackoff); i++ {
+		d *= p.Multiplier
+	}
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryableInitError(err)
+}
+
+// IsRetryableInitError reports whether InitSDK may succeed later after the error,
+// it is IsRetryable, such as the failures of the connection and the timeouts of the requests.
+func IsRetryableInitError(err error) bool {
+	return IsRetryable(err)
+}
+
+// InitSupervisor initializes the sdk of a backend and retries the retryable failures with exponential backoff.
+//
+// DeInitOnFailed should be true for the native backend, so the threads of a failed initialization are stopped
+// by the sdk itself, otherwise DeInitSDK is called after the failure before the retry.
+type InitSupervisor struct {
+	Lifecycle Lifecycle
+
+	Device         *DeviceInfo
+	Auth           *AuthInfo
+	Key            *RSA2048Key
+	Logger         *Logger
+	DeInitOnFailed bool
+
+	// Policy the backoff of the retries, DefaultRetryPolicy if nil
+	Policy *RetryPolicy
+	// OnRetry is called with the failure before waiting for the next attempt
+	OnRetry func(attempt int, err error, backoff time.Duration)
+}
+
+// Run calls InitSDK until it succeeds, fails with an error not retryable, or the attempts are exhausted.
+// if ctx is done while waiting for a retry, the error of ctx is returned,
+// a running InitSDK can't be interrupted, so ctx is only checked between the attempts.
+// an invalid Policy is returned as an error before InitSDK is called.
+func (s *InitSupervisor) Run(ctx context.Context) error {
+	policy, err := s.Policy.withDefaults()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := s.Lifecycle.InitSDK(s.Device, s.Auth, s.Key, s.Logger, s.DeInitOnFailed)
+		if err == nil || !policy.retryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+		if !s.DeInitOnFailed && s.Lifecycle.State() == StateFailed {
+			_ = s.Lifecycle.DeInitSDK()
+		}
+		backoff := policy.backoff(attempt)
+		if s.OnRetry != nil {
+			s.OnRetry(attempt, err, backoff)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		want    *RetryPolicy
+		invalid bool
+	}{
+		{name: "nil", policy: nil, want: DefaultRetryPolicy()},
+		{name: "zero", policy: &RetryPolicy{}, want: &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Multiplier: 2}},
+		{name: "long initial", policy: &RetryPolicy{InitialBackoff: time.Minute},
+			want: &RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Minute, Multiplier: 2}},
+		{name: "constant", policy: &RetryPolicy{Multiplier: 1, MaxAttempts: 3},
+			want: &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Multiplier: 1, MaxAttempts: 3}},
+		{name: "negative initial", policy: &RetryPolicy{InitialBackoff: -1}, invalid: true},
+		{name: "negative max", policy: &RetryPolicy{MaxBackoff: -time.Second}, invalid: true},
+		{name: "negative multiplier", policy: &RetryPolicy{Multiplier: -2}, invalid: true},
+		{name: "negative jitter", policy: &RetryPolicy{Jitter: -0.1}, invalid: true},
+		{name: "negative attempts", policy: &RetryPolicy{MaxAttempts: -1}, invalid: true},
+		{name: "shrinking", policy: &RetryPolicy{Multiplier: 0.5}, invalid: true},
+		{name: "jitter above 1", policy: &RetryPolicy{Jitter: 1.5}, invalid: true},
+		{name: "max below initial", policy: &RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Second}, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.withDefaults()
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("no error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.InitialBackoff != tt.want.InitialBackoff || got.MaxBackoff != tt.want.MaxBackoff || got.Multiplier != tt.want.Multiplier ||
+				got.Jitter != tt.want.Jitter || got.MaxAttempts != tt.want.MaxAttempts {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p, _ := (&RetryPolicy{}).withDefaults()
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30, 30}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Second {
+			t.Errorf("attempt %d: %v, want %v", i+1, d, w*time.Second)
+		}
+	}
+	if d := p.backoff(1 << 20); d != 30*time.Second {
+		t.Errorf("attempt 1<<20: %v", d)
+	}
+
+	p = DefaultRetryPolicy()
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jittered backoff %v out of ±20%%", d)
+		}
+	}
+}
+
+func TestInitSupervisor(t *testing.T) {
+	device := &DeviceInfo{SerialNumber: "SN0001"}
+	newSupervisor := func(m *MockEdge, policy *RetryPolicy) (*InitSupervisor, *[]time.Duration) {
+		var backoffs []time.Duration
+		return &InitSupervisor{
+			Lifecycle: m,
+			Device:    device,
+			Auth:      &AuthInfo{},
+			Key:       &RSA2048Key{},
+			Policy:    policy,
+			OnRetry: func(attempt int, err error, backoff time.Duration) {
+				backoffs = append(backoffs, backoff)
+			},
+		}, &backoffs
+	}
+	countInits := func(m *MockEdge) int {
+		n := 0
+		for _, c := range m.Calls() {
+			if c.Op == MockOpInitSDK {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		m := NewMockEdge()
+		m.SetError(MockOpInitSDK, ErrConnectFailure)
+		s, backoffs := newSupervisor(m, &RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3})
+		if err := s.Run(context.Background()); !errors.Is(err, ErrConnectFailure) {
+			t.Fatalf("got %v", err)
+		}
+		if n := countInits(m); n != 3 {
+			t.Errorf("InitSDK is called %d times, want 3", n)
+		}
+		if want := []time.Duration{time.Millisecond, 2 * time.Millisecond}; len(*backoffs) != 2 || (*backoffs)[0] != want[0] || (*backoffs)[1] != want[1] {
+			t.Errorf("backoffs %v, want %v", *backoffs, want)
+		}
+	})
+	t.Run("de-initialized before retry", func(t *testing.T) {
+		for _, deInitOnFailed := range []bool{false, true} {
+			m := NewMockEdge()
+			m.SetError(MockOpInitSDK, ErrConnectFailure)
+			s, _ := newSupervisor(m, &RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3})
+			s.DeInitOnFailed = deInitOnFailed
+			if err := s.Run(context.Background()); !errors.Is(err, ErrConnectFailure) {
+				t.Fatalf("deInitOnFailed %v: got %v", deInitOnFailed, err)
+			}
+			want := []MockOp{MockOpInitSDK, MockOpInitSDK, MockOpInitSDK}
+			if !deInitOnFailed {
+				want = []MockOp{MockOpInitSDK, MockOpDeInitSDK, MockOpInitSDK, MockOpDeInitSDK, MockOpInitSDK}
+			}
+			if ops := mockOps(m); !slices.Equal(ops, want) {
+				t.Errorf("deInitOnFailed %v: calls %v, want %v", deInitOnFailed, ops, want)
+			}
+		}
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		m := NewMockEdge()
+		m.SetError(MockOpInitSDK, ErrAuthVerifyFailure)
+		s, _ := newSupervisor(m, &RetryPolicy{InitialBackoff: time.Millisecond})
+		if err := s.Run(context.Background()); !errors.Is(err, ErrAuthVerifyFailure) || countInits(m) != 1 {
+			t.Errorf("got %v after %d calls", err, countInits(m))
+		}
+	})
+	t.Run("succeeded", func(t *testing.T) {
+		m := NewMockEdge()
+		m.SetError(MockOpInitSDK, ErrRequestTimeout)
+		s, _ := newSupervisor(m, &RetryPolicy{InitialBackoff: time.Millisecond})
+		s.OnRetry = func(attempt int, err error, backoff time.Duration) {
+			if attempt == 2 {
+				m.SetError(MockOpInitSDK, nil)
+			}
+		}
+		if err := s.Run(context.Background()); err != nil || countInits(m) != 3 || m.State() != StateReady {
+			t.Errorf("got %v after %d calls, state %v", err, countInits(m), m.State())
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		m := NewMockEdge()
+		m.SetError(MockOpInitSDK, ErrConnectFailure)
+		s, _ := newSupervisor(m, &RetryPolicy{InitialBackoff: time.Hour})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := s.Run(ctx); !errors.Is(err, context.DeadlineExceeded) || countInits(m) != 1 {
+			t.Errorf("got %v after %d calls", err, countInits(m))
+		}
+	})
+	t.Run("invalid policy", func(t *testing.T) {
+		m := NewMockEdge()
+		s, _ := newSupervisor(m, &RetryPolicy{InitialBackoff: -time.Second})
+		if err := s.Run(context.Background()); err == nil || countInits(m) != 0 {
+			t.Errorf("got %v after %d calls", err, countInits(m))
+		}
+	})
+}
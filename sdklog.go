@@ -0,0 +1,306 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSDKLogLine the limit of a partial line kept for the following writes
+const maxSDKLogLine = 64 * 1024
+
+// SDKLogRecord a parsed line of the sdk log, such as
+//
+//	[Info]-[edge_sdk_init.cc:60]-[Init] the sdk is initialized
+//	[12.345][core]-[Warn]-[DjiCore_Init:100) identify aircraft series
+type SDKLogRecord struct {
+	Level LogLevel
+	// Time the time of the line, the time of receiving if the line has no date and time
+	Time time.Time
+	// Uptime the relative timestamp of the line, such as [12.345], 0 if it has none
+	Uptime time.Duration
+	// Module the name of the module, such as core or simulation
+	Module string
+	// Source the file or function and the line, such as edge_sdk_init.cc:60
+	Source  string
+	Message string
+	// Continuation the line doesn't begin with a prefix, it continues the multi-line message of the previous line
+	Continuation bool
+}
+
+// ParseSDKLogLine parses a line of the sdk log without the newline, the ANSI colour codes are removed.
+// the line without prefixes is returned as the message of LogLevelInfo.
+func ParseSDKLogLine(line string) SDKLogRecord {
+	line = stripANSI(line)
+	r := SDKLogRecord{Level: LogLevelInfo, Time: time.Now()}
+	rest := strings.TrimSpace(line)
+	if !strings.HasPrefix(rest, "[") {
+		r.Message, r.Continuation = rest, true
+		return r
+	}
+	levelSet := false
+	for strings.HasPrefix(rest, "[") {
+		// the closing of a function source is ')' in some modules
+		end := strings.IndexAny(rest, "])")
+		if end < 0 {
+			break
+		}
+		token := strings.TrimSpace(rest[1:end])
+		rest = strings.TrimPrefix(rest[end+1:], "-")
+		switch {
+		case !levelSet && parseSDKLogLevel(token, &r.Level):
+			levelSet = true
+		case parseSDKLogTime(token, &r):
+		case isSDKLogSource(token):
+			r.Source = token
+		case r.Module == "":
+			r.Module = token
+		default:
+			r.Module += "/" + token
+		}
+	}
+	r.Message = strings.TrimSpace(rest)
+	return r
+}
+
+func parseSDKLogLevel(s string, level *LogLevel) bool {
+	switch strings.ToLower(s) {
+	case "error", "err", "e", "fatal":
+		*level = LogLevelError
+	case "warn", "warning", "w":
+		*level = LogLevelWarn
+	case "info", "i", "notice":
+		*level = LogLevelInfo
+	case "debug", "d", "trace", "verbose":
+		*level = LogLevelDebug
+	default:
+		return false
+	}
+	return true
+}
+
+var sdkLogTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006/01/02 15:04:05.999999999",
+}
+
+func parseSDKLogTime(s string, r *SDKLogRecord) bool {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 {
+		r.Uptime = time.Duration(f * float64(time.Second))
+		return true
+	}
+	for _, layout := range sdkLogTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			r.Time = t
+			return true
+		}
+	}
+	if t, err := time.ParseInLocation("15:04:05.999999999", s, time.Local); err == nil {
+		y, m, d := r.Time.Date()
+		r.Time = time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		return true
+	}
+	return false
+}
+
+// isSDKLogSource reports whether the token is like file.cc:60 or func:60
+func isSDKLogSource(s string) bool {
+	i := strings.LastIndexByte(s, ':')
+	if i <= 0 || i == len(s)-1 {
+		return false
+	}
+	_, err := strconv.ParseUint(s[i+1:], 10, 32)
+	return err == nil
+}
+
+// stripANSI removes the escape sequences of the colours
+func stripANSI(s string) string {
+	if strings.IndexByte(s, 0x1b) < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != 0x1b {
+			b.WriteByte(s[i])
+			continue
+		}
+		// CSI: ESC [ parameters intermediates final
+		if i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			i = j
+			continue
+		}
+		i++ // ESC and the next byte
+	}
+	return b.String()
+}
+
+// slogLevel converts the level of the sdk to slog
+func (l LogLevel) slogLevel() slog.Level {
+	switch l {
+	case LogLevelError:
+		return slog.LevelError
+	case LogLevelWarn:
+		return slog.LevelWarn
+	case LogLevelDebug:
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// sdkLogWriter splits the writes of the sdk log into lines, and delivers them to the Outputer and the Handler of a Logger.
+// a write may contain several lines or a part of a line, the partial line is kept until its newline is written,
+// or until a write begins with a new prefix like "[Info]".
+type sdkLogWriter struct {
+	outputer func(string)
+	handler  slog.Handler
+
+	mu      sync.Mutex
+	pending []byte
+	parser  sdkLogParser
+}
+
+func newSDKLogWriter(logger *Logger) *sdkLogWriter {
+	if logger == nil || (logger.Outputer == nil && logger.Handler == nil) {
+		return nil
+	}
+	return &sdkLogWriter{outputer: logger.Outputer, handler: logger.Handler}
+}
+
+// write delivers the complete lines of the data
+func (w *sdkLogWriter) write(data []byte) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 && len(data) > 0 && (data[0] == '[' || data[0] == 0x1b) {
+		w.emitPending()
+	}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			w.pending = append(w.pending, data...)
+			if len(w.pending) >= maxSDKLogLine {
+				w.emitPending()
+			}
+			return
+		}
+		line := data[:i]
+		if len(w.pending) > 0 {
+			line = append(w.pending, line...)
+		}
+		w.emit(line)
+		w.pending = w.pending[:0]
+		data = data[i+1:]
+	}
+}
+
+// writeLine delivers a line, the newline is optional
+func (w *sdkLogWriter) writeLine(line string) {
+	if w == nil {
+		return
+	}
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	w.write([]byte(line))
+}
+
+// flush delivers the partial line
+func (w *sdkLogWriter) flush() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.emitPending()
+}
+
+func (w *sdkLogWriter) emitPending() {
+	if len(w.pending) > 0 {
+		w.emit(w.pending)
+		w.pending = w.pending[:0]
+	}
+}
+
+func (w *sdkLogWriter) emit(line []byte) {
+	s := string(bytes.TrimRight(line, "\r"))
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	if w.outputer != nil {
+		w.outputer(s)
+	}
+	if w.handler == nil {
+		return
+	}
+	r := w.parser.parse(s)
+	_ = handleSlogRecord(w.handler, &r)
+}
+
+// sdkLogParser parses the lines of a log, the continuation of a multi-line message gets the level of its first line
+type sdkLogParser struct {
+	last    SDKLogRecord
+	hasLast bool
+}
+
+func (p *sdkLogParser) parse(line string) SDKLogRecord {
+	r := ParseSDKLogLine(line)
+	switch {
+	case !r.Continuation:
+		p.last, p.hasLast = r, true
+	case p.hasLast:
+		r.Level, r.Module, r.Source = p.last.Level, p.last.Module, p.last.Source
+	}
+	return r
+}
+
+// handleSlogRecord emits the record to the handler if its level is enabled
+func handleSlogRecord(h slog.Handler, r *SDKLogRecord) error {
+	ctx := context.Background()
+	level := r.Level.slogLevel()
+	if !h.Enabled(ctx, level) {
+		return nil
+	}
+	rec := slog.NewRecord(r.Time, level, r.Message, 0)
+	if r.Module != "" {
+		rec.AddAttrs(slog.String("module", r.Module))
+	}
+	if r.Source != "" {
+		rec.AddAttrs(slog.String("sdk_source", r.Source))
+	}
+	if r.Uptime > 0 {
+		rec.AddAttrs(slog.Duration("sdk_uptime", r.Uptime))
+	}
+	return h.Handle(ctx, rec)
+}
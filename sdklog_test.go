@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSDKLogLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want SDKLogRecord // the time is not compared
+	}{
+		{"edge", "[Info]-[edge_sdk_init.cc:60]-[Init] the sdk is initialized",
+			SDKLogRecord{Level: LogLevelInfo, Module: "Init", Source: "edge_sdk_init.cc:60", Message: "the sdk is initialized"}},
+		{"core", "[12.345][core]-[Warn]-[DjiCore_Init:100) identify aircraft series",
+			SDKLogRecord{Level: LogLevelWarn, Uptime: 12345 * time.Millisecond, Module: "core", Source: "DjiCore_Init:100",
+				Message: "identify aircraft series"}},
+		{"colours", "\x1b[31m[Error]-[edge] init failed\x1b[0m",
+			SDKLogRecord{Level: LogLevelError, Module: "edge", Message: "init failed"}},
+		{"short level", "[D][liveview] frame", SDKLogRecord{Level: LogLevelDebug, Module: "liveview", Message: "frame"}},
+		{"nested modules", "[Info][media][reader] opened", SDKLogRecord{Level: LogLevelInfo, Module: "media/reader", Message: "opened"}},
+		{"second level is a module", "[Error][warn] x", SDKLogRecord{Level: LogLevelError, Module: "warn", Message: "x"}},
+		{"continuation", "    at frame 2", SDKLogRecord{Level: LogLevelInfo, Message: "at frame 2", Continuation: true}},
+		{"empty", "", SDKLogRecord{Level: LogLevelInfo, Continuation: true}},
+		{"unclosed prefix", "[Info unclosed", SDKLogRecord{Level: LogLevelInfo, Message: "[Info unclosed"}},
+		{"empty prefix", "[]-[Warn] message", SDKLogRecord{Level: LogLevelWarn, Message: "message"}},
+		{"source without line", "[Info][main.cc:] m", SDKLogRecord{Level: LogLevelInfo, Module: "main.cc:", Message: "m"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseSDKLogLine(tt.line)
+			if got.Time.IsZero() {
+				t.Fatal("zero time")
+			}
+			got.Time = time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseSDKLogLine(%q)\n got %+v\nwant %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSDKLogLineTime(t *testing.T) {
+	r := ParseSDKLogLine("[2023-07-01 10:30:00.5][Debug][sdk] hello")
+	if want := time.Date(2023, 7, 1, 10, 30, 0, 5e8, time.Local); !r.Time.Equal(want) || r.Level != LogLevelDebug {
+		t.Fatalf("time %v level %v, want %v", r.Time, r.Level, want)
+	}
+	r = ParseSDKLogLine("[2023-07-01T10:30:00Z][Info] utc")
+	if want := time.Date(2023, 7, 1, 10, 30, 0, 0, time.UTC); !r.Time.Equal(want) {
+		t.Fatalf("time %v, want %v", r.Time, want)
+	}
+	// the time without date is of the day of receiving
+	r = ParseSDKLogLine("[10:30:00.250][W] short")
+	if r.Time.Year() < 2023 || r.Time.Hour() != 10 || r.Time.Minute() != 30 || r.Time.Nanosecond() != 25e7 {
+		t.Fatalf("time %v", r.Time)
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"\x1b[31mred\x1b[0m", "red"},
+		{"\x1b[1;32mbold green\x1b[m!", "bold green!"},
+		{"\x1bMreverse index", "reverse index"},
+		{"end\x1b", "end"},
+		{"cut\x1b[12", "cut"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := stripANSI(tt.in); got != tt.want {
+			t.Errorf("stripANSI(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSDKLogWriter(t *testing.T) {
+	long := strings.Repeat("x", maxSDKLogLine)
+	tests := []struct {
+		name   string
+		writes []string
+		flush  bool
+		want   []string
+	}{
+		{"line", []string{"one line\n"}, false, []string{"one line"}},
+		{"lines of a write", []string{"[Info] a\n[Warn] b\n"}, false, []string{"[Info] a", "[Warn] b"}},
+		{"split line", []string{"[Info] hel", "lo", " world\n"}, false, []string{"[Info] hello world"}},
+		{"line split after the newline", []string{"[Info] a\n[Warn] b", "c\n"}, false, []string{"[Info] a", "[Warn] bc"}},
+		{"new prefix ends the partial line", []string{"[Info] partial", "[Warn] next\n"}, false,
+			[]string{"[Info] partial", "[Warn] next"}},
+		{"coloured prefix ends the partial line", []string{"[Info] partial", "\x1b[31m[Error] x\n"}, false,
+			[]string{"[Info] partial", "\x1b[31m[Error] x"}},
+		{"crlf", []string{"line\r\n"}, false, []string{"line"}},
+		{"blank lines", []string{"\n\n  \n\r\n"}, false, nil},
+		{"partial line is kept", []string{"[Info] partial"}, false, nil},
+		{"flush", []string{"[Info] partial"}, true, []string{"[Info] partial"}},
+		{"long partial line", []string{long[:100], long[100:], "tail\n"}, false, []string{long, "tail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			w := newSDKLogWriter(&Logger{Outputer: func(s string) { got = append(got, s) }})
+			for _, s := range tt.writes {
+				w.write([]byte(s))
+			}
+			if tt.flush {
+				w.flush()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lines %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordHandler keeps the records of the enabled level
+type recordHandler struct {
+	level   slog.Level
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	h.records = append(h.records, r)
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordHandler) WithGroup(string) slog.Handler { return h }
+
+func TestSDKLogWriterHandler(t *testing.T) {
+	h := &recordHandler{level: slog.LevelInfo}
+	w := newSDKLogWriter(&Logger{Handler: h})
+	w.write([]byte("[1.5][core]-[Error]-[Init:12) init failed\n  caused by timeout\n[Debug] hidden\n"))
+	w.writeLine("[Warn]-[edge] retry")
+
+	type record struct {
+		level slog.Level
+		msg   string
+		attrs map[string]string
+	}
+	var got []record
+	for _, r := range h.records {
+		rec := record{level: r.Level, msg: r.Message, attrs: map[string]string{}}
+		r.Attrs(func(a slog.Attr) bool {
+			rec.attrs[a.Key] = a.Value.String()
+			return true
+		})
+		got = append(got, rec)
+	}
+	// the continuation gets the level, module and source of its first line
+	want := []record{
+		{slog.LevelError, "init failed", map[string]string{"module": "core", "sdk_source": "Init:12", "sdk_uptime": "1.5s"}},
+		{slog.LevelError, "caused by timeout", map[string]string{"module": "core", "sdk_source": "Init:12"}},
+		{slog.LevelWarn, "retry", map[string]string{"module": "edge"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("records %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSDKLogWriterNil(t *testing.T) {
+	if w := newSDKLogWriter(nil); w != nil {
+		t.Fatal("writer of nil logger")
+	}
+	if w := newSDKLogWriter(&Logger{Level: LogLevelDebug}); w != nil {
+		t.Fatal("writer of logger without outputs")
+	}
+	var w *sdkLogWriter
+	w.write([]byte("line\n"))
+	w.writeLine("line")
+	w.flush()
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// the names of the secrets read from a SecretProvider
+const (
+	SecretAppKey        = "app_key"
+	SecretAppLicense    = "app_license"
+	SecretPrivateKey    = "private_key"
+	SecretPublicKey     = "public_key"
+	SecretKeyPassphrase = "key_passphrase"
+)
+
+var (
+	ErrSecretNotFound = errors.New("djiedge: secret not found")
+	// ErrSecretsWiped is returned by InitSDK if the secrets of the parameters were zeroed by Wipe
+	ErrSecretsWiped = errors.New("djiedge: the secrets were zeroed by Wipe, load them again")
+)
+
+// SecretProvider provides the secrets of the app credentials and the keys, such as SecretAppKey
+type SecretProvider interface {
+	// Secret returns the secret of the name, or ErrSecretNotFound if it doesn't exist.
+	// the caller owns the returned buffer, and zeroes it after use.
+	Secret(name string) ([]byte, error)
+}
+
+// EnvSecretProvider reads the secrets from the environment variables named by Prefix and the upper case name,
+// such as DJIEDGE_APP_KEY. the environment of the process can't be zeroed.
+type EnvSecretProvider struct {
+	// Prefix the prefix of the variables, DJIEDGE_ if empty
+	Prefix string
+}
+
+func (p *EnvSecretProvider) Secret(name string) ([]byte, error) {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = "DJIEDGE_"
+	}
+	v, ok := os.LookupEnv(prefix + strings.ToUpper(name))
+	if !ok || v == "" {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return []byte(v), nil
+}
+
+// FileSecretProvider reads the secret from the file of the name in Dir, such as /run/secrets/app_key.
+// on unix the file must be owned by the user of the process or root, and not accessible by the group and others,
+// the trailing newline of a text file is removed.
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p *FileSecretProvider) Secret(name string) ([]byte, error) {
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("djiedge: invalid secret name %q", name)
+	}
+	b, err := readSecretFile(filepath.Join(p.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if utf8.Valid(b) {
+		n := len(bytes.TrimRight(b, "\r\n"))
+		zeroBytes(b[n:])
+		b = b[:n]
+	}
+	return b, nil
+}
+
+// readSecretFile reads the file after checking its permissions
+func readSecretFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSecretFile(path, fi); err != nil {
+		return nil, err
+	}
+	b := make([]byte, fi.Size())
+	if _, err = f.ReadAt(b, 0); err != nil {
+		zeroBytes(b)
+		return nil, err
+	}
+	return b, nil
+}
+
+// LoadSecrets loads the app key and the license from the provider, they are used by InitSDK instead of AppKey
+// and License. they are kept in buffers instead of the strings, so Wipe can zero them when they are no longer used.
+func (a *AuthInfo) LoadSecrets(p SecretProvider) error {
+	appKey, err := p.Secret(SecretAppKey)
+	if err != nil {
+		return err
+	}
+	license, err := p.Secret(SecretAppLicense)
+	if err != nil {
+		zeroBytes(appKey)
+		return err
+	}
+	a.secrets.wipe()
+	a.secrets = &secretBuffers{appKey: appKey, license: license}
+	return nil
+}
+
+// Wipe zeroes the secrets loaded by LoadSecrets, a later InitSDK returns ErrSecretsWiped.
+// AppKey and License are strings, they can't be zeroed.
+func (a *AuthInfo) Wipe() {
+	a.secrets.wipe()
+}
+
+// appKey returns the app key loaded by LoadSecrets, or AppKey
+func (a *AuthInfo) appKey() []byte {
+	if a.secrets != nil {
+		return a.secrets.appKey
+	}
+	return []byte(a.AppKey)
+}
+
+// license returns the license loaded by LoadSecrets, or License
+func (a *AuthInfo) license() []byte {
+	if a.secrets != nil {
+		return a.secrets.license
+	}
+	return []byte(a.License)
+}
+
+// LoadRSA2048KeyFromSecrets loads the key of SecretPrivateKey from the provider, see ParseRSA2048Key,
+// it is checked to match SecretPublicKey and decrypted by SecretKeyPassphrase if they exist.
+// the private key is kept in a buffer instead of PrivateKey, so RSA2048Key.Wipe can zero it.
+func LoadRSA2048KeyFromSecrets(p SecretProvider) (*RSA2048Key, error) {
+	private, err := p.Secret(SecretPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(private)
+	passphrase, err := optionalSecret(p, SecretKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(passphrase)
+	public, err := optionalSecret(p, SecretPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseRSAPrivateKey(private, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroRSAKey(key)
+	if public != nil {
+		pub, err := parseRSAPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		if !key.PublicKey.Equal(pub) {
+			return nil, ErrKeyMismatch
+		}
+	}
+	return newRSA2048Key(key, &secretBuffers{})
+}
+
+func optionalSecret(p SecretProvider, name string) ([]byte, error) {
+	b, err := p.Secret(name)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// secretBuffers the secrets of AuthInfo and RSA2048Key loaded from a SecretProvider,
+// they are owned by the package, so they can be zeroed, unlike the memory of the strings.
+type secretBuffers struct {
+	appKey     []byte
+	license    []byte
+	privateKey []byte
+	wiped      bool
+}
+
+func (s *secretBuffers) wipe() {
+	if s == nil {
+		return
+	}
+	for _, b := range [][]byte{s.appKey, s.license, s.privateKey} {
+		zeroBytes(b)
+	}
+	s.appKey, s.license, s.privateKey = nil, nil, nil
+	s.wiped = true
+}
+
+func (s *secretBuffers) isWiped() bool {
+	return s != nil && s.wiped
+}
+
+// zeroBytes best-effort zeroing of a secret buffer
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// zeroRSAKey best-effort zeroing of the private values of the key
+func zeroRSAKey(key *rsa.PrivateKey) {
+	ints := []*big.Int{key.D, key.Precomputed.Dp, key.Precomputed.Dq, key.Precomputed.Qinv}
+	ints = append(ints, key.Primes...)
+	for _, n := range ints {
+		if n == nil {
+			continue
+		}
+		words := n.Bits()
+		for i := range words {
+			words[i] = 0
+		}
+		n.SetInt64(0)
+	}
+}
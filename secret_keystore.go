@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// the keystore file: magic "DJKS", version(1 byte), pbkdf2 iterations(uint32), salt(16 bytes), nonce(12 bytes),
+// then the AES-256-GCM sealed secrets with the header as additional data.
+// the secrets are a sequence of uvarint length prefixed name and value.
+const (
+	keystoreMagic      = "DJKS"
+	keystoreVersion    = 1
+	keystoreSaltSize   = 16
+	keystoreIterations = 200000
+	keystoreHeaderSize = len(keystoreMagic) + 1 + 4 + keystoreSaltSize + 12
+)
+
+var ErrKeystorePassphrase = errors.New("djiedge: wrong passphrase or corrupted keystore")
+
+// Keystore is a SecretProvider of a local file encrypted by AES-GCM with a key derived from a passphrase.
+// the secrets are changed in memory by Set and Delete, and written by Save.
+type Keystore struct {
+	path string
+
+	mu      sync.Mutex
+	key     []byte
+	salt    []byte
+	iter    uint32
+	secrets map[string][]byte
+}
+
+// CreateKeystore creates an empty keystore, it is written to the file by Save
+func CreateKeystore(path string, passphrase []byte) (*Keystore, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("djiedge: keystore passphrase is empty")
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("djiedge: keystore %s already exists", path)
+	}
+	salt := make([]byte, keystoreSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &Keystore{
+		path:    path,
+		key:     pbkdf2(sha256.New, passphrase, salt, keystoreIterations, 32),
+		salt:    salt,
+		iter:    keystoreIterations,
+		secrets: map[string][]byte{},
+	}, nil
+}
+
+// OpenKeystore decrypts the keystore file, the permissions of the file are checked like FileSecretProvider
+func OpenKeystore(path string, passphrase []byte) (*Keystore, error) {
+	b, err := readSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < keystoreHeaderSize || string(b[:len(keystoreMagic)]) != keystoreMagic {
+		return nil, fmt.Errorf("djiedge: %s is not a keystore", path)
+	}
+	if v := b[len(keystoreMagic)]; v != keystoreVersion {
+		return nil, fmt.Errorf("djiedge: unsupported keystore version %d", v)
+	}
+	header := b[:keystoreHeaderSize]
+	iter := binary.BigEndian.Uint32(header[len(keystoreMagic)+1:])
+	if iter == 0 || iter > 1<<24 {
+		return nil, ErrKeystorePassphrase
+	}
+	salt := header[len(keystoreMagic)+5 : len(keystoreMagic)+5+keystoreSaltSize]
+	nonce := header[keystoreHeaderSize-12:]
+
+	k := &Keystore{
+		path:    path,
+		key:     pbkdf2(sha256.New, passphrase, salt, int(iter), 32),
+		salt:    append([]byte(nil), salt...),
+		iter:    iter,
+		secrets: map[string][]byte{},
+	}
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, nonce, b[keystoreHeaderSize:], header)
+	if err != nil {
+		k.Close()
+		return nil, ErrKeystorePassphrase
+	}
+	defer zeroBytes(plain)
+	for rest := plain; len(rest) > 0; {
+		var name, value []byte
+		if name, rest, err = readKeystoreField(rest); err == nil {
+			value, rest, err = readKeystoreField(rest)
+		}
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+		k.secrets[string(name)] = append([]byte(nil), value...)
+	}
+	return k, nil
+}
+
+func readKeystoreField(b []byte) (field, rest []byte, err error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)-size) {
+		return nil, nil, errors.New("djiedge: corrupted keystore")
+	}
+	return b[size : size+int(n)], b[size+int(n):], nil
+}
+
+func (k *Keystore) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Secret returns a copy of the secret
+func (k *Keystore) Secret(name string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	v, ok := k.secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return append([]byte(nil), v...), nil
+}
+
+// Set sets a copy of the secret
+func (k *Keystore) Set(name string, value []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	zeroBytes(k.secrets[name])
+	k.secrets[name] = append([]byte(nil), value...)
+}
+
+func (k *Keystore) Delete(name string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	zeroBytes(k.secrets[name])
+	delete(k.secrets, name)
+}
+
+// Names returns the sorted names of the secrets
+func (k *Keystore) Names() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	names := make([]string, 0, len(k.secrets))
+	for name := range k.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the secrets with a new nonce, and replaces the file atomically, the file is readable only by the owner
+func (k *Keystore) Save() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	header := make([]byte, 0, keystoreHeaderSize)
+	header = append(header, keystoreMagic...)
+	header = append(header, keystoreVersion)
+	header = binary.BigEndian.AppendUint32(header, k.iter)
+	header = append(header, k.salt...)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	header = append(header, nonce...)
+
+	var plain []byte
+	for name, value := range k.secrets {
+		plain = binary.AppendUvarint(plain, uint64(len(name)))
+		plain = append(plain, name...)
+		plain = binary.AppendUvarint(plain, uint64(len(value)))
+		plain = append(plain, value...)
+	}
+	defer zeroBytes(plain)
+	gcm, err := k.gcm()
+	if err != nil {
+		return err
+	}
+	data := gcm.Seal(header, nonce, plain, header)
+
+	f, err := os.CreateTemp(filepath.Dir(k.path), "."+filepath.Base(k.path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err = f.Chmod(0o600); err == nil {
+		if _, err = f.Write(data); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, k.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// Close zeroes the secrets and the key in memory, the keystore can't be used after closing
+func (k *Keystore) Close() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for name, v := range k.secrets {
+		zeroBytes(v)
+		delete(k.secrets, name)
+	}
+	zeroBytes(k.key)
+}
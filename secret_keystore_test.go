@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+var testKeystoreSecrets = map[string][]byte{
+	SecretAppKey:     []byte("0123456789abcdef"),
+	SecretAppLicense: []byte("license\x00with\nbinary\xff"),
+	"empty":          {},
+}
+
+// createTestKeystore saves a keystore with testKeystoreSecrets
+func createTestKeystore(t *testing.T, passphrase string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secrets.ks")
+	ks, err := CreateKeystore(path, []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	for name, value := range testKeystoreSecrets {
+		ks.Set(name, value)
+	}
+	if err = ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path := createTestKeystore(t, "correct horse")
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0o600 {
+			t.Errorf("keystore permissions %v, want 0600", perm)
+		}
+	}
+
+	ks, err := OpenKeystore(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := ks.Names(); !reflect.DeepEqual(names, []string{SecretAppKey, SecretAppLicense, "empty"}) {
+		t.Errorf("names %q", names)
+	}
+	for name, want := range testKeystoreSecrets {
+		got, err := ks.Secret(name)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("secret %s: %q %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err = ks.Secret("missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("missing secret: %v", err)
+	}
+
+	// the secrets are loaded as a provider
+	var auth AuthInfo
+	if err = auth.LoadSecrets(ks); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(auth.appKey(), testKeystoreSecrets[SecretAppKey]) || !bytes.Equal(auth.license(), testKeystoreSecrets[SecretAppLicense]) {
+		t.Error("the secrets loaded differ")
+	}
+
+	// the changes are saved with a new nonce
+	before, _ := os.ReadFile(path)
+	ks.Delete(SecretAppKey)
+	ks.Set(SecretAppLicense, []byte("new license"))
+	if err = ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+	ks.Close()
+	if _, err = ks.Secret(SecretAppLicense); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("the secret is readable after Close: %v", err)
+	}
+	after, _ := os.ReadFile(path)
+	if bytes.Equal(before[keystoreHeaderSize-12:keystoreHeaderSize], after[keystoreHeaderSize-12:keystoreHeaderSize]) {
+		t.Error("the nonce is reused")
+	}
+
+	ks, err = OpenKeystore(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	if names := ks.Names(); !reflect.DeepEqual(names, []string{SecretAppLicense, "empty"}) {
+		t.Errorf("names %q", names)
+	}
+	if v, _ := ks.Secret(SecretAppLicense); string(v) != "new license" {
+		t.Errorf("license %q", v)
+	}
+}
+
+func TestCreateKeystoreErrors(t *testing.T) {
+	path := createTestKeystore(t, "pass")
+	if _, err := CreateKeystore(path, []byte("pass")); err == nil {
+		t.Error("the existing keystore is overwritten")
+	}
+	if _, err := CreateKeystore(filepath.Join(t.TempDir(), "new.ks"), nil); err == nil {
+		t.Error("the keystore is created without passphrase")
+	}
+}
+
+func TestKeystoreWrongPassphrase(t *testing.T) {
+	path := createTestKeystore(t, "correct horse")
+	for _, passphrase := range []string{"", "correct hors", "correct horse ", "Correct horse"} {
+		if _, err := OpenKeystore(path, []byte(passphrase)); !errors.Is(err, ErrKeystorePassphrase) {
+			t.Errorf("passphrase %q: %v", passphrase, err)
+		}
+	}
+}
+
+func TestKeystoreTampered(t *testing.T) {
+	path := createTestKeystore(t, "pass")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(i int) func([]byte) []byte {
+		return func(b []byte) []byte {
+			if i < 0 {
+				i += len(b)
+			}
+			b[i] ^= 0x01
+			return b
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+		want   error
+	}{
+		{"iterations", flip(8), ErrKeystorePassphrase},
+		{"salt", flip(len(keystoreMagic) + 5), ErrKeystorePassphrase},
+		{"nonce", flip(keystoreHeaderSize - 1), ErrKeystorePassphrase},
+		{"ciphertext", flip(keystoreHeaderSize), ErrKeystorePassphrase},
+		{"tag", flip(-1), ErrKeystorePassphrase},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }, ErrKeystorePassphrase},
+		{"appended", func(b []byte) []byte { return append(b, 0) }, ErrKeystorePassphrase},
+		{"no ciphertext", func(b []byte) []byte { return b[:keystoreHeaderSize] }, ErrKeystorePassphrase},
+		{"huge iterations", func(b []byte) []byte { b[5] = 0xff; return b }, ErrKeystorePassphrase},
+		{"magic", flip(0), nil},
+		{"version", flip(len(keystoreMagic)), nil},
+		{"header", func(b []byte) []byte { return b[:keystoreHeaderSize-1] }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "tampered.ks")
+			if err := os.WriteFile(p, tt.modify(bytes.Clone(data)), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			ks, err := OpenKeystore(p, []byte("pass"))
+			if err == nil {
+				ks.Close()
+				t.Fatal("the tampered keystore is opened")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeystorePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the permissions are not checked on windows")
+	}
+	path := createTestKeystore(t, "pass")
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeystore(path, []byte("pass")); err == nil || errors.Is(err, ErrKeystorePassphrase) {
+		t.Errorf("the keystore readable by others is opened: %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSessionClosed the session is closed or closing
+var ErrSessionClosed = errors.New("session is closed")
+
+// SessionConfig the parameters of Open, see InitSDK
+type SessionConfig struct {
+	// Edge the backend of the session, DefaultEdge if nil
+	Edge Edge
+
+	Device         *DeviceInfo
+	Auth           *AuthInfo
+	Key            *RSA2048Key
+	Logger         *Logger
+	DeInitOnFailed bool
+
+	// Retry retries the retryable failures of InitSDK until ctx of Open is done, nil disables retrying.
+	// see InitSupervisor
+	Retry *RetryPolicy
+}
+
+// Session owns an initialized sdk and every resource created through it.
+//
+// The live-views, media file readers, opened media files and handlers of the session are tracked,
+// Session.Close tears them down in order and de-initializes the sdk,
+// so the backend can be opened again by a new session.
+type Session struct {
+	edge Edge
+
+	mu          sync.Mutex
+	closed      bool
+	liveViews   map[*sessionLiveView]struct{}
+	readers     map[*sessionMediaReader]struct{}
+	hasObserver bool
+	hasCloudMsg bool
+	closeDone   chan struct{}
+	closeErr    error
+}
+
+// Open initializes the sdk of the backend and returns the session owning it.
+//
+// InitSDK may block for seconds, if ctx is done before it returns, Open returns the error of ctx,
+// and the sdk is de-initialized in the background once the initialization completes.
+func Open(ctx context.Context, cfg *SessionConfig) (*Session, error) {
+	if cfg == nil {
+		return nil, errors.New("parameter is nil")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	edge := cfg.Edge
+	if edge == nil {
+		edge = DefaultEdge()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		if cfg.Retry == nil {
+			result <- edge.InitSDK(cfg.Device, cfg.Auth, cfg.Key, cfg.Logger, cfg.DeInitOnFailed)
+			return
+		}
+		supervisor := &InitSupervisor{
+			Lifecycle:      edge,
+			Device:         cfg.Device,
+			Auth:           cfg.Auth,
+			Key:            cfg.Key,
+			Logger:         cfg.Logger,
+			DeInitOnFailed: cfg.DeInitOnFailed,
+			Policy:         cfg.Retry,
+		}
+		result <- supervisor.Run(ctx)
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		go func() {
+			if <-result == nil {
+				_ = edge.DeInitSDK()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+
+	return &Session{
+		edge:      edge,
+		liveViews: map[*sessionLiveView]struct{}{},
+		readers:   map[*sessionMediaReader]struct{}{},
+	}, nil
+}
+
+// Edge returns the backend of the session,
+// the resources created by the backend directly are not tracked by the session.
+func (s *Session) Edge() Edge {
+	return s.edge
+}
+
+// NewLiveView returns a live-view tracked by the session
+func (s *Session) NewLiveView() (LiveViewer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+	lv := &sessionLiveView{LiveViewer: s.edge.NewLiveView(), session: s}
+	s.liveViews[lv] = struct{}{}
+	return lv, nil
+}
+
+// NewMediaFileReader returns a media file reader tracked by the session with its opened files
+func (s *Session) NewMediaFileReader() (MediaReader, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+	r := &sessionMediaReader{MediaReader: s.edge.NewMediaFileReader(), session: s, files: map[*MediaFile]struct{}{}}
+	s.readers[r] = struct{}{}
+	return r, nil
+}
+
+// RegisterMediaFilesObserver registers the observer of new media files, it is unregistered by Close
+func (s *Session) RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSessionClosed
+	}
+	if err := s.edge.RegisterMediaFilesObserver(observer); err != nil {
+		return err
+	}
+	s.hasObserver = observer != nil
+	return nil
+}
+
+// RegisterCloudCustomMsgHandler registers the handler of the cloud custom messages, it is unregistered by Close
+func (s *Session) RegisterCloudCustomMsgHandler(handler func([]byte)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSessionClosed
+	}
+	if err := s.edge.RegisterCloudCustomMsgHandler(handler); err != nil {
+		return err
+	}
+	s.hasCloudMsg = handler != nil
+	return nil
+}
+
+// SendCustomMessageToCloud send custom event message to cloud, see the package function SendCustomMessageToCloud
+func (s *Session) SendCustomMessageToCloud(data []byte) error {
+	if s.isClosed() {
+		return ErrSessionClosed
+	}
+	return s.edge.SendCustomMessageToCloud(data)
+}
+
+func (s *Session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close tears down the session in order: stop the streams, close the media files,
+// close the media file readers, destroy the live-views, unregister the handlers and de-initialize the sdk.
+//
+// if ctx is done before the teardown completes, Close returns the error of ctx and the teardown goes on,
+// the later calls of Close wait for the same teardown and return its result.
+func (s *Session) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		s.closeDone = make(chan struct{})
+		go s.teardown()
+	}
+	done := s.closeDone
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+		return s.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel closed when the teardown of Close completes, nil before Close is called
+func (s *Session) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeDone
+}
+
+func (s *Session) teardown() {
+	// no resource is added after the session is closed
+	s.mu.Lock()
+	liveViews := make([]*sessionLiveView, 0, len(s.liveViews))
+	for lv := range s.liveViews {
+		liveViews = append(liveViews, lv)
+	}
+	readers := make([]*sessionMediaReader, 0, len(s.readers))
+	for r := range s.readers {
+		readers = append(readers, r)
+	}
+	hasObserver, hasCloudMsg := s.hasObserver, s.hasCloudMsg
+	s.mu.Unlock()
+
+	var errs []error
+	for _, lv := range liveViews {
+		if lv.streaming.Load() {
+			errs = append(errs, lv.StopH264Stream())
+		}
+	}
+	for _, r := range readers {
+		errs = append(errs, r.closeFiles())
+	}
+	for _, r := range readers {
+		if r.IsOpened() {
+			errs = append(errs, r.MediaReader.Close())
+		}
+		r.Destroy()
+	}
+	for _, lv := range liveViews {
+		lv.Destroy()
+	}
+	if hasObserver {
+		errs = append(errs, s.edge.RegisterMediaFilesObserver(nil))
+	}
+	if hasCloudMsg {
+		errs = append(errs, s.edge.RegisterCloudCustomMsgHandler(nil))
+	}
+	errs = append(errs, s.edge.DeInitSDK())
+
+	s.closeErr = errors.Join(errs...)
+	close(s.closeDone)
+}
+
+// sessionLiveView tracks the stream state of a live-view of the session
+type sessionLiveView struct {
+	LiveViewer
+	session   *Session
+	streaming atomic.Bool
+	destroyed atomic.Bool
+}
+
+func (lv *sessionLiveView) StartH264Stream() error {
+	if lv.session.isClosed() {
+		return ErrSessionClosed
+	}
+	if err := lv.LiveViewer.StartH264Stream(); err != nil {
+		return err
+	}
+	lv.streaming.Store(true)
+	return nil
+}
+
+// StopH264Stream keeps the stream tracked if it fails, so the teardown stops it again
+func (lv *sessionLiveView) StopH264Stream() error {
+	if err := lv.LiveViewer.StopH264Stream(); err != nil {
+		return err
+	}
+	lv.streaming.Store(false)
+	return nil
+}
+
+func (lv *sessionLiveView) Destroy() {
+	if !lv.destroyed.CompareAndSwap(false, true) {
+		return
+	}
+	lv.streaming.Store(false)
+	lv.LiveViewer.Destroy()
+	lv.session.mu.Lock()
+	delete(lv.session.liveViews, lv)
+	lv.session.mu.Unlock()
+}
+
+// sessionMediaReader tracks the opened files of a media file reader of the session
+type sessionMediaReader struct {
+	MediaReader
+	session   *Session
+	destroyed atomic.Bool
+
+	mu    sync.Mutex
+	files map[*MediaFile]struct{}
+}
+
+func (r *sessionMediaReader) Open() error {
+	if r.session.isClosed() {
+		return ErrSessionClosed
+	}
+	return r.MediaReader.Open()
+}
+
+// Close closes the opened files before the transfer connection
+func (r *sessionMediaReader) Close() error {
+	err := r.closeFiles()
+	return errors.Join(err, r.MediaReader.Close())
+}
+
+func (r *sessionMediaReader) OpenFile(path string) (*MediaFile, error) {
+	if r.session.isClosed() {
+		return nil, ErrSessionClosed
+	}
+	mf, err := r.MediaReader.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mf.reader = &sessionFileIO{mediaFileIO: mf.reader, reader: r, file: mf}
+	r.mu.Lock()
+	r.files[mf] = struct{}{}
+	r.mu.Unlock()
+	return mf, nil
+}
+
+func (r *sessionMediaReader) closeFiles() error {
+	r.mu.Lock()
+	files := make([]*MediaFile, 0, len(r.files))
+	for f := range r.files {
+		files = append(files, f)
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, f := range files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (r *sessionMediaReader) Destroy() {
+	if !r.destroyed.CompareAndSwap(false, true) {
+		return
+	}
+	_ = r.closeFiles()
+	r.MediaReader.Destroy()
+	r.session.mu.Lock()
+	delete(r.session.readers, r)
+	r.session.mu.Unlock()
+}
+
+// sessionFileIO forgets the file when it is closed
+type sessionFileIO struct {
+	mediaFileIO
+	reader *sessionMediaReader
+	file   *MediaFile
+}
+
+func (f *sessionFileIO) closeFile(fh fileHandle) error {
+	f.reader.mu.Lock()
+	delete(f.reader.files, f.file)
+	f.reader.mu.Unlock()
+	return f.mediaFileIO.closeFile(fh)
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testSessionConfig(m Edge) *SessionConfig {
+	return &SessionConfig{Edge: m, Device: &DeviceInfo{SerialNumber: "SN0001"}, Auth: &AuthInfo{}, Key: &RSA2048Key{}}
+}
+
+// blockingInitEdge is a MockEdge whose InitSDK returns after release is closed
+type blockingInitEdge struct {
+	*MockEdge
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *blockingInitEdge) InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) error {
+	close(e.started)
+	<-e.release
+	return e.MockEdge.InitSDK(device, auth, key, logger, deInitOnFailed)
+}
+
+func mockOps(m *MockEdge) []MockOp {
+	var ops []MockOp
+	for _, c := range m.Calls() {
+		ops = append(ops, c.Op)
+	}
+	return ops
+}
+
+func TestOpen(t *testing.T) {
+	m := NewMockEdge()
+	s, err := Open(context.Background(), testSessionConfig(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Edge() != m || m.State() != StateReady {
+		t.Fatalf("edge %v in state %v", s.Edge(), m.State())
+	}
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.State() != StateUninitialized {
+		t.Fatalf("state %v after Close", m.State())
+	}
+	// the backend is opened again by a new session
+	if s, err = Open(context.Background(), testSessionConfig(m)); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close(context.Background())
+
+	if _, err := Open(context.Background(), nil); err == nil {
+		t.Error("no error for a nil config")
+	}
+	m.SetError(MockOpInitSDK, ErrAuthVerifyFailure)
+	if _, err := Open(context.Background(), testSessionConfig(m)); !errors.Is(err, ErrAuthVerifyFailure) {
+		t.Errorf("Open returned %v, want the error of InitSDK", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Open(ctx, testSessionConfig(NewMockEdge())); !errors.Is(err, context.Canceled) {
+		t.Errorf("Open of a cancelled ctx returned %v", err)
+	}
+}
+
+func TestOpenRetry(t *testing.T) {
+	m := NewMockEdge()
+	m.SetError(MockOpInitSDK, ErrRequestTimeout)
+	cfg := testSessionConfig(m)
+	cfg.Retry = &RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 5}
+	time.AfterFunc(5*time.Millisecond, func() { m.SetError(MockOpInitSDK, nil) })
+	s, err := Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close(context.Background())
+}
+
+func TestOpenCancelledDuringInit(t *testing.T) {
+	e := &blockingInitEdge{MockEdge: NewMockEdge(), started: make(chan struct{}), release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-e.started
+		cancel()
+	}()
+	if _, err := Open(ctx, testSessionConfig(e)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Open returned %v, want the error of ctx", err)
+	}
+	// the initialization completing after Open returned is de-initialized in the background
+	done := make(chan struct{})
+	unsubscribe := e.SubscribeState(func(tr StateTransition) {
+		if tr.To == StateUninitialized {
+			close(done)
+		}
+	})
+	defer unsubscribe()
+	close(e.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the sdk isn't de-initialized in the background, state %v", e.State())
+	}
+	if ops := mockOps(e.MockEdge); len(ops) != 2 || ops[0] != MockOpInitSDK || ops[1] != MockOpDeInitSDK {
+		t.Fatalf("calls %v", ops)
+	}
+}
+
+func TestSessionTeardownOrder(t *testing.T) {
+	m := NewMockEdge()
+	m.AddMediaFile(MediaFileDesc{FilePath: "/DJI/a.jpg"}, []byte("jpeg"))
+	s, err := Open(context.Background(), testSessionConfig(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := s.NewLiveView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.Init(CameraTypePayload, StreamQuality720p, StreamStatusFunc(func(*LiveStatus) {})); err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.StartH264Stream(); err != nil {
+		t.Fatal(err)
+	}
+	frames := lv.Frames(context.Background(), nil)
+	r, err := s.NewMediaFileReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.OpenFile("/DJI/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterMediaFilesObserver(func(*MediaFileDesc) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterCloudCustomMsgHandler(func([]byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	setup := len(m.Calls())
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []MockOp{MockOpStopH264Stream, MockOpCloseFile, MockOpReaderClose,
+		MockOpRegisterMediaFilesObserver, MockOpRegisterCloudHandler, MockOpDeInitSDK}
+	ops := mockOps(m)[setup:]
+	if len(ops) != len(want) {
+		t.Fatalf("teardown calls %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Fatalf("teardown calls %v, want %v", ops, want)
+		}
+	}
+	// the live-view is destroyed before the handlers are unregistered
+	if _, ok := <-frames; ok {
+		t.Fatal("the frames of the live-view aren't closed")
+	}
+	if m.LiveViews()[0].Streaming() || r.IsOpened() || m.Initialized() {
+		t.Fatal("a resource is left after the teardown")
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("Done isn't closed after Close returned")
+	}
+
+	if _, err := s.NewLiveView(); err != ErrSessionClosed {
+		t.Errorf("NewLiveView after Close returned %v", err)
+	}
+	if _, err := s.NewMediaFileReader(); err != ErrSessionClosed {
+		t.Errorf("NewMediaFileReader after Close returned %v", err)
+	}
+	if err := s.SendCustomMessageToCloud([]byte("x")); err != ErrSessionClosed {
+		t.Errorf("SendCustomMessageToCloud after Close returned %v", err)
+	}
+	if err := s.Close(context.Background()); err != nil {
+		t.Errorf("the second Close returned %v", err)
+	}
+}
+
+func TestSessionStopStreamFailed(t *testing.T) {
+	m := NewMockEdge()
+	s, err := Open(context.Background(), testSessionConfig(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, _ := s.NewLiveView()
+	_ = lv.Init(CameraTypeFpv, StreamQuality720p, StreamStatusFunc(func(*LiveStatus) {}))
+	if err := lv.StartH264Stream(); err != nil {
+		t.Fatal(err)
+	}
+	m.SetError(MockOpStopH264Stream, ErrRequestTimeout)
+	if err := lv.StopH264Stream(); !errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("StopH264Stream returned %v", err)
+	}
+	m.SetError(MockOpStopH264Stream, nil)
+
+	// the stream still running is stopped by the teardown
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, op := range mockOps(m) {
+		if op == MockOpStopH264Stream {
+			n++
+		}
+	}
+	if n != 2 || m.LiveViews()[0].Streaming() {
+		t.Fatalf("StopH264Stream is called %d times, streaming %v", n, m.LiveViews()[0].Streaming())
+	}
+}
+
+func TestSessionCloseContext(t *testing.T) {
+	m := NewMockEdge()
+	s, err := Open(context.Background(), testSessionConfig(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetError(MockOpDeInitSDK, ErrSystemError)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the teardown goes on after Close of a cancelled ctx returns, the later calls get its result
+	if err := s.Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Close returned %v, want the error of ctx", err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the teardown doesn't complete")
+	}
+	if err := s.Close(context.Background()); !errors.Is(err, ErrSystemError) {
+		t.Fatalf("Close returned %v, want the error of DeInitSDK", err)
+	}
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const fakeEdgeStreamFileName = "edge_stream.h264"
+
+// Simulator is an Edge backend simulating the dock without DJI Edge-SDK, it is available on every platform.
+//
+// The live-view reads local h264-stream file[edge_stream.h264 by default] and pushes it to StreamReceiver,
+// the media files of the simulated dock are the files of SimulatorConfig.MediaDir,
+// and custom messages of the cloud are exchanged with the clients of SimulatorConfig.CloudEndpoint.
+// The behaviour is configured by SimulatorConfig.
+type Simulator struct {
+	cfg       *SimulatorConfig
+	faults    *faultInjector
+	lifecycle lifecycle
+	// configErr the error returned by InitSDK, the invalid config of the environment of the default simulator
+	configErr error
+
+	mu           sync.RWMutex
+	logLevel     LogLevel
+	logOut       *sdkLogWriter
+	mfObserver   func(desc *MediaFileDesc)
+	cloudHandler func([]byte)
+
+	uploadCloud atomic.Bool
+	autoDelete  atomic.Bool
+
+	media        *simMediaStore // nil if the dock has no media files
+	mediaLimiter *rateLimiter
+	mediaConn    atomic.Int64 // the generation of the media transfer connections
+
+	cloud *simCloudChannel // nil if the cloud endpoint is not listened
+
+	replayStop chan bool // nil if the capture is not replayed
+	replayDone chan bool
+}
+
+// NewSimulator return a new simulated backend with the DefaultSimulatorConfig,
+// every Simulator has its own sdk state.
+func NewSimulator() *Simulator {
+	return newSimulator(DefaultSimulatorConfig())
+}
+
+// NewSimulatorWithConfig return a new simulated backend with the config,
+// the config should not be modified after the call.
+func NewSimulatorWithConfig(cfg *SimulatorConfig) (*Simulator, error) {
+	if cfg == nil {
+		return nil, errors.New("simulator: config is nil")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newSimulator(cfg), nil
+}
+
+func newSimulator(cfg *SimulatorConfig) *Simulator {
+	s := &Simulator{
+		cfg:          cfg,
+		faults:       newFaultInjector(cfg.Faults, cfg.StreamFaults, cfg.FaultSeed),
+		mediaLimiter: &rateLimiter{rate: cfg.MediaBandwidth},
+	}
+	if cfg.MediaDir != "" {
+		s.media = newSimMediaStore(s, cfg.MediaDir)
+	}
+	return s
+}
+
+// Config returns the config of the simulator
+func (s *Simulator) Config() *SimulatorConfig {
+	return s.cfg
+}
+
+// log simulates the sdk log output, the message is only delivered when the level is enabled by the logger
+func (s *Simulator) log(level LogLevel, format string, args ...any) {
+	s.mu.RLock()
+	out, enabled := s.logOut, s.logLevel
+	s.mu.RUnlock()
+	if out == nil || level > enabled {
+		return
+	}
+	tags := [...]string{"Error", "Warn", "Info", "Debug"}
+	out.writeLine(fmt.Sprintf("[%s]-[simulation] ", tags[level]) + fmt.Sprintf(format, args...))
+}
+
+// Initialized returns whether the sdk instance has been initialized
+func (s *Simulator) Initialized() bool {
+	return s.lifecycle.State() == StateReady
+}
+
+// State returns the lifecycle state of the simulated sdk
+func (s *Simulator) State() LifecycleState {
+	return s.lifecycle.State()
+}
+
+// SubscribeState calls fn on every transition of the lifecycle state until unsubscribe is called
+func (s *Simulator) SubscribeState(fn func(StateTransition)) (unsubscribe func()) {
+	return s.lifecycle.SubscribeState(fn)
+}
+
+// InitSDK simulate the initialization of edge-sdk, it takes SimulatorConfig.InitDelay like a real device.
+//
+// if SimulatorConfig.ReplayFile is set, the sdk log, media files and cloud messages of the capture
+// are replayed at their offsets from the call.
+func (s *Simulator) InitSDK(device *DeviceInfo, auth *AuthInfo, key *RSA2048Key, logger *Logger, deInitOnFailed bool) (err error) {
+	if err = s.lifecycle.beginInit(); err != nil {
+		return err
+	}
+	begin := time.Now()
+	called := false
+	defer func() {
+		// like the native backend, the simulated sdk is released by deInitOnFailed
+		s.lifecycle.finishInit(err, !called || deInitOnFailed)
+	}()
+
+	if s.configErr != nil {
+		return s.configErr
+	}
+	if err = validateInitParams(device, auth, key, logger); err != nil {
+		return err
+	}
+	if logger != nil {
+		s.mu.Lock()
+		s.logLevel = logger.Level
+		s.logOut = newSDKLogWriter(logger)
+		s.mu.Unlock()
+	}
+
+	called = true
+	s.log(LogLevelInfo, "init sdk,device sn:%s", device.SerialNumber)
+	time.Sleep(s.cfg.InitDelay)
+	if err = s.injectFault(FaultInitSDK); err != nil {
+		return err
+	}
+	if s.cfg.CloudEndpoint != "" {
+		cloud, err := listenCloudEndpoint(s, s.cfg.CloudEndpoint)
+		if err != nil {
+			s.log(LogLevelError, "listen cloud endpoint fail:%v", err)
+			return wrapSDKError("InitSDK", ErrConnectFailure)
+		}
+		s.mu.Lock()
+		s.cloud = cloud
+		s.mu.Unlock()
+		s.log(LogLevelInfo, "cloud endpoint listened on %s", cloud.Addr())
+	}
+	if s.cfg.ReplayFile != "" {
+		r, f, err := openCaptureFile(s.cfg.ReplayFile)
+		if err != nil {
+			s.log(LogLevelError, "open replay file fail:%v", err)
+			s.closeCloud()
+			return err
+		}
+		s.replayStop, s.replayDone = make(chan bool), make(chan bool)
+		go s.replaySession(r, f, begin, s.replayStop, s.replayDone)
+	}
+	if s.media != nil {
+		s.media.start()
+	}
+	s.log(LogLevelInfo, "sdk initialized")
+	return nil
+}
+
+// DeInitSDK will de-initialize SDK environment
+func (s *Simulator) DeInitSDK() error {
+	if _, err := s.lifecycle.beginDeInit(); err != nil {
+		return err
+	}
+	if s.media != nil {
+		s.media.stop()
+	}
+	if s.replayStop != nil {
+		close(s.replayStop)
+		<-s.replayDone
+		s.replayStop, s.replayDone = nil, nil
+	}
+	s.closeCloud()
+	s.log(LogLevelInfo, "sdk de-initialized")
+	s.lifecycle.finishDeInit(nil)
+	return nil
+}
+
+func (s *Simulator) closeCloud() {
+	s.mu.Lock()
+	cloud := s.cloud
+	s.cloud = nil
+	s.mu.Unlock()
+	if cloud != nil {
+		cloud.close()
+	}
+}
+
+// NewLiveView return a simulated live-view
+func (s *Simulator) NewLiveView() LiveViewer {
+	return newSimLiveView(s)
+}
+
+// RegisterMediaFilesObserver
+// register media file notification processing callback.
+func (s *Simulator) RegisterMediaFilesObserver(observer func(desc *MediaFileDesc)) error {
+	s.mu.Lock()
+	s.mfObserver = observer
+	s.mu.Unlock()
+	return nil
+}
+
+// notifyMediaFile calls the media files observer with a new file
+func (s *Simulator) notifyMediaFile(desc *MediaFileDesc) {
+	s.mu.RLock()
+	observer := s.mfObserver
+	s.mu.RUnlock()
+	s.log(LogLevelInfo, "new media file:%s", desc.FilePath)
+	if observer != nil {
+		observer(desc)
+	}
+}
+
+// DisconnectMedia drops the media transfer connections of all the readers like a network failure,
+// the reads fail with ErrConnectFailure until the reader is opened again.
+func (s *Simulator) DisconnectMedia() {
+	s.mediaConn.Add(1)
+	s.log(LogLevelWarn, "media file transfer disconnected")
+}
+
+// SetDroneNestUploadCloud
+// Set media files for cloud upload.
+func (s *Simulator) SetDroneNestUploadCloud(enable bool) error {
+	if !s.Initialized() {
+		return ErrSDKNotInit
+	}
+	s.uploadCloud.Store(enable)
+	s.log(LogLevelInfo, "set drone nest upload cloud:%v", enable)
+	return nil
+}
+
+// SetDroneNestAutoDelete
+// set whether to delete local media files at the dock after uploading is complete.
+func (s *Simulator) SetDroneNestAutoDelete(enable bool) error {
+	if !s.Initialized() {
+		return ErrSDKNotInit
+	}
+	s.autoDelete.Store(enable)
+	s.log(LogLevelInfo, "set drone nest auto delete:%v", enable)
+	return nil
+}
+
+// NewMediaFileReader return a simulated media file reader
+func (s *Simulator) NewMediaFileReader() MediaReader {
+	return newSimMediaFileReader(s)
+}
+
+// SendCustomMessageToCloud simulate sending custom event message to cloud,
+// allows for sending data up to 256 bytes, the message is written to the sdk log and the clients of the cloud endpoint.
+func (s *Simulator) SendCustomMessageToCloud(data []byte) error {
+	if !s.Initialized() {
+		return ErrSDKNotInit
+	}
+	if len(data) > cloudMessageLimit {
+		return errors.New("data size exceeds 256 bytes")
+	}
+	if err := s.injectFault(FaultSendCustomMessage); err != nil {
+		return err
+	}
+	s.log(LogLevelDebug, "send custom message to cloud:%q", data)
+	if cloud := s.cloudChannel(); cloud != nil {
+		return cloud.send(cloud.up, data)
+	}
+	return nil
+}
+
+// RegisterCloudCustomMsgHandler register a callback function via this interface to manage incoming data from the cloud.
+func (s *Simulator) RegisterCloudCustomMsgHandler(handler func([]byte)) error {
+	s.mu.Lock()
+	s.cloudHandler = handler
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Simulator) cloudChannel() *simCloudChannel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cloud
+}
+
+// DeliverCloudMessage simulates a custom message from the cloud like the clients of the cloud endpoint,
+// the message is passed to the handler of RegisterCloudCustomMsgHandler.
+func (s *Simulator) DeliverCloudMessage(data []byte) error {
+	if !s.Initialized() {
+		return ErrSDKNotInit
+	}
+	if cloud := s.cloudChannel(); cloud != nil {
+		return cloud.send(cloud.down, data)
+	}
+	if len(data) > cloudMessageLimit {
+		return errors.New("data size exceeds 256 bytes")
+	}
+	s.mu.RLock()
+	handler := s.cloudHandler
+	s.mu.RUnlock()
+	if handler != nil {
+		handler(append([]byte(nil), data...))
+	}
+	return nil
+}
+
+// CloudEndpointAddr returns the listened address of the cloud endpoint, empty if it is not listened
+func (s *Simulator) CloudEndpointAddr() string {
+	if cloud := s.cloudChannel(); cloud != nil {
+		return cloud.Addr().String()
+	}
+	return ""
+}
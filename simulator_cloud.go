@@ -0,0 +1,444 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// the types of CloudMessage
+const (
+	CloudMessageUp    = "up"    // a message sent by the edge to the cloud
+	CloudMessageDown  = "down"  // a message sent by the cloud to the edge
+	CloudMessageError = "error" // the injected message is rejected
+)
+
+const (
+	// cloudMessageLimit the maximum size of a custom message
+	cloudMessageLimit = 256
+	// cloudJSONLimit the maximum size of a CloudMessage read from a client,
+	// the json of a message of cloudMessageLimit bytes escaped as text, which is longer than base64 data
+	cloudJSONLimit = 4096
+)
+
+// CloudMessage is a line of newline delimited json on the simulated cloud endpoint,
+// or a text frame of the websocket endpoint.
+//
+// The clients write "down" messages to inject them to the cloud message handler,
+// and receive an "up" message for every message sent by SendCustomMessageToCloud.
+// The data of a "down" message can be given by Text instead of Data.
+type CloudMessage struct {
+	Type  string    `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+	Text  string    `json:"text,omitempty"`
+	Time  time.Time `json:"time,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type cloudPending struct {
+	due  time.Time
+	data []byte
+}
+
+// simCloudChannel is the simulated cloud connection of the dock,
+// the messages of both directions are delayed by SimulatorConfig.CloudLatency and lost by SimulatorConfig.CloudLoss.
+type simCloudChannel struct {
+	sim *Simulator
+
+	ln      net.Listener
+	server  *http.Server // the websocket server, nil for the stream endpoints
+	mu      sync.Mutex
+	clients map[*simCloudClient]struct{}
+
+	up       chan cloudPending
+	down     chan cloudPending
+	closeSig chan struct{}
+	wg       sync.WaitGroup
+}
+
+// listenCloudEndpoint starts the endpoint of the address,
+// such as "tcp://127.0.0.1:7788", "unix:///tmp/edge_cloud.sock" and "ws://127.0.0.1:7789/cloud".
+func listenCloudEndpoint(sim *Simulator, endpoint string) (*simCloudChannel, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("simulator: invalid cloud endpoint %q: %w", endpoint, err)
+	}
+	c := &simCloudChannel{
+		sim:      sim,
+		clients:  map[*simCloudClient]struct{}{},
+		up:       make(chan cloudPending, 64),
+		down:     make(chan cloudPending, 64),
+		closeSig: make(chan struct{}),
+	}
+	switch u.Scheme {
+	case "tcp":
+		c.ln, err = net.Listen("tcp", u.Host)
+	case "unix":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		_ = os.Remove(path)
+		c.ln, err = net.Listen("unix", path)
+	case "ws":
+		if c.ln, err = net.Listen("tcp", u.Host); err == nil {
+			mux := http.NewServeMux()
+			p := u.Path
+			if p == "" {
+				p = "/"
+			}
+			mux.HandleFunc(p, c.serveWebSocket)
+			c.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		}
+	default:
+		return nil, fmt.Errorf("simulator: unsupported cloud endpoint %q", endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.wg.Add(3)
+	go func() {
+		defer c.wg.Done()
+		if c.server != nil {
+			_ = c.server.Serve(c.ln)
+		} else {
+			c.accept()
+		}
+	}()
+	go c.deliver(c.up, c.broadcast)
+	go c.deliver(c.down, c.handle)
+	return c, nil
+}
+
+// Addr returns the address of the listener
+func (c *simCloudChannel) Addr() net.Addr {
+	return c.ln.Addr()
+}
+
+func (c *simCloudChannel) close() {
+	close(c.closeSig)
+	if c.server != nil {
+		_ = c.server.Close()
+	} else {
+		_ = c.ln.Close()
+	}
+	c.mu.Lock()
+	for client := range c.clients {
+		_ = client.conn.Close()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+func (c *simCloudChannel) accept() {
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return
+		}
+		c.serve(&simCloudClient{conn: conn})
+	}
+}
+
+// serve reads the messages of the client until it is closed
+func (c *simCloudChannel) serve(client *simCloudClient) {
+	c.mu.Lock()
+	select {
+	case <-c.closeSig:
+		c.mu.Unlock()
+		_ = client.conn.Close()
+		return
+	default:
+	}
+	c.clients[client] = struct{}{}
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.clients, client)
+			c.mu.Unlock()
+			_ = client.conn.Close()
+		}()
+		client.readLoop(func(b []byte) {
+			var msg CloudMessage
+			if err := json.Unmarshal(b, &msg); err != nil {
+				client.write(&CloudMessage{Type: CloudMessageError, Error: err.Error(), Time: time.Now()})
+				return
+			}
+			if err := c.inject(&msg); err != nil {
+				client.write(&CloudMessage{Type: CloudMessageError, Error: err.Error(), Time: time.Now()})
+			}
+		})
+	}()
+}
+
+// inject queues a message from the cloud
+func (c *simCloudChannel) inject(msg *CloudMessage) error {
+	if msg.Type != CloudMessageDown {
+		return fmt.Errorf("invalid message type %q", msg.Type)
+	}
+	data := msg.Data
+	if data == nil {
+		data = []byte(msg.Text)
+	}
+	return c.send(c.down, data)
+}
+
+// send queues a message to the direction
+func (c *simCloudChannel) send(queue chan cloudPending, data []byte) error {
+	if len(data) > cloudMessageLimit {
+		return errors.New("data size exceeds 256 bytes")
+	}
+	p := cloudPending{due: time.Now().Add(c.sim.cfg.CloudLatency), data: append([]byte(nil), data...)}
+	select {
+	case queue <- p:
+		return nil
+	case <-c.closeSig:
+		return errors.New("cloud channel is closed")
+	}
+}
+
+// deliver passes the messages of the queue in order at the due time
+func (c *simCloudChannel) deliver(queue chan cloudPending, fn func([]byte)) {
+	defer c.wg.Done()
+	for {
+		var p cloudPending
+		select {
+		case p = <-queue:
+		case <-c.closeSig:
+			return
+		}
+		select {
+		case <-time.After(time.Until(p.due)):
+		case <-c.closeSig:
+			return
+		}
+		if c.sim.faults.chance(c.sim.cfg.CloudLoss) {
+			c.sim.log(LogLevelDebug, "cloud custom message lost:%q", p.data)
+			continue
+		}
+		fn(p.data)
+	}
+}
+
+// broadcast writes an outgoing message to all clients
+func (c *simCloudChannel) broadcast(data []byte) {
+	msg := &CloudMessage{Type: CloudMessageUp, Data: data, Time: time.Now()}
+	if utf8.Valid(data) {
+		msg.Text = string(data)
+	}
+	c.mu.Lock()
+	clients := make([]*simCloudClient, 0, len(c.clients))
+	for client := range c.clients {
+		clients = append(clients, client)
+	}
+	c.mu.Unlock()
+	for _, client := range clients {
+		client.write(msg)
+	}
+}
+
+// handle passes an incoming message to the cloud message handler
+func (c *simCloudChannel) handle(data []byte) {
+	c.sim.mu.RLock()
+	handler := c.sim.cloudHandler
+	c.sim.mu.RUnlock()
+	if handler == nil {
+		c.sim.log(LogLevelWarn, "no cloud custom message handler, message dropped:%q", data)
+		return
+	}
+	handler(data)
+}
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// serveWebSocket upgrades the http connection to a websocket client
+func (c *simCloudChannel) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		_ = conn.Close()
+		return
+	}
+	c.serve(&simCloudClient{conn: conn, reader: rw.Reader, ws: true})
+}
+
+// simCloudClient is a connection of the endpoint
+type simCloudClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	ws     bool
+
+	wmu sync.Mutex
+}
+
+func (c *simCloudClient) readLoop(fn func([]byte)) {
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.conn)
+	}
+	if !c.ws {
+		scanner := bufio.NewScanner(c.reader)
+		scanner.Buffer(make([]byte, 0, 512), cloudJSONLimit)
+		for scanner.Scan() {
+			if line := scanner.Bytes(); len(strings.TrimSpace(string(line))) > 0 {
+				fn(line)
+			}
+		}
+		if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+			c.write(&CloudMessage{Type: CloudMessageError, Error: "message too large", Time: time.Now()})
+		}
+		return
+	}
+	var message []byte
+	for {
+		fin, opcode, payload, err := readWebSocketFrame(c.reader)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case 0x0, 0x1, 0x2:
+			// the frames of a message are reassembled up to the limit, a larger message closes the connection
+			if len(message)+len(payload) > cloudJSONLimit {
+				c.write(&CloudMessage{Type: CloudMessageError, Error: "message too large", Time: time.Now()})
+				c.writeFrame(0x8, binary.BigEndian.AppendUint16(nil, 1009))
+				return
+			}
+			message = append(message, payload...)
+			if fin {
+				fn(message)
+				message = nil
+			}
+		case 0x8:
+			c.writeFrame(0x8, payload)
+			return
+		case 0x9:
+			c.writeFrame(0xa, payload)
+		}
+	}
+}
+
+func (c *simCloudClient) write(msg *CloudMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if c.ws {
+		c.writeFrame(0x1, b)
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, _ = c.conn.Write(append(b, '\n'))
+}
+
+// writeFrame writes an unmasked websocket frame
+func (c *simCloudClient) writeFrame(opcode byte, payload []byte) {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, _ = c.conn.Write(append(header, payload...))
+}
+
+// readWebSocketFrame reads a frame of the client, the payload is unmasked
+func readWebSocketFrame(r io.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > cloudJSONLimit {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+	var mask [4]byte
+	masked := head[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
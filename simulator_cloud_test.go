@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listenTestCloud(t *testing.T, endpoint string) (*simCloudChannel, chan []byte) {
+	t.Helper()
+	sim := NewSimulator()
+	received := make(chan []byte, 4)
+	_ = sim.RegisterCloudCustomMsgHandler(func(b []byte) { received <- b })
+	c, err := listenCloudEndpoint(sim, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+	return c, received
+}
+
+// dialTestWebSocket connects to the websocket endpoint, the frames of the server are read by the reader returned
+func dialTestWebSocket(t *testing.T, addr net.Addr) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET /cloud HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %s", resp.Status)
+	}
+	return conn, r
+}
+
+// writeTestFrame writes a masked frame of the client
+func writeTestFrame(t *testing.T, w io.Writer, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	head := []byte{opcode}
+	if fin {
+		head[0] |= 0x80
+	}
+	if n := len(payload); n < 126 {
+		head = append(head, 0x80|byte(n))
+	} else {
+		head = binary.BigEndian.AppendUint16(append(head, 0x80|126), uint16(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := w.Write(append(append(head, mask...), masked...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloudWebSocketFragments(t *testing.T) {
+	c, received := listenTestCloud(t, "ws://127.0.0.1:0/cloud")
+	conn, _ := dialTestWebSocket(t, c.Addr())
+
+	msg := `{"type":"down","text":"` + strings.Repeat("x", cloudMessageLimit) + `"}`
+	writeTestFrame(t, conn, false, 0x1, []byte(msg[:100]))
+	writeTestFrame(t, conn, false, 0x0, []byte(msg[100:200]))
+	writeTestFrame(t, conn, true, 0x0, []byte(msg[200:]))
+	select {
+	case b := <-received:
+		if string(b) != strings.Repeat("x", cloudMessageLimit) {
+			t.Fatalf("received %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reassembled message isn't received")
+	}
+}
+
+func TestCloudWebSocketMessageTooLarge(t *testing.T) {
+	c, received := listenTestCloud(t, "ws://127.0.0.1:0/cloud")
+	conn, r := dialTestWebSocket(t, c.Addr())
+
+	// the continuation frames are under the frame limit, but the message isn't
+	chunk := []byte(strings.Repeat("x", 1000))
+	writeTestFrame(t, conn, false, 0x1, chunk)
+	for i := 0; i < cloudJSONLimit/len(chunk); i++ {
+		writeTestFrame(t, conn, false, 0x0, chunk)
+	}
+
+	_, opcode, payload, err := readWebSocketFrame(r)
+	if err != nil || opcode != 0x1 {
+		t.Fatalf("frame %d %q: %v, want the error message", opcode, payload, err)
+	}
+	var msg CloudMessage
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Type != CloudMessageError {
+		t.Fatalf("message %q: %v", payload, err)
+	}
+	_, opcode, payload, err = readWebSocketFrame(r)
+	if err != nil || opcode != 0x8 || binary.BigEndian.Uint16(payload) != 1009 {
+		t.Fatalf("frame %d %x: %v, want the close frame", opcode, payload, err)
+	}
+	if _, _, _, err = readWebSocketFrame(r); err == nil {
+		t.Fatal("the connection isn't closed")
+	}
+	if len(received) != 0 {
+		t.Fatal("the message too large is delivered")
+	}
+}
+
+func TestCloudStreamMessageTooLarge(t *testing.T) {
+	c, received := listenTestCloud(t, "tcp://127.0.0.1:0")
+	conn, err := net.Dial("tcp", c.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.WriteString(conn, `{"type":"down","text":"`+strings.Repeat("x", cloudJSONLimit)+"\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	var msg CloudMessage
+	if err != nil || json.Unmarshal(line, &msg) != nil || msg.Type != CloudMessageError {
+		t.Fatalf("line %q: %v, want the error message", line, err)
+	}
+	// the connection is reset if the rest of the line isn't read by the server
+	if _, err = r.ReadByte(); err == nil {
+		t.Fatal("the connection isn't closed")
+	}
+	if len(received) != 0 {
+		t.Fatal("the message too large is delivered")
+	}
+}
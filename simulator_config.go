@@ -0,0 +1,411 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// environment variables read by SimulatorConfigFromEnv
+const (
+	SimConfigFileEnv        = "DJIEDGE_SIM_CONFIG"
+	SimStreamFileEnv        = "DJIEDGE_SIM_STREAM_FILE"
+	SimFrameIntervalEnv     = "DJIEDGE_SIM_FRAME_INTERVAL"
+	SimInitDelayEnv         = "DJIEDGE_SIM_INIT_DELAY"
+	SimStartDelayEnv        = "DJIEDGE_SIM_START_DELAY"
+	SimStatusScriptEnv      = "DJIEDGE_SIM_STATUS_SCRIPT"
+	SimStatusRepeatEnv      = "DJIEDGE_SIM_STATUS_REPEAT"
+	SimStatusIntervalEnv    = "DJIEDGE_SIM_STATUS_INTERVAL"
+	SimMediaDirEnv          = "DJIEDGE_SIM_MEDIA_DIR"
+	SimMediaPollIntervalEnv = "DJIEDGE_SIM_MEDIA_POLL_INTERVAL"
+	SimMediaBandwidthEnv    = "DJIEDGE_SIM_MEDIA_BANDWIDTH"
+	SimCloudEndpointEnv     = "DJIEDGE_SIM_CLOUD_ENDPOINT"
+	SimCloudLatencyEnv      = "DJIEDGE_SIM_CLOUD_LATENCY"
+	SimCloudLossEnv         = "DJIEDGE_SIM_CLOUD_LOSS"
+	SimReplayFileEnv        = "DJIEDGE_SIM_REPLAY_FILE"
+)
+
+// SimulatorStream selects the h264 file pushed for a camera
+type SimulatorStream struct {
+	CameraType CameraType `json:"camera_type"`
+	// Source the camera source, 0 matches every source of the camera
+	Source CameraSource `json:"source"`
+	File   string       `json:"file"`
+}
+
+// LiveStatusStep changes the simulated stream status at a point of the timeline
+type LiveStatusStep struct {
+	// At offset from the initialization of the live-view
+	At time.Duration `json:"at"`
+	// Value the status bitmask, see LiveStatus
+	Value int `json:"value"`
+}
+
+func (s *LiveStatusStep) UnmarshalJSON(b []byte) error {
+	var aux struct {
+		At    jsonDuration `json:"at"`
+		Value int          `json:"value"`
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	s.At, s.Value = time.Duration(aux.At), aux.Value
+	return nil
+}
+
+// SimulatorConfig configures the behaviour of the Simulator.
+// it can be built programmatically, or loaded by LoadSimulatorConfig and SimulatorConfigFromEnv,
+// the durations in a json file are written as strings like "30ms".
+type SimulatorConfig struct {
+	// StreamFile the h264 file pushed when no entry of StreamFiles matches the camera,
+	// SimTestPatternStream pushes the synthetic TestPattern instead of a file.
+	StreamFile string `json:"stream_file"`
+	// StreamFiles the h264 file of a camera type and source
+	StreamFiles []SimulatorStream `json:"stream_files"`
+	// StreamLoop restart from the beginning of the file at the end of the stream
+	StreamLoop bool `json:"stream_loop"`
+	// FrameInterval the interval of pushing the access units(frames) of the stream,
+	// it is only used if the sps of the stream has no timing info or IgnoreStreamTiming is true.
+	FrameInterval time.Duration `json:"frame_interval"`
+	// IgnoreStreamTiming always use FrameInterval, ignore the frame rate of the sps
+	IgnoreStreamTiming bool `json:"ignore_stream_timing"`
+	// StartDelay the latency of LiveView.StartH264Stream
+	StartDelay time.Duration `json:"start_delay"`
+	// InitDelay the latency of InitSDK
+	InitDelay time.Duration `json:"init_delay"`
+
+	// StatusInterval the interval of reporting the stream status
+	StatusInterval time.Duration `json:"status_interval"`
+	// StatusScript the timeline of the stream status, the status is 0 before the first step
+	StatusScript []LiveStatusStep `json:"status_script"`
+	// StatusRepeat restart the timeline after the duration, 0 disables repeating
+	StatusRepeat time.Duration `json:"status_repeat"`
+
+	// MediaDir the directory of the media files(jpeg and mp4) of the dock, empty means the dock has no media files,
+	// new files copied to the directory are reported to the media files observer.
+	MediaDir string `json:"media_dir"`
+	// MediaPollInterval the interval of scanning MediaDir for new files
+	MediaPollInterval time.Duration `json:"media_poll_interval"`
+	// MediaBandwidth limits the transfer rate of the media files in bytes per second, 0 means unlimited
+	MediaBandwidth int64 `json:"media_bandwidth"`
+	// MediaDisconnectEvery drops the transfer connection of a reader every time the number of bytes are read,
+	// 0 disables it. see Simulator.DisconnectMedia
+	MediaDisconnectEvery int64 `json:"media_disconnect_every"`
+
+	// CloudEndpoint the local endpoint of the simulated cloud channel, such as "tcp://127.0.0.1:7788",
+	// "unix:///tmp/edge_cloud.sock" or "ws://127.0.0.1:7789/cloud", it is listened while the sdk is initialized.
+	// empty means the messages sent to the cloud are only written to the sdk log.
+	CloudEndpoint string `json:"cloud_endpoint"`
+	// CloudLatency delays the custom messages of both directions
+	CloudLatency time.Duration `json:"cloud_latency"`
+	// CloudLoss the probability of a custom message being lost
+	CloudLoss float64 `json:"cloud_loss"`
+
+	// ReplayFile the capture file recorded by StartCapture on a real device,
+	// the callbacks of the capture are replayed with the original timing instead of the simulated ones,
+	// see Simulator.InitSDK and the StartH264Stream of the live-view.
+	ReplayFile string `json:"replay_file"`
+
+	// Faults makes the operations of the simulator fail
+	Faults []Fault `json:"faults"`
+	// StreamFaults damages the simulated streams
+	StreamFaults StreamFaults `json:"stream_faults"`
+	// FaultSeed the seed of the random faults, 0 means a random seed
+	FaultSeed int64 `json:"fault_seed"`
+}
+
+// DefaultSimulatorConfig returns the config used by NewSimulator
+func DefaultSimulatorConfig() *SimulatorConfig {
+	return &SimulatorConfig{
+		StreamFile:     fakeEdgeStreamFileName,
+		FrameInterval:  time.Second / 30,
+		StartDelay:     1 * time.Second,
+		InitDelay:      5 * time.Second,
+		StatusInterval: 2 * time.Second,
+		StatusScript: []LiveStatusStep{
+			{At: 0, Value: 0},
+			{At: 8 * time.Second, Value: 1},
+		},
+		StatusRepeat:      366 * time.Second,
+		MediaPollInterval: time.Second,
+	}
+}
+
+func (c *SimulatorConfig) UnmarshalJSON(b []byte) error {
+	type plain SimulatorConfig
+	aux := struct {
+		*plain
+		FrameInterval  *jsonDuration `json:"frame_interval"`
+		StartDelay     *jsonDuration `json:"start_delay"`
+		InitDelay      *jsonDuration `json:"init_delay"`
+		StatusInterval *jsonDuration `json:"status_interval"`
+		StatusRepeat   *jsonDuration `json:"status_repeat"`
+		MediaPoll      *jsonDuration `json:"media_poll_interval"`
+		CloudLatency   *jsonDuration `json:"cloud_latency"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	aux.FrameInterval.assign(&c.FrameInterval)
+	aux.StartDelay.assign(&c.StartDelay)
+	aux.InitDelay.assign(&c.InitDelay)
+	aux.StatusInterval.assign(&c.StatusInterval)
+	aux.StatusRepeat.assign(&c.StatusRepeat)
+	aux.MediaPoll.assign(&c.MediaPollInterval)
+	aux.CloudLatency.assign(&c.CloudLatency)
+	return nil
+}
+
+// Validate checks the config
+func (c *SimulatorConfig) Validate() error {
+	if c.FrameInterval <= 0 {
+		return errors.New("simulator: frame interval must be positive")
+	}
+	if c.StatusInterval <= 0 {
+		return errors.New("simulator: status interval must be positive")
+	}
+	if c.StartDelay < 0 || c.InitDelay < 0 || c.StatusRepeat < 0 {
+		return errors.New("simulator: delay must not be negative")
+	}
+	if c.MediaDir != "" && c.MediaPollInterval <= 0 {
+		return errors.New("simulator: media poll interval must be positive")
+	}
+	if c.CloudLatency < 0 || c.CloudLoss < 0 || c.CloudLoss > 1 {
+		return errors.New("simulator: invalid cloud latency or loss")
+	}
+	if c.MediaBandwidth < 0 || c.MediaDisconnectEvery < 0 {
+		return errors.New("simulator: media bandwidth and disconnect bytes must not be negative")
+	}
+	for _, s := range c.StreamFiles {
+		if !s.CameraType.IsValid() {
+			return fmt.Errorf("simulator: invalid camera type %v of stream file %q", s.CameraType, s.File)
+		}
+		if s.Source != 0 && !s.Source.IsValid() {
+			return fmt.Errorf("simulator: invalid camera source %v of stream file %q", s.Source, s.File)
+		}
+		if s.File == "" {
+			return errors.New("simulator: empty stream file")
+		}
+	}
+	for i := range c.Faults {
+		if err := c.Faults[i].validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.StreamFaults.validate(); err != nil {
+		return err
+	}
+	for i, step := range c.StatusScript {
+		if step.At < 0 {
+			return fmt.Errorf("simulator: status step %d has negative offset", i)
+		}
+		if i > 0 && step.At < c.StatusScript[i-1].At {
+			return fmt.Errorf("simulator: status step %d is out of order", i)
+		}
+		if step.Value < 0 || step.Value > 0x1f {
+			return fmt.Errorf("simulator: status step %d has invalid value %d", i, step.Value)
+		}
+	}
+	return nil
+}
+
+// streamFile returns the h264 file of the camera
+func (c *SimulatorConfig) streamFile(cameraType CameraType, source CameraSource) string {
+	file := c.StreamFile
+	for _, s := range c.StreamFiles {
+		if s.CameraType != cameraType {
+			continue
+		}
+		if s.Source == source {
+			return s.File
+		}
+		if s.Source == 0 {
+			file = s.File
+		}
+	}
+	return file
+}
+
+// statusAt returns the status value of the timeline at the offset, and the offset of the next change,
+// the next offset is negative if the status doesn't change anymore.
+func (c *SimulatorConfig) statusAt(offset time.Duration) (int, time.Duration) {
+	var base time.Duration
+	if c.StatusRepeat > 0 {
+		base = offset / c.StatusRepeat * c.StatusRepeat
+		offset -= base
+	}
+	value := 0
+	for _, step := range c.StatusScript {
+		if step.At > offset {
+			return value, base + step.At
+		}
+		value = step.Value
+	}
+	if c.StatusRepeat > 0 {
+		return value, base + c.StatusRepeat
+	}
+	return value, -1
+}
+
+// LoadSimulatorConfig loads the config from a json file, the missing fields keep the default value
+func LoadSimulatorConfig(path string) (*SimulatorConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultSimulatorConfig()
+	if err = json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("simulator: parse config %s: %w", path, err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SimulatorConfigFromEnv returns the config loaded from the file named by DJIEDGE_SIM_CONFIG,
+// then overridden by the other DJIEDGE_SIM_* variables.
+//
+// DJIEDGE_SIM_STATUS_SCRIPT is a comma separated list of 'offset=value', such as "0s=0,8s=31,60s=15".
+func SimulatorConfigFromEnv() (*SimulatorConfig, error) {
+	cfg := DefaultSimulatorConfig()
+	if path := os.Getenv(SimConfigFileEnv); path != "" {
+		var err error
+		if cfg, err = LoadSimulatorConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	if v := os.Getenv(SimStreamFileEnv); v != "" {
+		cfg.StreamFile = v
+	}
+	if v := os.Getenv(SimCloudEndpointEnv); v != "" {
+		cfg.CloudEndpoint = v
+	}
+	if v := os.Getenv(SimReplayFileEnv); v != "" {
+		cfg.ReplayFile = v
+	}
+	if v := os.Getenv(SimMediaDirEnv); v != "" {
+		cfg.MediaDir = v
+	}
+	if v := os.Getenv(SimMediaBandwidthEnv); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: invalid %s: %w", SimMediaBandwidthEnv, err)
+		}
+		cfg.MediaBandwidth = n
+	}
+	if v := os.Getenv(SimCloudLossEnv); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: invalid %s: %w", SimCloudLossEnv, err)
+		}
+		cfg.CloudLoss = p
+	}
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{SimFrameIntervalEnv, &cfg.FrameInterval},
+		{SimInitDelayEnv, &cfg.InitDelay},
+		{SimStartDelayEnv, &cfg.StartDelay},
+		{SimStatusRepeatEnv, &cfg.StatusRepeat},
+		{SimStatusIntervalEnv, &cfg.StatusInterval},
+		{SimMediaPollIntervalEnv, &cfg.MediaPollInterval},
+		{SimCloudLatencyEnv, &cfg.CloudLatency},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.env)
+		if v == "" {
+			continue
+		}
+		tmp, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: invalid %s: %w", d.env, err)
+		}
+		*d.dst = tmp
+	}
+	if v := os.Getenv(SimStatusScriptEnv); v != "" {
+		script, err := parseStatusScript(v)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: invalid %s: %w", SimStatusScriptEnv, err)
+		}
+		cfg.StatusScript = script
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func parseStatusScript(s string) ([]LiveStatusStep, error) {
+	var script []LiveStatusStep
+	for _, item := range strings.Split(s, ",") {
+		at, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("step %q is not 'offset=value'", item)
+		}
+		d, err := time.ParseDuration(at)
+		if err != nil {
+			return nil, err
+		}
+		v, err := strconv.ParseInt(value, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		script = append(script, LiveStatusStep{At: d, Value: int(v)})
+	}
+	sort.SliceStable(script, func(i, j int) bool {
+		return script[i].At < script[j].At
+	})
+	return script, nil
+}
+
+// jsonDuration is a time.Duration written as string in json
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = jsonDuration(value)
+	case string:
+		tmp, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(tmp)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+func (d *jsonDuration) assign(dst *time.Duration) {
+	if d != nil {
+		*dst = time.Duration(*d)
+	}
+}
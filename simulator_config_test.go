@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusAt(t *testing.T) {
+	script := []LiveStatusStep{{At: 0, Value: 0}, {At: 8 * time.Second, Value: 1}, {At: 20 * time.Second, Value: 15}}
+	tests := []struct {
+		name      string
+		script    []LiveStatusStep
+		repeat    time.Duration
+		offset    time.Duration
+		wantValue int
+		wantNext  time.Duration
+	}{
+		{"start", script, 0, 0, 0, 8 * time.Second},
+		{"before a step", script, 0, 8*time.Second - 1, 0, 8 * time.Second},
+		{"at a step", script, 0, 8 * time.Second, 1, 20 * time.Second},
+		{"after the last step", script, 0, time.Minute, 15, -1},
+		{"before the repeat", script, 30 * time.Second, 25 * time.Second, 15, 30 * time.Second},
+		{"repeated", script, 30 * time.Second, 31 * time.Second, 0, 38 * time.Second},
+		{"second repeat", script, 30 * time.Second, 80 * time.Second, 15, 90 * time.Second},
+		{"first step later", []LiveStatusStep{{At: 5 * time.Second, Value: 31}}, 0, time.Second, 0, 5 * time.Second},
+		{"empty script", nil, 0, time.Minute, 0, -1},
+		{"empty script repeated", nil, 10 * time.Second, 15 * time.Second, 0, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SimulatorConfig{StatusScript: tt.script, StatusRepeat: tt.repeat}
+			value, next := c.statusAt(tt.offset)
+			if value != tt.wantValue || next != tt.wantNext {
+				t.Fatalf("statusAt(%v) = %d,%v, want %d,%v", tt.offset, value, next, tt.wantValue, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestParseStatusScript(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []LiveStatusStep
+		wantErr bool
+	}{
+		{"0s=0,8s=31,60s=15", []LiveStatusStep{{0, 0}, {8 * time.Second, 31}, {time.Minute, 15}}, false},
+		{" 60s=15 , 0s=0 ", []LiveStatusStep{{0, 0}, {time.Minute, 15}}, false},
+		{"1m30s=0x1f", []LiveStatusStep{{90 * time.Second, 31}}, false},
+		{"5s", nil, true},
+		{"x=1", nil, true},
+		{"1s=abc", nil, true},
+		{"1s=1,", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatusScript(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStatusScript(%q) err %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStatusScript(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSimulatorConfigFromEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sim.json")
+	err := os.WriteFile(file, []byte(`{"init_delay":"2s","cloud_latency":"50ms","media_dir":"/data/media","cloud_loss":0.5}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(SimConfigFileEnv, file)
+	t.Setenv(SimStreamFileEnv, "/data/wide.h264")
+	t.Setenv(SimFrameIntervalEnv, "40ms")
+	t.Setenv(SimStatusScriptEnv, "0s=0,3s=31")
+	t.Setenv(SimMediaPollIntervalEnv, "250ms")
+	t.Setenv(SimMediaBandwidthEnv, "1048576")
+	t.Setenv(SimCloudLatencyEnv, "120ms")
+	t.Setenv(SimCloudLossEnv, "0.25")
+
+	cfg, err := SimulatorConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultSimulatorConfig()
+	want.StreamFile = "/data/wide.h264"
+	want.FrameInterval = 40 * time.Millisecond
+	want.InitDelay = 2 * time.Second // from the file
+	want.StatusScript = []LiveStatusStep{{0, 0}, {3 * time.Second, 31}}
+	want.MediaDir = "/data/media" // from the file
+	want.MediaPollInterval = 250 * time.Millisecond
+	want.MediaBandwidth = 1 << 20
+	want.CloudLatency = 120 * time.Millisecond
+	want.CloudLoss = 0.25
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("config %+v\nwant %+v", cfg, want)
+	}
+
+	invalid := []struct {
+		env, value string
+	}{
+		{SimConfigFileEnv, filepath.Join(t.TempDir(), "missing.json")},
+		{SimFrameIntervalEnv, "fast"},
+		{SimMediaPollIntervalEnv, "0s"},
+		{SimMediaBandwidthEnv, "1MB"},
+		{SimCloudLatencyEnv, "-1s"},
+		{SimCloudLossEnv, "half"},
+		{SimCloudLossEnv, "1.5"},
+		{SimStatusScriptEnv, "3s=64"},
+		{SimStatusScriptEnv, "3s"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			if cfg, err := SimulatorConfigFromEnv(); err == nil {
+				t.Fatalf("config %+v, want error", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadSimulatorConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	cfg, err := LoadSimulatorConfig(write("ok.json", `{"frame_interval":"20ms","status_repeat":0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.FrameInterval != 20*time.Millisecond || cfg.StatusRepeat != 0 || cfg.InitDelay != DefaultSimulatorConfig().InitDelay {
+		t.Fatalf("config %+v", cfg)
+	}
+	for name, data := range map[string]string{
+		"syntax.json":   `{"frame_interval":`,
+		"duration.json": `{"frame_interval":true}`,
+		"invalid.json":  `{"frame_interval":"0s"}`,
+	} {
+		if cfg, err := LoadSimulatorConfig(write(name, data)); err == nil {
+			t.Errorf("%s: config %+v, want error", name, cfg)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultOp an operation of the Simulator that can fail by fault injection
+type FaultOp string
+
+const (
+	FaultInitSDK           FaultOp = "InitSDK"
+	FaultStartH264Stream   FaultOp = "StartH264Stream"
+	FaultReaderOpen        FaultOp = "MediaFileReader.Open"
+	FaultOpenFile          FaultOp = "OpenFile"
+	FaultReadFile          FaultOp = "Read"
+	FaultSendCustomMessage FaultOp = "SendCustomMessageToCloud"
+)
+
+func (f FaultOp) IsValid() bool {
+	switch f {
+	case FaultInitSDK, FaultStartH264Stream, FaultReaderOpen, FaultOpenFile, FaultReadFile, FaultSendCustomMessage:
+		return true
+	}
+	return false
+}
+
+// call returns the name of the call in SDKError.Op, the same as the native backend
+func (f FaultOp) call() string {
+	switch f {
+	case FaultStartH264Stream:
+		return "LiveView.StartH264Stream"
+	case FaultOpenFile:
+		return "MediaFileReader.OpenFile"
+	case FaultReadFile:
+		return "MediaFile.Read"
+	}
+	return string(f)
+}
+
+// Fault makes an operation of the Simulator fail.
+//
+// The error is Err if it is set, otherwise the *SDKError of the sdk error code Code,
+// such as 10 for ErrRequestTimeout and 17 for ErrConnectFailure.
+// If Sequence is set, the consecutive calls take the codes of it in order (0 means the call succeeds),
+// the fault is exhausted at the end of the sequence.
+type Fault struct {
+	Op       FaultOp `json:"op"`
+	Err      error   `json:"-"`
+	Code     int     `json:"code"`
+	Sequence []int   `json:"sequence"`
+	// Probability the probability of a call failing, 0 means always
+	Probability float64 `json:"probability"`
+	// Skip the number of calls passed before the fault is active
+	Skip int `json:"skip"`
+	// Count the maximum number of failures, 0 means unlimited
+	Count int `json:"count"`
+}
+
+func (f *Fault) validate() error {
+	if !f.Op.IsValid() {
+		return fmt.Errorf("simulator: invalid fault operation %q", f.Op)
+	}
+	if f.Err == nil && len(f.Sequence) == 0 && codeErrMap[f.Code] == nil {
+		return fmt.Errorf("simulator: fault of %s has invalid error code %d", f.Op, f.Code)
+	}
+	for _, code := range f.Sequence {
+		if code != 0 && codeErrMap[code] == nil {
+			return fmt.Errorf("simulator: fault of %s has invalid error code %d", f.Op, code)
+		}
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("simulator: fault of %s has invalid probability %v", f.Op, f.Probability)
+	}
+	return nil
+}
+
+// StreamStall pauses the simulated stream
+type StreamStall struct {
+	// At offset from the start of the stream
+	At       time.Duration `json:"at"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (s *StreamStall) UnmarshalJSON(b []byte) error {
+	var aux struct {
+		At       jsonDuration `json:"at"`
+		Duration jsonDuration `json:"duration"`
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	s.At, s.Duration = time.Duration(aux.At), time.Duration(aux.Duration)
+	return nil
+}
+
+// StreamFaults damages the simulated stream
+type StreamFaults struct {
+	// Stalls the scripted pauses of the stream
+	Stalls []StreamStall `json:"stalls"`
+	// StallProbability the probability of pausing before an access unit for StallDuration
+	StallProbability float64       `json:"stall_probability"`
+	StallDuration    time.Duration `json:"stall_duration"`
+	// TruncateProbability the probability of the data being truncated at a random length,
+	// it is a nal unit of the h264 files, and a whole access unit of the TestPattern and the replayed capture.
+	TruncateProbability float64 `json:"truncate_probability"`
+}
+
+func (s *StreamFaults) UnmarshalJSON(b []byte) error {
+	type plain StreamFaults
+	aux := struct {
+		*plain
+		StallDuration *jsonDuration `json:"stall_duration"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	aux.StallDuration.assign(&s.StallDuration)
+	return nil
+}
+
+func (s *StreamFaults) validate() error {
+	if s.StallProbability < 0 || s.StallProbability > 1 || s.TruncateProbability < 0 || s.TruncateProbability > 1 {
+		return fmt.Errorf("simulator: invalid probability of stream faults")
+	}
+	for _, stall := range s.Stalls {
+		if stall.At < 0 || stall.Duration < 0 {
+			return fmt.Errorf("simulator: invalid stream stall %v", stall)
+		}
+	}
+	return nil
+}
+
+type faultState struct {
+	Fault
+	calls  int
+	fired  int
+	seqPos int
+}
+
+// faultInjector decides the failures of the simulated operations
+type faultInjector struct {
+	mu     sync.Mutex
+	rand   *rand.Rand
+	faults []*faultState
+	stream StreamFaults
+}
+
+func newFaultInjector(faults []Fault, stream StreamFaults, seed int64) *faultInjector {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fi := &faultInjector{rand: rand.New(rand.NewSource(seed)), stream: stream}
+	for _, f := range faults {
+		fi.faults = append(fi.faults, &faultState{Fault: f})
+	}
+	return fi
+}
+
+func (fi *faultInjector) add(f Fault) {
+	fi.mu.Lock()
+	fi.faults = append(fi.faults, &faultState{Fault: f})
+	fi.mu.Unlock()
+}
+
+func (fi *faultInjector) reset() {
+	fi.mu.Lock()
+	fi.faults = nil
+	fi.stream = StreamFaults{}
+	fi.mu.Unlock()
+}
+
+func (fi *faultInjector) setStreamFaults(s StreamFaults) {
+	fi.mu.Lock()
+	fi.stream = s
+	fi.mu.Unlock()
+}
+
+// check returns the error injected to the call of the operation, the first matched fault is used
+func (fi *faultInjector) check(op FaultOp, args ...any) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	for _, f := range fi.faults {
+		if f.Op != op {
+			continue
+		}
+		f.calls++
+		if f.calls <= f.Skip {
+			continue
+		}
+		if f.Count > 0 && f.fired >= f.Count {
+			continue
+		}
+		if len(f.Sequence) > 0 {
+			if f.seqPos >= len(f.Sequence) {
+				continue
+			}
+			code := f.Sequence[f.seqPos]
+			f.seqPos++
+			if code == 0 {
+				continue
+			}
+			f.fired++
+			return sdkError(op.call(), code, args...)
+		}
+		if f.Probability > 0 && fi.rand.Float64() >= f.Probability {
+			continue
+		}
+		f.fired++
+		if f.Err != nil {
+			return wrapSDKError(op.call(), f.Err, args...)
+		}
+		return sdkError(op.call(), f.Code, args...)
+	}
+	return nil
+}
+
+// stall returns the duration of pausing the stream at the offset from the start of the stream,
+// the scripted stalls before the offset and after the last check are consumed.
+func (fi *faultInjector) stall(last, offset time.Duration) time.Duration {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var d time.Duration
+	for _, s := range fi.stream.Stalls {
+		if s.At >= last && s.At < offset {
+			d += s.Duration
+		}
+	}
+	if fi.stream.StallProbability > 0 && fi.rand.Float64() < fi.stream.StallProbability {
+		d += fi.stream.StallDuration
+	}
+	return d
+}
+
+// truncate cuts the data at a random length by the probability, the start code is kept
+func (fi *faultInjector) truncate(nalu []byte) []byte {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.stream.TruncateProbability <= 0 || len(nalu) <= 5 || fi.rand.Float64() >= fi.stream.TruncateProbability {
+		return nalu
+	}
+	return nalu[:5+fi.rand.Intn(len(nalu)-5)]
+}
+
+// chance returns true by the probability
+func (fi *faultInjector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.rand.Float64() < p
+}
+
+// InjectFault adds a fault to the simulator at runtime
+func (s *Simulator) InjectFault(f Fault) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	s.faults.add(f)
+	return nil
+}
+
+// SetStreamFaults replaces the stream faults at runtime, it takes effect on the running streams immediately
+func (s *Simulator) SetStreamFaults(faults StreamFaults) error {
+	if err := faults.validate(); err != nil {
+		return err
+	}
+	s.faults.setStreamFaults(faults)
+	return nil
+}
+
+// ClearFaults removes all the faults of the simulator
+func (s *Simulator) ClearFaults() {
+	s.faults.reset()
+}
+
+// injectFault returns the injected error of the operation and writes it to the sdk log
+func (s *Simulator) injectFault(op FaultOp, args ...any) error {
+	err := s.faults.check(op, args...)
+	if err != nil {
+		s.log(LogLevelError, "%s failed: %v", op, err)
+	}
+	return err
+}
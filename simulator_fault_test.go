@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// checkFaults returns the errors of n calls of the operation
+func checkFaults(fi *faultInjector, op FaultOp, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = fi.check(op)
+	}
+	return errs
+}
+
+func TestFaultValidate(t *testing.T) {
+	tests := []struct {
+		fault Fault
+		ok    bool
+	}{
+		{Fault{Op: FaultInitSDK, Code: 10}, true},
+		{Fault{Op: FaultReadFile, Err: io.ErrUnexpectedEOF}, true},
+		{Fault{Op: FaultOpenFile, Sequence: []int{0, 17, 10}}, true},
+		{Fault{Op: FaultStartH264Stream, Code: 17, Probability: 1}, true},
+		{Fault{Op: "Unknown", Code: 10}, false},
+		{Fault{Op: FaultInitSDK}, false},
+		{Fault{Op: FaultInitSDK, Code: -12345}, false},
+		{Fault{Op: FaultOpenFile, Sequence: []int{17, -12345}}, false},
+		{Fault{Op: FaultInitSDK, Code: 10, Probability: -0.1}, false},
+		{Fault{Op: FaultInitSDK, Code: 10, Probability: 1.5}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.fault.validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: validate returned %v", tt.fault, err)
+		}
+	}
+
+	streams := []struct {
+		faults StreamFaults
+		ok     bool
+	}{
+		{StreamFaults{}, true},
+		{StreamFaults{Stalls: []StreamStall{{At: time.Second, Duration: time.Second}}, StallProbability: 0.5, TruncateProbability: 1}, true},
+		{StreamFaults{StallProbability: 2}, false},
+		{StreamFaults{TruncateProbability: -1}, false},
+		{StreamFaults{Stalls: []StreamStall{{At: -time.Second, Duration: time.Second}}}, false},
+		{StreamFaults{Stalls: []StreamStall{{At: time.Second, Duration: -time.Second}}}, false},
+	}
+	for _, tt := range streams {
+		if err := tt.faults.validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: validate returned %v", tt.faults, err)
+		}
+	}
+}
+
+func TestFaultInjectorCheck(t *testing.T) {
+	t.Run("code", func(t *testing.T) {
+		fi := newFaultInjector([]Fault{{Op: FaultReadFile, Code: 10}}, StreamFaults{}, 1)
+		if err := fi.check(FaultOpenFile, "a.jpg"); err != nil {
+			t.Fatalf("other operation failed: %v", err)
+		}
+		err := fi.check(FaultReadFile, fileHandle(3))
+		var sdkErr *SDKError
+		if !errors.As(err, &sdkErr) || !errors.Is(err, ErrRequestTimeout) {
+			t.Fatalf("check returned %#v", err)
+		}
+		if sdkErr.Op != "MediaFile.Read" || sdkErr.Code != 10 || len(sdkErr.Args) != 1 {
+			t.Errorf("sdk error %+v", sdkErr)
+		}
+	})
+
+	t.Run("err", func(t *testing.T) {
+		fi := newFaultInjector([]Fault{
+			{Op: FaultStartH264Stream, Err: ErrNoVideoID, Count: 1},
+			{Op: FaultStartH264Stream, Err: io.ErrUnexpectedEOF},
+		}, StreamFaults{}, 1)
+		err := fi.check(FaultStartH264Stream)
+		var sdkErr *SDKError
+		if !errors.Is(err, ErrNoVideoID) || !errors.As(err, &sdkErr) || sdkErr.Op != "LiveView.StartH264Stream" {
+			t.Fatalf("sentinel fault returned %#v", err)
+		}
+		if err := fi.check(FaultStartH264Stream); err != io.ErrUnexpectedEOF {
+			t.Errorf("other fault returned %#v", err)
+		}
+	})
+
+	t.Run("skip and count", func(t *testing.T) {
+		fi := newFaultInjector([]Fault{{Op: FaultInitSDK, Code: 17, Skip: 2, Count: 2}}, StreamFaults{}, 1)
+		for i, err := range checkFaults(fi, FaultInitSDK, 6) {
+			if want := i == 2 || i == 3; (err != nil) != want {
+				t.Errorf("call %d returned %v", i, err)
+			}
+		}
+	})
+
+	t.Run("sequence", func(t *testing.T) {
+		fi := newFaultInjector([]Fault{{Op: FaultOpenFile, Sequence: []int{17, 0, 10}}}, StreamFaults{}, 1)
+		want := []error{ErrConnectFailure, nil, ErrRequestTimeout, nil, nil}
+		for i, err := range checkFaults(fi, FaultOpenFile, len(want)) {
+			if (err == nil) != (want[i] == nil) || want[i] != nil && !errors.Is(err, want[i]) {
+				t.Errorf("call %d returned %v, want %v", i, err, want[i])
+			}
+		}
+	})
+
+	t.Run("first matched", func(t *testing.T) {
+		fi := newFaultInjector([]Fault{
+			{Op: FaultReaderOpen, Code: 10, Count: 1},
+			{Op: FaultReaderOpen, Code: 17},
+		}, StreamFaults{}, 1)
+		errs := checkFaults(fi, FaultReaderOpen, 3)
+		if !errors.Is(errs[0], ErrRequestTimeout) || !errors.Is(errs[1], ErrConnectFailure) || !errors.Is(errs[2], ErrConnectFailure) {
+			t.Errorf("errors %v", errs)
+		}
+	})
+
+	t.Run("probability", func(t *testing.T) {
+		fi := newFaultInjector([]Fault{{Op: FaultSendCustomMessage, Code: 10, Probability: 0.3}}, StreamFaults{}, 1)
+		var failed int
+		for _, err := range checkFaults(fi, FaultSendCustomMessage, 1000) {
+			if err != nil {
+				failed++
+			}
+		}
+		if failed < 200 || failed > 400 {
+			t.Errorf("%d of 1000 calls failed with probability 0.3", failed)
+		}
+	})
+
+	t.Run("add and reset", func(t *testing.T) {
+		fi := newFaultInjector(nil, StreamFaults{TruncateProbability: 1}, 1)
+		if err := fi.check(FaultInitSDK); err != nil {
+			t.Fatalf("no fault returned %v", err)
+		}
+		fi.add(Fault{Op: FaultInitSDK, Code: 10})
+		if err := fi.check(FaultInitSDK); !errors.Is(err, ErrRequestTimeout) {
+			t.Fatalf("added fault returned %v", err)
+		}
+		fi.reset()
+		if err := fi.check(FaultInitSDK); err != nil {
+			t.Errorf("reset fault returned %v", err)
+		}
+		if data := []byte("0123456789"); len(fi.truncate(data)) != len(data) {
+			t.Errorf("reset stream faults still truncate")
+		}
+	})
+}
+
+func TestFaultInjectorStall(t *testing.T) {
+	fi := newFaultInjector(nil, StreamFaults{Stalls: []StreamStall{
+		{At: 0, Duration: time.Second},
+		{At: 100 * time.Millisecond, Duration: 2 * time.Second},
+		{At: 150 * time.Millisecond, Duration: 3 * time.Second},
+	}}, 1)
+	steps := []struct {
+		last, offset, want time.Duration
+	}{
+		{0, 10 * time.Millisecond, time.Second},
+		{10 * time.Millisecond, 100 * time.Millisecond, 0},
+		{100 * time.Millisecond, 200 * time.Millisecond, 5 * time.Second},
+		{200 * time.Millisecond, time.Second, 0},
+	}
+	for _, s := range steps {
+		if d := fi.stall(s.last, s.offset); d != s.want {
+			t.Errorf("stall [%v, %v) = %v, want %v", s.last, s.offset, d, s.want)
+		}
+	}
+
+	fi.setStreamFaults(StreamFaults{StallProbability: 1, StallDuration: 50 * time.Millisecond})
+	if d := fi.stall(0, time.Millisecond); d != 50*time.Millisecond {
+		t.Errorf("stall with probability 1 = %v", d)
+	}
+}
+
+func TestFaultInjectorTruncate(t *testing.T) {
+	data := concat(testSPS, testPPS, testIDR)
+	fi := newFaultInjector(nil, StreamFaults{}, 1)
+	if got := fi.truncate(data); !bytes.Equal(got, data) {
+		t.Fatalf("truncated without stream faults")
+	}
+
+	fi.setStreamFaults(StreamFaults{TruncateProbability: 1})
+	for i := 0; i < 100; i++ {
+		got := fi.truncate(data)
+		if len(got) < 5 || len(got) >= len(data) || !bytes.HasPrefix(data, got) {
+			t.Fatalf("truncated to %d bytes of %d", len(got), len(data))
+		}
+	}
+	short := []byte{0, 0, 0, 1, 0x65}
+	if got := fi.truncate(short); !bytes.Equal(got, short) {
+		t.Errorf("truncated %d bytes to %d", len(short), len(got))
+	}
+}
+
+func TestStreamFaultsJSON(t *testing.T) {
+	var faults StreamFaults
+	err := json.Unmarshal([]byte(`{"stalls":[{"at":"1.5s","duration":"200ms"},{"at":1000,"duration":2000}],
+		"stall_probability":0.1,"stall_duration":"1s","truncate_probability":0.2}`), &faults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StreamStall{{At: 1500 * time.Millisecond, Duration: 200 * time.Millisecond}, {At: 1000, Duration: 2000}}
+	if len(faults.Stalls) != 2 || faults.Stalls[0] != want[0] || faults.Stalls[1] != want[1] {
+		t.Errorf("stalls %v", faults.Stalls)
+	}
+	if faults.StallProbability != 0.1 || faults.StallDuration != time.Second || faults.TruncateProbability != 0.2 {
+		t.Errorf("stream faults %+v", faults)
+	}
+	if err := json.Unmarshal([]byte(`{"stall_duration":"1x"}`), &faults); err == nil {
+		t.Errorf("invalid duration is accepted")
+	}
+
+	var fault Fault
+	if err := json.Unmarshal([]byte(`{"op":"OpenFile","sequence":[17,0],"skip":1}`), &fault); err != nil {
+		t.Fatal(err)
+	}
+	if fault.Op != FaultOpenFile || len(fault.Sequence) != 2 || fault.Skip != 1 || fault.validate() != nil {
+		t.Errorf("fault %+v", fault)
+	}
+}
+
+func TestSimulatorFaults(t *testing.T) {
+	cfg := DefaultSimulatorConfig()
+	cfg.InitDelay, cfg.StartDelay = 0, 0
+	cfg.Faults = []Fault{{Op: FaultInitSDK, Code: 17, Count: 1}}
+	sim, err := NewSimulatorWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.DeInitSDK()
+
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); !errors.Is(err, ErrConnectFailure) {
+		t.Fatalf("InitSDK returned %v", err)
+	}
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); !errors.Is(err, errSDKNotReleased) {
+		t.Fatalf("InitSDK before DeInitSDK returned %v", err)
+	}
+	if err := sim.DeInitSDK(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); err != nil {
+		t.Fatalf("InitSDK after the fault returned %v", err)
+	}
+
+	if err := sim.InjectFault(Fault{Op: FaultSendCustomMessage}); err == nil {
+		t.Errorf("invalid fault is injected")
+	}
+	if err := sim.InjectFault(Fault{Op: FaultSendCustomMessage, Code: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.SendCustomMessageToCloud([]byte("hello")); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("SendCustomMessageToCloud returned %v", err)
+	}
+	sim.ClearFaults()
+	if err := sim.SendCustomMessageToCloud([]byte("hello")); err != nil {
+		t.Errorf("SendCustomMessageToCloud after ClearFaults returned %v", err)
+	}
+
+	if err := sim.SetStreamFaults(StreamFaults{StallProbability: -1}); err == nil {
+		t.Errorf("invalid stream faults are set")
+	}
+	if err := sim.SetStreamFaults(StreamFaults{TruncateProbability: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.faults.truncate(concat(testSPS, testPPS)); len(got) >= len(testSPS)+len(testPPS) {
+		t.Errorf("stream faults aren't applied")
+	}
+}
+
+func TestSimulatorInitDeInitOnFailed(t *testing.T) {
+	cfg := DefaultSimulatorConfig()
+	cfg.InitDelay, cfg.StartDelay = 0, 0
+	cfg.Faults = []Fault{{Op: FaultInitSDK, Code: 10, Count: 1}}
+	sim, err := NewSimulatorWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.DeInitSDK()
+
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, true); !errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("InitSDK returned %v", err)
+	}
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, true); err != nil {
+		t.Fatalf("InitSDK after a failure with deInitOnFailed returned %v", err)
+	}
+}
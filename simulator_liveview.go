@@ -0,0 +1,412 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lynnplus/go-djiedge/h264"
+)
+
+// simLiveView simulate edge device sending h264 data stream
+type simLiveView struct {
+	sim             *Simulator
+	handler         atomic.Pointer[StreamReceiver]
+	cameraInitState atomic.Int32
+	cameraType      CameraType
+	source          atomic.Int32
+
+	quality StreamQuality
+
+	// the captured live-view replayed for the camera, 0 if the capture is not replayed
+	replayChannel uint32
+	replayOffset  time.Duration
+
+	mu       sync.Mutex // serializes starting and stopping the stream
+	reading  atomic.Bool
+	stream   accessUnitSource
+	dataChan chan []byte
+	closeSig chan bool
+	stateSig chan bool
+
+	frames frameFeed
+	// dropped the access units dropped because the receiver is slower than the stream
+	dropped atomic.Uint64
+
+	wg sync.WaitGroup
+}
+
+func newSimLiveView(sim *Simulator) *simLiveView {
+	return &simLiveView{sim: sim, frames: frameFeed{gopLimit: defaultGOPCacheSize}}
+}
+
+// Destroy stops the stream and releases the live-view
+func (lv *simLiveView) Destroy() {
+	_ = lv.StopH264Stream()
+	lv.DeInit()
+	lv.handler.Store(nil)
+	lv.frames.close()
+}
+
+// Frames returns a channel of the stream data copied into pooled frames, see LiveView.Frames
+func (lv *simLiveView) Frames(ctx context.Context, opts *FrameOptions) <-chan *Frame {
+	return lv.frames.frames(ctx, opts)
+}
+
+// Init initialize live stream subscription.
+// Note: For a specific camera, you can initialize only once
+func (lv *simLiveView) Init(cameraType CameraType, quality StreamQuality, handler StreamReceiver) error {
+	if !cameraType.IsValid() || !quality.IsValid() {
+		return errors.New("invalid parameter for camera or quality")
+	}
+	if handler == nil {
+		return errors.New("parameter handler is nil")
+	}
+	if !lv.sim.Initialized() {
+		return ErrSDKNotInit
+	}
+
+	var channel uint32
+	var offset time.Duration
+	if lv.sim.cfg.ReplayFile != "" {
+		var err error
+		if channel, offset, err = findReplayChannel(lv.sim.cfg.ReplayFile, cameraType, quality); err != nil {
+			return err
+		}
+		if channel == 0 {
+			lv.sim.log(LogLevelInfo, "camera %v is not captured in %s, simulate it", cameraType, lv.sim.cfg.ReplayFile)
+		}
+	}
+
+	lv.handler.Store(&handler)
+	if lv.cameraInitState.CompareAndSwap(0, 1) {
+		lv.cameraType = cameraType
+		lv.quality = quality
+		lv.replayChannel, lv.replayOffset = channel, offset
+		lv.stateSig = make(chan bool)
+		if channel != 0 {
+			go lv.replayState(lv.stateSig, channel, offset)
+		} else {
+			go lv.updateState(lv.stateSig)
+		}
+		lv.cameraInitState.Store(2)
+	}
+	return nil
+}
+
+// DeInit de-initialize stream subscription
+func (lv *simLiveView) DeInit() {
+	if lv.cameraInitState.CompareAndSwap(2, 0) {
+		close(lv.stateSig)
+	}
+}
+
+func (lv *simLiveView) cameraInitialized() bool {
+	return lv.cameraInitState.Load() == 2
+}
+
+func (lv *simLiveView) receiver() StreamReceiver {
+	if h := lv.handler.Load(); h != nil {
+		return *h
+	}
+	return nil
+}
+
+// SetCameraSource can switch the camera source used,
+// the stream is switched to the file of the source when it is started, the replayed stream is not switched.
+func (lv *simLiveView) SetCameraSource(source CameraSource) error {
+	if !source.IsValid() {
+		return errors.New("invalid parameter for camera source")
+	}
+	if !lv.cameraInitialized() {
+		return errors.New(" live-view is not initialized")
+	}
+
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	cfg := lv.sim.cfg
+	old := CameraSource(lv.source.Swap(int32(source)))
+	if !lv.reading.Load() || lv.replayChannel != 0 || cfg.streamFile(lv.cameraType, old) == cfg.streamFile(lv.cameraType, source) {
+		return nil
+	}
+	lv.stopStream()
+	return lv.startStream()
+}
+
+// StartH264Stream read local h264-stream file of the camera and push data to StreamReceiver,
+// the synthetic TestPattern is pushed if there is no stream file.
+func (lv *simLiveView) StartH264Stream() error {
+	if !lv.cameraInitialized() {
+		return errors.New(" live-view is not initialized")
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if lv.reading.Load() {
+		return nil
+	}
+	time.Sleep(lv.sim.cfg.StartDelay)
+	if err := lv.sim.injectFault(FaultStartH264Stream, lv.cameraType, lv.quality); err != nil {
+		return err
+	}
+	return lv.startStream()
+}
+
+// openStream opens the stream file of the camera source,
+// the TestPattern is used for SimTestPatternStream or if the default stream file doesn't exist.
+// the captured stream data of the camera is replayed instead if the capture is replayed.
+func (lv *simLiveView) openStream() (accessUnitSource, error) {
+	cfg := lv.sim.cfg
+	if lv.replayChannel != 0 {
+		return newReplayStreamSource(cfg, lv.sim.faults, lv.replayChannel)
+	}
+	name := cfg.streamFile(lv.cameraType, CameraSource(lv.source.Load()))
+	if name != SimTestPatternStream {
+		f, err := os.Open(name)
+		if err == nil {
+			return newH264FileSource(f, cfg, lv.sim.faults), nil
+		}
+		if name != fakeEdgeStreamFileName || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		lv.sim.log(LogLevelInfo, "stream file %s doesn't exist, push the test pattern", name)
+	}
+	pattern, err := NewTestPattern(lv.quality)
+	if err != nil {
+		return nil, err
+	}
+	return &testPatternSource{pattern: pattern, faults: lv.sim.faults}, nil
+}
+
+func (lv *simLiveView) startStream() error {
+	stream, err := lv.openStream()
+	if err != nil {
+		return err
+	}
+	lv.reading.Store(true)
+	lv.stream = stream
+	lv.closeSig = make(chan bool)
+	lv.dataChan = make(chan []byte, 10)
+
+	lv.wg.Add(2)
+	go func() {
+		loopStreamAccessUnits(lv.stream, lv.sim.faults, lv.dataChan, lv.closeSig, lv.dropAccessUnit)
+		close(lv.dataChan)
+		lv.wg.Done()
+	}()
+	go func() {
+		lv.pushStreamData()
+		lv.wg.Done()
+	}()
+	return nil
+}
+
+// StopH264Stream stop receive live H264 stream
+func (lv *simLiveView) StopH264Stream() error {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.stopStream()
+	return nil
+}
+
+func (lv *simLiveView) stopStream() {
+	if !lv.reading.CompareAndSwap(true, false) {
+		return
+	}
+	close(lv.closeSig)
+	lv.wg.Wait()
+
+	_ = lv.stream.Close()
+	lv.stream = nil
+}
+
+// updateState reports the stream status of the SimulatorConfig.StatusScript periodically,
+// and immediately when the status changes.
+func (lv *simLiveView) updateState(closeSig <-chan bool) {
+	cfg := lv.sim.cfg
+	begin := time.Now()
+	ticker := time.NewTicker(cfg.StatusInterval)
+	defer ticker.Stop()
+
+	for {
+		value, next := cfg.statusAt(time.Since(begin))
+		if h := lv.receiver(); h != nil {
+			h.OnStreamStatusUpdate(newLiveStatus(value))
+		}
+
+		var timer *time.Timer
+		var change <-chan time.Time
+		if next >= 0 {
+			timer = time.NewTimer(next - time.Since(begin))
+			change = timer.C
+		}
+		select {
+		case <-ticker.C:
+		case <-change:
+		case <-closeSig:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-closeSig:
+			return
+		default:
+		}
+	}
+}
+
+func (lv *simLiveView) pushStreamData() {
+	for d := range lv.dataChan {
+		if h := lv.receiver(); h != nil {
+			lv.frames.push(d, lv.cameraType, CameraSource(lv.source.Load()))
+			h.OnReceiveStreamData(d)
+		}
+	}
+}
+
+// accessUnitSource provides the access units of the simulated stream
+type accessUnitSource interface {
+	// next returns the access unit presented at the time and the interval to the following one, nil at the end
+	next(at time.Time) ([]byte, time.Duration)
+	Close() error
+}
+
+// h264FileSource reads the access units from a h264 annex-b file,
+// the frame rate is taken from the timing info of the sps, or SimulatorConfig.FrameInterval if missing.
+// the file is restarted from the beginning at the end if SimulatorConfig.StreamLoop is true.
+type h264FileSource struct {
+	file     io.ReadSeekCloser
+	cfg      *SimulatorConfig
+	faults   *faultInjector
+	scanner  *bufio.Scanner
+	splitter h264.AccessUnitSplitter
+	interval time.Duration
+	eof      bool
+}
+
+func newH264FileSource(file io.ReadSeekCloser, cfg *SimulatorConfig, faults *faultInjector) *h264FileSource {
+	s := &h264FileSource{file: file, cfg: cfg, faults: faults, interval: cfg.FrameInterval}
+	s.resetScanner()
+	return s
+}
+
+func (s *h264FileSource) resetScanner() {
+	s.scanner = bufio.NewScanner(s.file)
+	s.scanner.Buffer(nil, 1024*1024*2)
+	s.scanner.Split(h264.ScanNALUnits)
+}
+
+func (s *h264FileSource) next(time.Time) ([]byte, time.Duration) {
+	for !s.eof {
+		if !s.scanner.Scan() {
+			au := s.splitter.Flush()
+			if !s.cfg.StreamLoop || s.scanner.Err() != nil {
+				s.eof = true
+			} else if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+				s.eof = true
+			} else {
+				s.resetScanner()
+			}
+			if len(au) > 0 {
+				return au, s.interval
+			}
+			continue
+		}
+		nalu := s.scanner.Bytes()
+		if h264.Type(nalu) == h264.NALSPS && !s.cfg.IgnoreStreamTiming {
+			if sps, err := h264.ParseSPS(nalu); err == nil && sps.FrameInterval() > 0 {
+				s.interval = sps.FrameInterval()
+			}
+		}
+		if au := s.splitter.Push(s.faults.truncate(nalu)); len(au) > 0 {
+			return au, s.interval
+		}
+	}
+	return nil, 0
+}
+
+func (s *h264FileSource) Close() error {
+	return s.file.Close()
+}
+
+// testPatternSource generates the access units by the TestPattern endlessly
+type testPatternSource struct {
+	pattern *TestPattern
+	faults  *faultInjector
+}
+
+func (s *testPatternSource) next(at time.Time) ([]byte, time.Duration) {
+	return s.faults.truncate(s.pattern.NextAccessUnit(at)), s.pattern.FrameInterval()
+}
+
+func (s *testPatternSource) Close() error {
+	return nil
+}
+
+// dropAccessUnit counts an access unit dropped, the first drop and every 100 drops are logged
+func (lv *simLiveView) dropAccessUnit() {
+	if n := lv.dropped.Add(1); n == 1 || n%100 == 0 {
+		lv.sim.log(LogLevelWarn, "the receiver of camera %v is slower than the stream, %d access units dropped", lv.cameraType, n)
+	}
+}
+
+// loopStreamAccessUnits sends the access units of the source to receiver at the presentation time,
+// the stream is stalled by the stream faults of the injector, and an access unit is dropped by drop if receiver is full.
+func loopStreamAccessUnits(source accessUnitSource, faults *faultInjector, receiver chan []byte, closeSig <-chan bool, drop func()) {
+	begin := time.Now()
+	next := begin
+	var last time.Duration
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		offset := time.Since(begin)
+		if d := faults.stall(last, offset); d > 0 {
+			next = next.Add(d)
+		}
+		last = offset
+
+		// restart the schedule if the stream falls behind too much
+		if now := time.Now(); now.Sub(next) > time.Second {
+			next = now
+		}
+		au, interval := source.next(next)
+		if au == nil {
+			return
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-closeSig:
+			return
+		}
+		next = next.Add(interval)
+
+		select {
+		case receiver <- au:
+		default:
+			drop()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// countingSource returns numbered access units at the interval
+type countingSource struct {
+	n        int
+	interval time.Duration
+}
+
+func (s *countingSource) next(time.Time) ([]byte, time.Duration) {
+	s.n++
+	return []byte{0, 0, 0, 1, 0x41, byte(s.n)}, s.interval
+}
+
+func (s *countingSource) Close() error {
+	return nil
+}
+
+func TestLoopStreamAccessUnitsDrop(t *testing.T) {
+	lv := newSimLiveView(NewSimulator())
+	receiver := make(chan []byte, 2)
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		loopStreamAccessUnits(&countingSource{interval: time.Millisecond}, newFaultInjector(nil, StreamFaults{}, 1),
+			receiver, closeSig, lv.dropAccessUnit)
+	}()
+	// the receiver doesn't read, the access units after the first two are dropped
+	deadline := time.Now().Add(5 * time.Second)
+	for lv.dropped.Load() < 10 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(closeSig)
+	<-done
+	if n := lv.dropped.Load(); n < 10 {
+		t.Fatalf("%d access units dropped", n)
+	}
+	if len(receiver) != 2 {
+		t.Fatalf("%d access units received, want 2", len(receiver))
+	}
+	for i := byte(1); i <= 2; i++ {
+		if au := <-receiver; au[len(au)-1] != i {
+			t.Fatalf("access unit %d received, want %d", au[len(au)-1], i)
+		}
+	}
+}
+
+// discardReceiver drops the stream data and the status
+type discardReceiver struct{}
+
+func (discardReceiver) OnStreamStatusUpdate(*LiveStatus) {}
+
+func (discardReceiver) OnReceiveStreamData([]byte) {}
+
+// the camera is read by StartH264Stream as soon as the live-view reports it is initialized,
+// run with -race to check the camera is set before that.
+func TestSimLiveViewInitRace(t *testing.T) {
+	cfg := DefaultSimulatorConfig()
+	cfg.InitDelay, cfg.StartDelay = 0, 0
+	sim, err := NewSimulatorWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.DeInitSDK()
+
+	lv := newSimLiveView(sim)
+	defer lv.DeInit()
+	started := make(chan error, 1)
+	go func() {
+		for !lv.cameraInitialized() {
+			runtime.Gosched()
+		}
+		if err := lv.StartH264Stream(); err != nil {
+			started <- err
+			return
+		}
+		started <- lv.StopH264Stream()
+	}()
+	if err := lv.Init(CameraTypePayload, StreamQuality540p, discardReceiver{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream is not started")
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// simMediaFileReader simulate the media file transfer connection with the dock,
+// the files are read from SimulatorConfig.MediaDir at the rate of SimulatorConfig.MediaBandwidth.
+type simMediaFileReader struct {
+	sim    *Simulator
+	status atomic.Int32 //0:closed  1:opening  2:opened 3:closing
+
+	mu          sync.Mutex
+	conn        int64 // the connection generation of the simulator when opened
+	transferred int64
+	nextHandle  fileHandle
+	files       map[fileHandle]*os.File
+}
+
+func newSimMediaFileReader(sim *Simulator) *simMediaFileReader {
+	r := &simMediaFileReader{sim: sim, files: map[fileHandle]*os.File{}}
+	runtime.SetFinalizer(r, (*simMediaFileReader).Destroy)
+	return r
+}
+
+func (m *simMediaFileReader) Destroy() {
+	runtime.SetFinalizer(m, nil)
+	m.status.Store(0)
+	m.mu.Lock()
+	m.closeFilesLocked()
+	m.mu.Unlock()
+}
+
+func (m *simMediaFileReader) closeFilesLocked() {
+	for fh, f := range m.files {
+		_ = f.Close()
+		delete(m.files, fh)
+	}
+}
+
+// Open establish a media file transfer connection with the dock.
+// note: This interface sets the dock's local media file strategy to not delete.
+func (m *simMediaFileReader) Open() error {
+	if !m.sim.Initialized() {
+		return ErrSDKNotInit
+	}
+	if !m.status.CompareAndSwap(0, 1) {
+		return errors.New("status abnormal")
+	}
+	if err := m.sim.injectFault(FaultReaderOpen); err != nil {
+		m.status.Store(0)
+		return err
+	}
+	m.mu.Lock()
+	m.conn = m.sim.mediaConn.Load()
+	m.transferred = 0
+	m.mu.Unlock()
+	m.sim.autoDelete.Store(false)
+	m.status.Store(2)
+	return nil
+}
+
+// Close disconnect the media file transfer connection with the dock.
+func (m *simMediaFileReader) Close() error {
+	if !m.status.CompareAndSwap(2, 0) {
+		return errors.New("status abnormal")
+	}
+	m.mu.Lock()
+	m.closeFilesLocked()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *simMediaFileReader) IsOpened() bool {
+	return m.status.Load() == 2
+}
+
+// checkConnLocked closes the reader if the connection is dropped, the error is of the call of op
+func (m *simMediaFileReader) checkConnLocked(op string, args ...any) error {
+	if m.conn == m.sim.mediaConn.Load() {
+		return nil
+	}
+	m.status.Store(0)
+	m.closeFilesLocked()
+	return wrapSDKError(op, ErrConnectFailure, args...)
+}
+
+// GetFileList gets the media file list from the most recent wayline mission.
+func (m *simMediaFileReader) GetFileList() ([]*MediaFileDesc, error) {
+	if !m.IsOpened() {
+		return nil, ErrFileReaderNotOpen
+	}
+	m.mu.Lock()
+	err := m.checkConnLocked("MediaFileReader.GetFileList")
+	m.mu.Unlock()
+	if err != nil || m.sim.media == nil {
+		return nil, err
+	}
+	return m.sim.media.list(), nil
+}
+
+// OpenFile returns an opened *MediaFile.
+// The parameter 'path' from MediaFileDesc.FilePath
+func (m *simMediaFileReader) OpenFile(path string) (*MediaFile, error) {
+	if !m.IsOpened() {
+		return nil, ErrFileReaderNotOpen
+	}
+	if err := m.sim.injectFault(FaultOpenFile, path); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnLocked("MediaFileReader.OpenFile", path); err != nil {
+		return nil, err
+	}
+	var f *os.File
+	ok := false
+	if m.sim.media != nil {
+		f, ok = m.sim.media.open(path)
+	}
+	if !ok {
+		return nil, &SDKError{Code: -1, Op: "MediaFileReader.OpenFile", Args: []any{path}, Err: ErrOpenFileFailure}
+	}
+	m.nextHandle++
+	m.files[m.nextHandle] = f
+	mf := &MediaFile{
+		handle: m.nextHandle,
+		reader: m,
+		path:   path,
+	}
+	mf.setup()
+	return mf, nil
+}
+
+func (m *simMediaFileReader) readFile(fh fileHandle, buf []byte) (int, error) {
+	if !m.IsOpened() {
+		return 0, ErrFileReaderNotOpen
+	}
+	if err := m.sim.injectFault(FaultReadFile, fh); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	if err := m.checkConnLocked("MediaFile.Read", fh); err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+	f := m.files[fh]
+	m.mu.Unlock()
+	if f == nil {
+		return 0, wrapSDKError("MediaFile.Read", ErrInvalidArgument, fh)
+	}
+
+	// read in small chunks when the bandwidth is limited, so the rate is smooth
+	if rate := m.sim.cfg.MediaBandwidth; rate > 0 && int64(len(buf)) > rate/10+1 {
+		buf = buf[:rate/10+1]
+	}
+	n, err := f.Read(buf)
+	m.sim.mediaLimiter.wait(n)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transferred += int64(n)
+	if every := m.sim.cfg.MediaDisconnectEvery; every > 0 && m.transferred >= every {
+		// the connection of this reader drops, the data read is lost
+		m.transferred = 0
+		m.conn = -1
+		m.sim.log(LogLevelWarn, "media file transfer disconnected")
+		return 0, m.checkConnLocked("MediaFile.Read", fh)
+	}
+	return n, err
+}
+
+func (m *simMediaFileReader) closeFile(fh fileHandle) error {
+	if !m.IsOpened() {
+		return ErrFileReaderNotOpen
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.files[fh]
+	if f == nil {
+		return wrapSDKError("MediaFile.Close", ErrInvalidArgument, fh)
+	}
+	delete(m.files, fh)
+	return f.Close()
+}
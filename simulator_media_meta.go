@@ -0,0 +1,485 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidMediaFile = errors.New("invalid media file")
+
+// mediaFileTypeOf returns the media type by the extension of the file name
+func mediaFileTypeOf(name string) (MediaFileType, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return MediaFileTypeJPEG, true
+	case ".mp4", ".mov":
+		return MediaFileTypeMP4, true
+	}
+	return 0, false
+}
+
+// cameraAttrOf returns the camera by the suffix of the DJI file name, such as DJI_20230701103000_0001_W.JPG
+func cameraAttrOf(name string) CameraAttr {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.LastIndexByte(name, '_'); i >= 0 {
+		switch strings.ToUpper(name[i+1:]) {
+		case "W":
+			return CameraAttrWide
+		case "Z":
+			return CameraAttrZoom
+		case "T":
+			return CameraAttrInfrared
+		}
+	}
+	return CameraAttrVisible
+}
+
+// readMediaFileDesc returns the description of a jpeg or mp4 file, the path is the FilePath of the description.
+// the fields are read from the EXIF and XMP of the jpeg, or the boxes of the mp4,
+// the description has the basic file info with the error if the metadata is invalid.
+func readMediaFileDesc(file, path string) (*MediaFileDesc, error) {
+	typ, ok := mediaFileTypeOf(file)
+	if !ok {
+		return nil, errInvalidMediaFile
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	desc := &MediaFileDesc{
+		FileName:   filepath.Base(file),
+		FilePath:   path,
+		FileSize:   uint64(info.Size()),
+		FileType:   typ,
+		CameraAttr: cameraAttrOf(file),
+		CreateTime: info.ModTime().Truncate(time.Second),
+	}
+	if typ == MediaFileTypeJPEG {
+		err = readJPEGMeta(bufio.NewReader(f), desc)
+	} else {
+		err = readMP4Meta(f, info.Size(), desc)
+	}
+	return desc, err
+}
+
+const (
+	jpegExifHeader = "Exif\x00\x00"
+	jpegXMPHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+)
+
+// readJPEGMeta reads the segments of the jpeg before the image data
+func readJPEGMeta(r io.Reader, desc *MediaFileDesc) error {
+	br := &byteReader{r: r}
+	if br.u16() != 0xffd8 {
+		return errInvalidMediaFile
+	}
+	var xmp []byte
+	for br.err == nil {
+		marker := br.u16()
+		if marker>>8 != 0xff {
+			return errInvalidMediaFile
+		}
+		if marker == 0xffd9 || marker == 0xffda {
+			break
+		}
+		if marker >= 0xffd0 && marker <= 0xffd7 || marker == 0xff01 {
+			continue
+		}
+		n := int(br.u16()) - 2
+		if n < 0 {
+			return errInvalidMediaFile
+		}
+		seg := br.bytes(n)
+		switch {
+		case marker == 0xffe1 && bytes.HasPrefix(seg, []byte(jpegExifHeader)):
+			parseExif(seg[len(jpegExifHeader):], desc)
+		case marker == 0xffe1 && bytes.HasPrefix(seg, []byte(jpegXMPHeader)):
+			xmp = seg[len(jpegXMPHeader):]
+		case marker >= 0xffc0 && marker <= 0xffcf && marker != 0xffc4 && marker != 0xffc8 && marker != 0xffcc:
+			if len(seg) >= 5 {
+				desc.ImageHeight = int(binary.BigEndian.Uint16(seg[1:]))
+				desc.ImageWidth = int(binary.BigEndian.Uint16(seg[3:]))
+			}
+		}
+	}
+	if br.err != nil {
+		return br.err
+	}
+	if xmp != nil {
+		parseDJIXMP(string(xmp), desc)
+	}
+	return nil
+}
+
+// exif tags used by the simulator
+const (
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+	exifTagPixelXDimension  = 0xa002
+	exifTagPixelYDimension  = 0xa003
+
+	gpsTagLatitudeRef  = 1
+	gpsTagLatitude     = 2
+	gpsTagLongitudeRef = 3
+	gpsTagLongitude    = 4
+	gpsTagAltitudeRef  = 5
+	gpsTagAltitude     = 6
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// tiffTypeSizes the size of the field types of the tiff entries
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifd returns the entries of the image file directory at the offset
+func (t *tiffReader) ifd(offset uint32) map[uint16]tiffEntry {
+	entries := map[uint16]tiffEntry{}
+	if int(offset)+2 > len(t.data) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < n; i++ {
+		p := int(offset) + 2 + i*12
+		if p+12 > len(t.data) {
+			break
+		}
+		e := tiffEntry{typ: t.order.Uint16(t.data[p+2:]), count: t.order.Uint32(t.data[p+4:])}
+		size := tiffTypeSizes[e.typ] * int(e.count)
+		if size <= 4 {
+			e.value = t.data[p+8 : p+8+size]
+		} else if off := int(t.order.Uint32(t.data[p+8:])); off >= 0 && off+size <= len(t.data) && size > 0 {
+			e.value = t.data[off : off+size]
+		}
+		entries[t.order.Uint16(t.data[p:])] = e
+	}
+	return entries
+}
+
+func (t *tiffReader) uint(e tiffEntry) (uint32, bool) {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return t.order.Uint32(e.value), true
+	case e.typ == 1 && len(e.value) >= 1:
+		return uint32(e.value[0]), true
+	}
+	return 0, false
+}
+
+func (t *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 && e.typ != 10 {
+		return nil
+	}
+	var ret []float64
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		num, den := t.order.Uint32(e.value[i:]), t.order.Uint32(e.value[i+4:])
+		if den == 0 {
+			ret = append(ret, 0)
+		} else if e.typ == 10 {
+			ret = append(ret, float64(int32(num))/float64(int32(den)))
+		} else {
+			ret = append(ret, float64(num)/float64(den))
+		}
+	}
+	return ret
+}
+
+func (t *tiffReader) string(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(e.value), "\x00 ")
+}
+
+// parseExif reads the gps, dimensions and create time of the tiff structure of the exif segment
+func parseExif(data []byte, desc *MediaFileDesc) {
+	if len(data) < 8 {
+		return
+	}
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return
+	}
+	ifd0 := t.ifd(t.order.Uint32(data[4:]))
+	if e, ok := ifd0[exifTagExifIFD]; ok {
+		if off, ok := t.uint(e); ok {
+			exif := t.ifd(off)
+			if ct, err := time.ParseInLocation(exifTimeLayout, t.string(exif[exifTagDateTimeOriginal]), time.Local); err == nil {
+				desc.CreateTime = ct
+			}
+			if w, ok := t.uint(exif[exifTagPixelXDimension]); ok {
+				desc.ImageWidth = int(w)
+			}
+			if h, ok := t.uint(exif[exifTagPixelYDimension]); ok {
+				desc.ImageHeight = int(h)
+			}
+		}
+	}
+	e, ok := ifd0[exifTagGPSIFD]
+	if !ok {
+		return
+	}
+	off, ok := t.uint(e)
+	if !ok {
+		return
+	}
+	gps := t.ifd(off)
+	degrees := func(tag uint16, negRef string, refTag uint16) (float64, bool) {
+		v := t.rationals(gps[tag])
+		if len(v) != 3 {
+			return 0, false
+		}
+		d := v[0] + v[1]/60 + v[2]/3600
+		if t.string(gps[refTag]) == negRef {
+			d = -d
+		}
+		return d, true
+	}
+	if lat, ok := degrees(gpsTagLatitude, "S", gpsTagLatitudeRef); ok {
+		desc.Latitude = lat
+	}
+	if lon, ok := degrees(gpsTagLongitude, "W", gpsTagLongitudeRef); ok {
+		desc.Longitude = lon
+	}
+	if alt := t.rationals(gps[gpsTagAltitude]); len(alt) == 1 {
+		desc.AbsoluteAltitude = alt[0]
+		if ref := gps[gpsTagAltitudeRef].value; len(ref) == 1 && ref[0] == 1 {
+			desc.AbsoluteAltitude = -alt[0]
+		}
+	}
+}
+
+var djiXMPField = regexp.MustCompile(`drone-dji:(\w+)(?:="([^"]*)"|>([^<]*)<)`)
+
+// parseDJIXMP reads the drone-dji fields of the xmp, they override the values of the exif
+func parseDJIXMP(xmp string, desc *MediaFileDesc) {
+	for _, m := range djiXMPField.FindAllStringSubmatch(xmp, -1) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(m[2]+m[3]), 64)
+		if err != nil {
+			continue
+		}
+		switch m[1] {
+		case "AbsoluteAltitude":
+			desc.AbsoluteAltitude = v
+		case "RelativeAltitude":
+			desc.RelativeAltitude = v
+		case "GimbalYawDegree":
+			desc.GimbalYawDegree = v
+		case "GpsLatitude":
+			desc.Latitude = v
+		case "GpsLongitude", "GpsLongtitude": // the typo is written by some firmware
+			desc.Longitude = v
+		}
+	}
+}
+
+var iso6709Location = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+// readMP4Meta reads the movie header, the track headers and the location of the user data of the mp4
+func readMP4Meta(r io.ReaderAt, size int64, desc *MediaFileDesc) error {
+	moov, ok := findMP4Box(r, 0, size, "moov")
+	if !ok {
+		return errInvalidMediaFile
+	}
+	data := make([]byte, moov.size)
+	if _, err := r.ReadAt(data, moov.offset); err != nil {
+		return err
+	}
+	for _, box := range mp4Children(data) {
+		switch box.typ {
+		case "mvhd":
+			parseMvhd(box.data, desc)
+		case "trak":
+			for _, b := range mp4Children(box.data) {
+				if b.typ == "tkhd" {
+					parseTkhd(b.data, desc)
+				}
+			}
+		case "udta":
+			for _, b := range mp4Children(box.data) {
+				if b.typ == "\xa9xyz" && len(b.data) > 4 {
+					parseISO6709(string(b.data[4:]), desc)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type mp4BoxRange struct {
+	offset, size int64
+}
+
+// findMP4Box finds the payload range of a box of the type in the range of the file
+func findMP4Box(r io.ReaderAt, offset, end int64, typ string) (mp4BoxRange, bool) {
+	head := make([]byte, 16)
+	for offset+8 <= end {
+		if _, err := r.ReadAt(head[:8], offset); err != nil {
+			return mp4BoxRange{}, false
+		}
+		size, hdr := int64(binary.BigEndian.Uint32(head)), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(head[8:], offset+8); err != nil {
+				return mp4BoxRange{}, false
+			}
+			size, hdr = int64(binary.BigEndian.Uint64(head[8:])), 16
+		}
+		if size < hdr || size > end-offset {
+			return mp4BoxRange{}, false
+		}
+		if string(head[4:8]) == typ {
+			return mp4BoxRange{offset: offset + hdr, size: size - hdr}, true
+		}
+		offset += size
+	}
+	return mp4BoxRange{}, false
+}
+
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// mp4Children splits the payload of a container box
+func mp4Children(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size, hdr := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size, hdr = binary.BigEndian.Uint64(data[8:]), 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < hdr || size > uint64(len(data)) {
+			break
+		}
+		boxes = append(boxes, mp4Box{typ: string(data[4:8]), data: data[hdr:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func parseMvhd(b []byte, desc *MediaFileDesc) {
+	var created, timescale, duration uint64
+	switch {
+	case len(b) >= 20 && b[0] == 0:
+		created = uint64(binary.BigEndian.Uint32(b[4:]))
+		timescale = uint64(binary.BigEndian.Uint32(b[12:]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:]))
+	case len(b) >= 32 && b[0] == 1:
+		created = binary.BigEndian.Uint64(b[4:])
+		timescale = uint64(binary.BigEndian.Uint32(b[20:]))
+		duration = binary.BigEndian.Uint64(b[24:])
+	default:
+		return
+	}
+	if created > 0 {
+		desc.CreateTime = mp4Epoch.Add(time.Duration(created) * time.Second).Local()
+	}
+	if timescale > 0 {
+		// the sdk reports the duration in seconds
+		desc.VideoDuration = time.Duration(duration/timescale) * time.Second
+	}
+}
+
+func parseTkhd(b []byte, desc *MediaFileDesc) {
+	// the width and height are the last 8 bytes in 16.16 fixed point
+	if len(b) < 84 || (b[0] == 1 && len(b) < 96) {
+		return
+	}
+	w := binary.BigEndian.Uint32(b[len(b)-8:]) >> 16
+	h := binary.BigEndian.Uint32(b[len(b)-4:]) >> 16
+	if w > 0 && h > 0 {
+		desc.ImageWidth, desc.ImageHeight = int(w), int(h)
+	}
+}
+
+// parseISO6709 reads a location like "+22.5760+113.9360+52.000/"
+func parseISO6709(s string, desc *MediaFileDesc) {
+	m := iso6709Location.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	desc.Latitude, _ = strconv.ParseFloat(m[1], 64)
+	desc.Longitude, _ = strconv.ParseFloat(m[2], 64)
+	if m[3] != "" {
+		desc.AbsoluteAltitude, _ = strconv.ParseFloat(m[3], 64)
+	}
+}
+
+// byteReader reads big endian values and remembers the first error
+type byteReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *byteReader) bytes(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(b.r, buf); err != nil {
+		b.err = err
+		return nil
+	}
+	return buf
+}
+
+func (b *byteReader) u16() uint16 {
+	buf := b.bytes(2)
+	if buf == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(buf)
+}
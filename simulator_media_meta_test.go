@@ -0,0 +1,401 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testMissionShot = &MissionShot{
+	Latitude:          22.576,
+	Longitude:         113.936,
+	AbsoluteAltitude:  52.5,
+	RelativeAltitude:  30.1,
+	FlightYawDegree:   -90,
+	GimbalYawDegree:   -89.5,
+	GimbalPitchDegree: -45,
+	Duration:          time.Second,
+}
+
+// checkMediaMeta compares the metadata fields of the descriptions, the coordinates within the tolerance
+func checkMediaMeta(t *testing.T, got, want *MediaFileDesc, tolerance float64) {
+	t.Helper()
+	near := func(a, b float64) bool { return math.Abs(a-b) <= tolerance }
+	if !near(got.Latitude, want.Latitude) || !near(got.Longitude, want.Longitude) ||
+		!near(got.AbsoluteAltitude, want.AbsoluteAltitude) || !near(got.RelativeAltitude, want.RelativeAltitude) ||
+		!near(got.GimbalYawDegree, want.GimbalYawDegree) {
+		t.Errorf("location %v,%v alt %v/%v yaw %v, want %v,%v alt %v/%v yaw %v",
+			got.Latitude, got.Longitude, got.AbsoluteAltitude, got.RelativeAltitude, got.GimbalYawDegree,
+			want.Latitude, want.Longitude, want.AbsoluteAltitude, want.RelativeAltitude, want.GimbalYawDegree)
+	}
+	if got.ImageWidth != want.ImageWidth || got.ImageHeight != want.ImageHeight {
+		t.Errorf("size %dx%d, want %dx%d", got.ImageWidth, got.ImageHeight, want.ImageWidth, want.ImageHeight)
+	}
+	if !got.CreateTime.Equal(want.CreateTime) {
+		t.Errorf("create time %v, want %v", got.CreateTime, want.CreateTime)
+	}
+	if got.VideoDuration != want.VideoDuration {
+		t.Errorf("duration %v, want %v", got.VideoDuration, want.VideoDuration)
+	}
+}
+
+// littleEndianGPSExif returns a little endian tiff with only the gps ifd, the values are whole rationals
+func littleEndianGPSExif(latRef, lonRef string, lat, lon [3]uint32, alt uint32, altRef byte) []byte {
+	le := binary.LittleEndian
+	b := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	entry := func(tag, typ uint16, count uint32, value []byte) {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		b = append(b, value...)
+		b = append(b, make([]byte, 4-len(value))...)
+	}
+	offset := func(v uint32) []byte { return le.AppendUint32(nil, v) }
+
+	b = le.AppendUint16(b, 1)
+	entry(exifTagGPSIFD, 4, 1, offset(26))
+	b = le.AppendUint32(b, 0)
+	// 6 entries, the rationals follow the ifd at 104
+	b = le.AppendUint16(b, 6)
+	entry(gpsTagLatitudeRef, 2, 2, []byte(latRef+"\x00"))
+	entry(gpsTagLatitude, 5, 3, offset(104))
+	entry(gpsTagLongitudeRef, 2, 2, []byte(lonRef+"\x00"))
+	entry(gpsTagLongitude, 5, 3, offset(128))
+	entry(gpsTagAltitudeRef, 1, 1, []byte{altRef})
+	entry(gpsTagAltitude, 5, 1, offset(152))
+	b = le.AppendUint32(b, 0)
+	for _, v := range [7]uint32{lat[0], lat[1], lat[2], lon[0], lon[1], lon[2], alt} {
+		b = le.AppendUint32(b, v)
+		b = le.AppendUint32(b, 1)
+	}
+	return b
+}
+
+func TestParseExif(t *testing.T) {
+	created := time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)
+	southWest := littleEndianGPSExif("S", "W", [3]uint32{22, 30, 0}, [3]uint32{113, 15, 36}, 50, 1)
+	badGPSOffset := littleEndianGPSExif("N", "E", [3]uint32{22, 30, 0}, [3]uint32{113, 15, 36}, 50, 0)
+	binary.LittleEndian.PutUint32(badGPSOffset[18:], 1<<20)
+	badValueOffset := littleEndianGPSExif("N", "E", [3]uint32{22, 30, 0}, [3]uint32{113, 15, 36}, 50, 0)
+	binary.LittleEndian.PutUint32(badValueOffset[26+2+12+8:], math.MaxUint32)
+	tests := []struct {
+		name string
+		data []byte
+		want MediaFileDesc
+	}{
+		{"big endian", buildExif(testMissionShot, created, 4000, 3000), MediaFileDesc{
+			Latitude: 22.576, Longitude: 113.936, AbsoluteAltitude: 52.5,
+			ImageWidth: 4000, ImageHeight: 3000, CreateTime: created,
+		}},
+		{"little endian south west", southWest, MediaFileDesc{Latitude: -22.5, Longitude: -113.26, AbsoluteAltitude: -50}},
+		{"little endian north east", littleEndianGPSExif("N", "E", [3]uint32{22, 30, 0}, [3]uint32{113, 15, 36}, 50, 0),
+			MediaFileDesc{Latitude: 22.5, Longitude: 113.26, AbsoluteAltitude: 50}},
+		{"gps ifd out of range", badGPSOffset, MediaFileDesc{}},
+		{"latitude out of range", badValueOffset, MediaFileDesc{Longitude: 113.26, AbsoluteAltitude: 50}},
+		{"truncated", buildExif(testMissionShot, created, 4000, 3000)[:40], MediaFileDesc{}},
+		{"unknown byte order", append([]byte("XX"), southWest[2:]...), MediaFileDesc{}},
+		{"short", []byte("II*\x00"), MediaFileDesc{}},
+		{"empty", nil, MediaFileDesc{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var desc MediaFileDesc
+			parseExif(tt.data, &desc)
+			checkMediaMeta(t, &desc, &tt.want, 1e-6)
+		})
+	}
+}
+
+func TestParseDJIXMP(t *testing.T) {
+	tests := []struct {
+		name string
+		xmp  string
+		want MediaFileDesc
+	}{
+		{"attributes", string(buildDJIXMP(testMissionShot)), MediaFileDesc{
+			Latitude: 22.576, Longitude: 113.936, AbsoluteAltitude: 52.5, RelativeAltitude: 30.1, GimbalYawDegree: -89.5,
+		}},
+		{"elements", `<drone-dji:GpsLatitude>-33.8688</drone-dji:GpsLatitude>` +
+			`<drone-dji:GpsLongitude> 151.2093 </drone-dji:GpsLongitude>`,
+			MediaFileDesc{Latitude: -33.8688, Longitude: 151.2093}},
+		{"longitude typo", `drone-dji:GpsLongtitude="-70.5"`, MediaFileDesc{Longitude: -70.5}},
+		{"invalid number", `drone-dji:AbsoluteAltitude="abc" drone-dji:RelativeAltitude="+12.5"`,
+			MediaFileDesc{RelativeAltitude: 12.5}},
+		{"unknown field", `drone-dji:FlightRollDegree="+1.00"`, MediaFileDesc{}},
+		{"no fields", `<x:xmpmeta/>`, MediaFileDesc{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var desc MediaFileDesc
+			parseDJIXMP(tt.xmp, &desc)
+			checkMediaMeta(t, &desc, &tt.want, 1e-9)
+		})
+	}
+}
+
+func TestReadJPEGMeta(t *testing.T) {
+	created := time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)
+	photo, err := buildMissionPhoto(&MissionConfig{ImageWidth: 64, ImageHeight: 48}, testMissionShot, CameraAttrWide, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, image.NewGray(image.Rect(0, 0, 32, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    MediaFileDesc
+		wantErr bool
+	}{
+		{"mission photo", photo, MediaFileDesc{
+			Latitude: 22.576, Longitude: 113.936, AbsoluteAltitude: 52.5, RelativeAltitude: 30.1, GimbalYawDegree: -89.5,
+			ImageWidth: 64, ImageHeight: 48, CreateTime: created,
+		}, false},
+		{"frame header only", plain.Bytes(), MediaFileDesc{ImageWidth: 32, ImageHeight: 16}, false},
+		{"not a jpeg", []byte("GIF89a"), MediaFileDesc{}, true},
+		{"empty", nil, MediaFileDesc{}, true},
+		{"truncated segment", photo[:100], MediaFileDesc{}, true},
+		{"bad segment length", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01}, MediaFileDesc{}, true},
+		{"bad marker", []byte{0xff, 0xd8, 0x00, 0x11}, MediaFileDesc{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var desc MediaFileDesc
+			err := readJPEGMeta(bytes.NewReader(tt.data), &desc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				checkMediaMeta(t, &desc, &tt.want, 1e-6)
+			}
+		})
+	}
+}
+
+// testMP4Box returns a box with a 32 bit size
+func testMP4Box(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, typ...), data...)
+}
+
+func TestReadMP4Meta(t *testing.T) {
+	created := time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)
+	clip, err := buildMissionClip(testMissionShot, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := NewTestPattern(StreamQuality540p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, height := pattern.Size()
+
+	ftyp := testMP4Box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	// version 0 mvhd: created, modified, timescale 1000 and 90.5s
+	mvhd := testMP4Box("mvhd", []byte{0, 0, 0, 0},
+		binary.BigEndian.AppendUint32(nil, uint32(created.Sub(mp4Epoch)/time.Second)), make([]byte, 4),
+		binary.BigEndian.AppendUint32(nil, 1000), binary.BigEndian.AppendUint32(nil, 90500))
+	// the 64 bit size of the moov box overflows the offset
+	overflow := append(append([]byte{}, ftyp...), 0, 0, 0, 1, 'm', 'o', 'o', 'v')
+	overflow = binary.BigEndian.AppendUint64(overflow, math.MaxInt64-4)
+	overflow = append(overflow, mvhd...)
+	negative := append(append([]byte{}, ftyp...), 0, 0, 0, 1, 'm', 'o', 'o', 'v')
+	negative = binary.BigEndian.AppendUint64(negative, math.MaxUint64)
+	pastEnd := append(append([]byte{}, ftyp...), testMP4Box("moov", mvhd)...)
+	binary.BigEndian.PutUint32(pastEnd[len(ftyp):], 1000)
+	toEnd := append(append([]byte{}, ftyp...), testMP4Box("moov", mvhd)...)
+	binary.BigEndian.PutUint32(toEnd[len(ftyp):], 0)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    MediaFileDesc
+		wantErr bool
+	}{
+		{"mission clip", clip, MediaFileDesc{
+			Latitude: 22.576, Longitude: 113.936, AbsoluteAltitude: 52.5,
+			ImageWidth: width, ImageHeight: height, CreateTime: created, VideoDuration: time.Second,
+		}, false},
+		{"movie header", append(append([]byte{}, ftyp...), testMP4Box("moov", mvhd)...),
+			MediaFileDesc{CreateTime: created, VideoDuration: 90 * time.Second}, false},
+		{"moov to the end of file", toEnd, MediaFileDesc{CreateTime: created, VideoDuration: 90 * time.Second}, false},
+		{"truncated movie header", append(append([]byte{}, ftyp...), testMP4Box("moov", mvhd[:20])...),
+			MediaFileDesc{}, false},
+		{"no moov", ftyp, MediaFileDesc{}, true},
+		{"overflowing box size", overflow, MediaFileDesc{}, true},
+		{"negative box size", negative, MediaFileDesc{}, true},
+		{"box past the end", pastEnd, MediaFileDesc{}, true},
+		{"empty", nil, MediaFileDesc{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var desc MediaFileDesc
+			err := readMP4Meta(bytes.NewReader(tt.data), int64(len(tt.data)), &desc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				checkMediaMeta(t, &desc, &tt.want, 1e-4)
+			}
+		})
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	tests := []struct {
+		in   string
+		want MediaFileDesc
+	}{
+		{"+22.5760+113.9360+52.000/", MediaFileDesc{Latitude: 22.576, Longitude: 113.936, AbsoluteAltitude: 52}},
+		{"-33.8688+151.2093/", MediaFileDesc{Latitude: -33.8688, Longitude: 151.2093}},
+		{"+40-074/", MediaFileDesc{Latitude: 40, Longitude: -74}},
+		{"+22.5/", MediaFileDesc{}},
+		{"22.5+113.9/", MediaFileDesc{}},
+		{"", MediaFileDesc{}},
+	}
+	for _, tt := range tests {
+		var desc MediaFileDesc
+		parseISO6709(tt.in, &desc)
+		if desc.Latitude != tt.want.Latitude || desc.Longitude != tt.want.Longitude ||
+			desc.AbsoluteAltitude != tt.want.AbsoluteAltitude {
+			t.Errorf("parseISO6709(%q) = %v,%v,%v, want %v,%v,%v", tt.in, desc.Latitude, desc.Longitude,
+				desc.AbsoluteAltitude, tt.want.Latitude, tt.want.Longitude, tt.want.AbsoluteAltitude)
+		}
+	}
+}
+
+func TestMediaFileNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    MediaFileType
+		ok     bool
+		camera CameraAttr
+	}{
+		{"DJI_20230701103000_0001_W.JPG", MediaFileTypeJPEG, true, CameraAttrWide},
+		{"DJI_20230701103000_0001_z.jpeg", MediaFileTypeJPEG, true, CameraAttrZoom},
+		{"DJI_20230701103000_0001_T.MP4", MediaFileTypeMP4, true, CameraAttrInfrared},
+		{"DJI_20230701103000_0001_V.mov", MediaFileTypeMP4, true, CameraAttrVisible},
+		{"clip.mp4", MediaFileTypeMP4, true, CameraAttrVisible},
+		{"notes_W.txt", 0, false, CameraAttrWide},
+		{"W", 0, false, CameraAttrVisible},
+	}
+	for _, tt := range tests {
+		typ, ok := mediaFileTypeOf(tt.name)
+		if typ != tt.typ || ok != tt.ok {
+			t.Errorf("mediaFileTypeOf(%q) = %v,%v, want %v,%v", tt.name, typ, ok, tt.typ, tt.ok)
+		}
+		if camera := cameraAttrOf(tt.name); camera != tt.camera {
+			t.Errorf("cameraAttrOf(%q) = %v, want %v", tt.name, camera, tt.camera)
+		}
+	}
+}
+
+func TestReadMediaFileDesc(t *testing.T) {
+	created := time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)
+	photo, err := buildMissionPhoto(&MissionConfig{ImageWidth: 64, ImageHeight: 48}, testMissionShot, CameraAttrInfrared, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "DJI_20230701103000_0001_T.JPG")
+	if err := os.WriteFile(file, photo, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := readMediaFileDesc(file, "mission/DJI_20230701103000_0001_T.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.FileName != "DJI_20230701103000_0001_T.JPG" || desc.FilePath != "mission/DJI_20230701103000_0001_T.JPG" ||
+		desc.FileSize != uint64(len(photo)) || desc.FileType != MediaFileTypeJPEG || desc.CameraAttr != CameraAttrInfrared {
+		t.Errorf("desc %+v", desc)
+	}
+	checkMediaMeta(t, desc, &MediaFileDesc{
+		Latitude: 22.576, Longitude: 113.936, AbsoluteAltitude: 52.5, RelativeAltitude: 30.1, GimbalYawDegree: -89.5,
+		ImageWidth: 640, ImageHeight: 512, CreateTime: created,
+	}, 1e-6)
+
+	// the basic file info is returned with the error of the metadata
+	broken := filepath.Join(dir, "broken.mp4")
+	if err := os.WriteFile(broken, []byte("not a mp4 file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	desc, err = readMediaFileDesc(broken, "broken.mp4")
+	if err == nil || desc == nil || desc.FileSize != 14 || desc.FileType != MediaFileTypeMP4 {
+		t.Errorf("broken file: %+v, %v", desc, err)
+	}
+	if desc, err := readMediaFileDesc(filepath.Join(dir, "missing.jpg"), "missing.jpg"); desc != nil || err == nil {
+		t.Errorf("missing file: %+v, %v", desc, err)
+	}
+	if desc, err := readMediaFileDesc(filepath.Join(dir, "notes.txt"), "notes.txt"); desc != nil || err != errInvalidMediaFile {
+		t.Errorf("unknown type: %+v, %v", desc, err)
+	}
+}
+
+func FuzzReadJPEGMeta(f *testing.F) {
+	photo, err := buildMissionPhoto(&MissionConfig{ImageWidth: 16, ImageHeight: 16}, testMissionShot, CameraAttrWide, time.Unix(1688178600, 0))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(photo)
+	f.Add([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x08, 'E', 'x', 'i', 'f', 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var desc MediaFileDesc
+		_ = readJPEGMeta(bytes.NewReader(data), &desc)
+	})
+}
+
+func FuzzParseExif(f *testing.F) {
+	f.Add(buildExif(testMissionShot, time.Unix(1688178600, 0), 640, 480))
+	f.Add(littleEndianGPSExif("S", "W", [3]uint32{22, 30, 0}, [3]uint32{113, 15, 36}, 50, 1))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var desc MediaFileDesc
+		parseExif(data, &desc)
+	})
+}
+
+func FuzzParseDJIXMP(f *testing.F) {
+	f.Add(string(buildDJIXMP(testMissionShot)))
+	f.Add(`<drone-dji:GpsLatitude>1e400</drone-dji:GpsLatitude>`)
+	f.Fuzz(func(t *testing.T, xmp string) {
+		var desc MediaFileDesc
+		parseDJIXMP(xmp, &desc)
+	})
+}
+
+func FuzzReadMP4Meta(f *testing.F) {
+	clip, err := buildMissionClip(&MissionShot{Duration: 200 * time.Millisecond}, time.Unix(1688178600, 0))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(clip)
+	f.Add(append(testMP4Box("ftyp"), 0, 0, 0, 1, 'm', 'o', 'o', 'v', 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var desc MediaFileDesc
+		_ = readMP4Meta(bytes.NewReader(data), int64(len(data)), &desc)
+	})
+}
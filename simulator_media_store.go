@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type simMediaEntry struct {
+	desc    *MediaFileDesc // nil until the file is stable
+	size    int64
+	modTime time.Time
+}
+
+// simMediaStore is the media storage of the simulated dock backed by SimulatorConfig.MediaDir.
+//
+// The directory is scanned every SimulatorConfig.MediaPollInterval while the sdk is initialized,
+// a new file is published when its size and modification time are unchanged for a scan,
+// so a file being copied is not reported half written.
+// The files existing at the initialization are published without notifying the observer.
+type simMediaStore struct {
+	sim *Simulator
+	dir string
+
+	mu       sync.Mutex
+	files    map[string]*simMediaEntry // by MediaFileDesc.FilePath
+	closeSig chan struct{}
+	done     chan struct{}
+}
+
+func newSimMediaStore(sim *Simulator, dir string) *simMediaStore {
+	return &simMediaStore{sim: sim, dir: dir, files: map[string]*simMediaEntry{}}
+}
+
+// start scans the directory and starts watching it
+func (m *simMediaStore) start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closeSig != nil {
+		return
+	}
+	m.files = map[string]*simMediaEntry{}
+	m.scanLocked(false)
+	for path, e := range m.files {
+		m.publishLocked(path, e)
+	}
+	m.closeSig = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.watch(m.closeSig, m.done)
+}
+
+// stop stops watching the directory
+func (m *simMediaStore) stop() {
+	m.mu.Lock()
+	closeSig, done := m.closeSig, m.done
+	m.closeSig, m.done = nil, nil
+	m.mu.Unlock()
+	if closeSig != nil {
+		close(closeSig)
+		<-done
+	}
+}
+
+func (m *simMediaStore) watch(closeSig <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(m.sim.cfg.MediaPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-closeSig:
+			return
+		}
+		m.mu.Lock()
+		added := m.scanLocked(true)
+		m.mu.Unlock()
+		for _, desc := range added {
+			m.sim.notifyMediaFile(desc)
+		}
+	}
+}
+
+// scanLocked updates the entries by the directory, returns the published files if publish is true
+func (m *simMediaStore) scanLocked(publish bool) []*MediaFileDesc {
+	var added []*MediaFileDesc
+	seen := map[string]bool{}
+	err := filepath.WalkDir(m.dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if _, ok := mediaFileTypeOf(file); !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(m.dir, file)
+		if err != nil {
+			return nil
+		}
+		path := filepath.ToSlash(rel)
+		seen[path] = true
+
+		e, ok := m.files[path]
+		switch {
+		case !ok:
+			m.files[path] = &simMediaEntry{size: info.Size(), modTime: info.ModTime()}
+		case e.size != info.Size() || !e.modTime.Equal(info.ModTime()):
+			e.size, e.modTime = info.Size(), info.ModTime()
+			if e.desc != nil {
+				// the published file is rewritten, read the metadata again
+				m.publishLocked(path, e)
+			}
+		case e.desc == nil && publish:
+			if desc := m.publishLocked(path, e); desc != nil {
+				added = append(added, desc)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		m.sim.log(LogLevelWarn, "scan media dir %s fail:%v", m.dir, err)
+	}
+	for path := range m.files {
+		if !seen[path] {
+			delete(m.files, path)
+		}
+	}
+	return added
+}
+
+// publishLocked reads the metadata of the entry
+func (m *simMediaStore) publishLocked(path string, e *simMediaEntry) *MediaFileDesc {
+	desc, err := readMediaFileDesc(filepath.Join(m.dir, filepath.FromSlash(path)), path)
+	if desc == nil {
+		m.sim.log(LogLevelWarn, "read media file %s fail:%v", path, err)
+		return nil
+	}
+	if err != nil {
+		m.sim.log(LogLevelWarn, "read metadata of media file %s fail:%v", path, err)
+	}
+	e.desc = desc
+	cp := *desc
+	return &cp
+}
+
+// publish adds a file written to the directory without waiting for the scan, returns nil if it is invalid
+func (m *simMediaStore) publish(path string) *MediaFileDesc {
+	info, err := os.Stat(filepath.Join(m.dir, filepath.FromSlash(path)))
+	if err != nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &simMediaEntry{size: info.Size(), modTime: info.ModTime()}
+	m.files[path] = e
+	return m.publishLocked(path, e)
+}
+
+// list returns the published files ordered by the create time
+func (m *simMediaStore) list() []*MediaFileDesc {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []*MediaFileDesc
+	for _, e := range m.files {
+		if e.desc != nil {
+			desc := *e.desc
+			ret = append(ret, &desc)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].CreateTime.Equal(ret[j].CreateTime) {
+			return ret[i].CreateTime.Before(ret[j].CreateTime)
+		}
+		return ret[i].FilePath < ret[j].FilePath
+	})
+	return ret
+}
+
+// open opens a published file by MediaFileDesc.FilePath
+func (m *simMediaStore) open(path string) (*os.File, bool) {
+	m.mu.Lock()
+	e, ok := m.files[path]
+	ok = ok && e.desc != nil
+	m.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(filepath.Join(m.dir, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// rateLimiter delays the transfer to the bytes per second
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+// wait blocks until n bytes can be transferred
+func (l *rateLimiter) wait(n int) {
+	if l.rate <= 0 || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	d := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(d)
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestMediaSimulator(t *testing.T, poll time.Duration) *Simulator {
+	t.Helper()
+	cfg := DefaultSimulatorConfig()
+	cfg.MediaDir = t.TempDir()
+	cfg.MediaPollInterval = poll
+	sim, err := NewSimulatorWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+// writeTestPhoto writes a mission photo taken at the time to the path of the media dir
+func writeTestPhoto(t *testing.T, dir, path string, created time.Time, shot *MissionShot) {
+	t.Helper()
+	photo, err := buildMissionPhoto(&MissionConfig{ImageWidth: 32, ImageHeight: 24}, shot, cameraAttrOf(path), created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, photo, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scanTestMediaStore(m *simMediaStore) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var paths []string
+	for _, desc := range m.scanLocked(true) {
+		paths = append(paths, desc.FilePath)
+	}
+	return paths
+}
+
+func listTestMediaStore(m *simMediaStore) []string {
+	var paths []string
+	for _, desc := range m.list() {
+		paths = append(paths, desc.FilePath)
+	}
+	return paths
+}
+
+func TestSimMediaStoreScan(t *testing.T) {
+	sim := newTestMediaSimulator(t, time.Hour)
+	m, dir := sim.media, sim.cfg.MediaDir
+	base := time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)
+
+	writeTestPhoto(t, dir, "DJI_0002_W.JPG", base.Add(time.Minute), testMissionShot)
+	writeTestPhoto(t, dir, "mission/DJI_0001_Z.JPG", base, testMissionShot)
+	writeTestPhoto(t, dir, "mission/DJI_0003_W.JPG", base.Add(time.Minute), testMissionShot)
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not media"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new file is published when it is unchanged for one scan
+	if added := scanTestMediaStore(m); len(added) != 0 {
+		t.Fatalf("first scan published %v", added)
+	}
+	if list := m.list(); len(list) != 0 {
+		t.Fatalf("unstable files listed: %v", listTestMediaStore(m))
+	}
+	if _, ok := m.open("DJI_0002_W.JPG"); ok {
+		t.Fatal("unstable file opened")
+	}
+	if added := scanTestMediaStore(m); len(added) != 3 {
+		t.Fatalf("second scan published %v", added)
+	}
+	if added := scanTestMediaStore(m); len(added) != 0 {
+		t.Fatalf("stable files published again: %v", added)
+	}
+
+	// sorted by the create time of the exif, then the path
+	want := []string{"mission/DJI_0001_Z.JPG", "DJI_0002_W.JPG", "mission/DJI_0003_W.JPG"}
+	if got := listTestMediaStore(m); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("list %v, want %v", got, want)
+	}
+	f, ok := m.open("mission/DJI_0001_Z.JPG")
+	if !ok {
+		t.Fatal("open published file fail")
+	}
+	_ = f.Close()
+	if _, ok := m.open("notes.txt"); ok {
+		t.Fatal("opened a file which is not media")
+	}
+
+	// the metadata of a rewritten file is read again without a notification
+	moved := *testMissionShot
+	moved.Latitude = -10
+	writeTestPhoto(t, dir, "DJI_0002_W.JPG", base.Add(time.Minute), &moved)
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "DJI_0002_W.JPG"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if added := scanTestMediaStore(m); len(added) != 0 {
+		t.Fatalf("rewritten file notified: %v", added)
+	}
+	for _, desc := range m.list() {
+		if desc.FilePath == "DJI_0002_W.JPG" && desc.Latitude != -10 {
+			t.Fatalf("latitude %v of the rewritten file", desc.Latitude)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "mission/DJI_0001_Z.JPG")); err != nil {
+		t.Fatal(err)
+	}
+	scanTestMediaStore(m)
+	if got := listTestMediaStore(m); len(got) != 2 || got[0] != "DJI_0002_W.JPG" {
+		t.Fatalf("list after removal %v", got)
+	}
+	if _, ok := m.open("mission/DJI_0001_Z.JPG"); ok {
+		t.Fatal("removed file opened")
+	}
+}
+
+func TestSimMediaStoreWatch(t *testing.T) {
+	sim := newTestMediaSimulator(t, 10*time.Millisecond)
+	dir := sim.cfg.MediaDir
+	created := time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)
+	writeTestPhoto(t, dir, "DJI_0001_W.JPG", created, testMissionShot)
+
+	notified := make(chan *MediaFileDesc, 4)
+	_ = sim.RegisterMediaFilesObserver(func(desc *MediaFileDesc) { notified <- desc })
+	sim.media.start()
+	defer sim.media.stop()
+
+	// the existing files are published by the start without notifications
+	if got := listTestMediaStore(sim.media); len(got) != 1 || got[0] != "DJI_0001_W.JPG" {
+		t.Fatalf("list %v", got)
+	}
+	writeTestPhoto(t, dir, "DJI_0002_Z.JPG", created.Add(time.Second), testMissionShot)
+	select {
+	case desc := <-notified:
+		if desc.FilePath != "DJI_0002_Z.JPG" || desc.CameraAttr != CameraAttrZoom || desc.ImageWidth != 32 {
+			t.Fatalf("notified %+v", desc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new file is not notified")
+	}
+	select {
+	case desc := <-notified:
+		t.Fatalf("notified again: %+v", desc)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sim.media.stop()
+	writeTestPhoto(t, dir, "DJI_0003_Z.JPG", created.Add(2*time.Second), testMissionShot)
+	select {
+	case desc := <-notified:
+		t.Fatalf("notified after stop: %+v", desc)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int64
+		reads []int
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"unlimited", 0, []int{1 << 30}, 0, 50 * time.Millisecond},
+		{"empty read", 1, []int{0, -1}, 0, 50 * time.Millisecond},
+		{"one read", 1 << 20, []int{1 << 18}, 240 * time.Millisecond, time.Second},
+		{"reads accumulate", 1 << 20, []int{1 << 17, 1 << 17, 1 << 17, 1 << 17}, 480 * time.Millisecond, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &rateLimiter{rate: tt.rate}
+			start := time.Now()
+			for _, n := range tt.reads {
+				l.wait(n)
+			}
+			if d := time.Since(start); d < tt.min || d > tt.max {
+				t.Fatalf("waited %v, want [%v,%v]", d, tt.min, tt.max)
+			}
+		})
+	}
+
+	// the idle time is not saved for the later reads
+	l := &rateLimiter{rate: 1 << 20}
+	l.wait(1 << 16)
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	l.wait(1 << 18)
+	if d := time.Since(start); d < 240*time.Millisecond {
+		t.Fatalf("waited %v after idle", d)
+	}
+}
@@ -0,0 +1,394 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MissionPattern the flight route of a simulated wayline mission
+type MissionPattern string
+
+const (
+	// MissionLawnmower surveys a rectangle by parallel east-west lines, the camera points down
+	MissionLawnmower MissionPattern = "lawnmower"
+	// MissionOrbit flies a circle around the center clockwise, the camera points to the center
+	MissionOrbit MissionPattern = "orbit"
+)
+
+// MissionConfig describes a simulated wayline mission, the zero fields take the default values.
+type MissionConfig struct {
+	Pattern MissionPattern
+	// Name the sub directory of the media files, empty means the media directory itself
+	Name string
+	// Latitude and Longitude the center of the survey area or the orbit
+	Latitude  float64
+	Longitude float64
+	// TakeoffAltitude the absolute altitude of the takeoff point, the absolute altitude of the media files
+	// is TakeoffAltitude + Altitude.
+	TakeoffAltitude float64
+	// Altitude the flight altitude relative to the takeoff point, default 100m
+	Altitude float64
+	// Speed the flight speed in m/s, default 10m/s
+	Speed float64
+	// PhotoSpacing the distance between two photos in meters, default 20m
+	PhotoSpacing float64
+
+	// Width and Height the east-west and north-south size of the lawnmower area in meters, default 200m
+	Width  float64
+	Height float64
+	// LineSpacing the distance between the lawnmower lines in meters, default 40m
+	LineSpacing float64
+	// Radius the radius of the orbit in meters, default 50m
+	Radius float64
+
+	// Cameras takes a photo of every camera at each capture point, default the wide camera, a camera is listed once
+	Cameras []CameraAttr
+	// ImageWidth and ImageHeight the size of the jpeg files, default 640x480
+	ImageWidth  int
+	ImageHeight int
+	// VideoInterval records a clip every the distance in meters, 0 means no video
+	VideoInterval float64
+	// VideoDuration the duration of the clips, default 3s
+	VideoDuration time.Duration
+
+	// StartTime the take off time, default now
+	StartTime time.Time
+	// Speedup runs the mission faster than the real time by Simulator.RunMission, default 1
+	Speedup float64
+}
+
+// maxMissionShots limits the capture points of a mission, a small spacing of a large area would never end
+const maxMissionShots = 10000
+
+// MissionShot is a capture point of the mission
+type MissionShot struct {
+	// Index the number of the media files, starts from 1
+	Index int
+	// Offset from the start of the mission, the media files are produced at Offset + Duration
+	Offset   time.Duration
+	Duration time.Duration
+	// Video whether the shot is a video clip
+	Video bool
+
+	Latitude          float64
+	Longitude         float64
+	AbsoluteAltitude  float64
+	RelativeAltitude  float64
+	FlightYawDegree   float64
+	GimbalYawDegree   float64
+	GimbalPitchDegree float64
+}
+
+func (c MissionConfig) withDefaults() *MissionConfig {
+	if c.Pattern == "" {
+		c.Pattern = MissionLawnmower
+	}
+	setDefault := func(v *float64, d float64) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	setDefault(&c.Altitude, 100)
+	setDefault(&c.Speed, 10)
+	setDefault(&c.PhotoSpacing, 20)
+	setDefault(&c.Width, 200)
+	setDefault(&c.Height, 200)
+	setDefault(&c.LineSpacing, 40)
+	setDefault(&c.Radius, 50)
+	setDefault(&c.Speedup, 1)
+	if len(c.Cameras) == 0 {
+		c.Cameras = []CameraAttr{CameraAttrWide}
+	}
+	if c.ImageWidth == 0 || c.ImageHeight == 0 {
+		c.ImageWidth, c.ImageHeight = 640, 480
+	}
+	if c.VideoDuration == 0 {
+		c.VideoDuration = 3 * time.Second
+	}
+	if c.StartTime.IsZero() {
+		c.StartTime = time.Now()
+	}
+	c.StartTime = c.StartTime.Truncate(time.Second)
+	return &c
+}
+
+func (c *MissionConfig) validate() error {
+	if c.Pattern != MissionLawnmower && c.Pattern != MissionOrbit {
+		return fmt.Errorf("mission: invalid pattern %q", c.Pattern)
+	}
+	if c.Latitude < -90 || c.Latitude > 90 || c.Longitude < -180 || c.Longitude > 180 {
+		return errors.New("mission: invalid center")
+	}
+	if c.Speed < 0 || c.PhotoSpacing < 0 || c.Width < 0 || c.Height < 0 || c.LineSpacing < 0 ||
+		c.Radius < 0 || c.VideoInterval < 0 || c.VideoDuration < 0 || c.Speedup < 0 {
+		return errors.New("mission: distance, speed and duration must be positive")
+	}
+	seen := map[CameraAttr]bool{}
+	for _, camera := range c.Cameras {
+		if !camera.IsValid() {
+			return fmt.Errorf("mission: invalid camera %v", camera)
+		}
+		// the photos of the same camera would have the same file name
+		if seen[camera] {
+			return fmt.Errorf("mission: duplicate camera %v", camera)
+		}
+		seen[camera] = true
+	}
+	var points float64
+	if c.Pattern == MissionLawnmower {
+		points = (math.Floor(c.Height/c.LineSpacing) + 1) * (math.Floor(c.Width/c.PhotoSpacing) + 1)
+	} else {
+		points = math.Ceil(2 * math.Pi * c.Radius / math.Min(c.PhotoSpacing, c.Radius))
+	}
+	if !(points <= maxMissionShots) {
+		return fmt.Errorf("mission: more than %d capture points, increase the spacing", maxMissionShots)
+	}
+	if c.ImageWidth < 0 || c.ImageHeight < 0 || c.ImageWidth > 8192 || c.ImageHeight > 8192 {
+		return errors.New("mission: invalid image size")
+	}
+	if c.Name != "" && (filepath.IsAbs(c.Name) || !filepath.IsLocal(c.Name)) {
+		return fmt.Errorf("mission: invalid name %q", c.Name)
+	}
+	return nil
+}
+
+// Plan returns the capture points of the mission ordered by the time, at most 10000 points
+func (c *MissionConfig) Plan() ([]MissionShot, error) {
+	cfg := c.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	// the route is a polyline of the offsets from the center in meters
+	type point struct{ east, north float64 }
+	var route []point
+	if cfg.Pattern == MissionLawnmower {
+		lines := int(cfg.Height/cfg.LineSpacing) + 1
+		for i := 0; i < lines; i++ {
+			north := -cfg.Height/2 + float64(i)*cfg.LineSpacing
+			west, east := point{-cfg.Width / 2, north}, point{cfg.Width / 2, north}
+			if i%2 == 0 {
+				route = append(route, west, east)
+			} else {
+				route = append(route, east, west)
+			}
+		}
+	} else {
+		n := int(math.Ceil(2 * math.Pi * cfg.Radius / math.Min(cfg.PhotoSpacing, cfg.Radius)))
+		for i := 0; i <= n; i++ {
+			a := 2 * math.Pi * float64(i) / float64(n)
+			route = append(route, point{cfg.Radius * math.Sin(a), cfg.Radius * math.Cos(a)})
+		}
+	}
+
+	var shots []MissionShot
+	var dist, nextPhoto, nextVideo float64
+	add := func(p point, heading float64, video bool) {
+		shot := MissionShot{
+			Index:            len(shots) + 1,
+			Offset:           time.Duration(dist / cfg.Speed * float64(time.Second)),
+			Video:            video,
+			RelativeAltitude: cfg.Altitude,
+			AbsoluteAltitude: cfg.TakeoffAltitude + cfg.Altitude,
+			FlightYawDegree:  normalizeYaw(heading),
+		}
+		shot.Latitude, shot.Longitude = offsetLatLon(cfg.Latitude, cfg.Longitude, p.east, p.north)
+		if video {
+			shot.Duration = cfg.VideoDuration
+		}
+		if cfg.Pattern == MissionLawnmower {
+			shot.GimbalYawDegree, shot.GimbalPitchDegree = shot.FlightYawDegree, -90
+		} else {
+			shot.GimbalYawDegree = normalizeYaw(math.Atan2(-p.east, -p.north) * 180 / math.Pi)
+			shot.GimbalPitchDegree = -math.Atan2(cfg.Altitude, cfg.Radius) * 180 / math.Pi
+		}
+		shots = append(shots, shot)
+	}
+	for i := 0; i+1 < len(route); i++ {
+		a, b := route[i], route[i+1]
+		length := math.Hypot(b.east-a.east, b.north-a.north)
+		heading := math.Atan2(b.east-a.east, b.north-a.north) * 180 / math.Pi
+		// the lawnmower takes photos on the survey lines only
+		survey := cfg.Pattern == MissionOrbit || i%2 == 0
+		if survey {
+			nextPhoto = dist
+		}
+		start := dist
+		for survey && nextPhoto <= start+length+1e-6 {
+			t := (nextPhoto - start) / length
+			dist = nextPhoto
+			p := point{a.east + (b.east-a.east)*t, a.north + (b.north-a.north)*t}
+			add(p, heading, false)
+			if cfg.VideoInterval > 0 && dist >= nextVideo {
+				add(p, heading, true)
+				nextVideo = dist + cfg.VideoInterval
+			}
+			nextPhoto += cfg.PhotoSpacing
+			if cfg.Pattern == MissionOrbit {
+				break // every segment of the orbit is a photo spacing
+			}
+		}
+		dist = start + length
+	}
+	return shots, nil
+}
+
+// offsetLatLon moves the coordinate by the offsets in meters
+func offsetLatLon(lat, lon, east, north float64) (float64, float64) {
+	const earthRadius = 6378137.0
+	lat2 := lat + north/earthRadius*180/math.Pi
+	lon2 := lon + east/(earthRadius*math.Cos(lat*math.Pi/180))*180/math.Pi
+	return lat2, lon2
+}
+
+// normalizeYaw returns the yaw in (-180,180], 0 is the north
+func normalizeYaw(yaw float64) float64 {
+	yaw = math.Mod(yaw, 360)
+	if yaw > 180 {
+		yaw -= 360
+	} else if yaw <= -180 {
+		yaw += 360
+	}
+	return yaw
+}
+
+// missionFile is a media file of a shot
+type missionFile struct {
+	path   string // relative slash path in the media directory
+	camera CameraAttr
+	shot   *MissionShot
+}
+
+// files returns the media files of the shot, a photo of every camera or a clip of the first camera
+func (c *MissionConfig) files(shot *MissionShot) []missionFile {
+	created := c.StartTime.Add(shot.Offset)
+	var ret []missionFile
+	for i, camera := range c.Cameras {
+		if shot.Video && i > 0 {
+			break
+		}
+		suffix := map[CameraAttr]string{
+			CameraAttrWide: "W", CameraAttrZoom: "Z", CameraAttrInfrared: "T", CameraAttrVisible: "V",
+		}[camera]
+		ext := "JPG"
+		if shot.Video {
+			ext = "MP4"
+		}
+		name := fmt.Sprintf("DJI_%s_%04d_%s.%s", created.Format("20060102150405"), shot.Index, suffix, ext)
+		ret = append(ret, missionFile{path: path.Join(c.Name, name), camera: camera, shot: shot})
+	}
+	return ret
+}
+
+// writeFile writes the media file to the directory, the file appears atomically
+func (c *MissionConfig) writeFile(dir string, f missionFile) error {
+	file := filepath.Join(dir, filepath.FromSlash(f.path))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	created := c.StartTime.Add(f.shot.Offset)
+	var data []byte
+	var err error
+	if f.shot.Video {
+		data, err = buildMissionClip(f.shot, created)
+	} else {
+		data, err = buildMissionPhoto(c, f.shot, f.camera, created)
+	}
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// GenerateMission writes all the media files of the mission to the directory at once,
+// returns the descriptions of the files in the order of the flight.
+func GenerateMission(dir string, cfg *MissionConfig) ([]*MediaFileDesc, error) {
+	c := cfg.withDefaults()
+	shots, err := c.Plan()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*MediaFileDesc
+	for i := range shots {
+		for _, f := range c.files(&shots[i]) {
+			if err = c.writeFile(dir, f); err != nil {
+				return nil, err
+			}
+			desc, err := readMediaFileDesc(filepath.Join(dir, filepath.FromSlash(f.path)), f.path)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, desc)
+		}
+	}
+	return ret, nil
+}
+
+// RunMission simulates the flight of the mission, the media files are written to SimulatorConfig.MediaDir
+// and reported to the media files observer as the flight progresses, MissionConfig.Speedup runs it faster.
+// It returns when the mission is completed or the context is done.
+func (s *Simulator) RunMission(ctx context.Context, cfg *MissionConfig) error {
+	if s.media == nil {
+		return errors.New("simulator: media dir is not configured")
+	}
+	c := cfg.withDefaults()
+	shots, err := c.Plan()
+	if err != nil {
+		return err
+	}
+	// the files of a shot are produced at the end of the shot
+	sort.SliceStable(shots, func(i, j int) bool {
+		return shots[i].Offset+shots[i].Duration < shots[j].Offset+shots[j].Duration
+	})
+	s.log(LogLevelInfo, "mission %s started, %d shots", c.Pattern, len(shots))
+	begin := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := range shots {
+		shot := &shots[i]
+		at := time.Duration(float64(shot.Offset+shot.Duration) / c.Speedup)
+		timer.Reset(time.Until(begin.Add(at)))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		for _, f := range c.files(shot) {
+			if err = c.writeFile(s.cfg.MediaDir, f); err != nil {
+				return err
+			}
+			if desc := s.media.publish(f.path); desc != nil && s.Initialized() {
+				s.notifyMediaFile(desc)
+			}
+		}
+	}
+	s.log(LogLevelInfo, "mission %s completed", c.Pattern)
+	return nil
+}
@@ -0,0 +1,401 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"time"
+
+	"github.com/lynnplus/go-djiedge/h264"
+)
+
+// buildMissionPhoto encodes a jpeg of the shot with the EXIF and DJI XMP metadata
+func buildMissionPhoto(c *MissionConfig, shot *MissionShot, camera CameraAttr, created time.Time) ([]byte, error) {
+	w, h := c.ImageWidth, c.ImageHeight
+	if camera == CameraAttrInfrared {
+		w, h = 640, 512
+	}
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	// a gradient tinted by the shot, the infrared image is gray
+	cb, cr := uint8(128), uint8(128)
+	if camera != CameraAttrInfrared {
+		cb, cr = uint8(96+shot.Index*37%64), uint8(96+shot.Index*53%64)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Y[img.YOffset(x, y)] = uint8(16 + (x+y)*219/(w+h))
+			i := img.COffset(x, y)
+			img.Cb[i], img.Cr[i] = cb, cr
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	var out bytes.Buffer
+	out.Write(data[:2]) // SOI
+	writeJPEGSegment(&out, 0xffe1, append([]byte(jpegExifHeader), buildExif(shot, created, w, h)...))
+	writeJPEGSegment(&out, 0xffe1, append([]byte(jpegXMPHeader), buildDJIXMP(shot)...))
+	out.Write(data[2:])
+	return out.Bytes(), nil
+}
+
+func writeJPEGSegment(buf *bytes.Buffer, marker uint16, data []byte) {
+	_ = binary.Write(buf, binary.BigEndian, marker)
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(data)+2))
+	buf.Write(data)
+}
+
+// tiffIFD is an image file directory to be written
+type tiffIFD []tiffEntryValue
+
+type tiffEntryValue struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // big endian
+}
+
+// size returns the bytes of the directory and its values
+func (d tiffIFD) size() int {
+	n := 2 + 12*len(d) + 4
+	for _, e := range d {
+		if len(e.value) > 4 {
+			n += len(e.value) + len(e.value)%2
+		}
+	}
+	return n
+}
+
+// write appends the directory at the offset of the tiff
+func (d tiffIFD) write(buf []byte, offset int) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(d)))
+	extra := offset + 2 + 12*len(d) + 4
+	var values []byte
+	for _, e := range d {
+		buf = binary.BigEndian.AppendUint16(buf, e.tag)
+		buf = binary.BigEndian.AppendUint16(buf, e.typ)
+		buf = binary.BigEndian.AppendUint32(buf, e.count)
+		if len(e.value) <= 4 {
+			buf = append(buf, e.value...)
+			buf = append(buf, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(extra+len(values)))
+		values = append(values, e.value...)
+		if len(e.value)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	buf = binary.BigEndian.AppendUint32(buf, 0) // no next directory
+	return append(buf, values...)
+}
+
+func tiffASCII(tag uint16, s string) tiffEntryValue {
+	return tiffEntryValue{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func tiffLong(tag uint16, v uint32) tiffEntryValue {
+	return tiffEntryValue{tag: tag, typ: 4, count: 1, value: binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// tiffRationals writes the values with the denominator
+func tiffRationals(tag uint16, den uint32, values ...float64) tiffEntryValue {
+	e := tiffEntryValue{tag: tag, typ: 5, count: uint32(len(values))}
+	for _, v := range values {
+		e.value = binary.BigEndian.AppendUint32(e.value, uint32(math.Round(v*float64(den))))
+		e.value = binary.BigEndian.AppendUint32(e.value, den)
+	}
+	return e
+}
+
+// buildExif returns the big endian tiff structure with the camera, create time, size and gps
+func buildExif(shot *MissionShot, created time.Time, w, h int) []byte {
+	dms := func(tag uint16, v float64) tiffEntryValue {
+		v = math.Abs(v)
+		d := math.Floor(v)
+		m := math.Floor((v - d) * 60)
+		s := (v - d - m/60) * 3600
+		e := tiffRationals(tag, 1, d, m)
+		e.count++
+		e.value = append(e.value, tiffRationals(tag, 1000000, s).value...)
+		return e
+	}
+	latRef, lonRef, altRef := "N", "E", byte(0)
+	if shot.Latitude < 0 {
+		latRef = "S"
+	}
+	if shot.Longitude < 0 {
+		lonRef = "W"
+	}
+	if shot.AbsoluteAltitude < 0 {
+		altRef = 1
+	}
+	exif := tiffIFD{
+		tiffASCII(exifTagDateTimeOriginal, created.Format(exifTimeLayout)),
+		tiffLong(exifTagPixelXDimension, uint32(w)),
+		tiffLong(exifTagPixelYDimension, uint32(h)),
+	}
+	gps := tiffIFD{
+		{tag: 0, typ: 1, count: 4, value: []byte{2, 3, 0, 0}}, // GPSVersionID
+		tiffASCII(gpsTagLatitudeRef, latRef),
+		dms(gpsTagLatitude, shot.Latitude),
+		tiffASCII(gpsTagLongitudeRef, lonRef),
+		dms(gpsTagLongitude, shot.Longitude),
+		{tag: gpsTagAltitudeRef, typ: 1, count: 1, value: []byte{altRef}},
+		tiffRationals(gpsTagAltitude, 1000, math.Abs(shot.AbsoluteAltitude)),
+	}
+	ifd0 := tiffIFD{
+		tiffASCII(0x010f, "DJI"),
+		tiffASCII(0x0110, "Simulator"),
+		tiffLong(exifTagExifIFD, 0),
+		tiffLong(exifTagGPSIFD, 0),
+	}
+	exifOffset := 8 + ifd0.size()
+	gpsOffset := exifOffset + exif.size()
+	ifd0[2] = tiffLong(exifTagExifIFD, uint32(exifOffset))
+	ifd0[3] = tiffLong(exifTagGPSIFD, uint32(gpsOffset))
+
+	buf := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	buf = ifd0.write(buf, 8)
+	buf = exif.write(buf, exifOffset)
+	return gps.write(buf, gpsOffset)
+}
+
+// buildDJIXMP returns the xmp packet with the drone-dji fields
+func buildDJIXMP(shot *MissionShot) []byte {
+	return []byte(fmt.Sprintf("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>"+`
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:drone-dji="http://www.dji.com/drone-dji/1.0/"
+   drone-dji:GpsLatitude="%.8f"
+   drone-dji:GpsLongitude="%.8f"
+   drone-dji:AbsoluteAltitude="%+.3f"
+   drone-dji:RelativeAltitude="%+.3f"
+   drone-dji:GimbalRollDegree="+0.00"
+   drone-dji:GimbalYawDegree="%+.2f"
+   drone-dji:GimbalPitchDegree="%+.2f"
+   drone-dji:FlightRollDegree="+0.00"
+   drone-dji:FlightYawDegree="%+.2f"
+   drone-dji:FlightPitchDegree="+0.00"/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`, shot.Latitude, shot.Longitude, shot.AbsoluteAltitude, shot.RelativeAltitude,
+		shot.GimbalYawDegree, shot.GimbalPitchDegree, shot.FlightYawDegree))
+}
+
+// buildMissionClip encodes the TestPattern of the clip duration to a mp4 file with the location
+func buildMissionClip(shot *MissionShot, created time.Time) ([]byte, error) {
+	pattern, err := NewTestPattern(StreamQuality540p)
+	if err != nil {
+		return nil, err
+	}
+	n := int(shot.Duration / pattern.FrameInterval())
+	aus := make([][]byte, n)
+	for i := range aus {
+		aus[i] = pattern.NextAccessUnit(created.Add(time.Duration(i) * pattern.FrameInterval()))
+	}
+	w, h := pattern.Size()
+	location := fmt.Sprintf("%+08.4f%+09.4f%+.3f/", shot.Latitude, shot.Longitude, shot.AbsoluteAltitude)
+	return buildMP4(aus, w, h, pattern.FrameInterval(), created, location)
+}
+
+// buildMP4 muxes the annex-b access units to a progressive mp4 file with a single video track,
+// the location is written to the ©xyz box in ISO 6709.
+func buildMP4(aus [][]byte, width, height int, interval time.Duration, created time.Time, location string) ([]byte, error) {
+	const timescale = 90000
+	var sps, pps []byte
+	var samples [][]byte
+	var syncSamples []uint32
+	for i, au := range aus {
+		var sample []byte
+		sync := false
+		for len(au) > 0 {
+			adv, nalu, _ := h264.ScanNALUnits(au, true)
+			if adv == 0 {
+				break
+			}
+			au = au[adv:]
+			if nalu == nil {
+				continue
+			}
+			nalu = h264.TrimStartCode(nalu)
+			switch h264.Type(nalu) {
+			case h264.NALSPS:
+				sps = nalu
+				continue
+			case h264.NALPPS:
+				pps = nalu
+				continue
+			case h264.NALIDR:
+				sync = true
+			}
+			sample = binary.BigEndian.AppendUint32(sample, uint32(len(nalu)))
+			sample = append(sample, nalu...)
+		}
+		if sync {
+			syncSamples = append(syncSamples, uint32(i+1))
+		}
+		samples = append(samples, sample)
+	}
+	avcC, err := h264.AVCDecoderConfig(sps, pps)
+	if err != nil {
+		return nil, fmt.Errorf("mp4: %w", err)
+	}
+
+	delta := uint32((interval*timescale + time.Second/2) / time.Second)
+	duration := delta * uint32(len(samples))
+	movieDuration := uint32(uint64(duration) * 1000 / timescale)
+	ts := uint32(created.Sub(mp4Epoch) / time.Second)
+
+	b := &mp4Builder{}
+	b.box("ftyp", func() {
+		b.bytes([]byte("isom"))
+		b.u32(0x200)
+		b.bytes([]byte("isomiso2avc1mp41"))
+	})
+	var mdatSize int
+	for _, s := range samples {
+		mdatSize += len(s)
+	}
+	chunkOffset := len(b.buf) + 8
+	b.u32(uint32(mdatSize + 8))
+	b.bytes([]byte("mdat"))
+	for _, s := range samples {
+		b.bytes(s)
+	}
+
+	b.box("moov", func() {
+		b.box("mvhd", func() {
+			b.u32(0)
+			b.u32(ts)
+			b.u32(ts)
+			b.u32(1000)
+			b.u32(movieDuration)
+			b.u32(0x10000) // rate
+			b.u16(0x100)   // volume
+			b.bytes(make([]byte, 10))
+			b.matrix()
+			b.bytes(make([]byte, 24))
+			b.u32(2) // next_track_ID
+		})
+		b.box("trak", func() {
+			b.box("tkhd", func() {
+				b.u32(3) // enabled and in movie
+				b.u32(ts)
+				b.u32(ts)
+				b.u32(1) // track_ID
+				b.u32(0)
+				b.u32(movieDuration)
+				b.bytes(make([]byte, 8))
+				b.u16(0) // layer
+				b.u16(0) // alternate_group
+				b.u16(0) // volume
+				b.u16(0)
+				b.matrix()
+				b.u32(uint32(width) << 16)
+				b.u32(uint32(height) << 16)
+			})
+			b.box("mdia", func() {
+				b.box("mdhd", func() {
+					b.u32(0)
+					b.u32(ts)
+					b.u32(ts)
+					b.u32(timescale)
+					b.u32(duration)
+					b.u16(0x55c4) // und
+					b.u16(0)
+				})
+				b.box("hdlr", func() {
+					b.u32(0)
+					b.u32(0)
+					b.bytes([]byte("vide"))
+					b.bytes(make([]byte, 12))
+					b.bytes([]byte("VideoHandler\x00"))
+				})
+				b.box("minf", func() {
+					b.box("vmhd", func() {
+						b.u32(1)
+						b.bytes(make([]byte, 8))
+					})
+					b.box("dinf", func() {
+						b.box("dref", func() {
+							b.u32(0)
+							b.u32(1)
+							b.box("url ", func() { b.u32(1) })
+						})
+					})
+					b.box("stbl", func() {
+						b.box("stsd", func() {
+							b.u32(0)
+							b.u32(1)
+							b.avc1(width, height, avcC)
+						})
+						b.box("stts", func() {
+							b.u32(0)
+							b.u32(1)
+							b.u32(uint32(len(samples)))
+							b.u32(delta)
+						})
+						b.box("stss", func() {
+							b.u32(0)
+							b.u32(uint32(len(syncSamples)))
+							for _, s := range syncSamples {
+								b.u32(s)
+							}
+						})
+						b.box("stsc", func() {
+							b.u32(0)
+							b.u32(1)
+							b.u32(1)
+							b.u32(uint32(len(samples)))
+							b.u32(1)
+						})
+						b.box("stsz", func() {
+							b.u32(0)
+							b.u32(0)
+							b.u32(uint32(len(samples)))
+							for _, s := range samples {
+								b.u32(uint32(len(s)))
+							}
+						})
+						b.box("stco", func() {
+							b.u32(0)
+							b.u32(1)
+							b.u32(uint32(chunkOffset))
+						})
+					})
+				})
+			})
+		})
+		b.box("udta", func() {
+			b.box("\xa9xyz", func() {
+				b.u16(uint16(len(location)))
+				b.u16(0x15c7) // language
+				b.bytes([]byte(location))
+			})
+		})
+	})
+	return b.buf, nil
+}
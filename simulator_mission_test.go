@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nearly(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestMissionPlanLawnmower(t *testing.T) {
+	cfg := &MissionConfig{
+		Latitude: 22.5, Longitude: 113.9, TakeoffAltitude: 10, Altitude: 60,
+		Width: 100, Height: 80, LineSpacing: 40, PhotoSpacing: 25, Speed: 10,
+	}
+	shots, err := cfg.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 lines of 5 photos, the lines are flown east, west and east
+	if len(shots) != 15 {
+		t.Fatalf("%d shots, want 15", len(shots))
+	}
+	for i, shot := range shots {
+		line, n := i/5, i%5
+		heading, east := 90.0, -50+25*float64(n)
+		if line == 1 {
+			heading, east = -90, -east
+		}
+		// the transit of 40m between the lines takes no photos
+		wantOffset := time.Duration((float64(line)*14 + float64(n)*2.5) * float64(time.Second))
+		lat, lon := offsetLatLon(22.5, 113.9, east, -40+40*float64(line))
+		if shot.Index != i+1 || shot.Video || shot.Offset != wantOffset || shot.FlightYawDegree != heading ||
+			shot.GimbalYawDegree != heading || shot.GimbalPitchDegree != -90 ||
+			!nearly(shot.Latitude, lat) || !nearly(shot.Longitude, lon) ||
+			shot.RelativeAltitude != 60 || shot.AbsoluteAltitude != 70 {
+			t.Fatalf("shot %d: %+v, want offset %v heading %v at %v,%v", i, shot, wantOffset, heading, lat, lon)
+		}
+	}
+}
+
+func TestMissionPlanVideo(t *testing.T) {
+	cfg := &MissionConfig{Width: 100, Height: 1, PhotoSpacing: 25, Speed: 10, VideoInterval: 50, VideoDuration: 2 * time.Second}
+	shots, err := cfg.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a clip is recorded with the photo every 50m
+	want := []struct {
+		offset time.Duration
+		video  bool
+	}{
+		{0, false}, {0, true}, {2500 * time.Millisecond, false}, {5 * time.Second, false}, {5 * time.Second, true},
+		{7500 * time.Millisecond, false}, {10 * time.Second, false}, {10 * time.Second, true},
+	}
+	if len(shots) != len(want) {
+		t.Fatalf("%d shots, want %d", len(shots), len(want))
+	}
+	for i, shot := range shots {
+		duration := time.Duration(0)
+		if want[i].video {
+			duration = 2 * time.Second
+		}
+		if shot.Index != i+1 || shot.Offset != want[i].offset || shot.Video != want[i].video || shot.Duration != duration {
+			t.Fatalf("shot %d: %+v, want %+v", i, shot, want[i])
+		}
+	}
+}
+
+func TestMissionPlanOrbit(t *testing.T) {
+	cfg := &MissionConfig{Pattern: MissionOrbit, Radius: 50, PhotoSpacing: 20, Altitude: 100, Speed: 5}
+	shots, err := cfg.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ceil(2*pi*50/20) photos clockwise from the north
+	if len(shots) != 16 {
+		t.Fatalf("%d shots, want 16", len(shots))
+	}
+	segment := 2 * 50 * math.Sin(math.Pi/16)
+	pitch := -math.Atan2(100, 50) * 180 / math.Pi
+	for i, shot := range shots {
+		// the camera points to the center, the flight is tangent to the circle
+		angle := 22.5 * float64(i)
+		gimbal := normalizeYaw(angle + 180)
+		if shot.Offset != time.Duration(float64(i)*segment/5*float64(time.Second)) ||
+			!nearly(shot.FlightYawDegree, normalizeYaw(angle+90+11.25)) ||
+			!nearly(shot.GimbalYawDegree, gimbal) || !nearly(shot.GimbalPitchDegree, pitch) {
+			t.Fatalf("shot %d: %+v, want flight yaw %v gimbal %v/%v", i, shot, normalizeYaw(angle+101.25), gimbal, pitch)
+		}
+	}
+}
+
+func TestMissionPlanInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  MissionConfig
+	}{
+		{"pattern", MissionConfig{Pattern: "spiral"}},
+		{"center", MissionConfig{Latitude: 91}},
+		{"negative speed", MissionConfig{Speed: -1}},
+		{"invalid camera", MissionConfig{Cameras: []CameraAttr{CameraAttr(9)}}},
+		{"duplicate camera", MissionConfig{Cameras: []CameraAttr{CameraAttrWide, CameraAttrInfrared, CameraAttrWide}}},
+		{"image size", MissionConfig{ImageWidth: 10000, ImageHeight: 10}},
+		{"name outside the media dir", MissionConfig{Name: "../flight"}},
+		{"absolute name", MissionConfig{Name: "/flight"}},
+		{"line spacing", MissionConfig{LineSpacing: 1e-9}},
+		{"photo spacing", MissionConfig{PhotoSpacing: 1e-300}},
+		{"large area", MissionConfig{Width: 1e5, Height: 1e5}},
+		{"not a number", MissionConfig{Width: math.NaN()}},
+		{"orbit photo spacing", MissionConfig{Pattern: MissionOrbit, Radius: 1e7, PhotoSpacing: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if shots, err := tt.cfg.Plan(); err == nil {
+				t.Fatalf("%d shots, want error", len(shots))
+			}
+		})
+	}
+}
+
+func testMissionConfig() *MissionConfig {
+	// photos at 0, 20 and 40m, clips at 0 and 40m
+	return &MissionConfig{
+		Name: "flight1", Latitude: 22.5, Longitude: 113.9, Width: 40, Height: 1, PhotoSpacing: 20, Speed: 10,
+		Cameras:       []CameraAttr{CameraAttrWide, CameraAttrInfrared},
+		ImageWidth:    64,
+		ImageHeight:   48,
+		VideoInterval: 40, VideoDuration: time.Second,
+		StartTime: time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local),
+	}
+}
+
+func TestGenerateMission(t *testing.T) {
+	dir := t.TempDir()
+	files, err := GenerateMission(dir, testMissionConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		path   string
+		camera CameraAttr
+		typ    MediaFileType
+	}{
+		{"flight1/DJI_20230701103000_0001_W.JPG", CameraAttrWide, MediaFileTypeJPEG},
+		{"flight1/DJI_20230701103000_0001_T.JPG", CameraAttrInfrared, MediaFileTypeJPEG},
+		{"flight1/DJI_20230701103000_0002_W.MP4", CameraAttrWide, MediaFileTypeMP4},
+		{"flight1/DJI_20230701103002_0003_W.JPG", CameraAttrWide, MediaFileTypeJPEG},
+		{"flight1/DJI_20230701103002_0003_T.JPG", CameraAttrInfrared, MediaFileTypeJPEG},
+		{"flight1/DJI_20230701103004_0004_W.JPG", CameraAttrWide, MediaFileTypeJPEG},
+		{"flight1/DJI_20230701103004_0004_T.JPG", CameraAttrInfrared, MediaFileTypeJPEG},
+		{"flight1/DJI_20230701103004_0005_W.MP4", CameraAttrWide, MediaFileTypeMP4},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("%d files, want %d", len(files), len(want))
+	}
+	for i, desc := range files {
+		if desc.FilePath != want[i].path || desc.CameraAttr != want[i].camera || desc.FileType != want[i].typ {
+			t.Fatalf("file %d: %s %v %v, want %+v", i, desc.FilePath, desc.CameraAttr, desc.FileType, want[i])
+		}
+		if math.Abs(desc.Latitude-22.5) > 1e-4 || math.Abs(desc.Longitude-113.9) > 1e-3 {
+			t.Fatalf("file %d: location %v,%v", i, desc.Latitude, desc.Longitude)
+		}
+	}
+	if files[1].ImageWidth != 640 || files[0].ImageWidth != 64 || files[2].VideoDuration != time.Second {
+		t.Fatalf("sizes %d %d duration %v", files[0].ImageWidth, files[1].ImageWidth, files[2].VideoDuration)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "flight1"))
+	if err != nil || len(entries) != len(want) {
+		t.Fatalf("%d files in the dir, %v", len(entries), err)
+	}
+}
+
+func TestRunMission(t *testing.T) {
+	cfg := DefaultSimulatorConfig()
+	cfg.InitDelay = 0
+	cfg.MediaDir = t.TempDir()
+	sim, err := NewSimulatorWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan *MediaFileDesc, 16)
+	_ = sim.RegisterMediaFilesObserver(func(desc *MediaFileDesc) { notified <- desc })
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.DeInitSDK()
+
+	mission := testMissionConfig()
+	mission.Speedup = 100
+	if err := sim.RunMission(context.Background(), mission); err != nil {
+		t.Fatal(err)
+	}
+	// the files are reported at the end of the shots, the first clip ends before the second photo
+	want := []string{
+		"flight1/DJI_20230701103000_0001_W.JPG",
+		"flight1/DJI_20230701103000_0001_T.JPG",
+		"flight1/DJI_20230701103000_0002_W.MP4",
+		"flight1/DJI_20230701103002_0003_W.JPG",
+		"flight1/DJI_20230701103002_0003_T.JPG",
+		"flight1/DJI_20230701103004_0004_W.JPG",
+		"flight1/DJI_20230701103004_0004_T.JPG",
+		"flight1/DJI_20230701103004_0005_W.MP4",
+	}
+	if len(notified) != len(want) {
+		t.Fatalf("%d files notified, want %d", len(notified), len(want))
+	}
+	for i, path := range want {
+		if desc := <-notified; desc.FilePath != path {
+			t.Fatalf("file %d: %s, want %s", i, desc.FilePath, path)
+		}
+	}
+	if n := len(sim.media.list()); n != len(want) {
+		t.Fatalf("%d files listed", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mission.Name = "flight2"
+	if err := sim.RunMission(ctx, mission); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled mission: %v", err)
+	}
+	if err := NewSimulator().RunMission(context.Background(), mission); err == nil {
+		t.Fatal("mission run without media dir")
+	}
+	if err := sim.RunMission(context.Background(), &MissionConfig{Pattern: "spiral"}); err == nil {
+		t.Fatal("invalid mission run")
+	}
+}
@@ -0,0 +1,526 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lynnplus/go-djiedge/h264"
+)
+
+// SimTestPatternStream can be used as a stream file of SimulatorConfig to push the TestPattern stream,
+// the test pattern is also used if the default stream file[edge_stream.h264] doesn't exist.
+const SimTestPatternStream = "testpattern"
+
+const (
+	testPatternFPS     = 30
+	testPatternGOP     = 2 * testPatternFPS
+	testPatternBoxSize = 4 // in macroblocks
+	testPatternScale   = 3 // scale of the font
+)
+
+// testPatternUUID identifies the user data unregistered sei of the test pattern
+var testPatternUUID = [16]byte{
+	0x64, 0x6a, 0x69, 0x65, 0x64, 0x67, 0x65, 0x2d, 0x74, 0x65, 0x73, 0x74, 0x70, 0x61, 0x74, 0x74,
+}
+
+// TestPatternInfo is carried by every frame of the test pattern
+type TestPatternInfo struct {
+	// Seq the frame counter, starts from 0
+	Seq uint64
+	// Time the presentation time of the frame
+	Time time.Time
+}
+
+// TestPattern generates a synthetic h264 annex-b stream in pure Go.
+//
+// The picture has color bars, a box moving across the picture, and the frame counter and timestamp
+// printed at the top-left corner. The frames are coded as I_PCM macroblocks, the IDR frame codes the whole picture
+// every 2 seconds, the P frames only code the changed macroblocks and skip the others.
+// Every frame carries a user data unregistered sei with the frame counter and timestamp,
+// which can be read by ParseTestPatternInfo to verify the frame ordering and latency.
+type TestPattern struct {
+	width, height int
+	mbWidth       int
+	mbHeight      int
+
+	base [3][]byte // the background planes
+	cur  [3][]byte // the planes of the current frame
+
+	seq       uint64
+	frameNum  int
+	idrID     int
+	lastBoxX  int
+	lastText  int
+	textWidth int
+}
+
+// NewTestPattern returns a test pattern with the resolution of the quality, the frame rate is 30fps
+func NewTestPattern(quality StreamQuality) (*TestPattern, error) {
+	var w, h int
+	switch quality {
+	case StreamQuality540p:
+		w, h = 960, 540
+	case StreamQuality720p, StreamQuality720pHigh:
+		w, h = 1280, 720
+	case StreamQuality1080p:
+		w, h = 1920, 1080
+	default:
+		return nil, errors.New("invalid parameter for quality")
+	}
+	p := &TestPattern{width: w, height: h, mbWidth: (w + 15) / 16, mbHeight: (h + 15) / 16, lastBoxX: -1}
+	lw, lh := p.mbWidth*16, p.mbHeight*16
+	for i := range p.base {
+		if i > 0 {
+			lw, lh = p.mbWidth*8, p.mbHeight*8
+		}
+		p.base[i] = make([]byte, lw*lh)
+		p.cur[i] = make([]byte, lw*lh)
+	}
+	p.drawBackground()
+	return p, nil
+}
+
+// Size returns the resolution of the picture
+func (p *TestPattern) Size() (width, height int) {
+	return p.width, p.height
+}
+
+// FrameInterval returns the interval of the frames
+func (p *TestPattern) FrameInterval() time.Duration {
+	return time.Second / testPatternFPS
+}
+
+// NextAccessUnit returns the next frame with the presentation time,
+// the IDR frame is prefixed with sps and pps.
+func (p *TestPattern) NextAccessUnit(at time.Time) []byte {
+	idr := p.seq%testPatternGOP == 0
+	if idr {
+		p.frameNum = 0
+	}
+
+	boxX := int(p.seq % uint64(p.mbWidth-testPatternBoxSize))
+	text := fmt.Sprintf("#%08d %s", p.seq, at.Format("15:04:05.000"))
+	dirty := p.render(boxX, text)
+
+	var buf bytes.Buffer
+	if idr {
+		writeNalu(&buf, 0x67, p.sps())
+		writeNalu(&buf, 0x68, p.pps())
+	}
+	writeNalu(&buf, 0x06, p.sei(at))
+	if idr {
+		writeNalu(&buf, 0x65, p.slice(true, nil))
+		p.idrID = (p.idrID + 1) % 2
+	} else {
+		writeNalu(&buf, 0x41, p.slice(false, dirty))
+	}
+
+	p.lastBoxX = boxX
+	p.frameNum = (p.frameNum + 1) % 256
+	p.seq++
+	return buf.Bytes()
+}
+
+// ParseTestPatternInfo reads the frame info of the test pattern from an access unit
+func ParseTestPatternInfo(au []byte) (*TestPatternInfo, bool) {
+	for len(au) > 0 {
+		start, w := h264.IndexStartCode(au)
+		if start < 0 {
+			return nil, false
+		}
+		au = au[start+w:]
+		end, _ := h264.IndexStartCode(au)
+		nalu := au
+		if end >= 0 {
+			nalu = au[:end]
+		}
+		if h264.Type(nalu) == h264.NALSEI {
+			payload := h264.EBSPToRBSP(nalu[1:])
+			// payload type 5 and payload size 32
+			if len(payload) >= 34 && payload[0] == 5 && payload[1] == 32 && bytes.Equal(payload[2:18], testPatternUUID[:]) {
+				return &TestPatternInfo{
+					Seq:  binary.BigEndian.Uint64(payload[18:26]),
+					Time: time.Unix(0, int64(binary.BigEndian.Uint64(payload[26:34]))),
+				}, true
+			}
+		}
+		if end < 0 {
+			break
+		}
+	}
+	return nil, false
+}
+
+// render draws the box and text to the current planes, returns the flags of the changed macroblocks
+func (p *TestPattern) render(boxX int, text string) []bool {
+	dirty := make([]bool, p.mbWidth*p.mbHeight)
+	boxY := (p.mbHeight - testPatternBoxSize) / 2
+	markBox := func(x int) {
+		if x < 0 {
+			return
+		}
+		for my := boxY; my < boxY+testPatternBoxSize; my++ {
+			for mx := x; mx < x+testPatternBoxSize; mx++ {
+				dirty[my*p.mbWidth+mx] = true
+			}
+		}
+	}
+	markBox(p.lastBoxX)
+	markBox(boxX)
+
+	// the text is drawn at the second and third macroblock rows
+	textPixels := len(text) * 6 * testPatternScale
+	textMBs := (textPixels + 8 + 15) / 16
+	if textMBs > p.mbWidth-1 {
+		textMBs = p.mbWidth - 1
+	}
+	for my := 1; my <= 2; my++ {
+		for mx := 1; mx <= textMBs; mx++ {
+			dirty[my*p.mbWidth+mx] = true
+		}
+	}
+
+	for i := range p.cur {
+		copy(p.cur[i], p.base[i])
+	}
+	p.fillRect(boxX*16, boxY*16, testPatternBoxSize*16, testPatternBoxSize*16, 235, 128, 128)
+	p.fillRect(boxX*16+16, boxY*16+16, (testPatternBoxSize-2)*16, (testPatternBoxSize-2)*16, 16, 128, 128)
+	p.fillRect(16, 16, textMBs*16, 32, 16, 128, 128)
+	p.drawText(16+4, 16+5, text)
+	return dirty
+}
+
+func (p *TestPattern) drawBackground() {
+	// 75% color bars: white, yellow, cyan, green, magenta, red, blue, black
+	bars := [8][3]byte{
+		{180, 128, 128}, {168, 44, 136}, {145, 147, 44}, {133, 63, 52},
+		{63, 193, 204}, {51, 109, 212}, {28, 212, 120}, {16, 128, 128},
+	}
+	barHeight := p.mbHeight * 16 * 2 / 3
+	for i, c := range bars {
+		x0 := p.width * i / len(bars)
+		x1 := p.width * (i + 1) / len(bars)
+		if i == len(bars)-1 {
+			x1 = p.mbWidth * 16
+		}
+		p.fillRectTo(p.base, x0, 0, x1-x0, barHeight, c[0], c[1], c[2])
+	}
+	// gray ramp
+	lw := p.mbWidth * 16
+	for x := 0; x < lw; x++ {
+		y := byte(16 + x*219/lw)
+		p.fillRectTo(p.base, x, barHeight, 1, p.mbHeight*16-barHeight, y, 128, 128)
+	}
+}
+
+func (p *TestPattern) fillRect(x, y, w, h int, cy, cb, cr byte) {
+	p.fillRectTo(p.cur, x, y, w, h, cy, cb, cr)
+}
+
+func (p *TestPattern) fillRectTo(planes [3][]byte, x, y, w, h int, cy, cb, cr byte) {
+	lw := p.mbWidth * 16
+	for j := y; j < y+h; j++ {
+		row := planes[0][j*lw:]
+		for i := x; i < x+w; i++ {
+			row[i] = cy
+		}
+	}
+	cw := p.mbWidth * 8
+	for j := y / 2; j < (y+h+1)/2; j++ {
+		for i := x / 2; i < (x+w+1)/2; i++ {
+			planes[1][j*cw+i] = cb
+			planes[2][j*cw+i] = cr
+		}
+	}
+}
+
+func (p *TestPattern) drawText(x, y int, text string) {
+	lw := p.mbWidth * 16
+	for _, ch := range text {
+		glyph, ok := testPatternFont[ch]
+		if ok {
+			for row, bits := range glyph {
+				for col := 0; col < 5; col++ {
+					if bits&(0x10>>col) == 0 {
+						continue
+					}
+					for sy := 0; sy < testPatternScale; sy++ {
+						py := y + row*testPatternScale + sy
+						for sx := 0; sx < testPatternScale; sx++ {
+							px := x + col*testPatternScale + sx
+							if px < lw {
+								p.cur[0][py*lw+px] = 235
+							}
+						}
+					}
+				}
+			}
+		}
+		x += 6 * testPatternScale
+	}
+}
+
+func (p *TestPattern) sps() []byte {
+	w := &bitWriter{}
+	w.writeBits(66, 8)   // profile_idc: baseline
+	w.writeBits(0xc0, 8) // constraint_set0_flag and constraint_set1_flag
+	w.writeBits(40, 8)   // level_idc
+	w.writeUE(0)         // seq_parameter_set_id
+	w.writeUE(4)         // log2_max_frame_num_minus4
+	w.writeUE(2)         // pic_order_cnt_type
+	w.writeUE(1)         // max_num_ref_frames
+	w.writeBit(false)    // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint32(p.mbWidth - 1))
+	w.writeUE(uint32(p.mbHeight - 1))
+	w.writeBit(true) // frame_mbs_only_flag
+	w.writeBit(true) // direct_8x8_inference_flag
+	cropRight, cropBottom := (p.mbWidth*16-p.width)/2, (p.mbHeight*16-p.height)/2
+	w.writeBit(cropRight > 0 || cropBottom > 0)
+	if cropRight > 0 || cropBottom > 0 {
+		w.writeUE(0)
+		w.writeUE(uint32(cropRight))
+		w.writeUE(0)
+		w.writeUE(uint32(cropBottom))
+	}
+	w.writeBit(true) // vui_parameters_present_flag
+	w.writeBits(0, 4)
+	w.writeBit(true) // timing_info_present_flag
+	w.writeBits(1, 32)
+	w.writeBits(2*testPatternFPS, 32)
+	w.writeBit(true) // fixed_frame_rate_flag
+	w.writeBits(0, 3)
+	w.writeBit(true) // bitstream_restriction_flag
+	w.writeBit(true) // motion_vectors_over_pic_boundaries_flag
+	w.writeUE(0)
+	w.writeUE(0)
+	w.writeUE(16)
+	w.writeUE(16)
+	w.writeUE(0) // max_num_reorder_frames
+	w.writeUE(1) // max_dec_frame_buffering
+	return w.trailing()
+}
+
+func (p *TestPattern) pps() []byte {
+	w := &bitWriter{}
+	w.writeUE(0)      // pic_parameter_set_id
+	w.writeUE(0)      // seq_parameter_set_id
+	w.writeBit(false) // entropy_coding_mode_flag
+	w.writeBit(false) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)      // num_slice_groups_minus1
+	w.writeUE(0)      // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)      // num_ref_idx_l1_default_active_minus1
+	w.writeBit(false) // weighted_pred_flag
+	w.writeBits(0, 2) // weighted_bipred_idc
+	w.writeSE(0)      // pic_init_qp_minus26
+	w.writeSE(0)      // pic_init_qs_minus26
+	w.writeSE(0)      // chroma_qp_index_offset
+	w.writeBit(true)  // deblocking_filter_control_present_flag
+	w.writeBit(false) // constrained_intra_pred_flag
+	w.writeBit(false) // redundant_pic_cnt_present_flag
+	return w.trailing()
+}
+
+func (p *TestPattern) sei(at time.Time) []byte {
+	b := make([]byte, 0, 35)
+	b = append(b, 5, 32) // user_data_unregistered, size
+	b = append(b, testPatternUUID[:]...)
+	b = binary.BigEndian.AppendUint64(b, p.seq)
+	b = binary.BigEndian.AppendUint64(b, uint64(at.UnixNano()))
+	return append(b, 0x80)
+}
+
+// slice codes the picture as a single slice, the IDR slice codes all macroblocks,
+// the P slice codes the dirty macroblocks and skips the others.
+func (p *TestPattern) slice(idr bool, dirty []bool) []byte {
+	w := &bitWriter{}
+	w.writeUE(0) // first_mb_in_slice
+	if idr {
+		w.writeUE(7) // slice_type: I
+	} else {
+		w.writeUE(5) // slice_type: P
+	}
+	w.writeUE(0) // pic_parameter_set_id
+	w.writeBits(uint32(p.frameNum), 8)
+	if idr {
+		w.writeUE(uint32(p.idrID))
+	} else {
+		w.writeBit(false) // num_ref_idx_active_override_flag
+		w.writeBit(false) // ref_pic_list_modification_flag_l0
+	}
+	if idr {
+		w.writeBit(false) // no_output_of_prior_pics_flag
+		w.writeBit(false) // long_term_reference_flag
+	} else {
+		w.writeBit(false) // adaptive_ref_pic_marking_mode_flag
+	}
+	w.writeSE(0) // slice_qp_delta
+	w.writeUE(1) // disable_deblocking_filter_idc
+
+	total := p.mbWidth * p.mbHeight
+	skip := 0
+	for mb := 0; mb < total; mb++ {
+		if !idr && !dirty[mb] {
+			skip++
+			continue
+		}
+		if idr {
+			w.writeUE(25) // mb_type: I_PCM
+		} else {
+			w.writeUE(uint32(skip)) // mb_skip_run
+			skip = 0
+			w.writeUE(30) // mb_type: I_PCM in P slice
+		}
+		w.align()
+		p.writePCM(w, mb)
+	}
+	if skip > 0 {
+		w.writeUE(uint32(skip))
+	}
+	return w.trailing()
+}
+
+// writePCM writes the samples of the macroblock, the samples must not be 0
+func (p *TestPattern) writePCM(w *bitWriter, mb int) {
+	mx, my := mb%p.mbWidth, mb/p.mbWidth
+	lw, cw := p.mbWidth*16, p.mbWidth*8
+	for y := 0; y < 16; y++ {
+		for _, v := range p.cur[0][(my*16+y)*lw+mx*16:][:16] {
+			w.writeByte(max8(v, 1))
+		}
+	}
+	for plane := 1; plane <= 2; plane++ {
+		for y := 0; y < 8; y++ {
+			for _, v := range p.cur[plane][(my*8+y)*cw+mx*8:][:8] {
+				w.writeByte(max8(v, 1))
+			}
+		}
+	}
+}
+
+func max8(a, b byte) byte {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// writeNalu writes the nal unit with the start code, the payload is escaped
+func writeNalu(buf *bytes.Buffer, header byte, rbsp []byte) {
+	buf.Write([]byte{0, 0, 0, 1, header})
+	zeros := 0
+	for _, c := range rbsp {
+		if zeros >= 2 && c <= 3 {
+			buf.WriteByte(3)
+			zeros = 0
+		}
+		buf.WriteByte(c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+}
+
+// bitWriter writes the fields of a rbsp
+type bitWriter struct {
+	buf   []byte
+	cur   byte
+	nbits int
+}
+
+func (w *bitWriter) writeBit(b bool) {
+	w.cur <<= 1
+	if b {
+		w.cur |= 1
+	}
+	w.nbits++
+	if w.nbits == 8 {
+		w.buf = append(w.buf, w.cur)
+		w.cur, w.nbits = 0, 0
+	}
+}
+
+func (w *bitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v>>i&1 == 1)
+	}
+}
+
+func (w *bitWriter) writeByte(b byte) {
+	if w.nbits == 0 {
+		w.buf = append(w.buf, b)
+		return
+	}
+	w.writeBits(uint32(b), 8)
+}
+
+// writeUE writes an unsigned exp-golomb code
+func (w *bitWriter) writeUE(v uint32) {
+	v++
+	n := 0
+	for tmp := v; tmp > 1; tmp >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(v, n+1)
+}
+
+// writeSE writes a signed exp-golomb code
+func (w *bitWriter) writeSE(v int32) {
+	if v > 0 {
+		w.writeUE(uint32(2*v - 1))
+	} else {
+		w.writeUE(uint32(-2 * v))
+	}
+}
+
+// align writes zero bits until the byte boundary
+func (w *bitWriter) align() {
+	for w.nbits != 0 {
+		w.writeBit(false)
+	}
+}
+
+// trailing writes the rbsp trailing bits and returns the rbsp
+func (w *bitWriter) trailing() []byte {
+	w.writeBit(true)
+	w.align()
+	return w.buf
+}
+
+// testPatternFont is a 5x7 font, every row is 5 bits
+var testPatternFont = map[rune][7]byte{
+	'0': {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1': {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2': {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3': {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4': {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5': {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6': {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9': {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	':': {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	'.': {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+	'#': {0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a},
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lynnplus/go-djiedge/h264"
+)
+
+func TestNewTestPattern(t *testing.T) {
+	tests := []struct {
+		quality StreamQuality
+		w, h    int
+	}{
+		{StreamQuality540p, 960, 540},
+		{StreamQuality720p, 1280, 720},
+		{StreamQuality720pHigh, 1280, 720},
+		{StreamQuality1080p, 1920, 1080},
+	}
+	for _, tt := range tests {
+		p, err := NewTestPattern(tt.quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := p.Size(); w != tt.w || h != tt.h {
+			t.Errorf("quality %d: size %dx%d, want %dx%d", tt.quality, w, h, tt.w, tt.h)
+		}
+		if p.FrameInterval() != time.Second/testPatternFPS {
+			t.Errorf("quality %d: frame interval %v", tt.quality, p.FrameInterval())
+		}
+	}
+	if _, err := NewTestPattern(StreamQuality(0)); err == nil {
+		t.Error("invalid quality is accepted")
+	}
+}
+
+func TestTestPatternParamSets(t *testing.T) {
+	for _, q := range []StreamQuality{StreamQuality540p, StreamQuality720p, StreamQuality720pHigh, StreamQuality1080p} {
+		p, err := NewTestPattern(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		writeNalu(&buf, 0x67, p.sps())
+		sps, err := h264.ParseSPS(buf.Bytes())
+		if err != nil {
+			t.Fatalf("quality %d: %v", q, err)
+		}
+		w, h := p.Size()
+		if sps.Width != w || sps.Height != h || sps.ProfileIDC != 66 || sps.PicOrderCntType != 2 {
+			t.Errorf("quality %d: unexpected sps %+v", q, sps)
+		}
+		if sps.FrameInterval() != p.FrameInterval() || sps.FrameRate() != testPatternFPS {
+			t.Errorf("quality %d: frame interval %v, want %v", q, sps.FrameInterval(), p.FrameInterval())
+		}
+		if sps.VUI == nil || !sps.VUI.FixedFrameRate || !sps.VUI.BitstreamRestriction ||
+			sps.VUI.MaxNumReorderFrames != 0 || sps.VUI.MaxDecFrameBuffering != 1 {
+			t.Errorf("quality %d: unexpected vui %+v", q, sps.VUI)
+		}
+		// the escaped sps is unescaped to the rbsp
+		if rbsp := h264.EBSPToRBSP(buf.Bytes()[5:]); !bytes.Equal(rbsp, p.sps()) {
+			t.Errorf("quality %d: unescaped sps %x, want %x", q, rbsp, p.sps())
+		}
+
+		buf.Reset()
+		writeNalu(&buf, 0x68, p.pps())
+		pps, err := h264.ParsePPS(buf.Bytes())
+		if err != nil {
+			t.Fatalf("quality %d: %v", q, err)
+		}
+		if pps.EntropyCodingMode || pps.NumSliceGroups != 1 || pps.PicInitQP != 26 || !pps.DeblockingFilterControlPresent {
+			t.Errorf("quality %d: unexpected pps %+v", q, pps)
+		}
+	}
+}
+
+func TestTestPatternStream(t *testing.T) {
+	p, err := NewTestPattern(StreamQuality540p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	var stream []byte
+	n := testPatternGOP + 5
+	for i := 0; i < n; i++ {
+		stream = append(stream, p.NextAccessUnit(start.Add(time.Duration(i)*p.FrameInterval()))...)
+	}
+
+	var ps h264.ParamSets
+	aus := h264.AccessUnits(stream)
+	if len(aus) != n {
+		t.Fatalf("got %d access units, want %d", len(aus), n)
+	}
+	for i, au := range aus {
+		info, ok := ParseTestPatternInfo(au)
+		if !ok || info.Seq != uint64(i) || !info.Time.Equal(start.Add(time.Duration(i)*p.FrameInterval())) {
+			t.Fatalf("access unit %d: info %+v", i, info)
+		}
+		if idr := h264.ContainsType(au, h264.NALIDR); idr != (i%testPatternGOP == 0) {
+			t.Errorf("access unit %d: idr %v", i, idr)
+		}
+		for _, nalu := range h264.SplitNALUnits(au) {
+			switch h264.Type(nalu) {
+			case h264.NALSPS, h264.NALPPS:
+				if err := ps.Update(nalu); err != nil {
+					t.Fatal(err)
+				}
+			case h264.NALIDR, h264.NALSlice:
+				h, err := ps.ParseSliceHeader(nalu)
+				if err != nil {
+					t.Fatalf("access unit %d: %v", i, err)
+				}
+				if h.FrameNum != uint32(i%testPatternGOP) {
+					t.Errorf("access unit %d: frame_num %d", i, h.FrameNum)
+				}
+			}
+		}
+	}
+}
+
+func TestParseTestPatternInfo(t *testing.T) {
+	if _, ok := ParseTestPatternInfo(nil); ok {
+		t.Error("info of an empty access unit")
+	}
+	var buf bytes.Buffer
+	writeNalu(&buf, 0x06, []byte{5, 32, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80})
+	if _, ok := ParseTestPatternInfo(buf.Bytes()); ok {
+		t.Error("info of a sei of another uuid")
+	}
+}
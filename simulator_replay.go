@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+func openCaptureFile(path string) (*CaptureReader, *os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := NewCaptureReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return r, f, nil
+}
+
+// sleepUntil waits for the time, returns false if it is closed
+func sleepUntil(t time.Time, closeSig <-chan bool) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-closeSig:
+		return false
+	}
+}
+
+// replaySession replays the sdk log, media files and cloud messages of the capture,
+// the offsets of the events are counted from the begin of InitSDK.
+func (s *Simulator) replaySession(r *CaptureReader, f io.Closer, begin time.Time, closeSig <-chan bool, done chan<- bool) {
+	defer close(done)
+	defer f.Close()
+	keep := func(t CaptureEventType, _ uint32) bool {
+		return t == CaptureLog || t == CaptureMediaFile || t == CaptureCloudMessage
+	}
+	for {
+		e, err := r.next(keep)
+		if err != nil {
+			if err != io.EOF {
+				s.log(LogLevelWarn, "replay %s stopped:%v", s.cfg.ReplayFile, err)
+			}
+			return
+		}
+		if !sleepUntil(begin.Add(e.Offset), closeSig) {
+			return
+		}
+		s.mu.RLock()
+		logOut, cloudHandler := s.logOut, s.cloudHandler
+		s.mu.RUnlock()
+
+		switch e.Type {
+		case CaptureLog:
+			logOut.writeLine(string(e.Data))
+		case CaptureMediaFile:
+			desc, err := e.MediaFile()
+			if err != nil {
+				s.log(LogLevelWarn, "replay media file fail:%v", err)
+				continue
+			}
+			s.notifyMediaFile(desc)
+		case CaptureCloudMessage:
+			if cloudHandler != nil {
+				cloudHandler(e.Data)
+			}
+		}
+	}
+}
+
+// findReplayChannel returns the channel of the captured live-view of the camera and the offset of its first event,
+// the live-view of the same quality is preferred. the channel is 0 if the camera is not captured.
+func findReplayChannel(path string, camera CameraType, quality StreamQuality) (uint32, time.Duration, error) {
+	r, f, err := openCaptureFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var channel uint32
+	var offset time.Duration
+	for {
+		e, err := r.next(func(t CaptureEventType, _ uint32) bool { return t == CaptureLiveView })
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return channel, offset, nil
+			}
+			return 0, 0, err
+		}
+		c, q, ok := e.LiveView()
+		if !ok || c != camera {
+			continue
+		}
+		if q == quality {
+			return e.Channel, e.Offset, nil
+		}
+		if channel == 0 {
+			channel, offset = e.Channel, e.Offset
+		}
+	}
+}
+
+// replayState reports the captured stream status of the channel,
+// the offsets are counted from the initialization of the live-view.
+func (lv *simLiveView) replayState(closeSig <-chan bool, channel uint32, offset time.Duration) {
+	r, f, err := openCaptureFile(lv.sim.cfg.ReplayFile)
+	if err != nil {
+		lv.sim.log(LogLevelWarn, "replay stream status fail:%v", err)
+		return
+	}
+	defer f.Close()
+
+	begin := time.Now()
+	for {
+		e, err := r.next(func(t CaptureEventType, c uint32) bool {
+			return t == CaptureStreamStatus && c == channel
+		})
+		if err != nil {
+			return
+		}
+		if !sleepUntil(begin.Add(e.Offset-offset), closeSig) {
+			return
+		}
+		status, ok := e.Status()
+		if h := lv.receiver(); ok && h != nil {
+			h.OnStreamStatusUpdate(status)
+		}
+	}
+}
+
+// replayStreamSource provides the captured stream data of a channel with the original intervals,
+// the capture is restarted at the end if SimulatorConfig.StreamLoop is true.
+type replayStreamSource struct {
+	cfg     *SimulatorConfig
+	faults  *faultInjector
+	channel uint32
+
+	file    *os.File
+	reader  *CaptureReader
+	pending *CaptureEvent
+}
+
+func newReplayStreamSource(cfg *SimulatorConfig, faults *faultInjector, channel uint32) (*replayStreamSource, error) {
+	r, f, err := openCaptureFile(cfg.ReplayFile)
+	if err != nil {
+		return nil, err
+	}
+	return &replayStreamSource{cfg: cfg, faults: faults, channel: channel, file: f, reader: r}, nil
+}
+
+// read returns the next stream data of the channel, nil at the end
+func (s *replayStreamSource) read() *CaptureEvent {
+	keep := func(t CaptureEventType, c uint32) bool {
+		return t == CaptureStreamData && c == s.channel
+	}
+	for restarted := false; s.reader != nil; restarted = true {
+		e, err := s.reader.next(keep)
+		if err == nil {
+			return e
+		}
+		if !s.cfg.StreamLoop || restarted {
+			return nil
+		}
+		_ = s.file.Close()
+		s.reader, s.file, _ = openCaptureFile(s.cfg.ReplayFile)
+	}
+	return nil
+}
+
+func (s *replayStreamSource) next(time.Time) ([]byte, time.Duration) {
+	e := s.pending
+	if e == nil {
+		if e = s.read(); e == nil {
+			return nil, 0
+		}
+	}
+	s.pending = s.read()
+	interval := s.cfg.FrameInterval
+	if s.pending != nil && s.pending.Offset >= e.Offset {
+		interval = s.pending.Offset - e.Offset
+	}
+	return s.faults.truncate(e.Data), interval
+}
+
+func (s *replayStreamSource) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
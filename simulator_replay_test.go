@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2023 Lynn <lynnplus90@gmail.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package djiedge
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chanReceiver passes the stream data and status of a live-view to the channels
+type chanReceiver struct {
+	status chan int
+	data   chan []byte
+}
+
+func newChanReceiver() *chanReceiver {
+	return &chanReceiver{status: make(chan int, 16), data: make(chan []byte, 16)}
+}
+
+func (r *chanReceiver) OnStreamStatusUpdate(status *LiveStatus) {
+	select {
+	case r.status <- status.Value:
+	default:
+	}
+}
+
+func (r *chanReceiver) OnReceiveStreamData(data []byte) {
+	select {
+	case r.data <- append([]byte(nil), data...):
+	default:
+	}
+}
+
+func TestSimulatorReplay(t *testing.T) {
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}
+	slice := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02}
+	events := []*CaptureEvent{
+		{Type: CaptureLog, Offset: 10 * time.Millisecond, Data: []byte("[Info]-[edge] captured line")},
+		// the fpv channel is captured in another quality, the payload one is not captured
+		newLiveViewCaptureEvent(20*time.Millisecond, 1, CameraTypeFpv, StreamQuality720p),
+		newStatusCaptureEvent(30*time.Millisecond, 1, 31),
+		{Type: CaptureStreamData, Offset: 40 * time.Millisecond, Channel: 1, Data: idr},
+		{Type: CaptureStreamData, Offset: 50 * time.Millisecond, Channel: 1, Data: slice},
+		newMediaFileCaptureEvent(60*time.Millisecond, &MediaFileDesc{FileName: "DJI_0001_W.JPG", FilePath: "DJI_0001_W.JPG"}),
+		{Type: CaptureCloudMessage, Offset: 70 * time.Millisecond, Data: []byte(`{"method":"ping"}`)},
+	}
+	data, _ := writeTestCapture(t, time.Now(), events)
+	// a capture cut by a crash is replayed to the last whole event
+	data = append(data, byte(CaptureLog), 2)
+	file := filepath.Join(t.TempDir(), "session.cap")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultSimulatorConfig()
+	cfg.InitDelay, cfg.StartDelay = 0, 0
+	cfg.ReplayFile = file
+	sim, err := NewSimulatorWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := make(chan *MediaFileDesc, 1)
+	cloud := make(chan []byte, 1)
+	_ = sim.RegisterMediaFilesObserver(func(desc *MediaFileDesc) { media <- desc })
+	_ = sim.RegisterCloudCustomMsgHandler(func(b []byte) { cloud <- b })
+	var mu sync.Mutex
+	var lines []string
+	logger := &Logger{Level: LogLevelDebug, Outputer: func(msg string) {
+		mu.Lock()
+		lines = append(lines, msg)
+		mu.Unlock()
+	}}
+	if err := sim.InitSDK(&DeviceInfo{SerialNumber: "SN0001"}, &AuthInfo{}, &RSA2048Key{}, logger, false); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.DeInitSDK()
+
+	select {
+	case desc := <-media:
+		if desc.FilePath != "DJI_0001_W.JPG" {
+			t.Fatalf("media file %+v", desc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("media file is not replayed")
+	}
+	select {
+	case b := <-cloud:
+		if string(b) != `{"method":"ping"}` {
+			t.Fatalf("cloud message %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cloud message is not replayed")
+	}
+	mu.Lock()
+	logged := strings.Join(lines, "\n")
+	mu.Unlock()
+	if !strings.Contains(logged, "captured line") {
+		t.Fatalf("captured log is not replayed:\n%s", logged)
+	}
+
+	// the stream and status of the captured channel are replayed
+	lv := newSimLiveView(sim)
+	defer lv.DeInit()
+	receiver := newChanReceiver()
+	if err := lv.Init(CameraTypeFpv, StreamQuality540p, receiver); err != nil {
+		t.Fatal(err)
+	}
+	if lv.replayChannel != 1 || lv.replayOffset != 20*time.Millisecond {
+		t.Fatalf("replay channel %d offset %v", lv.replayChannel, lv.replayOffset)
+	}
+	select {
+	case v := <-receiver.status:
+		if v != 31 {
+			t.Fatalf("status %d", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("status is not replayed")
+	}
+	if err := lv.StartH264Stream(); err != nil {
+		t.Fatal(err)
+	}
+	defer lv.StopH264Stream()
+	for _, want := range [][]byte{idr, slice} {
+		select {
+		case got := <-receiver.data:
+			if !bytes.Equal(got, want) {
+				t.Fatalf("stream data %x, want %x", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream data is not replayed")
+		}
+	}
+
+	// the camera which is not captured is simulated
+	other := newSimLiveView(sim)
+	defer other.DeInit()
+	if err := other.Init(CameraTypePayload, StreamQuality540p, newChanReceiver()); err != nil {
+		t.Fatal(err)
+	}
+	if other.replayChannel != 0 {
+		t.Fatalf("replay channel %d of the payload camera", other.replayChannel)
+	}
+}
+
+func TestFindReplayChannel(t *testing.T) {
+	events := []*CaptureEvent{
+		newLiveViewCaptureEvent(time.Second, 1, CameraTypeFpv, StreamQuality540p),
+		newLiveViewCaptureEvent(2*time.Second, 2, CameraTypePayload, StreamQuality720p),
+		newLiveViewCaptureEvent(3*time.Second, 3, CameraTypePayload, StreamQuality1080p),
+	}
+	data, _ := writeTestCapture(t, time.Now(), events)
+	file := filepath.Join(t.TempDir(), "session.cap")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		camera      CameraType
+		quality     StreamQuality
+		wantChannel uint32
+		wantOffset  time.Duration
+	}{
+		{CameraTypePayload, StreamQuality1080p, 3, 3 * time.Second},
+		{CameraTypePayload, StreamQuality540p, 2, 2 * time.Second},
+		{CameraTypeFpv, StreamQuality720p, 1, time.Second},
+	}
+	for _, tt := range tests {
+		channel, offset, err := findReplayChannel(file, tt.camera, tt.quality)
+		if err != nil || channel != tt.wantChannel || offset != tt.wantOffset {
+			t.Errorf("findReplayChannel(%v, %v) = %d,%v,%v, want %d,%v", tt.camera, tt.quality,
+				channel, offset, err, tt.wantChannel, tt.wantOffset)
+		}
+	}
+	if _, _, err := findReplayChannel(filepath.Join(t.TempDir(), "missing.cap"), CameraTypeFpv, StreamQuality540p); err == nil {
+		t.Error("missing capture is opened")
+	}
+}